@@ -11,11 +11,13 @@ import (
 
 func init() {
 	rootCmd.AddCommand(decryptCmd)
-	decryptCmd.Flags().StringVarP(&decryptPlatform, "platform", "p", "", "platform")
-	decryptCmd.Flags().IntVarP(&decryptVer, "version", "v", 0, "version")
+	decryptCmd.Flags().StringVarP(&decryptPlatform, "platform", "p", "", "platform, default detected from data dir")
+	decryptCmd.Flags().IntVarP(&decryptVer, "version", "v", 0, "version, default detected from data dir")
 	decryptCmd.Flags().StringVarP(&decryptDataDir, "data-dir", "d", "", "data dir")
 	decryptCmd.Flags().StringVarP(&decryptDatakey, "data-key", "k", "", "data key")
 	decryptCmd.Flags().StringVarP(&decryptWorkDir, "work-dir", "w", "", "work dir")
+	decryptCmd.Flags().StringVar(&decryptWorkKey, "work-key", "", "encrypt databases in work dir with this passphrase (sqlcipher)")
+	decryptCmd.Flags().BoolVar(&decryptVerify, "verify", false, "verify page hmac, skip corrupt pages and run integrity check")
 }
 
 var (
@@ -24,6 +26,8 @@ var (
 	decryptDataDir  string
 	decryptDatakey  string
 	decryptWorkDir  string
+	decryptWorkKey  string
+	decryptVerify   bool
 )
 
 var decryptCmd = &cobra.Command{
@@ -34,6 +38,22 @@ var decryptCmd = &cobra.Command{
 		cmdConf := getDecryptConfig()
 
 		m := chatlog.New()
+		if decryptVerify {
+			reports, err := m.CommandVerify("", cmdConf)
+			if err != nil {
+				log.Err(err).Msg("failed to verify")
+				return
+			}
+			corrupt := 0
+			for _, r := range reports {
+				fmt.Print(r.String())
+				if !r.OK() {
+					corrupt++
+				}
+			}
+			fmt.Printf("verified %d files, %d corrupt\n", len(reports), corrupt)
+			return
+		}
 		if err := m.CommandDecrypt("", cmdConf); err != nil {
 			log.Err(err).Msg("failed to decrypt")
 			return
@@ -53,6 +73,9 @@ func getDecryptConfig() map[string]any {
 	if len(decryptWorkDir) != 0 {
 		cmdConf["work_dir"] = decryptWorkDir
 	}
+	if len(decryptWorkKey) != 0 {
+		cmdConf["work_key"] = decryptWorkKey
+	}
 	if len(decryptPlatform) != 0 {
 		cmdConf["platform"] = decryptPlatform
 	}
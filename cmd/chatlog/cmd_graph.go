@@ -0,0 +1,65 @@
+package chatlog
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+)
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	graphCmd.PersistentPreRun = initLog
+	graphCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
+	graphCmd.Flags().StringVarP(&graphPlatform, "platform", "p", "", "platform")
+	graphCmd.Flags().IntVarP(&graphVer, "version", "v", 0, "version")
+	graphCmd.Flags().StringVarP(&graphDataDir, "data-dir", "d", "", "data dir")
+	graphCmd.Flags().StringVarP(&graphWorkDir, "work-dir", "w", "", "work dir")
+	graphCmd.Flags().StringVar(&graphWorkKey, "work-key", "", "passphrase of encrypted databases in work dir")
+	graphCmd.Flags().StringVarP(&graphTime, "time", "t", "last-1m", "time range")
+	graphCmd.Flags().StringVarP(&graphTalker, "talker", "", "", "talkers, separated by commas, default all sessions")
+	graphCmd.Flags().StringVarP(&graphFormat, "format", "f", "graphml", "output format: json, graphml, gexf")
+	graphCmd.Flags().StringVarP(&graphOutput, "output", "o", "", "output file, default stdout")
+}
+
+var (
+	graphDataDir  string
+	graphWorkDir  string
+	graphWorkKey  string
+	graphPlatform string
+	graphVer      int
+	graphTime     string
+	graphTalker   string
+	graphFormat   string
+	graphOutput   string
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Export relationship graph of who talks to whom",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		cmdConf := make(map[string]any)
+		if len(graphDataDir) != 0 {
+			cmdConf["data_dir"] = graphDataDir
+		}
+		if len(graphWorkDir) != 0 {
+			cmdConf["work_dir"] = graphWorkDir
+		}
+		if len(graphWorkKey) != 0 {
+			cmdConf["work_key"] = graphWorkKey
+		}
+		if len(graphPlatform) != 0 {
+			cmdConf["platform"] = graphPlatform
+		}
+		if graphVer != 0 {
+			cmdConf["version"] = graphVer
+		}
+
+		m := chatlog.New()
+		if err := m.CommandGraph("", cmdConf, graphTime, graphTalker, graphFormat, graphOutput); err != nil {
+			log.Err(err).Msg("failed to export graph")
+			return
+		}
+	},
+}
@@ -14,18 +14,35 @@ func init() {
 	keyCmd.Flags().IntVarP(&keyPID, "pid", "p", 0, "pid")
 	keyCmd.Flags().BoolVarP(&keyForce, "force", "f", false, "force")
 	keyCmd.Flags().BoolVarP(&keyShowXorKey, "xor-key", "x", false, "show xor key")
+	keyCmd.Flags().StringVar(&keyFromDump, "from-dump", "", "search key in memory dump file (.bin or .zip) created by dumpmemory")
+	keyCmd.Flags().StringVarP(&keyDataDir, "data-dir", "d", "", "data dir, required by --from-dump")
+	keyCmd.Flags().StringVar(&keyPlatform, "platform", "", "platform of memory dump, detected from data dir if not set, required for 4.x")
+	keyCmd.Flags().IntVar(&keyVersion, "version", 0, "version of memory dump, detected from file name or data dir if not set")
 }
 
 var (
 	keyPID        int
 	keyForce      bool
 	keyShowXorKey bool
+	keyFromDump   string
+	keyDataDir    string
+	keyPlatform   string
+	keyVersion    int
 )
 var keyCmd = &cobra.Command{
 	Use:   "key",
 	Short: "key",
 	Run: func(cmd *cobra.Command, args []string) {
 		m := chatlog.New()
+		if len(keyFromDump) != 0 {
+			ret, err := m.CommandKeyFromDump(keyFromDump, keyDataDir, keyPlatform, keyVersion)
+			if err != nil {
+				log.Err(err).Msg("failed to get key from memory dump")
+				return
+			}
+			fmt.Println(ret)
+			return
+		}
 		ret, err := m.CommandKey("", keyPID, keyForce, keyShowXorKey)
 		if err != nil {
 			log.Err(err).Msg("failed to get key")
@@ -0,0 +1,46 @@
+package chatlog
+
+import (
+	"fmt"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	keyCmd.AddCommand(keyCheckCmd)
+	keyCheckCmd.Flags().StringVarP(&keyCheckDataDir, "data-dir", "d", "", "data dir")
+	keyCheckCmd.Flags().StringVarP(&keyCheckKey, "key", "k", "", "data key")
+	keyCheckCmd.Flags().StringVar(&keyCheckImgKey, "img-key", "", "image key, only used by wechat 4.x")
+	keyCheckCmd.Flags().StringVar(&keyCheckPlatform, "platform", "", "platform, default detected from databases")
+	keyCheckCmd.Flags().IntVar(&keyCheckVersion, "version", 0, "version, default detected from databases")
+}
+
+var (
+	keyCheckDataDir  string
+	keyCheckKey      string
+	keyCheckImgKey   string
+	keyCheckPlatform string
+	keyCheckVersion  int
+)
+
+var keyCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check data key and image key against all databases in data dir",
+	Run: func(cmd *cobra.Command, args []string) {
+		m := chatlog.New()
+		report, err := m.CommandKeyCheck(keyCheckDataDir, keyCheckKey, keyCheckImgKey, keyCheckPlatform, keyCheckVersion)
+		if err != nil {
+			log.Err(err).Msg("failed to check key")
+			return
+		}
+		fmt.Print(report.String())
+		if report.OK() {
+			fmt.Println("key check passed")
+		} else {
+			fmt.Println("key check failed")
+		}
+	},
+}
@@ -0,0 +1,41 @@
+package chatlog
+
+import (
+	"fmt"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	keyCmd.AddCommand(keyImgCmd)
+	keyImgCmd.Flags().StringVarP(&keyImgDataDir, "data-dir", "d", "", "data dir, the image key is saved to chatlog.json in it")
+	keyImgCmd.Flags().StringVar(&keyImgDatDir, "dat-dir", "", "dir of encrypted images (*.dat, *_t.dat), default data dir")
+	keyImgCmd.Flags().StringVar(&keyImgFromDump, "from-dump", "", "memory dump file (.bin or .zip) created by dumpmemory")
+	keyImgCmd.Flags().StringVar(&keyImgKeyList, "key-list", "", "candidate key list file, one key per line")
+	keyImgCmd.Flags().IntVar(&keyImgWorkers, "workers", 0, "number of parallel workers, default number of cpus")
+}
+
+var (
+	keyImgDataDir  string
+	keyImgDatDir   string
+	keyImgFromDump string
+	keyImgKeyList  string
+	keyImgWorkers  int
+)
+
+var keyImgCmd = &cobra.Command{
+	Use:   "img",
+	Short: "recover image key (wechat 4.x) from memory dump or candidate key list",
+	Run: func(cmd *cobra.Command, args []string) {
+		m := chatlog.New()
+		ret, err := m.CommandImgKey(keyImgDataDir, keyImgDatDir, keyImgFromDump, keyImgKeyList, keyImgWorkers)
+		if err != nil {
+			log.Err(err).Msg("failed to recover image key")
+			return
+		}
+		fmt.Println(ret)
+	},
+}
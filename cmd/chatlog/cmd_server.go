@@ -12,12 +12,13 @@ func init() {
 	serverCmd.PersistentPreRun = initLog
 	serverCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
 	serverCmd.Flags().StringVarP(&serverAddr, "addr", "a", "", "server address")
-	serverCmd.Flags().StringVarP(&serverPlatform, "platform", "p", "", "platform")
-	serverCmd.Flags().IntVarP(&serverVer, "version", "v", 0, "version")
+	serverCmd.Flags().StringVarP(&serverPlatform, "platform", "p", "", "platform, default detected from data dir")
+	serverCmd.Flags().IntVarP(&serverVer, "version", "v", 0, "version, default detected from data dir")
 	serverCmd.Flags().StringVarP(&serverDataDir, "data-dir", "d", "", "data dir")
 	serverCmd.Flags().StringVarP(&serverDataKey, "data-key", "k", "", "data key")
 	serverCmd.Flags().StringVarP(&serverImgKey, "img-key", "i", "", "img key")
 	serverCmd.Flags().StringVarP(&serverWorkDir, "work-dir", "w", "", "work dir")
+	serverCmd.Flags().StringVar(&serverWorkKey, "work-key", "", "encrypt databases in work dir with this passphrase (sqlcipher)")
 	serverCmd.Flags().BoolVarP(&serverAutoDecrypt, "auto-decrypt", "", false, "auto decrypt")
 }
 
@@ -27,6 +28,7 @@ var (
 	serverDataKey     string
 	serverImgKey      string
 	serverWorkDir     string
+	serverWorkKey     string
 	serverPlatform    string
 	serverVer         int
 	serverAutoDecrypt bool
@@ -38,7 +40,7 @@ var serverCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 
 		cmdConf := getServerConfig()
-		log.Info().Msgf("server cmd config: %+v", cmdConf)
+		log.Info().Msgf("server cmd config: %+v", redactConfig(cmdConf))
 
 		m := chatlog.New()
 		if err := m.CommandHTTPServer("", cmdConf); err != nil {
@@ -65,6 +67,9 @@ func getServerConfig() map[string]any {
 	if len(serverWorkDir) != 0 {
 		cmdConf["work_dir"] = serverWorkDir
 	}
+	if len(serverWorkKey) != 0 {
+		cmdConf["work_key"] = serverWorkKey
+	}
 	if len(serverPlatform) != 0 {
 		cmdConf["platform"] = serverPlatform
 	}
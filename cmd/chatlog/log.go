@@ -4,8 +4,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/pkg/util"
 
 	"github.com/rs/zerolog"
@@ -43,3 +45,35 @@ func initTuiLog(cmd *cobra.Command, args []string) {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: logOutput, NoColor: true, TimeFormat: time.RFC3339})
 	logrus.SetOutput(logOutput)
 }
+
+// initMCPLog stdout 用于 MCP 协议通信，日志写入文件
+func initMCPLog(cmd *cobra.Command, args []string) {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	if Debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	logOutput := io.Discard
+	logpath := util.DefaultWorkDir("")
+	util.PrepareDir(logpath)
+	logFD, err := os.OpenFile(filepath.Join(logpath, "chatlog-mcp.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
+	if err == nil {
+		logOutput = logFD
+	}
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: logOutput, NoColor: true, TimeFormat: time.RFC3339})
+	logrus.SetOutput(logOutput)
+}
+
+// redactConfig 返回隐藏了密钥和密码的命令行配置，用于输出日志
+func redactConfig(cmdConf map[string]any) map[string]any {
+	redacted := make(map[string]any, len(cmdConf))
+	for k, v := range cmdConf {
+		if s, ok := v.(string); ok && strings.HasSuffix(k, "_key") {
+			v = conf.Redact(s)
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
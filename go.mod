@@ -23,6 +23,7 @@ require (
 	github.com/spf13/cobra v1.9.1
 	github.com/spf13/viper v1.20.1
 	golang.org/x/crypto v0.41.0
+	golang.org/x/image v0.25.0
 	golang.org/x/sys v0.35.0
 	google.golang.org/protobuf v1.36.7
 	howett.net/plist v1.0.1
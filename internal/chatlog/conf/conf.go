@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/rs/zerolog/log"
+	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/pkg/config"
 )
 
@@ -14,6 +15,9 @@ const (
 	ServerConfigName = "chatlog-server"
 	EnvPrefix        = "CHATLOG"
 	EnvConfigDir     = "CHATLOG_DIR"
+
+	// EnvWorkKey 工作目录中数据库的加密密码，TUI 不保存该密码，每次启动时从环境变量读取
+	EnvWorkKey = "CHATLOG_WORK_KEY"
 )
 
 // LoadTUIConfig 加载 TUI 配置
@@ -38,7 +42,7 @@ func LoadTUIConfig(configPath string) (*TUIConfig, *config.Manager, error) {
 	}
 	conf.ConfigDir = tcm.Path
 
-	b, _ := json.Marshal(conf)
+	b, _ := json.Marshal(conf.Redacted())
 	log.Info().Msgf("tui config: %s", string(b))
 
 	return conf, tcm, nil
@@ -89,7 +93,7 @@ func LoadServiceConfig(configPath string, cmdConf map[string]any) (*ServerConfig
 		}
 	}
 
-	b, _ := json.Marshal(conf)
+	b, _ := json.Marshal(conf.Redacted())
 	log.Info().Msgf("server config: %s", string(b))
 
 	return conf, scm, nil
@@ -103,3 +107,25 @@ var DataDirConfigs = map[string]bool{
 	"data_key":     true,
 	"img_key":      true,
 }
+
+// SaveDataDirConfig 将配置合并写入数据目录中的 chatlog.json，保留文件中已有的配置
+func SaveDataDirConfig(dataDir string, values map[string]any) error {
+	path := filepath.Join(dataDir, "chatlog.json")
+	pconf := make(map[string]any)
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &pconf); err != nil {
+			return errors.ReadFileFailed(path, err)
+		}
+	}
+	for key, value := range values {
+		pconf[key] = value
+	}
+	b, err := json.Marshal(pconf)
+	if err != nil {
+		return errors.WriteFileFailed(path, err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return errors.WriteFileFailed(path, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package conf
+
+type Digest struct {
+	Items []*DigestItem `mapstructure:"items"`
+}
+
+type DigestItem struct {
+	Name     string `mapstructure:"name"`
+	Schedule string `mapstructure:"schedule"` // daily 或 weekly
+	At       string `mapstructure:"at"`       // 生成时间，格式为 15:04，默认 09:00
+	Weekday  string `mapstructure:"weekday"`  // weekly 的生成日，如 monday，默认周一
+	Talker   string `mapstructure:"talker"`   // 对话方，多个以英文逗号分隔，为空时为全部会话
+	URL      string `mapstructure:"url"`      // webhook 地址，为空时在工作目录生成 markdown 文件
+	Disabled bool   `mapstructure:"disabled"`
+}
@@ -13,9 +13,12 @@ type ServerConfig struct {
 	DataKey     string   `mapstructure:"data_key"`
 	ImgKey      string   `mapstructure:"img_key"`
 	WorkDir     string   `mapstructure:"work_dir"`
+	WorkKey     string   `mapstructure:"work_key" json:"-"`
 	HTTPAddr    string   `mapstructure:"http_addr"`
 	AutoDecrypt bool     `mapstructure:"auto_decrypt"`
+	Self        string   `mapstructure:"self"` // 自己的微信 ID，用于识别 @我 的消息，为空时根据自己发送的消息识别
 	Webhook     *Webhook `mapstructure:"webhook"`
+	Digest      *Digest  `mapstructure:"digest"`
 }
 
 var ServerDefaults = map[string]any{}
@@ -28,6 +31,11 @@ func (c *ServerConfig) GetWorkDir() string {
 	return c.WorkDir
 }
 
+// GetWorkKey 工作目录中数据库的加密密码，为空时以明文保存
+func (c *ServerConfig) GetWorkKey() string {
+	return c.WorkKey
+}
+
 func (c *ServerConfig) GetPlatform() string {
 	return c.Platform
 }
@@ -36,6 +44,16 @@ func (c *ServerConfig) GetVersion() int {
 	return c.Version
 }
 
+// FillFormat 补充未指定的平台与版本，已指定的配置不变
+func (c *ServerConfig) FillFormat(platform string, version int) {
+	if len(c.Platform) == 0 {
+		c.Platform = platform
+	}
+	if c.Version == 0 {
+		c.Version = version
+	}
+}
+
 func (c *ServerConfig) GetDataKey() string {
 	return c.DataKey
 }
@@ -58,3 +76,28 @@ func (c *ServerConfig) GetHTTPAddr() string {
 func (c *ServerConfig) GetWebhook() *Webhook {
 	return c.Webhook
 }
+
+func (c *ServerConfig) GetDigest() *Digest {
+	return c.Digest
+}
+
+func (c *ServerConfig) GetSelf() string {
+	return c.Self
+}
+
+// Redacted 返回隐藏了密钥和密码的配置副本，用于输出日志
+func (c *ServerConfig) Redacted() ServerConfig {
+	rc := *c
+	rc.DataKey = Redact(rc.DataKey)
+	rc.ImgKey = Redact(rc.ImgKey)
+	rc.WorkKey = Redact(rc.WorkKey)
+	return rc
+}
+
+// Redact 隐藏密钥，仅保留是否已设置
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "******"
+}
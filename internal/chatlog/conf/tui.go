@@ -5,6 +5,8 @@ type TUIConfig struct {
 	LastAccount string          `mapstructure:"last_account" json:"last_account"`
 	History     []ProcessConfig `mapstructure:"history" json:"history"`
 	Webhook     *Webhook        `mapstructure:"webhook" json:"webhook"`
+	Digest      *Digest         `mapstructure:"digest" json:"digest"`
+	Self        string          `mapstructure:"self" json:"self"` // 自己的微信 ID，为空时根据自己发送的消息识别
 }
 
 var TUIDefaults = map[string]any{}
@@ -19,6 +21,7 @@ type ProcessConfig struct {
 	DataKey     string `mapstructure:"data_key" json:"data_key"`
 	ImgKey      string `mapstructure:"img_key" json:"img_key"`
 	WorkDir     string `mapstructure:"work_dir" json:"work_dir"`
+	WorkKey     string `mapstructure:"-" json:"-"` // 不保存到配置文件，每次启动时从 CHATLOG_WORK_KEY 环境变量读取
 	HTTPEnabled bool   `mapstructure:"http_enabled" json:"http_enabled"`
 	HTTPAddr    string `mapstructure:"http_addr" json:"http_addr"`
 	LastTime    int64  `mapstructure:"last_time" json:"last_time"`
@@ -31,6 +34,19 @@ type File struct {
 	Size         int64  `mapstructure:"size" json:"size"`
 }
 
+// Redacted 返回隐藏了历史记录中密钥的配置副本，用于输出日志
+func (c *TUIConfig) Redacted() TUIConfig {
+	rc := *c
+	rc.History = make([]ProcessConfig, len(c.History))
+	for i, h := range c.History {
+		h.DataKey = Redact(h.DataKey)
+		h.ImgKey = Redact(h.ImgKey)
+		h.WorkKey = Redact(h.WorkKey)
+		rc.History[i] = h
+	}
+	return rc
+}
+
 func (c *TUIConfig) ParseHistory() map[string]ProcessConfig {
 	m := make(map[string]ProcessConfig)
 	for _, v := range c.History {
@@ -39,6 +39,7 @@ type Context struct {
 
 	// 工作目录相关状态
 	WorkDir   string
+	WorkKey   string
 	WorkUsage string
 
 	// HTTP服务相关状态
@@ -99,6 +100,7 @@ func (c *Context) SwitchHistory(account string) {
 		c.ImgKey = history.ImgKey
 		c.DataDir = history.DataDir
 		c.WorkDir = history.WorkDir
+		c.WorkKey = os.Getenv(conf.EnvWorkKey)
 		c.HTTPEnabled = history.HTTPEnabled
 		c.HTTPAddr = history.HTTPAddr
 	} else {
@@ -110,6 +112,7 @@ func (c *Context) SwitchHistory(account string) {
 		c.ImgKey = ""
 		c.DataDir = ""
 		c.WorkDir = ""
+		c.WorkKey = os.Getenv(conf.EnvWorkKey)
 		c.HTTPEnabled = false
 		c.HTTPAddr = ""
 	}
@@ -162,6 +165,10 @@ func (c *Context) GetWorkDir() string {
 	return c.WorkDir
 }
 
+func (c *Context) GetWorkKey() string {
+	return c.WorkKey
+}
+
 func (c *Context) GetPlatform() string {
 	return c.Platform
 }
@@ -185,6 +192,14 @@ func (c *Context) GetWebhook() *conf.Webhook {
 	return c.conf.Webhook
 }
 
+func (c *Context) GetDigest() *conf.Digest {
+	return c.conf.Digest
+}
+
+func (c *Context) GetSelf() string {
+	return c.conf.Self
+}
+
 func (c *Context) SetHTTPEnabled(enabled bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// mentionsReadFile 记录提及消息已读位置的文件
+const mentionsReadFile = "mentions.json"
+
+// GetMentions 获取提及我的消息，unread 为 true 时仅返回未读消息
+func (s *Service) GetMentions(start, end time.Time, talker string, unread bool, limit, offset int) (*model.MentionInbox, error) {
+	mentions, err := s.db.GetMentions(start, end, talker, s.self())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	read := s.loadMentionsRead()
+	s.mutex.Unlock()
+
+	inbox := &model.MentionInbox{
+		StartTime: start,
+		EndTime:   end,
+		Items:     make([]*model.Mention, 0),
+	}
+	for _, m := range mentions {
+		m.Unread = !read.isRead(m)
+		if m.Unread {
+			inbox.Unread++
+		} else if unread {
+			continue
+		}
+		inbox.Items = append(inbox.Items, m)
+	}
+	inbox.Total = len(inbox.Items)
+
+	if offset > 0 {
+		inbox.Items = inbox.Items[min(offset, len(inbox.Items)):]
+	}
+	if limit > 0 && limit < len(inbox.Items) {
+		inbox.Items = inbox.Items[:limit]
+	}
+	return inbox, nil
+}
+
+// MarkMentionsRead 将 until 及之前的提及消息标记为已读
+// talker 为空时标记全部群聊，多个群聊以英文逗号分隔；until 为零值时标记截至当前的全部消息
+func (s *Service) MarkMentionsRead(talker string, until time.Time) error {
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	read := s.loadMentionsRead()
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		talkers = []string{""}
+	}
+	for _, t := range talkers {
+		if t != "" {
+			if chatRoom, err := s.db.GetChatRoom(t); err == nil {
+				t = chatRoom.Name
+			}
+		}
+		if until.After(read.Until[t]) {
+			read.Until[t] = until
+		}
+	}
+	return s.saveMentionsRead(read)
+}
+
+// MarkMentionItemsRead 将指定的提及消息标记为已读，不影响同一时间范围内的其他消息
+func (s *Service) MarkMentionItemsRead(items []*model.Mention) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	read := s.loadMentionsRead()
+	for _, m := range items {
+		if !read.isRead(m) {
+			read.Items[m.Talker] = append(read.Items[m.Talker], mentionKey(m.Time.Unix(), m.Seq))
+		}
+	}
+	return s.saveMentionsRead(read)
+}
+
+// mentionsRead 提及消息的已读状态
+// Until 为各群聊的已读位置，空字符串表示全部群聊，该时间及之前的消息均为已读；
+// Items 为已读位置之后单独标记为已读的消息，按群聊记录消息的 "时间.seq"
+type mentionsRead struct {
+	Until map[string]time.Time `json:"until"`
+	Items map[string][]string  `json:"items,omitempty"`
+}
+
+func mentionKey(unix int64, seq int64) string {
+	return fmt.Sprintf("%d.%d", unix, seq)
+}
+
+func (r *mentionsRead) isRead(m *model.Mention) bool {
+	if !m.Time.After(r.Until[""]) || !m.Time.After(r.Until[m.Talker]) {
+		return true
+	}
+	return slices.Contains(r.Items[m.Talker], mentionKey(m.Time.Unix(), m.Seq))
+}
+
+// prune 移除已被已读位置覆盖的单独标记
+func (r *mentionsRead) prune() {
+	for talker, keys := range r.Items {
+		keys = slices.DeleteFunc(keys, func(key string) bool {
+			sec, _, _ := strings.Cut(key, ".")
+			unix, err := strconv.ParseInt(sec, 10, 64)
+			if err != nil {
+				return true
+			}
+			t := time.Unix(unix, 0)
+			return !t.After(r.Until[""]) || !t.After(r.Until[talker])
+		})
+		if len(keys) == 0 {
+			delete(r.Items, talker)
+			continue
+		}
+		r.Items[talker] = keys
+	}
+}
+
+// loadMentionsRead 读取提及消息的已读状态，兼容只记录各群聊已读位置的旧格式
+func (s *Service) loadMentionsRead() *mentionsRead {
+	read := &mentionsRead{}
+	b, err := os.ReadFile(filepath.Join(s.conf.GetWorkDir(), mentionsReadFile))
+	if err == nil {
+		json.Unmarshal(b, read)
+		if read.Until == nil {
+			json.Unmarshal(b, &read.Until)
+		}
+	}
+	if read.Until == nil {
+		read.Until = make(map[string]time.Time)
+	}
+	if read.Items == nil {
+		read.Items = make(map[string][]string)
+	}
+	return read
+}
+
+func (s *Service) saveMentionsRead(read *mentionsRead) error {
+	read.prune()
+	b, _ := json.MarshalIndent(read, "", "  ")
+	return os.WriteFile(filepath.Join(s.conf.GetWorkDir(), mentionsReadFile), b, 0644)
+}
@@ -2,11 +2,14 @@ package database
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/digest"
 	"github.com/sjzar/chatlog/internal/chatlog/webhook"
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/internal/wechatdb"
@@ -26,30 +29,44 @@ type Service struct {
 	db            *wechatdb.DB
 	webhook       *webhook.Service
 	webhookCancel context.CancelFunc
+	digest        *digest.Service
+	digestCancel  context.CancelFunc
+
+	// 外部注册的文件变更回调，数据库重新打开时需要重新注册
+	callbacks map[string][]func(event fsnotify.Event) error
+	mutex     sync.Mutex
+
+	seg segmenterCache
 }
 
 type Config interface {
 	GetWorkDir() string
 	GetPlatform() string
 	GetVersion() int
+	GetWorkKey() string
 	GetWebhook() *conf.Webhook
+	GetDigest() *conf.Digest
+	GetSelf() string
 }
 
 func NewService(conf Config) *Service {
 	return &Service{
-		conf:    conf,
-		webhook: webhook.New(conf),
+		conf:      conf,
+		webhook:   webhook.New(conf),
+		digest:    digest.New(conf),
+		callbacks: make(map[string][]func(event fsnotify.Event) error),
 	}
 }
 
 func (s *Service) Start() error {
-	db, err := wechatdb.New(s.conf.GetWorkDir(), s.conf.GetPlatform(), s.conf.GetVersion())
+	db, err := wechatdb.New(s.conf.GetWorkDir(), s.conf.GetPlatform(), s.conf.GetVersion(), s.conf.GetWorkKey())
 	if err != nil {
 		return err
 	}
 	s.SetReady()
 	s.db = db
 	s.initWebhook()
+	s.initCallbacks()
 	return nil
 }
 
@@ -63,6 +80,10 @@ func (s *Service) Stop() error {
 		s.webhookCancel()
 		s.webhookCancel = nil
 	}
+	if s.digestCancel != nil {
+		s.digestCancel()
+		s.digestCancel = nil
+	}
 	return nil
 }
 
@@ -91,10 +112,55 @@ func (s *Service) GetMessages(start, end time.Time, talker string, sender string
 	return s.db.GetMessages(start, end, talker, sender, keyword, limit, offset)
 }
 
+func (s *Service) GetStats(start, end time.Time, talker string) (*model.Stats, error) {
+	return s.db.GetStats(start, end, talker)
+}
+
+func (s *Service) GetChatRoomReport(start, end time.Time, key string) (*model.ChatRoomReport, error) {
+	return s.db.GetChatRoomReport(start, end, key)
+}
+
+func (s *Service) GetGraph(start, end time.Time, talker string) (*model.Graph, error) {
+	return s.db.GetGraph(start, end, talker)
+}
+
+func (s *Service) GetDigest(start, end time.Time, talker string, self string) (*model.Digest, error) {
+	return s.db.GetDigest(start, end, talker, self)
+}
+
+func (s *Service) GetCatalog(start, end time.Time, talker, kind, keyword string, limit, offset int) (*model.Catalog, error) {
+	return s.db.GetCatalog(start, end, talker, kind, keyword, limit, offset)
+}
+
+func (s *Service) GetPayments(start, end time.Time, talker string) (*model.PaymentLedger, error) {
+	return s.db.GetPayments(start, end, talker)
+}
+
+func (s *Service) GetUnanswered(start, end time.Time, talker string, window time.Duration) ([]*model.Unanswered, error) {
+	return s.db.GetUnanswered(start, end, talker, s.self(), window)
+}
+
+func (s *Service) GetTopics(start, end time.Time, talker string, limit int) (*model.TopicReport, error) {
+	return s.db.GetTopics(start, end, talker, limit, s.segmenter())
+}
+
+// self 返回配置中自己的微信 ID，未配置时由数据层根据自己发送的消息识别
+func (s *Service) self() string {
+	return s.conf.GetSelf()
+}
+
 func (s *Service) GetContacts(key string, limit, offset int) (*wechatdb.GetContactsResp, error) {
 	return s.db.GetContacts(key, limit, offset)
 }
 
+func (s *Service) GetContact(key string) (*model.Contact, error) {
+	return s.db.GetContact(key)
+}
+
+func (s *Service) GetChatRoom(key string) (*model.ChatRoom, error) {
+	return s.db.GetChatRoom(key)
+}
+
 func (s *Service) GetChatRooms(key string, limit, offset int) (*wechatdb.GetChatRoomsResp, error) {
 	return s.db.GetChatRooms(key, limit, offset)
 }
@@ -125,6 +191,44 @@ func (s *Service) initWebhook() error {
 	return nil
 }
 
+// StartDigest 启动定时摘要，仅由常驻的服务调用，一次性命令不生成摘要
+// 数据库重新打开后需要重新调用
+func (s *Service) StartDigest() {
+	if s.digest == nil || s.db == nil {
+		return
+	}
+	if s.digestCancel != nil {
+		s.digestCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.digestCancel = cancel
+	s.digest.Run(ctx, s.db)
+}
+
+// AddCallback 注册数据库文件变更回调，服务重启后自动重新注册
+func (s *Service) AddCallback(group string, callback func(event fsnotify.Event) error) error {
+	s.mutex.Lock()
+	s.callbacks[group] = append(s.callbacks[group], callback)
+	s.mutex.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	return s.db.SetCallback(group, callback)
+}
+
+func (s *Service) initCallbacks() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for group, callbacks := range s.callbacks {
+		for _, callback := range callbacks {
+			if err := s.db.SetCallback(group, callback); err != nil {
+				log.Error().Err(err).Msgf("set callback for group %s failed", group)
+			}
+		}
+	}
+}
+
 // Close closes the database connection
 func (s *Service) Close() {
 	// Add cleanup code if needed
@@ -133,4 +237,8 @@ func (s *Service) Close() {
 		s.webhookCancel()
 		s.webhookCancel = nil
 	}
+	if s.digestCancel != nil {
+		s.digestCancel()
+		s.digestCancel = nil
+	}
 }
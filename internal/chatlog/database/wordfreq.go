@@ -0,0 +1,82 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/nlp"
+)
+
+const (
+	// stopwordsFile 工作目录中自定义停用词的文件，每行一个或多个词，以 # 开头的行为注释
+	stopwordsFile = "stopwords.txt"
+
+	// dictFile 工作目录中自定义分词词典的文件，格式与 jieba 的 dict.txt 一致，可以直接使用 jieba 的完整词典
+	dictFile = "dict.txt"
+)
+
+// segmenterCache 缓存加入了自定义词典和停用词的分词器，文件变化时重新加载
+type segmenterCache struct {
+	mutex sync.Mutex
+	seg   *nlp.Segmenter
+	key   segmenterKey
+}
+
+type segmenterKey struct {
+	dir  string
+	mods [2]time.Time
+}
+
+// GetWordFreq 统计消息中出现次数最多的词或词组，消息筛选条件与 GetMessages 一致
+func (s *Service) GetWordFreq(start, end time.Time, talker string, sender string, keyword string, ngram, limit int) (*model.WordFreq, error) {
+	messages, err := s.db.GetMessages(start, end, talker, sender, keyword, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewWordFreq(start, end, talker, sender, messages, s.segmenter(), ngram, limit), nil
+}
+
+// segmenter 返回加入了工作目录中自定义词典和停用词的分词器
+func (s *Service) segmenter() *nlp.Segmenter {
+	s.seg.mutex.Lock()
+	defer s.seg.mutex.Unlock()
+
+	dir := s.conf.GetWorkDir()
+	files := [2]string{filepath.Join(dir, dictFile), filepath.Join(dir, stopwordsFile)}
+	key := segmenterKey{dir: dir}
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			key.mods[i] = info.ModTime()
+		}
+	}
+	if s.seg.seg != nil && s.seg.key == key {
+		return s.seg.seg
+	}
+
+	seg := nlp.Default()
+	if b, ok := readWorkFile(files[0]); ok {
+		seg = seg.WithDict(nlp.ParseDict(string(b)))
+	}
+	if b, ok := readWorkFile(files[1]); ok {
+		seg = seg.WithStopwords(nlp.ParseWordList(string(b)))
+	}
+	s.seg.seg, s.seg.key = seg, key
+	return seg
+}
+
+// readWorkFile 读取工作目录中的可选文件，文件不存在时返回 false
+func readWorkFile(path string) ([]byte, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debug().Err(err).Msgf("read %s failed", path)
+		}
+		return nil, false
+	}
+	return b, true
+}
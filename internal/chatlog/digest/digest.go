@@ -0,0 +1,262 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/webhook"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	ScheduleDaily  = "daily"
+	ScheduleWeekly = "weekly"
+
+	// DefaultAt 默认生成时间
+	DefaultAt = "09:00"
+
+	// checkInterval 检查摘要是否到期的间隔
+	checkInterval = time.Minute
+
+	// stateFile 记录各摘要上次生成时间的文件
+	stateFile = "state.json"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+type Config interface {
+	GetWorkDir() string
+	GetDigest() *conf.Digest
+	GetSelf() string
+}
+
+type Service struct {
+	workDir string
+	self    string
+	items   []*item
+	client  *http.Client
+
+	// 各摘要上次生成的时间，持久化到工作目录
+	state map[string]time.Time
+	mutex sync.Mutex
+}
+
+type item struct {
+	conf    *conf.DigestItem
+	hour    int
+	minute  int
+	weekday time.Weekday
+}
+
+func New(config Config) *Service {
+	s := &Service{
+		workDir: config.GetWorkDir(),
+		self:    config.GetSelf(),
+		client:  &http.Client{Timeout: time.Second * 10},
+		state:   make(map[string]time.Time),
+	}
+
+	c := config.GetDigest()
+	if c == nil {
+		return s
+	}
+
+	names := make(map[string]bool)
+	for i, conf := range c.Items {
+		if conf.Disabled {
+			continue
+		}
+		if conf.Name == "" {
+			conf.Name = fmt.Sprintf("digest%d", i+1)
+		}
+		if names[conf.Name] {
+			log.Error().Msgf("duplicate digest name: %s", conf.Name)
+			continue
+		}
+		it, err := newItem(conf)
+		if err != nil {
+			log.Error().Err(err).Msgf("invalid digest %s", conf.Name)
+			continue
+		}
+		names[conf.Name] = true
+		s.items = append(s.items, it)
+	}
+
+	return s
+}
+
+func newItem(conf *conf.DigestItem) (*item, error) {
+	if conf.Schedule == "" {
+		conf.Schedule = ScheduleDaily
+	}
+	if conf.Schedule != ScheduleDaily && conf.Schedule != ScheduleWeekly {
+		return nil, fmt.Errorf("unknown schedule: %s", conf.Schedule)
+	}
+
+	at := conf.At
+	if at == "" {
+		at = DefaultAt
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %s", at)
+	}
+
+	it := &item{conf: conf, hour: t.Hour(), minute: t.Minute(), weekday: time.Monday}
+	if conf.Schedule == ScheduleWeekly && conf.Weekday != "" {
+		weekday, ok := weekdays[strings.ToLower(conf.Weekday)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday: %s", conf.Weekday)
+		}
+		it.weekday = weekday
+	}
+	return it, nil
+}
+
+// lastSchedule 返回 now 之前最近一次的计划生成时间
+func (it *item) lastSchedule(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), it.hour, it.minute, 0, 0, now.Location())
+	if it.conf.Schedule == ScheduleWeekly {
+		t = t.AddDate(0, 0, -((int(t.Weekday()) - int(it.weekday) + 7) % 7))
+		if t.After(now) {
+			t = t.AddDate(0, 0, -7)
+		}
+		return t
+	}
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// Run 启动定时任务，直到 ctx 结束
+func (s *Service) Run(ctx context.Context, db *wechatdb.DB) {
+	if len(s.items) == 0 {
+		return
+	}
+	s.loadState()
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			s.check(ctx, db, time.Now())
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *Service) check(ctx context.Context, db *wechatdb.DB, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := false
+	for _, it := range s.items {
+		if ctx.Err() != nil {
+			return
+		}
+		schedule := it.lastSchedule(now)
+		last, ok := s.state[it.conf.Name]
+		if !ok {
+			// 首次运行时仅记录起始时间，从下一个周期开始生成摘要
+			s.state[it.conf.Name] = schedule
+			changed = true
+			continue
+		}
+		if !last.Before(schedule) {
+			continue
+		}
+
+		if err := s.generate(db, it, last, now); err != nil {
+			log.Error().Err(err).Msgf("generate digest %s failed", it.conf.Name)
+			continue
+		}
+		s.state[it.conf.Name] = now
+		changed = true
+	}
+
+	if changed {
+		s.saveState()
+	}
+}
+
+func (s *Service) generate(db *wechatdb.DB, it *item, start, end time.Time) error {
+	digest, err := db.GetDigest(start, end, it.conf.Talker, s.self)
+	if err != nil {
+		return err
+	}
+	digest.Name = it.conf.Name
+	if digest.Total == 0 {
+		log.Info().Msgf("digest %s has no new messages", it.conf.Name)
+		return nil
+	}
+
+	markdown := digest.Markdown()
+	if it.conf.URL != "" {
+		body, _ := json.Marshal(map[string]any{
+			"name":      digest.Name,
+			"startTime": digest.StartTime.Format(time.DateTime),
+			"endTime":   digest.EndTime.Format(time.DateTime),
+			"markdown":  markdown,
+			"digest":    digest,
+		})
+		log.Info().Msgf("post digest %s to %s", it.conf.Name, it.conf.URL)
+		return webhook.Post(s.client, it.conf.URL, body)
+	}
+
+	dir := filepath.Join(s.workDir, "digest")
+	if err := util.PrepareDir(dir); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.md", it.conf.Name, end.Format("20060102_1504")))
+	log.Info().Msgf("write digest %s to %s", it.conf.Name, path)
+	return os.WriteFile(path, []byte(markdown), 0644)
+}
+
+func (s *Service) loadState() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := os.ReadFile(filepath.Join(s.workDir, "digest", stateFile))
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		log.Error().Err(err).Msg("load digest state failed")
+	}
+}
+
+func (s *Service) saveState() {
+	dir := filepath.Join(s.workDir, "digest")
+	if err := util.PrepareDir(dir); err != nil {
+		log.Error().Err(err).Msg("save digest state failed")
+		return
+	}
+	b, _ := json.MarshalIndent(s.state, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, stateFile), b, 0644); err != nil {
+		log.Error().Err(err).Msg("save digest state failed")
+	}
+}
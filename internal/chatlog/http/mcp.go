@@ -1,9 +1,10 @@
 package http
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,17 +14,46 @@ import (
 
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/thumbnail"
 	"github.com/sjzar/chatlog/pkg/version"
 )
 
+const (
+	// MCPImageMaxSize get_media 返回图片的默认最长边像素
+	MCPImageMaxSize = 1024
+
+	// MCPInlineImageMaxSize query_chat_log 内联图片的最长边像素
+	MCPInlineImageMaxSize = 512
+
+	// MCPInlineImageLimit query_chat_log 单次最多内联的图片数量
+	MCPInlineImageLimit = 10
+
+	// ChatRoomReportDefaultTime 群聊报告的默认时间范围
+	ChatRoomReportDefaultTime = "last-1m"
+)
+
 func (s *Service) initMCPServer() {
-	s.mcpServer = server.NewMCPServer(conf.AppName, version.Version)
+	s.mcpServer = server.NewMCPServer(conf.AppName, version.Version,
+		server.WithResourceCapabilities(true, false),
+		server.WithPromptCapabilities(false),
+		server.WithHooks(s.mcpHooks()),
+	)
 	s.mcpServer.AddTool(ContactTool, s.handleMCPContact)
 	s.mcpServer.AddTool(ChatRoomTool, s.handleMCPChatRoom)
 	s.mcpServer.AddTool(RecentChatTool, s.handleMCPRecentChat)
 	s.mcpServer.AddTool(ChatLogTool, s.handleMCPChatLog)
+	s.mcpServer.AddTool(StatsTool, s.handleMCPStats)
+	s.mcpServer.AddTool(ChatRoomReportTool, s.handleMCPChatRoomReport)
+	s.mcpServer.AddTool(CatalogTool, s.handleMCPCatalog)
+	s.mcpServer.AddTool(MentionsTool, s.handleMCPMentions)
+	s.mcpServer.AddTool(UnansweredTool, s.handleMCPUnanswered)
+	s.mcpServer.AddTool(TopicsTool, s.handleMCPTopics)
 	s.mcpServer.AddTool(CurrentTimeTool, s.handleMCPCurrentTime)
+	s.mcpServer.AddTool(MediaTool, s.handleMCPMediaTool)
+	s.initMCPResources()
+	s.initMCPPrompts()
 	s.mcpSSEServer = server.NewSSEServer(s.mcpServer)
 	s.mcpStreamableServer = server.NewStreamableHTTPServer(s.mcpServer)
 }
@@ -32,77 +62,34 @@ var ContactTool = mcp.NewTool(
 	"query_contact",
 	mcp.WithDescription(`查询用户的联系人信息。可以通过姓名、备注名或ID进行查询，返回匹配的联系人列表。当用户询问某人的联系方式、想了解联系人信息或需要查找特定联系人时使用此工具。参数为空时，将返回联系人列表`),
 	mcp.WithString("keyword", mcp.Description("联系人的搜索关键词，可以是姓名、备注名或ID。")),
+	maxCharsOption,
+	cursorOption,
 )
 
 var ChatRoomTool = mcp.NewTool(
 	"query_chat_room",
 	mcp.WithDescription(`查询用户参与的群聊信息。可以通过群名称、群ID或相关关键词进行查询，返回匹配的群聊列表。当用户询问群聊信息、想了解某个群的详情或需要查找特定群聊时使用此工具。`),
 	mcp.WithString("keyword", mcp.Description("群聊的搜索关键词，可以是群名称、群ID或相关描述")),
+	maxCharsOption,
+	cursorOption,
 )
 
 var RecentChatTool = mcp.NewTool(
 	"query_recent_chat",
 	mcp.WithDescription(`查询最近会话列表，包括个人聊天和群聊。当用户想了解最近的聊天记录、查看最近联系过的人或群组时使用此工具。不需要参数，直接返回最近的会话列表。`),
+	maxCharsOption,
+	cursorOption,
 )
 
 var ChatLogTool = mcp.NewTool(
 	"query_chat_log",
 	mcp.WithDescription(`检索历史聊天记录，可根据时间、对话方、发送者和关键词等条件进行精确查询。当用户需要查找特定信息或想了解与某人/某群的历史交流时使用此工具。
-
-【强制多步查询流程!】
-当查询特定话题或特定发送者发言时，必须严格按照以下流程使用，任何偏离都会导致错误的结果：
-
-步骤1: 初步定位相关消息
-- 使用keyword参数查找特定话题
-- 使用sender参数查找特定发送者的消息
-- 使用较宽时间范围初步查询
-
-步骤2: 【必须执行】针对每个关键结果点分别获取上下文
-- 必须对步骤1返回的每个时间点T1, T2, T3...分别执行独立查询（时间范围接近的消息可以合并为一个查询）
-- 每次独立查询必须移除keyword参数
-- 每次独立查询必须移除sender参数
-- 每次独立查询使用"Tn前后15-30分钟"的窄范围
-- 每次独立查询仅保留talker参数
-
-步骤3: 【必须执行】综合分析所有上下文
-- 必须等待所有步骤2的查询结果返回后再进行分析
-- 必须综合考虑所有上下文信息后再回答用户
-
-【严格执行规则！】
-- 禁止仅凭步骤1的结果直接回答用户
-- 禁止在步骤2使用过大的时间范围一次性查询所有上下文
-- 禁止跳过步骤2或步骤3
-- 必须对每个关键结果点分别执行独立的上下文查询
-
-【执行示例】
-正确流程示例:
-1. 步骤1: chatlog(time="2023-04-01~2023-04-30", talker="工作群", keyword="项目进度")
-返回结果: 4月5日、4月12日、4月20日有相关消息
-2. 步骤2:
-- 查询1: chatlog(time="2023-04-05/09:30~2023-04-05/10:30", talker="工作群") // 注意没有keyword
-- 查询2: chatlog(time="2023-04-12/14:00~2023-04-12/15:00", talker="工作群") // 注意没有keyword
-- 查询3: chatlog(time="2023-04-20/16:00~2023-04-20/17:00", talker="工作群") // 注意没有keyword
-3. 步骤3: 综合分析所有上下文后回答用户
-
-错误流程示例:
-- 仅执行步骤1后直接回答
-- 步骤2使用time="2023-04-01~2023-04-30"一次性查询
-- 步骤2仍然保留keyword或sender参数
-
-【自我检查】回答用户前必须自问:
-- 我是否对每个关键时间点都执行了独立的上下文查询?
-- 我是否在上下文查询中移除了keyword和sender参数?
-- 我是否分析了所有上下文后再回答?
-- 如果上述任一问题答案为"否"，则必须纠正流程
+使用keyword或sender定位到相关消息后，应去掉这两个参数，按命中时间前后15-30分钟分别查询完整上下文后再回答。
+常见的总结、待办整理、话题追踪、周报等场景，可直接使用 summarize_chat、action_items、sender_topic、weekly_report 提示词。
 
 返回格式："昵称(ID) 时间\n消息内容\n昵称(ID) 时间\n消息内容"
 当查询多个Talker时，返回格式为："昵称(ID)\n[TalkerName(Talker)] 时间\n消息内容"
-
-重要提示：
-1. 当用户询问特定时间段内的聊天记录时，必须使用正确的时间格式，特别是包含小时和分钟的查询
-2. 对于"今天下午4点到5点聊了啥"这类查询，正确的时间参数格式应为"2023-04-18/16:00~2023-04-18/17:00"
-3. 当用户询问具体群聊中某人的聊天记录时，使用"sender"参数
-4. 当用户询问包含特定关键词的聊天记录时，使用"keyword"参数`),
+结果过多时会被截断，末尾会提示省略的消息数量和用于继续查询的cursor。`),
 	mcp.WithString("time", mcp.Description(`指定查询的时间点或时间范围，格式必须严格遵循以下规则：
 
 【单一时间点格式】
@@ -123,24 +110,82 @@ var ChatLogTool = mcp.NewTool(
 - 月份："2023-04"或"202304"`), mcp.Required()),
 	mcp.WithString("talker", mcp.Description(`指定对话方（联系人或群组）
 - 可使用ID、昵称或备注名
-- 多个对话方用","分隔，如："张三,李四,工作群"
-- 【重要】这是多步查询中唯一应保留的参数`), mcp.Required()),
+- 多个对话方用","分隔，如："张三,李四,工作群"`), mcp.Required()),
 	mcp.WithString("sender", mcp.Description(`指定群聊中的发送者
 - 仅在查询群聊记录时有效
 - 多个发送者用","分隔，如："张三,李四"
-- 可使用ID、昵称或备注名
-【重要】查询特定发送者的消息时：
-1. 第一步：使用sender参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除sender参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带sender）`)),
-	mcp.WithString("keyword", mcp.Description(`搜索内容中的关键词
-- 支持正则表达式匹配
-- 【重要】查询特定话题时：
-1. 第一步：使用keyword参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除keyword参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的关键词消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带keyword）`)),
+- 可使用ID、昵称或备注名`)),
+	mcp.WithString("keyword", mcp.Description(`搜索内容中的关键词，支持正则表达式匹配`)),
+	mcp.WithNumber("max_messages", mcp.Description(fmt.Sprintf(`返回消息的数量上限，超出部分会被截断并返回 cursor，默认 %d`, MCPDefaultMaxMessages))),
+	maxCharsOption,
+	cursorOption,
+	mcp.WithBoolean("inline_images", mcp.Description(fmt.Sprintf(`是否在结果中直接附带图片内容，最多附带 %d 张缩略图，默认不附带`, MCPInlineImageLimit))),
+)
+
+var StatsTool = mcp.NewTool(
+	"query_chat_stats",
+	mcp.WithDescription(`统计指定对话在一段时间内的聊天数据，包括各发送者的消息数量、消息类型分布、每小时和每日的消息数量、首末条消息时间、图片/视频/语音/文件数量，以及我和对方的回复时间分布。
+当用户询问"谁最活跃"、"平时几点聊天"、"我回复得快不快"等统计类问题时使用此工具，无需逐条查询聊天记录。`),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`时间范围，格式与 query_chat_log 工具一致，如 "2023-04-18"、"2023-04-01~2023-04-18"、"last-7d"，默认为 %s`, StatsDefaultTime))),
+	mcp.WithString("talker", mcp.Description(`对话方（联系人或群组），可使用ID、昵称或备注名，仅支持单个对话方`), mcp.Required()),
+	maxCharsOption,
+	cursorOption,
+)
+
+var ChatRoomReportTool = mcp.NewTool(
+	"query_chat_room_report",
+	mcp.WithDescription(`生成群聊成员活跃度报告，包括每个成员的发言数量和最后发言时间、从未发言的成员、成员加入和移出记录，以及成员被@和@他人的次数。
+当用户询问"群里谁最活跃"、"哪些人从来不说话"、"最近谁进群了"等问题时使用此工具。`),
+	mcp.WithString("talker", mcp.Description(`群聊，可使用群ID、群名称或备注名`), mcp.Required()),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`时间范围，格式与 query_chat_log 工具一致，默认为 %s`, ChatRoomReportDefaultTime))),
+	maxCharsOption,
+	cursorOption,
+)
+
+var CatalogTool = mcp.NewTool(
+	"query_shared_links",
+	mcp.WithDescription(`查询聊天中分享过的链接、文件、小程序和视频号，重复分享的内容会合并，并列出每次分享的发送者、对话方和时间。
+当用户询问"上个月谁在群里发过那个 PDF"、"之前分享的某篇文章链接"等问题时使用此工具，无需逐条查询聊天记录。`),
+	mcp.WithString("keyword", mcp.Description(`关键词，匹配标题、描述、链接、发送者和对话方名称`)),
+	mcp.WithString("kind", mcp.Description(`类型，为空时查询全部类型`), mcp.Enum(model.CatalogKinds...)),
+	mcp.WithString("talker", mcp.Description(`对话方（联系人或群组），可使用ID、昵称或备注名，多个以英文逗号分隔，为空时查询全部会话`)),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`时间范围，格式与 query_chat_log 工具一致，默认为 %s`, CatalogDefaultTime))),
+	maxCharsOption,
+	cursorOption,
+)
+
+var MentionsTool = mcp.NewTool(
+	"query_mentions",
+	mcp.WithDescription(`查询群聊中 @我、@所有人 以及引用我的消息，按时间倒序排列，并标记是否未读。
+当用户询问"有没有人 @我"、"群里有什么需要我处理的消息"等问题时使用此工具。`),
+	mcp.WithString("talker", mcp.Description(`群聊，可使用群ID、群名称或备注名，多个以英文逗号分隔，为空时查询全部群聊`)),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`时间范围，格式与 query_chat_log 工具一致，默认为 %s`, MentionsDefaultTime))),
+	mcp.WithBoolean("unread", mcp.Description(`是否仅返回未读消息`)),
+	mcp.WithBoolean("mark_read", mcp.Description(`是否在返回后将本页返回的消息标记为已读，被截断的消息不会标记`)),
+	maxCharsOption,
+	cursorOption,
+)
+
+var UnansweredTool = mcp.NewTool(
+	"query_unanswered",
+	mcp.WithDescription(`查找尚未回复的对话：私聊中对方在我最后一次发言之后发送的消息，以及群聊中 @我 或引用我的消息，按等待时间从长到短排列。
+当用户询问"我有哪些消息还没回"、"谁在等我回复"等问题时使用此工具。`),
+	mcp.WithString("talker", mcp.Description(`对话方（联系人或群组），可使用ID、昵称或备注名，多个以英文逗号分隔，为空时查询全部会话`)),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`检查的时间范围，格式与 query_chat_log 工具一致，默认为 %s`, UnansweredDefaultTime))),
+	mcp.WithString("window", mcp.Description(fmt.Sprintf(`超过该时长未回复才列出，如 30m、2h、1d，默认为 %s`, UnansweredDefaultWindow))),
+	maxCharsOption,
+	cursorOption,
+)
+
+var TopicsTool = mcp.NewTool(
+	"query_topics",
+	mcp.WithDescription(`在本地分析对话的关键词、热词和话题：对消息分词后按 TF-IDF 提取关键词，与上一个等长时间范围对比得出热词，并将相似的对话段聚合为话题，附带示例消息。
+当用户询问"这周群里都在聊什么"、"最近的热门话题"、"某人最近常提到什么"等问题时使用此工具。`),
+	mcp.WithString("talker", mcp.Description(`对话方（联系人或群组），可使用ID、昵称或备注名，多个以英文逗号分隔，为空时分析消息较多的全部会话`)),
+	mcp.WithString("time", mcp.Description(fmt.Sprintf(`分析的时间范围，格式与 query_chat_log 工具一致，默认为 %s`, TopicsDefaultTime))),
+	mcp.WithNumber("limit", mcp.Description(fmt.Sprintf(`每个对话返回的关键词、热词和话题数量，默认为 %d`, TopicsDefaultLimit))),
+	maxCharsOption,
+	cursorOption,
 )
 
 var CurrentTimeTool = mcp.NewTool(
@@ -154,10 +199,20 @@ var CurrentTimeTool = mcp.NewTool(
 注意：此工具不需要任何输入参数，直接调用即可获取当前时间。`),
 )
 
+var MediaTool = mcp.NewTool(
+	"get_media",
+	mcp.WithDescription(`获取聊天记录中的图片或语音内容。query_chat_log 返回的 "![图片](http://host/image/KEY)" 和 "[语音](http://host/voice/KEY)" 链接无法直接访问时使用此工具，图片以图片内容返回，语音转换为 mp3 音频返回。`),
+	mcp.WithString("type", mcp.Description(`媒体类型，image 或 voice，对应链接中 host 后的第一段路径`), mcp.Enum("image", "voice"), mcp.Required()),
+	mcp.WithString("key", mcp.Description(`媒体 key，即链接中媒体类型后的部分，多个候选 key 用","分隔`), mcp.Required()),
+	mcp.WithNumber("max_size", mcp.Description(fmt.Sprintf(`图片最长边的像素上限，超过时等比缩小，默认 %d`, MCPImageMaxSize))),
+)
+
 type ContactRequest struct {
-	Keyword string `json:"keyword"`
-	Limit   int    `json:"limit"`
-	Offset  int    `json:"offset"`
+	Keyword  string `json:"keyword"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
 }
 
 func (s *Service) handleMCPContact(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -168,30 +223,39 @@ func (s *Service) handleMCPContact(ctx context.Context, request mcp.CallToolRequ
 		return errors.ErrMCPTool(err), nil
 	}
 
-	list, err := s.db.GetContacts(req.Keyword, req.Limit, req.Offset)
+	offset, err := decodeCursor(req.Cursor, req.Offset)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	list, err := s.db.GetContacts(req.Keyword, req.Limit, offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get contacts")
 		return errors.ErrMCPTool(err), nil
 	}
-	buf := &bytes.Buffer{}
-	buf.WriteString("UserName,Alias,Remark,NickName\n")
+	budget := newOutputBudget(req.MaxChars, 0)
+	budget.WriteHeader("UserName,Alias,Remark,NickName\n")
 	for _, contact := range list.Items {
-		buf.WriteString(fmt.Sprintf("%s,%s,%s,%s\n", contact.UserName, contact.Alias, contact.Remark, contact.NickName))
+		if !budget.Write(fmt.Sprintf("%s,%s,%s,%s\n", contact.UserName, contact.Alias, contact.Remark, contact.NickName)) {
+			break
+		}
 	}
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
-				Text: buf.String(),
+				Text: budget.String(len(list.Items), offset),
 			},
 		},
 	}, nil
 }
 
 type ChatRoomRequest struct {
-	Keyword string `json:"keyword"`
-	Limit   int    `json:"limit"`
-	Offset  int    `json:"offset"`
+	Keyword  string `json:"keyword"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
 }
 
 func (s *Service) handleMCPChatRoom(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -203,30 +267,39 @@ func (s *Service) handleMCPChatRoom(ctx context.Context, request mcp.CallToolReq
 		return errors.ErrMCPTool(err), nil
 	}
 
-	list, err := s.db.GetChatRooms(req.Keyword, req.Limit, req.Offset)
+	offset, err := decodeCursor(req.Cursor, req.Offset)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	list, err := s.db.GetChatRooms(req.Keyword, req.Limit, offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get chat rooms")
 		return errors.ErrMCPTool(err), nil
 	}
-	buf := &bytes.Buffer{}
-	buf.WriteString("Name,Remark,NickName,Owner,UserCount\n")
+	budget := newOutputBudget(req.MaxChars, 0)
+	budget.WriteHeader("Name,Remark,NickName,Owner,UserCount\n")
 	for _, chatRoom := range list.Items {
-		buf.WriteString(fmt.Sprintf("%s,%s,%s,%s,%d\n", chatRoom.Name, chatRoom.Remark, chatRoom.NickName, chatRoom.Owner, len(chatRoom.Users)))
+		if !budget.Write(fmt.Sprintf("%s,%s,%s,%s,%d\n", chatRoom.Name, chatRoom.Remark, chatRoom.NickName, chatRoom.Owner, len(chatRoom.Users))) {
+			break
+		}
 	}
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
-				Text: buf.String(),
+				Text: budget.String(len(list.Items), offset),
 			},
 		},
 	}, nil
 }
 
 type RecentChatRequest struct {
-	Keyword string `json:"keyword"`
-	Limit   int    `json:"limit"`
-	Offset  int    `json:"offset"`
+	Keyword  string `json:"keyword"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
 }
 
 func (s *Service) handleMCPRecentChat(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -238,21 +311,27 @@ func (s *Service) handleMCPRecentChat(ctx context.Context, request mcp.CallToolR
 		return errors.ErrMCPTool(err), nil
 	}
 
-	data, err := s.db.GetSessions(req.Keyword, req.Limit, req.Offset)
+	offset, err := decodeCursor(req.Cursor, req.Offset)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	data, err := s.db.GetSessions(req.Keyword, req.Limit, offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get sessions")
 		return errors.ErrMCPTool(err), nil
 	}
-	buf := &bytes.Buffer{}
+	budget := newOutputBudget(req.MaxChars, 0)
 	for _, session := range data.Items {
-		buf.WriteString(session.PlainText(120))
-		buf.WriteString("\n")
+		if !budget.Write(session.PlainText(120) + "\n") {
+			break
+		}
 	}
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
-				Text: buf.String(),
+				Text: budget.String(len(data.Items), offset),
 			},
 		},
 	}, nil
@@ -266,6 +345,11 @@ type ChatLogRequest struct {
 	Limit   int    `form:"limit"`
 	Offset  int    `form:"offset"`
 	Format  string `form:"format"`
+
+	MaxMessages  int    `form:"max_messages" json:"max_messages"`
+	MaxChars     int    `form:"max_chars" json:"max_chars"`
+	Cursor       string `form:"cursor" json:"cursor"`
+	InlineImages bool   `form:"inline_images" json:"inline_images"`
 }
 
 func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -277,40 +361,430 @@ func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequ
 		return errors.ErrMCPTool(err), nil
 	}
 
-	var err error
 	start, end, ok := util.TimeRangeOf(req.Time)
 	if !ok {
-		log.Error().Err(err).Msg("Failed to get messages")
-		return errors.ErrMCPTool(err), nil
+		log.Error().Str("time", req.Time).Msg("Failed to parse time range")
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
 	}
 	if req.Limit < 0 {
 		req.Limit = 0
 	}
-
-	if req.Offset < 0 {
-		req.Offset = 0
+	if req.MaxMessages <= 0 {
+		req.MaxMessages = MCPDefaultMaxMessages
 	}
 
-	messages, err := s.db.GetMessages(start, end, req.Talker, req.Sender, req.Keyword, req.Limit, req.Offset)
+	cursor, err := decodeMessageCursor(req.Cursor)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get messages")
 		return errors.ErrMCPTool(err), nil
 	}
 
-	buf := &bytes.Buffer{}
+	// cursor 在 limit 之前生效：上一页最后一条消息所在的秒内，按分页顺序跳过已返回的消息，再从下一秒开始查询
+	queryStart, offset, limit := start, max(req.Offset, 0), req.Limit
+	var head []*model.Message
+	if cursor != nil {
+		queryStart, offset = time.Unix(cursor.Time, 0), 0
+		if queryStart.Before(start) || queryStart.After(end) {
+			return errors.ErrMCPTool(errors.InvalidArg("cursor")), nil
+		}
+		seen, err := s.db.GetMessages(queryStart, queryStart.Add(time.Second-1), req.Talker, req.Sender, req.Keyword, 0, 0)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get messages")
+			return errors.ErrMCPTool(err), nil
+		}
+		sortMessages(seen)
+		head = seen[cursor.Skip(seen):]
+		if limit > 0 && len(head) >= limit {
+			head, limit = head[:limit], -1
+		} else if limit > 0 {
+			limit -= len(head)
+		}
+		queryStart = queryStart.Add(time.Second)
+	}
+
+	messages := head
+	if limit >= 0 && !queryStart.After(end) {
+		rest, err := s.db.GetMessages(queryStart, end, req.Talker, req.Sender, req.Keyword, limit, offset)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get messages")
+			return errors.ErrMCPTool(err), nil
+		}
+		sortMessages(rest)
+		messages = append(messages, rest...)
+	}
+
+	budget := newOutputBudget(req.MaxChars, req.MaxMessages)
 	if len(messages) == 0 {
-		buf.WriteString("未找到符合查询条件的聊天记录")
+		budget.WriteHeader("未找到符合查询条件的聊天记录")
 	}
 	for _, m := range messages {
-		buf.WriteString(m.PlainText(strings.Contains(req.Talker, ","), util.PerfectTimeFormat(start, end), ""))
-		buf.WriteString("\n")
+		if !budget.Write(m.PlainText(strings.Contains(req.Talker, ","), util.PerfectTimeFormat(start, end), "") + "\n") {
+			break
+		}
+	}
+	shown := messages[:budget.Count()]
+
+	contents := []mcp.Content{
+		mcp.TextContent{
+			Type: "text",
+			Text: budget.StringWithCursor(len(messages), nextMessageCursor(cursor, shown).String()),
+		},
+	}
+	if req.InlineImages {
+		contents = append(contents, s.inlineImages(shown)...)
+	}
+
+	return &mcp.CallToolResult{
+		Content: contents,
+	}, nil
+}
+
+// inlineImages 将消息中的图片转换为缩略图内容，每张图片前附带发送者和时间说明
+func (s *Service) inlineImages(messages []*model.Message) []mcp.Content {
+	contents := make([]mcp.Content, 0)
+	count := 0
+	for _, m := range messages {
+		if count >= MCPInlineImageLimit {
+			break
+		}
+		if m.Type != model.MessageTypeImage {
+			continue
+		}
+		keys := make([]string, 0)
+		for _, k := range []string{"md5", "path", "thumbpath"} {
+			if v, ok := m.Contents[k].(string); ok && v != "" {
+				keys = append(keys, v)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		content, err := s.mediaContent("image", strings.Join(keys, ","), MCPInlineImageMaxSize)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to inline image %s", keys[0])
+			continue
+		}
+		sender := m.SenderName
+		if m.IsSelf {
+			sender = "我"
+		} else if sender == "" {
+			sender = m.Sender
+		}
+		contents = append(contents, mcp.NewTextContent(fmt.Sprintf("%s %s 发送的图片：", sender, m.Time.Format("2006-01-02 15:04:05"))), content)
+		count++
+	}
+	return contents
+}
+
+type StatsRequest struct {
+	Time     string `json:"time"`
+	Talker   string `json:"talker"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPStats(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req StatsRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = StatsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Talker == "" || strings.Contains(req.Talker, ",") {
+		return errors.ErrMCPTool(errors.InvalidArg("talker")), nil
+	}
+
+	stats, err := s.db.GetStats(start, end, req.Talker)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get stats")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	text, err := budgetBlocks(stats.TextBlocks(), req.MaxChars, req.Cursor)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
 	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
-				Text: buf.String(),
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+type ChatRoomReportRequest struct {
+	Talker   string `json:"talker"`
+	Time     string `json:"time"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPChatRoomReport(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req ChatRoomReportRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = ChatRoomReportDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Talker == "" {
+		return errors.ErrMCPTool(errors.InvalidArg("talker")), nil
+	}
+
+	report, err := s.db.GetChatRoomReport(start, end, req.Talker)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get chat room report")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	text, err := budgetBlocks(report.TextBlocks(), req.MaxChars, req.Cursor)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+type CatalogRequest struct {
+	Keyword  string `json:"keyword"`
+	Kind     string `json:"kind"`
+	Talker   string `json:"talker"`
+	Time     string `json:"time"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPCatalog(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req CatalogRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = CatalogDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Kind != "" && !slices.Contains(model.CatalogKinds, req.Kind) {
+		return errors.ErrMCPTool(errors.InvalidArg("kind")), nil
+	}
+
+	catalog, err := s.db.GetCatalog(start, end, req.Talker, req.Kind, req.Keyword, 0, 0)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get catalog")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	text, err := budgetBlocks(catalog.TextBlocks(""), req.MaxChars, req.Cursor)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+type MentionsRequest struct {
+	Talker   string `json:"talker"`
+	Time     string `json:"time"`
+	Unread   bool   `json:"unread"`
+	MarkRead bool   `json:"mark_read"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPMentions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req MentionsRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = MentionsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	offset, err := decodeCursor(req.Cursor, 0)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	inbox, err := s.db.GetMentions(start, end, req.Talker, req.Unread, 0, 0)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get mentions")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	budget := newOutputBudget(req.MaxChars, 0)
+	budget.WriteHeader(inbox.Header())
+	items := inbox.Items[min(offset, len(inbox.Items)):]
+	for _, m := range items {
+		if !budget.Write("\n" + m.PlainText()) {
+			break
+		}
+	}
+
+	// 只将本页实际返回的提及标记为已读，被截断的消息留到下一页
+	// 仅查询未读消息时，已标记的消息不会再出现在下一页的结果中，下一页从相同位置开始
+	next := offset + budget.Count()
+	if req.MarkRead {
+		if err := s.db.MarkMentionItemsRead(items[:budget.Count()]); err != nil {
+			log.Error().Err(err).Msg("Failed to mark mentions read")
+		} else if req.Unread {
+			next = offset
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: budget.StringWithCursor(len(items), encodeCursor(next)),
+			},
+		},
+	}, nil
+}
+
+type UnansweredRequest struct {
+	Talker   string `json:"talker"`
+	Time     string `json:"time"`
+	Window   string `json:"window"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPUnanswered(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req UnansweredRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = UnansweredDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Window == "" {
+		req.Window = UnansweredDefaultWindow
+	}
+	window, ok := parseWindow(req.Window)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("window")), nil
+	}
+
+	items, err := s.db.GetUnanswered(start, end, req.Talker, window)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get unanswered messages")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	text, err := budgetBlocks(model.UnansweredTextBlocks(items), req.MaxChars, req.Cursor)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+type TopicsRequest struct {
+	Talker   string `json:"talker"`
+	Time     string `json:"time"`
+	Limit    int    `json:"limit"`
+	MaxChars int    `json:"max_chars"`
+	Cursor   string `json:"cursor"`
+}
+
+func (s *Service) handleMCPTopics(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req TopicsRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = TopicsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Limit <= 0 {
+		req.Limit = TopicsDefaultLimit
+	}
+
+	report, err := s.db.GetTopics(start, end, req.Talker, req.Limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get topics")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	text, err := budgetBlocks(report.TextBlocks(), req.MaxChars, req.Cursor)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
 			},
 		},
 	}, nil
@@ -326,3 +800,59 @@ func (s *Service) handleMCPCurrentTime(ctx context.Context, request mcp.CallTool
 		},
 	}, nil
 }
+
+type MediaRequest struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	MaxSize int    `json:"max_size"`
+}
+
+func (s *Service) handleMCPMediaTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req MediaRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+	if req.MaxSize <= 0 {
+		req.MaxSize = MCPImageMaxSize
+	}
+
+	content, err := s.mediaContent(req.Type, strings.TrimPrefix(req.Key, "/"), req.MaxSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get media")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{content},
+	}, nil
+}
+
+// mediaContent 读取媒体并转换为 MCP 图片或音频内容，图片会被缩小到 maxSize 以内
+func (s *Service) mediaContent(_type string, key string, maxSize int) (mcp.Content, error) {
+	switch _type {
+	case "image", "voice":
+	default:
+		return nil, errors.MediaTypeUnsupported(_type)
+	}
+
+	data, mimeType, err := s.readMedia(_type, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		out, outMIMEType, err := thumbnail.Thumbnail(data, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewImageContent(base64.StdEncoding.EncodeToString(out), outMIMEType), nil
+	case mimeType == "audio/mpeg":
+		return mcp.NewAudioContent(base64.StdEncoding.EncodeToString(data), mimeType), nil
+	default:
+		return nil, errors.MediaTypeUnsupported(mimeType)
+	}
+}
@@ -0,0 +1,241 @@
+package http
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+const (
+	// MCPDefaultMaxChars MCP 工具单次返回文本的默认字符上限
+	MCPDefaultMaxChars = 30000
+
+	// MCPDefaultMaxMessages query_chat_log 单次返回的默认消息数量上限
+	MCPDefaultMaxMessages = 500
+)
+
+// 各工具共用的输出控制参数
+var (
+	maxCharsOption = mcp.WithNumber("max_chars", mcp.Description(fmt.Sprintf(`返回文本的字符数上限，超出部分会被截断并返回 cursor，默认 %d`, MCPDefaultMaxChars)))
+	cursorOption   = mcp.WithString("cursor", mcp.Description(`分页游标，传入上次结果末尾提示的 cursor 值以继续获取后续内容`))
+)
+
+// outputBudget 按字符数和条目数限制 MCP 工具的文本输出
+type outputBudget struct {
+	maxChars int
+	maxItems int
+	chars    int
+	count    int
+	buf      strings.Builder
+}
+
+func newOutputBudget(maxChars, maxItems int) *outputBudget {
+	if maxChars <= 0 {
+		maxChars = MCPDefaultMaxChars
+	}
+	return &outputBudget{
+		maxChars: maxChars,
+		maxItems: maxItems,
+	}
+}
+
+// WriteHeader 写入表头等固定内容，不计入条目数
+func (b *outputBudget) WriteHeader(s string) {
+	b.buf.WriteString(s)
+	b.chars += utf8.RuneCountInString(s)
+}
+
+// Write 写入一个条目，超出预算时不写入并返回 false
+// 第一个条目总会写入，避免单条超长内容导致无法翻页
+func (b *outputBudget) Write(s string) bool {
+	if b.maxItems > 0 && b.count >= b.maxItems {
+		return false
+	}
+	n := utf8.RuneCountInString(s)
+	if b.count > 0 && b.chars+n > b.maxChars {
+		return false
+	}
+	b.buf.WriteString(s)
+	b.chars += n
+	b.count++
+	return true
+}
+
+// Count 返回已写入的条目数
+func (b *outputBudget) Count() int {
+	return b.count
+}
+
+// String 返回输出文本，total 为本次查询到的条目总数，offset 为本次查询的起始位置
+// 有条目被截断时，在末尾附带省略数量和继续查询的 cursor
+func (b *outputBudget) String(total, offset int) string {
+	return b.StringWithCursor(total, encodeCursor(offset+b.count))
+}
+
+// StringWithCursor 与 String 相同，由调用方提供继续查询的 cursor
+func (b *outputBudget) StringWithCursor(total int, cursor string) string {
+	if omitted := total - b.count; omitted > 0 {
+		b.buf.WriteString(fmt.Sprintf("\n[输出已截断] 已返回 %d 条，省略 %d 条。继续获取请使用 cursor=\"%s\"\n", b.count, omitted, cursor))
+	}
+	return b.buf.String()
+}
+
+// budgetBlocks 按段截断报告类文本，cursor 为上一页最后一段的 Key，为空时从头输出
+// 报告每次请求时重新生成，根据 Key 定位续读位置，报告中其他段落的增减不会导致重复或遗漏
+func budgetBlocks(blocks []model.TextBlock, maxChars int, cursor string) (string, error) {
+	start := 0
+	if cursor != "" {
+		key, err := decodeBlockCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+		i := slices.IndexFunc(blocks, func(b model.TextBlock) bool { return b.Key == key })
+		if i < 0 {
+			// 上一页最后一段已不在报告中，无法确定续读位置
+			return "", errors.InvalidArg("cursor")
+		}
+		start = i + 1
+	}
+
+	rest := blocks[start:]
+	budget := newOutputBudget(maxChars, 0)
+	for _, b := range rest {
+		if !budget.Write(b.Text) {
+			break
+		}
+	}
+	next := cursor
+	if n := budget.Count(); n > 0 {
+		next = encodeBlockCursor(rest[n-1].Key)
+	}
+	return budget.StringWithCursor(len(rest), next), nil
+}
+
+// encodeBlockCursor 报告分页游标，以 b 开头，后接段落 Key 的 base64 编码
+func encodeBlockCursor(key string) string {
+	return "b" + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeBlockCursor(cursor string) (string, error) {
+	if !strings.HasPrefix(cursor, "b") {
+		return "", errors.InvalidArg("cursor")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(cursor[1:])
+	if err != nil || len(key) == 0 {
+		return "", errors.InvalidArg("cursor")
+	}
+	return string(key), nil
+}
+
+func encodeCursor(offset int) string {
+	return strconv.Itoa(offset)
+}
+
+// decodeCursor 解析分页游标，cursor 为空时返回 offset
+func decodeCursor(cursor string, offset int) (int, error) {
+	if cursor == "" {
+		if offset < 0 {
+			return 0, nil
+		}
+		return offset, nil
+	}
+	n, err := strconv.Atoi(cursor)
+	if err != nil || n < 0 {
+		return 0, errors.InvalidArg("cursor")
+	}
+	return n, nil
+}
+
+// messageCursor 聊天记录的分页游标，记录上一页最后一条消息的 (time, seq, talker)
+// 消息按 seq 排序，不同对话的 seq 可能相同，以对话方区分；
+// macOS 3.x 的消息没有 seq，N 记录已返回的 (time, seq, talker) 相同的消息数量
+type messageCursor struct {
+	Time   int64
+	Seq    int64
+	Talker string
+	N      int
+}
+
+func (c *messageCursor) String() string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("m%d.%d.%d.%s", c.Time, c.Seq, c.N, base64.RawURLEncoding.EncodeToString([]byte(c.Talker)))
+}
+
+// decodeMessageCursor 解析聊天记录分页游标，cursor 为空时返回 nil
+func decodeMessageCursor(cursor string) (*messageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(cursor, "m"), ".")
+	if !strings.HasPrefix(cursor, "m") || len(parts) != 4 {
+		return nil, errors.InvalidArg("cursor")
+	}
+	c := &messageCursor{}
+	var err1, err2, err3, err4 error
+	var talker []byte
+	c.Time, err1 = strconv.ParseInt(parts[0], 10, 64)
+	c.Seq, err2 = strconv.ParseInt(parts[1], 10, 64)
+	c.N, err3 = strconv.Atoi(parts[2])
+	talker, err4 = base64.RawURLEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || c.Time < 0 || c.N < 0 {
+		return nil, errors.InvalidArg("cursor")
+	}
+	c.Talker = string(talker)
+	return c, nil
+}
+
+// compareMessages 按分页顺序比较两条消息：依次比较 seq 和对话方
+func compareMessages(a, b *model.Message) int {
+	if c := cmp.Compare(a.Seq, b.Seq); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Talker, b.Talker)
+}
+
+// sortMessages 将消息按分页顺序排序，seq 相同的消息按对话方排序，保证每次查询的顺序一致
+func sortMessages(messages []*model.Message) {
+	slices.SortStableFunc(messages, compareMessages)
+}
+
+// Skip 返回 messages 中已在之前的分页返回的数量
+// messages 为 cursor 所在秒内的全部消息，需已按 sortMessages 排序
+func (c *messageCursor) Skip(messages []*model.Message) int {
+	last := &model.Message{Seq: c.Seq, Talker: c.Talker}
+	skip, same := 0, 0
+	for _, m := range messages {
+		switch compareMessages(m, last) {
+		case -1:
+			skip++
+		case 0:
+			same++
+		}
+	}
+	return skip + min(same, c.N)
+}
+
+// nextMessageCursor 根据本页最后一条消息生成下一页的 cursor，prev 为本页使用的 cursor
+func nextMessageCursor(prev *messageCursor, shown []*model.Message) *messageCursor {
+	if len(shown) == 0 {
+		return prev
+	}
+	last := shown[len(shown)-1]
+	next := &messageCursor{Time: last.Time.Unix(), Seq: last.Seq, Talker: last.Talker}
+	for i := len(shown) - 1; i >= 0 && shown[i].Time.Unix() == next.Time && compareMessages(shown[i], last) == 0; i-- {
+		next.N++
+	}
+	if prev != nil && prev.Time == next.Time && prev.Seq == next.Seq && prev.Talker == next.Talker {
+		next.N += prev.N
+	}
+	return next
+}
@@ -0,0 +1,260 @@
+package http
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+var cursorRegexp = regexp.MustCompile(`cursor="([^"]*)"`)
+
+// nextCursor 返回输出末尾提示的 cursor，输出未截断时返回空字符串
+func nextCursor(text string) string {
+	m := cursorRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func TestOutputBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxChars int
+		maxItems int
+		items    []string
+		want     int
+	}{
+		{name: "all fit", maxChars: 10, items: []string{"ab", "cd", "ef"}, want: 3},
+		{name: "chars exceeded", maxChars: 5, items: []string{"ab", "cd", "ef"}, want: 2},
+		{name: "runes not bytes", maxChars: 4, items: []string{"你好", "世界", "!"}, want: 2},
+		{name: "first item always written", maxChars: 1, items: []string{"long item", "x"}, want: 1},
+		{name: "items exceeded", maxChars: 100, maxItems: 2, items: []string{"a", "b", "c"}, want: 2},
+		{name: "default max chars", items: []string{strings.Repeat("a", MCPDefaultMaxChars), "b"}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newOutputBudget(tt.maxChars, tt.maxItems)
+			for _, item := range tt.items {
+				if !b.Write(item) {
+					break
+				}
+			}
+			if b.Count() != tt.want {
+				t.Errorf("Count() = %d, want %d", b.Count(), tt.want)
+			}
+			out := b.String(len(tt.items), 0)
+			truncated := strings.Contains(out, "[输出已截断]")
+			if truncated != (tt.want < len(tt.items)) {
+				t.Errorf("String() truncated = %v, want %v", truncated, tt.want < len(tt.items))
+			}
+		})
+	}
+}
+
+func testBlocks(keys ...string) []model.TextBlock {
+	blocks := make([]model.TextBlock, 0, len(keys))
+	for _, key := range keys {
+		blocks = append(blocks, model.TextBlock{Key: key, Text: fmt.Sprintf("line %s\n", key)})
+	}
+	return blocks
+}
+
+func TestBudgetBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocks   []model.TextBlock
+		maxChars int
+		pages    int
+	}{
+		{name: "single page", blocks: testBlocks("a", "b", "c"), maxChars: 100, pages: 1},
+		{name: "one block per page", blocks: testBlocks("a", "b", "c"), maxChars: 8, pages: 3},
+		{name: "two blocks per page", blocks: testBlocks("a", "b", "c", "d", "e"), maxChars: 16, pages: 3},
+		{name: "keys with separators", blocks: testBlocks("senders/wxid_a", "senders/room@chatroom", "days/2025-01-01"), maxChars: 30, pages: 3},
+		{name: "empty report", blocks: nil, maxChars: 10, pages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			cursor := ""
+			for page := 1; ; page++ {
+				text, err := budgetBlocks(tt.blocks, tt.maxChars, cursor)
+				if err != nil {
+					t.Fatalf("budgetBlocks() page %d error = %v", page, err)
+				}
+				cursor = nextCursor(text)
+				got.WriteString(cursorRegexp.ReplaceAllString(text, ""))
+				if cursor == "" {
+					if page != tt.pages {
+						t.Errorf("pages = %d, want %d", page, tt.pages)
+					}
+					break
+				}
+				if page > len(tt.blocks) {
+					t.Fatal("budgetBlocks() does not advance")
+				}
+			}
+			for _, b := range tt.blocks {
+				if n := strings.Count(got.String(), b.Text); n != 1 {
+					t.Errorf("block %q emitted %d times", b.Key, n)
+				}
+			}
+		})
+	}
+}
+
+// TestBudgetBlocksChangedReport 报告在两次请求之间变化时，根据 Key 续读，不会重复或遗漏已有的段落
+func TestBudgetBlocksChangedReport(t *testing.T) {
+	first, err := budgetBlocks(testBlocks("a", "b", "c", "d"), 16, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := nextCursor(first)
+	if cursor == "" {
+		t.Fatal("first page is not truncated")
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []model.TextBlock
+		want    string
+		wantErr bool
+	}{
+		{name: "unchanged", blocks: testBlocks("a", "b", "c", "d"), want: "line c\nline d\n"},
+		{name: "block inserted before cursor", blocks: testBlocks("a", "new", "b", "c", "d"), want: "line c\nline d\n"},
+		{name: "block inserted after cursor", blocks: testBlocks("a", "b", "new", "c", "d"), want: "line new\nline c\n"},
+		{name: "cursor block removed", blocks: testBlocks("a", "c", "d"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := budgetBlocks(tt.blocks, 16, cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("budgetBlocks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !strings.HasPrefix(text, tt.want) {
+				t.Errorf("budgetBlocks() = %q, want prefix %q", text, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		cursor  string
+		wantErr bool
+	}{
+		{cursor: encodeBlockCursor("senders/wxid_a")},
+		{cursor: (&messageCursor{Time: 1700000000, Seq: 1700000000001, Talker: "room@chatroom", N: 2}).String()},
+		{cursor: "b", wantErr: true},
+		{cursor: "b!!", wantErr: true},
+		{cursor: "m1.2.3", wantErr: true},
+		{cursor: "m1.2.-1.YQ", wantErr: true},
+		{cursor: "x1.2.3.YQ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cursor, func(t *testing.T) {
+			var err error
+			if strings.HasPrefix(tt.cursor, "m") || strings.HasPrefix(tt.cursor, "x") {
+				var c *messageCursor
+				c, err = decodeMessageCursor(tt.cursor)
+				if err == nil && c.String() != tt.cursor {
+					t.Errorf("decodeMessageCursor(%q).String() = %q", tt.cursor, c.String())
+				}
+			} else {
+				_, err = decodeBlockCursor(tt.cursor)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decode error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestMessageCursor 按 max_messages 分页读取同一秒内多个对话的消息，每条消息恰好返回一次
+func TestMessageCursor(t *testing.T) {
+	sec := time.Unix(1700000000, 0)
+	msg := func(talker string, seq int64, content string) *model.Message {
+		return &model.Message{Time: sec, Seq: seq, Talker: talker, Content: content}
+	}
+
+	tests := []struct {
+		name     string
+		messages []*model.Message
+		pageSize int
+	}{
+		{
+			name: "distinct seq",
+			messages: []*model.Message{
+				msg("a", 1, "1"), msg("a", 2, "2"), msg("a", 3, "3"), msg("a", 4, "4"),
+			},
+			pageSize: 3,
+		},
+		{
+			// 不同对话的 seq 相同
+			name: "same seq across talkers",
+			messages: []*model.Message{
+				msg("b", 1, "b1"), msg("a", 1, "a1"), msg("a", 2, "a2"), msg("b", 2, "b2"), msg("c", 2, "c2"),
+			},
+			pageSize: 2,
+		},
+		{
+			// macOS 3.x 的消息没有 seq
+			name: "no seq",
+			messages: []*model.Message{
+				msg("a", 0, "1"), msg("a", 0, "2"), msg("b", 0, "3"), msg("a", 0, "4"), msg("b", 0, "5"),
+			},
+			pageSize: 2,
+		},
+		{
+			name: "one per page",
+			messages: []*model.Message{
+				msg("b", 5, "b5"), msg("a", 5, "a5"), msg("a", 0, "a0"),
+			},
+			pageSize: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]int)
+			var cursor *messageCursor
+			for page := 0; page <= len(tt.messages); page++ {
+				// 每次请求时数据源返回的顺序可能不同
+				all := make([]*model.Message, len(tt.messages))
+				for i := range all {
+					all[(i+page)%len(all)] = tt.messages[i]
+				}
+				sortMessages(all)
+
+				rest := all
+				if cursor != nil {
+					encoded := cursor.String()
+					decoded, err := decodeMessageCursor(encoded)
+					if err != nil {
+						t.Fatalf("decodeMessageCursor(%q) error = %v", encoded, err)
+					}
+					rest = all[decoded.Skip(all):]
+				}
+				shown := rest[:min(tt.pageSize, len(rest))]
+				for _, m := range shown {
+					seen[m.Content]++
+				}
+				if len(shown) == len(rest) {
+					break
+				}
+				cursor = nextMessageCursor(cursor, shown)
+			}
+			for _, m := range tt.messages {
+				if seen[m.Content] != 1 {
+					t.Errorf("message %s returned %d times", m.Content, seen[m.Content])
+				}
+			}
+		})
+	}
+}
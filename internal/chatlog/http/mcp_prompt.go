@@ -0,0 +1,249 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// promptMaxMessages 单个提示词最多嵌入的消息数量
+	promptMaxMessages = 2000
+
+	// promptMaxContexts 话题查询最多展开的上下文片段数量
+	promptMaxContexts = 20
+
+	// promptContextWindow 话题查询中每条命中消息前后展开的时间范围
+	promptContextWindow = 15 * time.Minute
+)
+
+const promptTalkerDesc = `对话方，可以是联系人或群聊的 ID、昵称或备注名`
+
+const promptTimeDesc = `时间范围，格式与 query_chat_log 工具一致，如 "2023-04-18"、"2023-04-01~2023-04-18"、"last-7d"`
+
+var SummarizeChatPrompt = mcp.NewPrompt(
+	"summarize_chat",
+	mcp.WithPromptDescription(`总结指定对话在一段时间内的聊天内容，默认为今天`),
+	mcp.WithArgument("talker", mcp.ArgumentDescription(promptTalkerDesc), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(promptTimeDesc+`，默认为今天`)),
+)
+
+var ActionItemsPrompt = mcp.NewPrompt(
+	"action_items",
+	mcp.WithPromptDescription(`从聊天记录中整理分配给我的待办事项`),
+	mcp.WithArgument("talker", mcp.ArgumentDescription(promptTalkerDesc), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(promptTimeDesc+`，默认为最近 7 天`)),
+)
+
+var SenderTopicPrompt = mcp.NewPrompt(
+	"sender_topic",
+	mcp.WithPromptDescription(`查看某人在对话中关于某个话题说了什么，会自动展开每条相关发言前后的上下文`),
+	mcp.WithArgument("talker", mcp.ArgumentDescription(promptTalkerDesc), mcp.RequiredArgument()),
+	mcp.WithArgument("sender", mcp.ArgumentDescription(`发送者，可以是 ID、昵称或备注名`), mcp.RequiredArgument()),
+	mcp.WithArgument("topic", mcp.ArgumentDescription(`话题关键词，支持正则表达式`), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(promptTimeDesc+`，默认为最近 1 个月`)),
+)
+
+var WeeklyReportPrompt = mcp.NewPrompt(
+	"weekly_report",
+	mcp.WithPromptDescription(`根据群聊记录起草周报`),
+	mcp.WithArgument("talker", mcp.ArgumentDescription(promptTalkerDesc), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(promptTimeDesc+`，默认为最近 7 天`)),
+)
+
+func (s *Service) initMCPPrompts() {
+	s.mcpServer.AddPrompt(SummarizeChatPrompt, s.handleMCPSummarizeChat)
+	s.mcpServer.AddPrompt(ActionItemsPrompt, s.handleMCPActionItems)
+	s.mcpServer.AddPrompt(SenderTopicPrompt, s.handleMCPSenderTopic)
+	s.mcpServer.AddPrompt(WeeklyReportPrompt, s.handleMCPWeeklyReport)
+}
+
+func (s *Service) handleMCPSummarizeChat(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	chatLog, err := s.promptChatLog(args["talker"], args["time"], "today")
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf(`请总结下面 %s 的聊天记录：
+1. 按话题归纳主要讨论内容，每个话题注明主要参与者
+2. 列出达成的结论或决定
+3. 列出尚未解决的问题
+
+%s`, args["talker"], chatLog)
+
+	return promptResult("总结聊天记录", text), nil
+}
+
+func (s *Service) handleMCPActionItems(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	chatLog, err := s.promptChatLog(args["talker"], args["time"], "last-7d")
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf(`下面是 %s 的聊天记录，发送者标记为"(我)"的消息是我本人发送的。
+请找出分配给我、需要我跟进或我承诺要完成的事项，按以下格式逐条列出：
+- 事项内容
+- 提出人和时间
+- 截止时间（如有）
+- 当前状态（根据后续消息判断是否已完成）
+
+如果没有相关事项，请直接说明。
+
+%s`, args["talker"], chatLog)
+
+	return promptResult("整理待办事项", text), nil
+}
+
+func (s *Service) handleMCPSenderTopic(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	talker, sender, topic := args["talker"], args["sender"], args["topic"]
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	if sender == "" {
+		return nil, errors.InvalidArg("sender")
+	}
+	if topic == "" {
+		return nil, errors.InvalidArg("topic")
+	}
+
+	start, end, err := promptTimeRange(args["time"], "last-1m")
+	if err != nil {
+		return nil, err
+	}
+
+	// 先定位相关发言，再分别展开每条发言前后的完整对话
+	hits, err := s.db.GetMessages(start, end, talker, sender, topic, promptMaxMessages, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := strings.Builder{}
+	if len(hits) == 0 {
+		buf.WriteString("未找到相关发言。\n")
+	}
+	for i, window := range mergeTimeWindows(hits, promptContextWindow) {
+		if i >= promptMaxContexts {
+			buf.WriteString(fmt.Sprintf("（相关片段过多，仅展示前 %d 个）\n", promptMaxContexts))
+			break
+		}
+		messages, err := s.db.GetMessages(window[0], window[1], talker, "", "", promptMaxMessages, 0)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(fmt.Sprintf("### 片段 %d（%s ~ %s）\n", i+1, window[0].Format("2006-01-02 15:04"), window[1].Format("2006-01-02 15:04")))
+		writePromptMessages(&buf, messages, "")
+		buf.WriteString("\n")
+	}
+
+	text := fmt.Sprintf(`下面是 %s 中与"%s"相关的聊天片段，每个片段包含 %s 的相关发言及其前后的完整对话。
+请结合上下文总结 %s 关于"%s"的观点和说法，注明时间，不要断章取义。
+
+%s`, talker, topic, sender, sender, topic, buf.String())
+
+	return promptResult("查看发言", text), nil
+}
+
+func (s *Service) handleMCPWeeklyReport(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	chatLog, err := s.promptChatLog(args["talker"], args["time"], "last-7d")
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf(`请根据下面 %s 的聊天记录起草一份周报，包含：
+1. 本周进展：按项目或话题归纳已完成的工作
+2. 问题与风险：讨论中暴露的问题、阻塞和风险
+3. 下周计划：聊天中提到的后续安排
+4. 需要协调的事项
+
+周报使用简洁的条目式表达，只写聊天记录中有依据的内容。
+
+%s`, args["talker"], chatLog)
+
+	return promptResult("起草周报", text), nil
+}
+
+// promptChatLog 获取对话的聊天记录并格式化为提示词文本
+func (s *Service) promptChatLog(talker string, timeStr string, defaultTime string) (string, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return "", err
+	}
+	if talker == "" {
+		return "", errors.ErrTalkerEmpty
+	}
+	start, end, err := promptTimeRange(timeStr, defaultTime)
+	if err != nil {
+		return "", err
+	}
+
+	messages, err := s.db.GetMessages(start, end, talker, "", "", promptMaxMessages+1, 0)
+	if err != nil {
+		return "", err
+	}
+
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("聊天记录（%s ~ %s）：\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04")))
+	if len(messages) == 0 {
+		buf.WriteString("该时间范围内没有聊天记录。\n")
+		return buf.String(), nil
+	}
+	if len(messages) > promptMaxMessages {
+		messages = messages[:promptMaxMessages]
+		buf.WriteString(fmt.Sprintf("（消息过多，仅包含前 %d 条）\n", promptMaxMessages))
+	}
+	writePromptMessages(&buf, messages, util.PerfectTimeFormat(start, end))
+	return buf.String(), nil
+}
+
+func promptTimeRange(timeStr string, defaultTime string) (time.Time, time.Time, error) {
+	if timeStr == "" {
+		timeStr = defaultTime
+	}
+	start, end, ok := util.TimeRangeOf(timeStr)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.InvalidArg("time")
+	}
+	return start, end, nil
+}
+
+func writePromptMessages(buf *strings.Builder, messages []*model.Message, timeFormat string) {
+	for _, m := range messages {
+		buf.WriteString(m.PlainText(false, timeFormat, ""))
+		buf.WriteString("\n")
+	}
+}
+
+// mergeTimeWindows 以每条消息为中心生成时间窗口，并合并相互重叠的窗口
+func mergeTimeWindows(messages []*model.Message, window time.Duration) [][2]time.Time {
+	ret := make([][2]time.Time, 0)
+	for _, m := range messages {
+		start, end := m.Time.Add(-window), m.Time.Add(window)
+		if n := len(ret); n > 0 && !start.After(ret[n-1][1]) {
+			if end.After(ret[n-1][1]) {
+				ret[n-1][1] = end
+			}
+			continue
+		}
+		ret = append(ret, [2]time.Time{start, end})
+	}
+	return ret
+}
+
+func promptResult(description string, text string) *mcp.GetPromptResult {
+	return mcp.NewGetPromptResult(description, []mcp.PromptMessage{
+		mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text)),
+	})
+}
@@ -0,0 +1,549 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 资源模板中的 ID 使用保留字符展开（{+id}），群聊 ID 中的 @ 等字符无需编码即可匹配
+var TalkerMessagesResource = mcp.NewResourceTemplate(
+	"chatlog://talker/{+id}/messages{?time,limit,offset}",
+	"talker_messages",
+	mcp.WithTemplateDescription(`指定对话方（联系人或群聊）的聊天记录，id 可以是 ID、昵称或备注名。time 格式与 query_chat_log 工具一致，默认为今天。订阅后有新消息时会收到 notifications/resources/updated 通知。`),
+	mcp.WithTemplateMIMEType("text/plain"),
+)
+
+var ContactResource = mcp.NewResourceTemplate(
+	"chatlog://contact/{+id}",
+	"contact",
+	mcp.WithTemplateDescription(`联系人信息，id 可以是 ID、昵称或备注名`),
+	mcp.WithTemplateMIMEType("application/json"),
+)
+
+var ChatRoomMembersResource = mcp.NewResourceTemplate(
+	"chatlog://chatroom/{+id}/members",
+	"chatroom_members",
+	mcp.WithTemplateDescription(`群聊成员列表，id 可以是群 ID、群名称或备注名`),
+	mcp.WithTemplateMIMEType("application/json"),
+)
+
+var MediaResource = mcp.NewResourceTemplate(
+	"chatlog://media/{type}/{+key}",
+	"media",
+	mcp.WithTemplateDescription(`聊天中的媒体文件，type 为 image、video、file 或 voice，key 为消息中的媒体 MD5 或文件路径。图片返回解码后的图片，语音返回 mp3。`),
+)
+
+func (s *Service) initMCPResources() {
+	s.mcpServer.AddResourceTemplate(TalkerMessagesResource, s.handleMCPTalkerMessages)
+	s.mcpServer.AddResourceTemplate(ContactResource, s.handleMCPContactResource)
+	s.mcpServer.AddResourceTemplate(ChatRoomMembersResource, s.handleMCPChatRoomMembers)
+	s.mcpServer.AddResourceTemplate(MediaResource, s.handleMCPMedia)
+
+	s.subscriptions = newResourceSubscriptions()
+	go s.notifyLoop()
+	if err := s.db.AddCallback("message", s.onMessageChanged); err != nil {
+		log.Error().Err(err).Msg("Failed to add message callback")
+	}
+}
+
+// resourceArg 获取资源模板中的变量值
+func resourceArg(request mcp.ReadResourceRequest, name string) string {
+	switch v := request.Params.Arguments[name].(type) {
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	case string:
+		return v
+	}
+	return ""
+}
+
+func (s *Service) checkMCPDBState() error {
+	switch s.db.State {
+	case database.StateReady:
+		return nil
+	case database.StateDecrypting:
+		return fmt.Errorf("database is decrypting, please wait")
+	case database.StateError:
+		return fmt.Errorf("database is error: %s", s.db.StateMsg)
+	default:
+		return fmt.Errorf("database is not ready")
+	}
+}
+
+func (s *Service) handleMCPTalkerMessages(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+
+	talker := resourceArg(request, "id")
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	timeStr := resourceArg(request, "time")
+	if timeStr == "" {
+		timeStr = "today"
+	}
+	start, end, ok := util.TimeRangeOf(timeStr)
+	if !ok {
+		return nil, errors.InvalidArg("time")
+	}
+	limit, _ := strconv.Atoi(resourceArg(request, "limit"))
+	offset, _ := strconv.Atoi(resourceArg(request, "offset"))
+	if limit < 0 {
+		limit = 0
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	messages, err := s.db.GetMessages(start, end, talker, "", "", limit, offset)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get messages")
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, m := range messages {
+		buf.WriteString(m.PlainText(false, util.PerfectTimeFormat(start, end), ""))
+		buf.WriteString("\n")
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "text/plain",
+			Text:     buf.String(),
+		},
+	}, nil
+}
+
+func (s *Service) handleMCPContactResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+
+	contact, err := s.db.GetContact(resourceArg(request, "id"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "application/json",
+			Text:     string(b),
+		},
+	}, nil
+}
+
+func (s *Service) handleMCPChatRoomMembers(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+
+	chatRoom, err := s.db.GetChatRoom(resourceArg(request, "id"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(chatRoom.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "application/json",
+			Text:     string(b),
+		},
+	}, nil
+}
+
+func (s *Service) handleMCPMedia(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+
+	_type, key := resourceArg(request, "type"), resourceArg(request, "key")
+	switch _type {
+	case "image", "video", "file", "voice":
+	default:
+		return nil, errors.MediaTypeUnsupported(_type)
+	}
+	if key == "" {
+		return nil, errors.ErrKeyEmpty
+	}
+
+	data, mimeType, err := s.readMedia(_type, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		mcp.BlobResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: mimeType,
+			Blob:     base64.StdEncoding.EncodeToString(data),
+		},
+	}, nil
+}
+
+const (
+	methodResourcesSubscribe   = "resources/subscribe"
+	methodResourcesUnsubscribe = "resources/unsubscribe"
+
+	// stdioSessionID mcp-go stdio 传输的固定会话 ID
+	stdioSessionID = "stdio"
+)
+
+// resourceSubscriptions 记录 MCP 会话订阅的资源
+type resourceSubscriptions struct {
+	mutex    sync.Mutex
+	active   map[string]server.ClientSession // 可接收通知的会话
+	sessions map[string]map[string]bool      // uri -> sessionID
+	cursors  map[string]*messageCursor       // uri -> 已通知的最后一条消息
+	ch       chan struct{}
+}
+
+func newResourceSubscriptions() *resourceSubscriptions {
+	return &resourceSubscriptions{
+		active:   make(map[string]server.ClientSession),
+		sessions: make(map[string]map[string]bool),
+		cursors:  make(map[string]*messageCursor),
+		ch:       make(chan struct{}, 1),
+	}
+}
+
+// Register 记录已注册的会话，只有已注册的会话才能接收通知
+func (r *resourceSubscriptions) Register(session server.ClientSession) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.active[session.SessionID()] = session
+}
+
+// Unregister 移除会话及其全部订阅
+func (r *resourceSubscriptions) Unregister(sessionID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.active, sessionID)
+	for uri := range r.sessions {
+		r.unsubscribe(sessionID, uri)
+	}
+}
+
+// Session 返回已注册且完成初始化的会话
+func (r *resourceSubscriptions) Session(sessionID string) (server.ClientSession, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	session, ok := r.active[sessionID]
+	return session, ok && session.Initialized()
+}
+
+// Subscribe 订阅资源，会话未注册时返回 false
+func (r *resourceSubscriptions) Subscribe(sessionID, uri string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.active[sessionID] == nil {
+		return false
+	}
+	if r.sessions[uri] == nil {
+		r.sessions[uri] = make(map[string]bool)
+		r.cursors[uri] = &messageCursor{Time: time.Now().Unix()}
+	}
+	r.sessions[uri][sessionID] = true
+	return true
+}
+
+func (r *resourceSubscriptions) Unsubscribe(sessionID, uri string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unsubscribe(sessionID, uri)
+}
+
+// UnsubscribeAll 移除会话的全部订阅
+func (r *resourceSubscriptions) UnsubscribeAll(sessionID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for uri := range r.sessions {
+		r.unsubscribe(sessionID, uri)
+	}
+}
+
+func (r *resourceSubscriptions) unsubscribe(sessionID, uri string) {
+	delete(r.sessions[uri], sessionID)
+	if len(r.sessions[uri]) == 0 {
+		delete(r.sessions, uri)
+		delete(r.cursors, uri)
+	}
+}
+
+// mcpHooks 资源订阅依赖的 MCP 会话钩子
+func (s *Service) mcpHooks() *server.Hooks {
+	hooks := &server.Hooks{}
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.subscriptions.Register(session)
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.subscriptions.Unregister(session.SessionID())
+	})
+	return hooks
+}
+
+// handleMCPSubscription 处理 resources/subscribe 和 resources/unsubscribe 请求，返回 JSON-RPC 响应
+// mcp-go 尚未实现资源订阅且不支持注册自定义方法，各传输层在消息交给 mcp-go 之前调用，
+// message 不是订阅请求时返回 nil
+func (s *Service) handleMCPSubscription(sessionID string, message []byte) mcp.JSONRPCMessage {
+	var req struct {
+		ID     *mcp.RequestId      `json:"id"`
+		Method string              `json:"method"`
+		Params mcp.SubscribeParams `json:"params"`
+	}
+	if err := json.Unmarshal(message, &req); err != nil || req.ID == nil {
+		return nil
+	}
+	if req.Method != methodResourcesSubscribe && req.Method != methodResourcesUnsubscribe {
+		return nil
+	}
+	if err := s.subscribeResource(sessionID, req.Method, req.Params.URI); err != nil {
+		return mcp.NewJSONRPCError(*req.ID, mcp.INVALID_PARAMS, err.Error(), nil)
+	}
+	return mcp.NewJSONRPCResponse(*req.ID, mcp.Result{})
+}
+
+func (s *Service) subscribeResource(sessionID, method, uri string) error {
+	if _, ok := s.subscriptions.Session(sessionID); !ok {
+		// Streamable HTTP 会话需要先建立 GET 事件流才能接收通知
+		return fmt.Errorf("session %s cannot receive notifications, initialize and open the event stream first", sessionID)
+	}
+	if uri == "" {
+		return fmt.Errorf("uri is required")
+	}
+
+	if method == methodResourcesUnsubscribe {
+		s.subscriptions.Unsubscribe(sessionID, uri)
+		return nil
+	}
+	if TalkerMessagesResource.URITemplate.Match(uri).Get("id").String() == "" {
+		return fmt.Errorf("only %s can be subscribed", TalkerMessagesResource.URITemplate.Raw())
+	}
+	if !s.subscriptions.Subscribe(sessionID, uri) {
+		return fmt.Errorf("session %s is closed", sessionID)
+	}
+	return nil
+}
+
+// mcpStreamableSubscription 处理 Streamable HTTP 传输的订阅请求，响应直接写入请求的响应体
+func (s *Service) mcpStreamableSubscription(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodDelete:
+		// Streamable HTTP 终止会话时不会注销事件流，这里直接移除订阅
+		if sessionID := c.GetHeader(server.HeaderKeySessionID); sessionID != "" {
+			s.subscriptions.UnsubscribeAll(sessionID)
+		}
+	case http.MethodPost:
+		body, ok := peekBody(c)
+		if !ok {
+			return
+		}
+		if resp := s.handleMCPSubscription(c.GetHeader(server.HeaderKeySessionID), body); resp != nil {
+			c.AbortWithStatusJSON(http.StatusOK, resp)
+			return
+		}
+	}
+	c.Next()
+}
+
+// mcpSSESubscription 处理 SSE 传输的订阅请求，与 mcp-go 一致通过会话的事件流返回响应
+func (s *Service) mcpSSESubscription(c *gin.Context) {
+	sessionID := c.Query("sessionId")
+	if c.Request.Method != http.MethodPost || sessionID == "" {
+		c.Next()
+		return
+	}
+	body, ok := peekBody(c)
+	if !ok {
+		return
+	}
+	resp := s.handleMCPSubscription(sessionID, body)
+	if resp == nil {
+		c.Next()
+		return
+	}
+	if err := s.mcpSSEServer.SendEventToSession(sessionID, resp); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.AbortWithStatus(http.StatusAccepted)
+}
+
+// peekBody 读取请求体并放回，以便后续处理器再次读取
+func peekBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// subscriptionReader 在 stdio 传输层逐行读取消息，订阅请求由 handle 处理并将响应写入 out，其他消息交给 mcp-go
+type subscriptionReader struct {
+	reader *bufio.Reader
+	handle func(message []byte) mcp.JSONRPCMessage
+	out    io.Writer
+	buf    []byte
+}
+
+func newSubscriptionReader(r io.Reader, out io.Writer, handle func(message []byte) mcp.JSONRPCMessage) *subscriptionReader {
+	return &subscriptionReader{reader: bufio.NewReader(r), handle: handle, out: out}
+}
+
+func (r *subscriptionReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		if resp := r.handle(bytes.TrimSpace(line)); resp != nil {
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := r.out.Write(append(data, '\n')); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		r.buf = line
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// lockedWriter 串行化写入，mcp-go 和 subscriptionReader 共用 stdout 时每条消息完整写出
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// onMessageChanged 消息数据库文件变更回调
+// 解密后的文件可能被原地写入或由临时文件重命名替换，Write 和 Create 事件都需要通知订阅者
+func (s *Service) onMessageChanged(event fsnotify.Event) error {
+	if !event.Op.Has(fsnotify.Create) && !event.Op.Has(fsnotify.Write) {
+		return nil
+	}
+	select {
+	case s.subscriptions.ch <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Service) notifyLoop() {
+	for range s.subscriptions.ch {
+		s.notifyResourceUpdated()
+	}
+}
+
+// notifyResourceUpdated 检查已订阅对话是否有新消息，并通知订阅的会话
+func (s *Service) notifyResourceUpdated() {
+	if s.checkMCPDBState() != nil {
+		return
+	}
+
+	r := s.subscriptions
+	r.mutex.Lock()
+	cursors := make(map[string]*messageCursor, len(r.cursors))
+	for uri, c := range r.cursors {
+		cursors[uri] = c
+	}
+	r.mutex.Unlock()
+
+	for uri, cursor := range cursors {
+		vars := TalkerMessagesResource.URITemplate.Match(uri)
+		talker := vars.Get("id").String()
+		if talker == "" {
+			continue
+		}
+
+		// 从上次通知的最后一条消息所在秒开始查询，同一秒内的新消息也需要通知
+		messages, err := s.db.GetMessages(time.Unix(cursor.Time, 0), time.Now().Add(time.Minute*10), talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to get messages for %s", uri)
+			continue
+		}
+		messages = newMessagesSince(cursor, messages)
+		if len(messages) == 0 {
+			continue
+		}
+
+		r.mutex.Lock()
+		if _, ok := r.cursors[uri]; ok {
+			r.cursors[uri] = nextMessageCursor(cursor, messages)
+		}
+		sessionIDs := make([]string, 0, len(r.sessions[uri]))
+		for sessionID := range r.sessions[uri] {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+		r.mutex.Unlock()
+
+		for _, sessionID := range sessionIDs {
+			if err := s.mcpServer.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri}); err != nil {
+				log.Debug().Err(err).Msgf("Failed to notify session %s", sessionID)
+			}
+		}
+	}
+}
+
+// newMessagesSince 返回 messages 中位于 cursor 之后的消息
+// messages 为从 cursor 所在秒开始查询的消息，按时间排序，同一秒内按 sortMessages 的顺序排序
+func newMessagesSince(cursor *messageCursor, messages []*model.Message) []*model.Message {
+	slices.SortStableFunc(messages, func(a, b *model.Message) int {
+		if c := cmp.Compare(a.Time.Unix(), b.Time.Unix()); c != 0 {
+			return c
+		}
+		return compareMessages(a, b)
+	})
+	n := 0
+	for n < len(messages) && messages[n].Time.Unix() <= cursor.Time {
+		n++
+	}
+	return messages[cursor.Skip(messages[:n]):]
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// TestNewMessagesSince 同一秒内晚于上次通知的消息也会被通知，已通知的消息不会重复通知
+func TestNewMessagesSince(t *testing.T) {
+	sec := int64(1700000000)
+	msg := func(offset int64, talker string, seq int64, content string) *model.Message {
+		return &model.Message{Time: time.Unix(sec+offset, 0), Seq: seq, Talker: talker, Content: content}
+	}
+
+	tests := []struct {
+		name     string
+		cursor   *messageCursor
+		messages []*model.Message
+		want     string
+	}{
+		{
+			name:     "same second after seq",
+			cursor:   &messageCursor{Time: sec, Seq: 2, Talker: "a", N: 1},
+			messages: []*model.Message{msg(1, "a", 4, "4"), msg(0, "a", 3, "3"), msg(0, "a", 2, "2"), msg(0, "a", 1, "1")},
+			want:     "3,4",
+		},
+		{
+			name:     "nothing new",
+			cursor:   &messageCursor{Time: sec, Seq: 2, Talker: "a", N: 1},
+			messages: []*model.Message{msg(0, "a", 1, "1"), msg(0, "a", 2, "2")},
+			want:     "",
+		},
+		{
+			// macOS 3.x 的消息没有 seq，以已通知的数量区分
+			name:     "no seq",
+			cursor:   &messageCursor{Time: sec, Seq: 0, Talker: "a", N: 2},
+			messages: []*model.Message{msg(0, "a", 0, "1"), msg(0, "a", 0, "2"), msg(0, "a", 0, "3"), msg(1, "a", 0, "4")},
+			want:     "3,4",
+		},
+		{
+			name:     "subscription start",
+			cursor:   &messageCursor{Time: sec},
+			messages: []*model.Message{msg(0, "a", 1, "1"), msg(2, "a", 2, "2")},
+			want:     "1,2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range newMessagesSince(tt.cursor, tt.messages) {
+				got = append(got, m.Content)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("newMessagesSince() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type testSession struct {
+	id          string
+	initialized bool
+}
+
+func (s *testSession) Initialize()                                         { s.initialized = true }
+func (s *testSession) Initialized() bool                                   { return s.initialized }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return nil }
+func (s *testSession) SessionID() string                                   { return s.id }
+
+func TestHandleMCPSubscription(t *testing.T) {
+	s := &Service{subscriptions: newResourceSubscriptions()}
+	s.subscriptions.Register(&testSession{id: "ready", initialized: true})
+	s.subscriptions.Register(&testSession{id: "pending"})
+
+	const uri = "chatlog://talker/room@chatroom/messages"
+	tests := []struct {
+		name       string
+		sessionID  string
+		message    string
+		want       string // 空表示不是订阅请求
+		subscribed bool
+	}{
+		{name: "subscribe", sessionID: "ready", message: `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"` + uri + `"}}`, want: `{"jsonrpc":"2.0","id":1,"result":{}}`, subscribed: true},
+		{name: "unsubscribe", sessionID: "ready", message: `{"jsonrpc":"2.0","id":"2","method":"resources/unsubscribe","params":{"uri":"` + uri + `"}}`, want: `{"jsonrpc":"2.0","id":"2","result":{}}`},
+		{name: "other resource", sessionID: "ready", message: `{"jsonrpc":"2.0","id":3,"method":"resources/subscribe","params":{"uri":"chatlog://contact/a"}}`, want: "error"},
+		{name: "missing uri", sessionID: "ready", message: `{"jsonrpc":"2.0","id":4,"method":"resources/subscribe","params":{}}`, want: "error"},
+		{name: "session not initialized", sessionID: "pending", message: `{"jsonrpc":"2.0","id":5,"method":"resources/subscribe","params":{"uri":"` + uri + `"}}`, want: "error"},
+		{name: "unknown session", sessionID: "", message: `{"jsonrpc":"2.0","id":6,"method":"resources/subscribe","params":{"uri":"` + uri + `"}}`, want: "error"},
+		{name: "other method", sessionID: "ready", message: `{"jsonrpc":"2.0","id":7,"method":"ping"}`},
+		{name: "notification", sessionID: "ready", message: `{"jsonrpc":"2.0","method":"resources/subscribe","params":{"uri":"` + uri + `"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.handleMCPSubscription(tt.sessionID, []byte(tt.message))
+			switch {
+			case tt.want == "" && resp != nil:
+				t.Fatalf("handleMCPSubscription() = %v, want nil", resp)
+			case tt.want == "error":
+				if _, ok := resp.(mcp.JSONRPCError); !ok {
+					t.Fatalf("handleMCPSubscription() = %#v, want error", resp)
+				}
+			case tt.want != "":
+				data, _ := json.Marshal(resp)
+				if string(data) != tt.want {
+					t.Fatalf("handleMCPSubscription() = %s, want %s", data, tt.want)
+				}
+			}
+			if got := s.subscriptions.sessions[uri]["ready"]; got != tt.subscribed {
+				t.Errorf("subscribed = %v, want %v", got, tt.subscribed)
+			}
+		})
+	}
+}
+
+// TestSubscriptionReader stdio 传输中订阅请求的响应写入 stdout，其他消息原样交给 mcp-go
+func TestSubscriptionReader(t *testing.T) {
+	s := &Service{subscriptions: newResourceSubscriptions()}
+	s.subscriptions.Register(&testSession{id: stdioSessionID, initialized: true})
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"resources/subscribe","params":{"uri":"chatlog://talker/a/messages"}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	r := newSubscriptionReader(strings.NewReader(input), &lockedWriter{w: &out}, func(message []byte) mcp.JSONRPCMessage {
+		return s.handleMCPSubscription(stdioSessionID, message)
+	})
+	passed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"jsonrpc":"2.0","id":1,"method":"ping"}` + "\n" + `{"jsonrpc":"2.0","id":3,"method":"tools/list"}` + "\n"
+	if string(passed) != want {
+		t.Errorf("passed to mcp-go = %q, want %q", passed, want)
+	}
+	if got := out.String(); got != `{"jsonrpc":"2.0","id":2,"result":{}}`+"\n" {
+		t.Errorf("stdout = %q", got)
+	}
+}
@@ -5,17 +5,59 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
 	"github.com/sjzar/chatlog/pkg/util/dat2img"
 	"github.com/sjzar/chatlog/pkg/util/silk"
+	"github.com/sjzar/chatlog/pkg/util/wordcloud"
+)
+
+const (
+	// StatsDefaultTime 聊天统计的默认时间范围
+	StatsDefaultTime = "last-30d"
+
+	// GraphDefaultTime 关系图的默认时间范围
+	GraphDefaultTime = "last-1m"
+
+	// CatalogDefaultTime 链接和文件目录的默认时间范围
+	CatalogDefaultTime = "last-1m"
+
+	// PaymentsDefaultTime 转账和红包账本的默认时间范围
+	PaymentsDefaultTime = "last-1y"
+
+	// MentionsDefaultTime 提及我的消息的默认时间范围
+	MentionsDefaultTime = "last-7d"
+
+	// UnansweredDefaultTime 未回复消息的默认时间范围
+	UnansweredDefaultTime = "last-7d"
+
+	// UnansweredDefaultWindow 超过该时长未回复才视为未回复
+	UnansweredDefaultWindow = "24h"
+
+	// TopicsDefaultTime 话题分析的默认时间范围
+	TopicsDefaultTime = "last-7d"
+
+	// TopicsDefaultLimit 每个对话默认返回的关键词与话题数量
+	TopicsDefaultLimit = 10
+
+	// WordFreqDefaultTime 词频统计的默认时间范围
+	WordFreqDefaultTime = "last-7d"
+
+	// WordFreqDefaultLimit 词频统计默认返回的词数量
+	WordFreqDefaultLimit = 100
 )
 
 // EFS holds embedded file system data for static assets.
@@ -58,18 +100,28 @@ func (s *Service) initAPIRouter() {
 		api.GET("/chatlog", s.handleChatlog)
 		api.GET("/contact", s.handleContacts)
 		api.GET("/chatroom", s.handleChatRooms)
+		api.GET("/chatroom/report", s.handleChatRoomReport)
 		api.GET("/session", s.handleSessions)
+		api.GET("/stats", s.handleStats)
+		api.GET("/graph", s.handleGraph)
+		api.GET("/catalog", s.handleCatalog)
+		api.GET("/payments", s.handlePayments)
+		api.GET("/mentions", s.handleMentions)
+		api.POST("/mentions/read", s.handleMentionsRead)
+		api.GET("/unanswered", s.handleUnanswered)
+		api.GET("/topics", s.handleTopics)
+		api.GET("/wordfreq", s.handleWordFreq)
 	}
 }
 
 func (s *Service) initMCPRouter() {
-	s.router.Any("/mcp", func(c *gin.Context) {
+	s.router.Any("/mcp", s.mcpStreamableSubscription, func(c *gin.Context) {
 		s.mcpStreamableServer.ServeHTTP(c.Writer, c.Request)
 	})
 	s.router.Any("/sse", func(c *gin.Context) {
 		s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
 	})
-	s.router.Any("/message", func(c *gin.Context) {
+	s.router.Any("/message", s.mcpSSESubscription, func(c *gin.Context) {
 		s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
 	})
 }
@@ -155,6 +207,491 @@ func (s *Service) handleChatlog(c *gin.Context) {
 	}
 }
 
+func (s *Service) handleStats(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = StatsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Talker == "" || strings.Contains(q.Talker, ",") {
+		errors.Err(c, errors.InvalidArg("talker"))
+		return
+	}
+
+	stats, err := s.db.GetStats(start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, stats)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(stats.PlainText())
+	}
+}
+
+func (s *Service) handleChatRoomReport(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = ChatRoomReportDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Talker == "" {
+		errors.Err(c, errors.InvalidArg("talker"))
+		return
+	}
+
+	report, err := s.db.GetChatRoomReport(start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s_%s.csv", report.ChatRoom, start.Format("2006-01-02"), end.Format("2006-01-02")))
+		csvWriter := csv.NewWriter(c.Writer)
+		csvWriter.Write(report.CSVHeader())
+		for _, m := range report.Members {
+			csvWriter.Write(m.CSV())
+		}
+		csvWriter.Flush()
+	case "json":
+		c.JSON(http.StatusOK, report)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(report.PlainText())
+	}
+}
+
+func (s *Service) handleGraph(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = GraphDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	format := strings.ToLower(q.Format)
+	if format == "" {
+		format = "json"
+	}
+	if !slices.Contains(model.GraphFormats, format) {
+		errors.Err(c, errors.InvalidArg("format"))
+		return
+	}
+
+	graph, err := s.db.GetGraph(start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	c.Writer.Header().Set("Content-Type", model.GraphContentType(format))
+	if format != "json" {
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=chatlog_%s_%s.%s", start.Format("2006-01-02"), end.Format("2006-01-02"), format))
+	}
+	if err := graph.Export(c.Writer, format); err != nil {
+		log.Err(err).Msg("export graph failed")
+	}
+}
+
+func (s *Service) handleCatalog(c *gin.Context) {
+
+	q := struct {
+		Time    string `form:"time"`
+		Talker  string `form:"talker"`
+		Kind    string `form:"kind"`
+		Keyword string `form:"keyword"`
+		Limit   int    `form:"limit"`
+		Offset  int    `form:"offset"`
+		Format  string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = CatalogDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Kind != "" && !slices.Contains(model.CatalogKinds, q.Kind) {
+		errors.Err(c, errors.InvalidArg("kind"))
+		return
+	}
+
+	catalog, err := s.db.GetCatalog(start, end, q.Talker, q.Kind, q.Keyword, q.Limit, q.Offset)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=catalog_%s_%s.csv", start.Format("2006-01-02"), end.Format("2006-01-02")))
+		csvWriter := csv.NewWriter(c.Writer)
+		csvWriter.Write(catalog.CSVHeader())
+		for _, item := range catalog.Items {
+			csvWriter.Write(item.CSV(c.Request.Host))
+		}
+		csvWriter.Flush()
+	case "json":
+		c.JSON(http.StatusOK, catalog)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(catalog.PlainText(c.Request.Host))
+	}
+}
+
+func (s *Service) handlePayments(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = PaymentsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	ledger, err := s.db.GetPayments(start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=payments_%s_%s.csv", start.Format("2006-01-02"), end.Format("2006-01-02")))
+		csvWriter := csv.NewWriter(c.Writer)
+		csvWriter.Write(ledger.CSVHeader())
+		for _, p := range ledger.Payments {
+			csvWriter.Write(p.CSV())
+		}
+		csvWriter.Flush()
+	case "json":
+		c.JSON(http.StatusOK, ledger)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(ledger.PlainText())
+	}
+}
+
+func (s *Service) handleMentions(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Unread bool   `form:"unread"`
+		Limit  int    `form:"limit"`
+		Offset int    `form:"offset"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = MentionsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	inbox, err := s.db.GetMentions(start, end, q.Talker, q.Unread, q.Limit, q.Offset)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, inbox)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(inbox.PlainText())
+	}
+}
+
+func (s *Service) handleMentionsRead(c *gin.Context) {
+
+	q := struct {
+		Talker string `form:"talker"`
+		Until  string `form:"until"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	var until time.Time
+	if q.Until != "" {
+		var ok bool
+		if until, ok = util.TimeOf(q.Until); !ok {
+			errors.Err(c, errors.InvalidArg("until"))
+			return
+		}
+	}
+
+	if err := s.db.MarkMentionsRead(q.Talker, until); err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (s *Service) handleUnanswered(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Window string `form:"window"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = UnansweredDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Window == "" {
+		q.Window = UnansweredDefaultWindow
+	}
+	window, ok := parseWindow(q.Window)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("window"))
+		return
+	}
+
+	items, err := s.db.GetUnanswered(start, end, q.Talker, window)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, items)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(model.UnansweredPlainText(items))
+	}
+}
+
+func (s *Service) handleTopics(c *gin.Context) {
+
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Limit  int    `form:"limit"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = TopicsDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = TopicsDefaultLimit
+	}
+
+	report, err := s.db.GetTopics(start, end, q.Talker, q.Limit)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, report)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(report.PlainText())
+	}
+}
+
+func (s *Service) handleWordFreq(c *gin.Context) {
+
+	q := struct {
+		Time    string `form:"time"`
+		Talker  string `form:"talker"`
+		Sender  string `form:"sender"`
+		Keyword string `form:"keyword"`
+		NGram   int    `form:"ngram"`
+		Limit   int    `form:"limit"`
+		Width   *int   `form:"width"`
+		Height  *int   `form:"height"`
+		Format  string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Talker == "" {
+		errors.Err(c, errors.InvalidArg("talker"))
+		return
+	}
+	if q.Time == "" {
+		q.Time = WordFreqDefaultTime
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.NGram < 0 || q.NGram > 3 {
+		errors.Err(c, errors.InvalidArg("ngram"))
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = WordFreqDefaultLimit
+	}
+	// 画布尺寸未指定时使用默认值，超过上限时截断
+	width, height := wordcloud.DefaultWidth, wordcloud.DefaultHeight
+	if q.Width != nil {
+		if *q.Width <= 0 {
+			errors.Err(c, errors.InvalidArg("width"))
+			return
+		}
+		width = min(*q.Width, wordcloud.MaxSize)
+	}
+	if q.Height != nil {
+		if *q.Height <= 0 {
+			errors.Err(c, errors.InvalidArg("height"))
+			return
+		}
+		height = min(*q.Height, wordcloud.MaxSize)
+	}
+
+	freq, err := s.db.GetWordFreq(start, end, q.Talker, q.Sender, q.Keyword, q.NGram, q.Limit)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=wordfreq_%s_%s.csv", start.Format("2006-01-02"), end.Format("2006-01-02")))
+		csvWriter := csv.NewWriter(c.Writer)
+		csvWriter.Write(freq.CSVHeader())
+		csvWriter.WriteAll(freq.CSV())
+	case "svg":
+		c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", freq.SVG(width, height))
+	case "json":
+		c.JSON(http.StatusOK, freq)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(freq.PlainText())
+	}
+}
+
+// parseWindow 解析时长，支持 Go duration 格式和以 d 结尾的天数，如 30m、2h、1d
+func parseWindow(str string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
 func (s *Service) handleContacts(c *gin.Context) {
 
 	q := struct {
@@ -396,20 +933,24 @@ func (s *Service) HandleDatFile(c *gin.Context, path string) {
 		return
 	}
 
+	c.Data(http.StatusOK, imageMIMEType(ext), out)
+}
+
+// imageMIMEType 返回 dat 文件解码后的 MIME 类型
+func imageMIMEType(ext string) string {
 	switch ext {
 	case "jpg", "jpeg":
-		c.Data(http.StatusOK, "image/jpeg", out)
+		return "image/jpeg"
 	case "png":
-		c.Data(http.StatusOK, "image/png", out)
+		return "image/png"
 	case "gif":
-		c.Data(http.StatusOK, "image/gif", out)
+		return "image/gif"
 	case "bmp":
-		c.Data(http.StatusOK, "image/bmp", out)
+		return "image/bmp"
 	case "mp4":
-		c.Data(http.StatusOK, "video/mp4", out)
+		return "video/mp4"
 	default:
-		c.Data(http.StatusOK, "image/jpg", out)
-		// c.File(path)
+		return "image/jpg"
 	}
 }
 
@@ -421,3 +962,67 @@ func (s *Service) HandleVoice(c *gin.Context, data []byte) {
 	}
 	c.Data(http.StatusOK, "audio/mp3", out)
 }
+
+// readMedia 读取媒体内容，dat 图片会被解码，语音会被转换为 mp3
+// key 支持以 "," 分隔的多个候选值，返回第一个读取成功的结果
+func (s *Service) readMedia(_type string, key string) ([]byte, string, error) {
+	keys := util.Str2List(key, ",")
+	if len(keys) == 0 {
+		return nil, "", errors.ErrKeyEmpty
+	}
+
+	var _err error
+	for _, k := range keys {
+		data, mimeType, err := s.readMediaKey(_type, k)
+		if err != nil {
+			_err = err
+			continue
+		}
+		return data, mimeType, nil
+	}
+	return nil, "", _err
+}
+
+func (s *Service) readMediaKey(_type string, key string) ([]byte, string, error) {
+	var path string
+	if strings.Contains(key, "/") {
+		if relativePath, err := s.findPath(_type, key); err == nil {
+			path = relativePath
+		}
+	}
+
+	if path == "" {
+		media, err := s.db.GetMedia(_type, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if media.Type == "voice" {
+			out, err := silk.Silk2MP3(media.Data)
+			if err != nil {
+				return media.Data, "audio/silk", nil
+			}
+			return out, "audio/mpeg", nil
+		}
+		path = media.Path
+	}
+
+	absolutePath := filepath.Join(s.conf.GetDataDir(), filepath.Clean("/"+path))
+	b, err := os.ReadFile(absolutePath)
+	if err != nil {
+		return nil, "", errors.ReadFileFailed(absolutePath, err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(absolutePath))
+	if ext == ".dat" {
+		if out, ext, err := dat2img.Dat2Image(b); err == nil {
+			return out, imageMIMEType(ext), nil
+		}
+		return b, "application/octet-stream", nil
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return b, mimeType, nil
+}
@@ -2,10 +2,15 @@ package http
 
 import (
 	"context"
+	stdlog "log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog/log"
 
@@ -23,6 +28,7 @@ type Service struct {
 	mcpServer           *server.MCPServer
 	mcpSSEServer        *server.SSEServer
 	mcpStreamableServer *server.StreamableHTTPServer
+	subscriptions       *resourceSubscriptions
 }
 
 type Config interface {
@@ -88,6 +94,23 @@ func (s *Service) ListenAndServe() error {
 	return s.server.ListenAndServe()
 }
 
+// ServeStdio 通过标准输入输出提供 MCP 服务，阻塞直到输入结束或收到退出信号
+func (s *Service) ServeStdio() error {
+	log.Info().Msg("Starting MCP stdio server")
+	stdio := server.NewStdioServer(s.mcpServer)
+	stdio.SetErrorLogger(stdlog.New(log.Logger, "", 0))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	// mcp-go 不处理订阅请求，由 subscriptionReader 处理并与 mcp-go 共用 stdout 写出响应
+	stdout := &lockedWriter{w: os.Stdout}
+	stdin := newSubscriptionReader(os.Stdin, stdout, func(message []byte) mcp.JSONRPCMessage {
+		return s.handleMCPSubscription(stdioSessionID, message)
+	})
+	return stdio.Listen(ctx, stdin, stdout)
+}
+
 func (s *Service) Stop() error {
 
 	if s.server == nil {
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -12,7 +14,11 @@ import (
 	"github.com/sjzar/chatlog/internal/chatlog/database"
 	"github.com/sjzar/chatlog/internal/chatlog/http"
 	"github.com/sjzar/chatlog/internal/chatlog/wechat"
+	"github.com/sjzar/chatlog/internal/model"
 	iwechat "github.com/sjzar/chatlog/internal/wechat"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/internal/wechat/key"
 	"github.com/sjzar/chatlog/pkg/config"
 	"github.com/sjzar/chatlog/pkg/util"
 	"github.com/sjzar/chatlog/pkg/util/dat2img"
@@ -106,6 +112,7 @@ func (m *Manager) StartService() error {
 		m.db.Stop()
 		return err
 	}
+	m.db.StartDigest()
 
 	// 如果是 4.0 版本，更新下 xorkey
 	if m.ctx.Version == 4 {
@@ -305,11 +312,89 @@ func (m *Manager) CommandKey(configPath string, pid int, force bool, showXorKey
 	return "", fmt.Errorf("wechat process not found")
 }
 
-func (m *Manager) CommandDecrypt(configPath string, cmdConf map[string]any) error {
+// CommandKeyFromDump 从 dumpmemory 生成的内存转储文件中离线搜索密钥
+// platform 或 version 为空时根据转储文件名和数据目录识别，无法识别时返回错误
+func (m *Manager) CommandKeyFromDump(dumpPath string, dataDir string, platform string, version int) (string, error) {
+	if len(dataDir) == 0 {
+		return "", fmt.Errorf("dataDir is required")
+	}
+	format, err := key.DumpFormat(dumpPath, dataDir, platform, version)
+	if err != nil {
+		return "", err
+	}
+	log.Info().Msgf("search key of %s in %s", format, dumpPath)
 
-	var err error
-	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	extractor, err := key.NewExtractor(format.Platform, format.Version)
+	if err != nil {
+		return "", err
+	}
+	validator, err := decrypt.NewValidator(format.Platform, format.Version, dataDir)
+	if err != nil {
+		return "", err
+	}
+	extractor.SetValidate(validator)
+
+	dataKey, imgKey, err := key.SearchDumpFile(context.Background(), extractor, dumpPath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Data Key: [%s]\nImage Key: [%s]", dataKey, imgKey), nil
+}
+
+// CommandKeyCheck 使用数据目录中的全部数据库验证数据密钥，并验证图片密钥
+func (m *Manager) CommandKeyCheck(dataDir string, dataKey string, imgKey string, platform string, version int) (*decrypt.KeyReport, error) {
+	if len(dataDir) == 0 {
+		return nil, fmt.Errorf("dataDir is required")
+	}
+	if len(dataKey) == 0 {
+		return nil, fmt.Errorf("dataKey is required")
+	}
+	return decrypt.CheckKey(dataDir, platform, version, dataKey, imgKey)
+}
+
+// CommandImgKey 从内存转储文件或候选密钥列表中离线恢复图片密钥，找到后保存到数据目录的 chatlog.json
+// datDir 为空时从数据目录中查找用于验证的图片
+func (m *Manager) CommandImgKey(dataDir string, datDir string, dumpPath string, listPath string, workers int) (string, error) {
+	if len(dataDir) == 0 && len(datDir) == 0 {
+		return "", fmt.Errorf("dataDir or datDir is required")
+	}
+	if len(dumpPath) == 0 && len(listPath) == 0 {
+		return "", fmt.Errorf("dump file or key list file is required")
+	}
+	if len(datDir) == 0 {
+		datDir = dataDir
+	}
+	if workers > 0 {
+		key.ImgKeyWorkers = workers
+	}
+
+	validators, err := key.NewImgKeyValidators(datDir)
+	if err != nil {
+		return "", err
+	}
+
+	var imgKey string
+	if len(dumpPath) != 0 {
+		imgKey, err = key.RecoverImgKeyFromDump(context.Background(), dumpPath, validators)
+	} else {
+		imgKey, err = key.RecoverImgKeyFromList(context.Background(), listPath, validators)
+	}
 	if err != nil {
+		return "", err
+	}
+
+	if len(dataDir) != 0 {
+		if err := conf.SaveDataDirConfig(dataDir, map[string]any{"img_key": imgKey}); err != nil {
+			return "", err
+		}
+		log.Info().Msgf("image key saved to %s", filepath.Join(dataDir, "chatlog.json"))
+	}
+	return fmt.Sprintf("Image Key: [%s]", imgKey), nil
+}
+
+func (m *Manager) CommandDecrypt(configPath string, cmdConf map[string]any) error {
+
+	if err := m.loadServiceConfig(configPath, cmdConf); err != nil {
 		return err
 	}
 
@@ -332,11 +417,31 @@ func (m *Manager) CommandDecrypt(configPath string, cmdConf map[string]any) erro
 	return nil
 }
 
+// CommandVerify 校验并解密全部数据库文件，跳过损坏的页面，返回每个文件的校验结果
+func (m *Manager) CommandVerify(configPath string, cmdConf map[string]any) ([]*common.VerifyReport, error) {
+
+	if err := m.loadServiceConfig(configPath, cmdConf); err != nil {
+		return nil, err
+	}
+
+	dataDir := m.sc.GetDataDir()
+	if len(dataDir) == 0 {
+		return nil, fmt.Errorf("dataDir is required")
+	}
+
+	dataKey := m.sc.GetDataKey()
+	if len(dataKey) == 0 {
+		return nil, fmt.Errorf("dataKey is required")
+	}
+
+	m.wechat = wechat.NewService(m.sc)
+
+	return m.wechat.VerifyDBFiles()
+}
+
 func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) error {
 
-	var err error
-	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
-	if err != nil {
+	if err := m.loadServiceConfig(configPath, cmdConf); err != nil {
 		return err
 	}
 
@@ -358,7 +463,7 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 		go dat2img.ScanAndSetXorKey(dataDir)
 	}
 
-	log.Info().Msgf("server config: %+v", m.sc)
+	log.Info().Msgf("server config: %+v", m.sc.Redacted())
 
 	m.wechat = wechat.NewService(m.sc)
 
@@ -401,7 +506,99 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 				return
 			}
 		}
+		m.db.StartDigest()
 	}()
 
 	return m.http.ListenAndServe()
 }
+
+func (m *Manager) CommandMCP(configPath string, cmdConf map[string]any) error {
+
+	if err := m.startCommandDB(configPath, cmdConf); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	m.http = http.NewService(m.sc, m.db)
+
+	return m.http.ServeStdio()
+}
+
+// CommandGraph 导出联系人关系图，output 为空时输出到标准输出
+func (m *Manager) CommandGraph(configPath string, cmdConf map[string]any, timeStr, talker, format, output string) error {
+
+	start, end, ok := util.TimeRangeOf(timeStr)
+	if !ok {
+		return fmt.Errorf("invalid time range: %s", timeStr)
+	}
+	if !slices.Contains(model.GraphFormats, format) {
+		return fmt.Errorf("unsupported graph format: %s", format)
+	}
+
+	if err := m.startCommandDB(configPath, cmdConf); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	graph, err := m.db.GetGraph(start, end, talker)
+	if err != nil {
+		return err
+	}
+
+	if len(output) == 0 {
+		return graph.Export(os.Stdout, format)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return graph.Export(f, format)
+}
+
+// loadServiceConfig 加载服务配置，未指定平台与版本时根据数据目录推断
+func (m *Manager) loadServiceConfig(configPath string, cmdConf map[string]any) error {
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return err
+	}
+	if len(m.sc.GetPlatform()) != 0 && m.sc.GetVersion() != 0 {
+		return nil
+	}
+	format, err := wechat.DetectFormat(m.sc)
+	if err != nil {
+		log.Warn().Err(err).Msg("detect platform and version failed")
+		return nil
+	}
+	m.sc.FillFormat(format.Platform, format.Version)
+	return nil
+}
+
+// startCommandDB 加载命令行配置并打开已解密的数据库
+func (m *Manager) startCommandDB(configPath string, cmdConf map[string]any) error {
+
+	if err := m.loadServiceConfig(configPath, cmdConf); err != nil {
+		return err
+	}
+
+	workDir := m.sc.GetWorkDir()
+	if len(workDir) == 0 {
+		return fmt.Errorf("workDir is required")
+	}
+	if len(m.sc.GetPlatform()) == 0 || m.sc.GetVersion() == 0 {
+		return fmt.Errorf("platform and version are required")
+	}
+
+	// 如果是 4.0 版本，处理图片密钥
+	dataDir := m.sc.GetDataDir()
+	if m.sc.GetVersion() == 4 && len(dataDir) != 0 {
+		dat2img.SetAesKey(m.sc.GetImgKey())
+		go dat2img.ScanAndSetXorKey(dataDir)
+	}
+
+	log.Info().Msgf("command config: %+v", m.sc.Redacted())
+
+	m.db = database.NewService(m.sc)
+	return m.db.Start()
+}
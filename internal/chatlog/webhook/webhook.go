@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -183,17 +184,28 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		"messages": messages,
 	}
 	body, _ := json.Marshal(ret)
-	req, _ := http.NewRequest("POST", m.conf.URL, bytes.NewBuffer(body))
+	log.Info().Msgf("post messages to %s, body: %s", m.conf.URL, string(body))
+	if err := Post(m.client, m.conf.URL, body); err != nil {
+		log.Error().Err(err).Msgf("post messages failed")
+	}
+}
+
+// Post 以 JSON 格式向 webhook 地址发送数据
+func Post(client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
-	log.Info().Msgf("post messages to %s, body: %s", m.conf.URL, string(body))
-	resp, err := m.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Error().Err(err).Msgf("post messages failed")
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Error().Msgf("post messages failed, status code: %d", resp.StatusCode)
+		return fmt.Errorf("status code: %d", resp.StatusCode)
 	}
+	return nil
 }
@@ -0,0 +1,35 @@
+package wechat
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+)
+
+// DetectFormat 根据数据目录推断平台与版本，数据目录无法推断时使用工作目录
+// 推断结果与配置中已指定的平台或版本冲突时返回错误
+func DetectFormat(conf Config) (decrypt.Format, error) {
+	dirs := []struct{ dir, key string }{
+		{conf.GetDataDir(), conf.GetDataKey()},
+		{conf.GetWorkDir(), ""},
+	}
+	var err error = errors.FormatUndetected(conf.GetDataDir())
+	for _, d := range dirs {
+		if len(d.dir) == 0 {
+			continue
+		}
+		format, e := decrypt.DetectFormat(d.dir, d.key)
+		if e != nil {
+			log.Debug().Err(e).Msgf("detect format of %s failed", d.dir)
+			err = e
+			continue
+		}
+		if format.Conflicts(conf.GetPlatform(), conf.GetVersion()) {
+			return decrypt.Format{}, errors.FormatConflict(format.String(), d.dir, conf.GetPlatform(), conf.GetVersion())
+		}
+		log.Info().Msgf("detected %s from %s", format, d.dir)
+		return format, nil
+	}
+	return decrypt.Format{}, err
+}
@@ -3,8 +3,10 @@ package wechat
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/wechat"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
 	"github.com/sjzar/chatlog/pkg/filemonitor"
 	"github.com/sjzar/chatlog/pkg/util"
 )
@@ -29,6 +32,10 @@ type Service struct {
 	pendingActions map[string]bool
 	mutex          sync.Mutex
 	fm             *filemonitor.FileMonitor
+
+	// decryptor 当前平台和版本的解密器，复用以保留派生密钥缓存
+	decryptor        decrypt.Decryptor
+	decryptorVersion string
 }
 
 type Config interface {
@@ -37,6 +44,9 @@ type Config interface {
 	GetWorkDir() string
 	GetPlatform() string
 	GetVersion() int
+
+	// GetWorkKey 工作目录中数据库的加密密码，为空时以明文保存
+	GetWorkKey() string
 }
 
 func NewService(conf Config) *Service {
@@ -69,7 +79,21 @@ func (s *Service) GetDataKey(info *wechat.Account) (string, error) {
 
 func (s *Service) StartAutoDecrypt() error {
 	log.Info().Msgf("start auto decrypt, data dir: %s", s.conf.GetDataDir())
-	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db$`, []string{"fts"})
+
+	// 自动解密只处理发生变化的数据库，工作目录中已有数据库的加密方式与当前配置不一致时先全部重新解密，
+	// 避免明文和加密的数据库混在一起，仍不一致时拒绝启动
+	if file := s.mismatchedWorkFile(); file != "" {
+		log.Info().Msgf("%s does not match the work key setting, decrypt all databases again", file)
+		if err := s.DecryptDBFiles(); err != nil {
+			return err
+		}
+		if file := s.mismatchedWorkFile(); file != "" {
+			return errors.WorkDirKeyMismatch(file)
+		}
+	}
+
+	// WAL 文件中的新内容会合并到解密后的数据库
+	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db(-wal)?$`, []string{"fts"})
 	if err != nil {
 		return err
 	}
@@ -84,6 +108,47 @@ func (s *Service) StartAutoDecrypt() error {
 	return nil
 }
 
+// mismatchedWorkFile 返回工作目录中加密方式与当前配置不一致的数据库文件
+// 设置了工作目录密码时数据库应为使用该密码加密的 SQLCipher 4 格式，否则应为明文
+func (s *Service) mismatchedWorkFile() string {
+	workKey := s.conf.GetWorkKey()
+	checked := false
+	var mismatched string
+	filepath.WalkDir(s.conf.GetWorkDir(), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".db") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		header := make([]byte, len(common.SQLiteHeader))
+		_, err = io.ReadFull(f, header)
+		f.Close()
+		if err != nil {
+			return nil
+		}
+
+		plain := string(header) == common.SQLiteHeader
+		if plain != (workKey == "") {
+			mismatched = path
+			return filepath.SkipAll
+		}
+		// 同一工作目录中的数据库使用相同的密码加密，只验证第一个文件的密码
+		if !plain && !checked {
+			checked = true
+			cf, err := common.NewCipher().OpenFile(path, workKey, true)
+			if err != nil {
+				mismatched = path
+				return filepath.SkipAll
+			}
+			cf.Close()
+		}
+		return nil
+	})
+	return mismatched
+}
+
 func (s *Service) StopAutoDecrypt() error {
 	if s.fm != nil {
 		if err := s.fm.Stop(); err != nil {
@@ -107,13 +172,16 @@ func (s *Service) DecryptFileCallback(event fsnotify.Event) error {
 		return nil
 	}
 
+	// WAL 文件变化时解密对应的数据库
+	dbFile := strings.TrimSuffix(event.Name, common.WALSuffix)
+
 	s.mutex.Lock()
-	s.lastEvents[event.Name] = time.Now()
+	s.lastEvents[dbFile] = time.Now()
 
-	if !s.pendingActions[event.Name] {
-		s.pendingActions[event.Name] = true
+	if !s.pendingActions[dbFile] {
+		s.pendingActions[dbFile] = true
 		s.mutex.Unlock()
-		go s.waitAndProcess(event.Name)
+		go s.waitAndProcess(dbFile)
 	} else {
 		s.mutex.Unlock()
 	}
@@ -143,9 +211,26 @@ func (s *Service) waitAndProcess(dbFile string) {
 	}
 }
 
-func (s *Service) DecryptDBFile(dbFile string) error {
+// getDecryptor 返回当前平台和版本的解密器，平台或版本变化时重新创建
+func (s *Service) getDecryptor() (decrypt.Decryptor, error) {
+	version := fmt.Sprintf("%s_%d", s.conf.GetPlatform(), s.conf.GetVersion())
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.decryptor != nil && s.decryptorVersion == version {
+		return s.decryptor, nil
+	}
 	decryptor, err := decrypt.NewDecryptor(s.conf.GetPlatform(), s.conf.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	s.decryptor, s.decryptorVersion = decryptor, version
+	return decryptor, nil
+}
+
+func (s *Service) DecryptDBFile(dbFile string) error {
+
+	decryptor, err := s.getDecryptor()
 	if err != nil {
 		return err
 	}
@@ -155,6 +240,26 @@ func (s *Service) DecryptDBFile(dbFile string) error {
 		return err
 	}
 
+	// 增量解密，仅解密与上次相比发生变化的页面
+	if d, ok := decryptor.(decrypt.IncrementalDecryptor); ok {
+		err := d.DecryptIncremental(context.Background(), dbFile, s.conf.GetDataKey(), output, s.conf.GetWorkKey())
+		if err == nil {
+			log.Debug().Msgf("Decrypted %s to %s", dbFile, output)
+			return nil
+		}
+		if err != errors.ErrAlreadyDecrypted {
+			log.Err(err).Msgf("failed to decrypt %s", dbFile)
+			return err
+		}
+	}
+
+	// 未加密的数据库没有保留加密所需的空间，无法重新加密，不在工作目录中保存明文副本
+	if s.conf.GetWorkKey() != "" {
+		err := errors.SQLCipherLayoutUnsupported(decryptor.GetPageSize(), 0)
+		log.Err(err).Msgf("failed to encrypt %s", dbFile)
+		return err
+	}
+
 	outputTemp := output + ".tmp"
 	outputFile, err := os.Create(outputTemp)
 	if err != nil {
@@ -194,12 +299,22 @@ func (s *Service) DecryptDBFiles() error {
 		return err
 	}
 
+	start := time.Now()
+	var count int
+	var size int64
 	for _, dbFile := range dbFiles {
 		if err := s.DecryptDBFile(dbFile); err != nil {
 			log.Debug().Msgf("DecryptDBFile %s failed: %v", dbFile, err)
 			continue
 		}
+		count++
+		if info, err := os.Stat(dbFile); err == nil {
+			size += info.Size()
+		}
 	}
 
+	elapsed := time.Since(start)
+	log.Info().Msgf("decrypted %d files, %.1f MB in %s (%.1f MB/s)", count, float64(size)/1024/1024, elapsed.Round(time.Millisecond), float64(size)/1024/1024/max(elapsed.Seconds(), 1e-6))
+
 	return nil
 }
@@ -0,0 +1,134 @@
+package wechat
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/internal/wechatdb/datasource/dbm"
+	"github.com/sjzar/chatlog/pkg/filemonitor"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// IntegrityCheckMaxErrors PRAGMA integrity_check 最多返回的错误数量
+const IntegrityCheckMaxErrors = 100
+
+// VerifyDBFiles 校验并解密全部数据库文件，返回每个文件的校验结果
+func (s *Service) VerifyDBFiles() ([]*common.VerifyReport, error) {
+	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db$`, []string{"fts"})
+	if err != nil {
+		return nil, err
+	}
+
+	dbFiles, err := dbGroup.List()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*common.VerifyReport, 0, len(dbFiles))
+	for _, dbFile := range dbFiles {
+		reports = append(reports, s.VerifyDBFile(dbFile))
+	}
+	return reports, nil
+}
+
+// VerifyDBFile 校验每个页面的 HMAC 并解密数据库文件，跳过损坏的页面，
+// 然后对解密后的文件执行 PRAGMA integrity_check
+func (s *Service) VerifyDBFile(dbFile string) *common.VerifyReport {
+	output := filepath.Join(s.conf.GetWorkDir(), dbFile[len(s.conf.GetDataDir()):])
+	report, err := s.verifyDBFile(dbFile, output)
+	if err != nil {
+		log.Debug().Err(err).Msgf("verify %s failed", dbFile)
+		return &common.VerifyReport{Path: dbFile, Output: output, Error: err.Error()}
+	}
+	report.Output = output
+
+	report.IntegrityCheck, err = integrityCheck(output, s.conf.GetWorkKey())
+	if err != nil {
+		report.IntegrityCheck = []string{err.Error()}
+	}
+	return report
+}
+
+func (s *Service) verifyDBFile(dbFile string, output string) (*common.VerifyReport, error) {
+	decryptor, err := s.getDecryptor()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := decryptor.(decrypt.VerifyDecryptor)
+	if !ok {
+		return nil, errors.PlatformUnsupported(s.conf.GetPlatform(), s.conf.GetVersion())
+	}
+
+	if err := util.PrepareDir(filepath.Dir(output)); err != nil {
+		return nil, err
+	}
+	outputTemp := output + ".tmp"
+	outputFile, err := os.Create(outputTemp)
+	if err != nil {
+		return nil, errors.OpenFileFailed(outputTemp, err)
+	}
+
+	report, err := d.Verify(context.Background(), dbFile, s.conf.GetDataKey(), outputFile, s.conf.GetWorkKey())
+	if err != nil {
+		outputFile.Close()
+		os.Remove(outputTemp)
+		return nil, err
+	}
+	if err := outputFile.Close(); err != nil {
+		os.Remove(outputTemp)
+		return nil, errors.WriteOutputFailed(err)
+	}
+
+	// 解密后的文件已重新生成，页面指纹清单不再对应
+	os.Remove(output + common.ManifestSuffix)
+	if err := os.Rename(outputTemp, output); err != nil {
+		os.Remove(outputTemp)
+		return nil, errors.WriteOutputFailed(err)
+	}
+	return report, nil
+}
+
+// integrityCheck 对数据库文件执行 PRAGMA integrity_check，文件完整时返回 ["ok"]
+// key 不为空时数据库文件为使用 key 加密的 SQLCipher 格式
+func integrityCheck(path string, key string) ([]string, error) {
+	var db *sql.DB
+	var err error
+	if key != "" {
+		db, err = dbm.OpenCipherDB(path, key)
+	} else {
+		db, err = sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	}
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA integrity_check(%d)", IntegrityCheckMaxErrors))
+	if err != nil {
+		return nil, errors.QueryFailed("PRAGMA integrity_check", err)
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		result = append(result, line)
+	}
+	// 页面损坏严重时，SQLite 会在返回部分检查结果后中断检查
+	if err := rows.Err(); err != nil {
+		result = append(result, err.Error())
+	}
+	return result, nil
+}
@@ -14,12 +14,23 @@ var (
 	ErrValidatorNotSet               = New(nil, http.StatusBadRequest, "validator not set")
 	ErrNoValidKey                    = New(nil, http.StatusBadRequest, "no valid key found")
 	ErrWeChatDLLNotFound             = New(nil, http.StatusBadRequest, "WeChatWin.dll module not found")
+	ErrMemoryDumpNotFound            = New(nil, http.StatusBadRequest, "memory dump not found in zip file")
+	ErrWorkKeyIncorrect              = New(nil, http.StatusBadRequest, "incorrect work dir key")
+	ErrImgKeySampleNotFound          = New(nil, http.StatusBadRequest, "no aes encrypted image (.dat) found")
 )
 
 func PlatformUnsupported(platform string, version int) *Error {
 	return Newf(nil, http.StatusBadRequest, "unsupported platform: %s v%d", platform, version).WithStack()
 }
 
+func WorkDirKeyMismatch(path string) *Error {
+	return Newf(nil, http.StatusBadRequest, "work dir database %s does not match the work key setting, remove the work dir or use the key it was encrypted with", path).WithStack()
+}
+
+func SQLCipherLayoutUnsupported(pageSize, reserve int) *Error {
+	return Newf(nil, http.StatusBadRequest, "unsupported sqlcipher layout: page size %d, reserve %d", pageSize, reserve).WithStack()
+}
+
 func DecryptCreateCipherFailed(cause error) *Error {
 	return New(cause, http.StatusInternalServerError, "failed to create cipher").WithStack()
 }
@@ -63,3 +74,15 @@ func WeChatAccountNotOnline(name string) *Error {
 func RefreshProcessStatusFailed(cause error) *Error {
 	return New(cause, http.StatusInternalServerError, "failed to refresh process status").WithStack()
 }
+
+func FormatUndetected(dir string) *Error {
+	return Newf(nil, http.StatusBadRequest, "cannot detect platform and version of %s, please specify them", dir).WithStack()
+}
+
+func FormatConflict(detected string, dir string, platform string, version int) *Error {
+	return Newf(nil, http.StatusBadRequest, "detected %s from %s, conflicts with platform %q version %d", detected, dir, platform, version).WithStack()
+}
+
+func DumpPlatformUndetected(path string, dir string) *Error {
+	return Newf(nil, http.StatusBadRequest, "cannot detect platform of memory dump %s from %s, please specify --platform", path, dir).WithStack()
+}
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CatalogLink        = "link"
+	CatalogFile        = "file"
+	CatalogMiniProgram = "miniprogram"
+	CatalogChannel     = "channel"
+)
+
+// CatalogKinds 支持的目录条目类型
+var CatalogKinds = []string{CatalogLink, CatalogFile, CatalogMiniProgram, CatalogChannel}
+
+// Catalog 聊天中分享的链接、文件、小程序和视频号目录
+type Catalog struct {
+	StartTime time.Time      `json:"startTime"`
+	EndTime   time.Time      `json:"endTime"`
+	Total     int            `json:"total"` // 去重后的条目总数
+	Items     []*CatalogItem `json:"items"`
+
+	// 目录不建立索引，每次请求扫描消息生成
+	// Talkers 为扫描的会话数量，Truncated 表示会话数量超过上限，部分会话未被扫描
+	ScanOnly  bool `json:"scanOnly"`
+	Talkers   int  `json:"talkers"`
+	Truncated bool `json:"truncated,omitempty"`
+
+	kind    string
+	keyword string
+	items   map[string]*CatalogItem
+}
+
+// CatalogItem 去重后的分享条目，同一链接或文件被多次分享时合并为一条
+type CatalogItem struct {
+	Kind      string          `json:"kind"`
+	Title     string          `json:"title"`
+	Desc      string          `json:"desc,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	MD5       string          `json:"md5,omitempty"`
+	Count     int             `json:"count"` // 被分享的次数
+	FirstTime time.Time       `json:"firstTime"`
+	LastTime  time.Time       `json:"lastTime"`
+	Shares    []*CatalogShare `json:"shares"`
+}
+
+// CatalogShare 一次分享记录
+type CatalogShare struct {
+	Time       time.Time `json:"time"`
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName"`
+}
+
+// NewCatalog 创建目录，kind 和 keyword 为空时不过滤
+// keyword 匹配标题、描述、链接、发送者和对话方名称，不区分大小写
+func NewCatalog(startTime, endTime time.Time, kind, keyword string) *Catalog {
+	return &Catalog{
+		StartTime: startTime,
+		EndTime:   endTime,
+		ScanOnly:  true,
+		kind:      kind,
+		keyword:   strings.ToLower(keyword),
+		items:     make(map[string]*CatalogItem),
+	}
+}
+
+// CatalogKind 返回消息对应的目录条目类型，不属于目录的消息返回空字符串
+func CatalogKind(msg *Message) string {
+	if msg.Type != MessageTypeShare {
+		return ""
+	}
+	switch msg.SubType {
+	case MessageSubTypeLink, MessageSubTypeLink2:
+		return CatalogLink
+	case MessageSubTypeFile:
+		return CatalogFile
+	case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
+		return CatalogMiniProgram
+	case MessageSubTypeChannel:
+		return CatalogChannel
+	}
+	return ""
+}
+
+// AddMessage 输入消息，按链接或文件 MD5 去重
+func (c *Catalog) AddMessage(msg *Message) {
+	kind := CatalogKind(msg)
+	if kind == "" || (c.kind != "" && kind != c.kind) {
+		return
+	}
+
+	item := &CatalogItem{Kind: kind}
+	item.Title, _ = msg.Contents["title"].(string)
+	item.Desc, _ = msg.Contents["desc"].(string)
+	item.URL, _ = msg.Contents["url"].(string)
+	item.MD5, _ = msg.Contents["md5"].(string)
+	share := &CatalogShare{
+		Time:       msg.Time,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+	}
+	if !c.match(item, share) {
+		return
+	}
+
+	key := item.Key()
+	if m, ok := c.items[key]; ok {
+		item = m
+	} else {
+		c.items[key] = item
+	}
+	item.Count++
+	item.Shares = append(item.Shares, share)
+	if item.FirstTime.IsZero() || msg.Time.Before(item.FirstTime) {
+		item.FirstTime = msg.Time
+	}
+	if msg.Time.After(item.LastTime) {
+		item.LastTime = msg.Time
+	}
+}
+
+func (c *Catalog) match(item *CatalogItem, share *CatalogShare) bool {
+	if c.keyword == "" {
+		return true
+	}
+	for _, s := range []string{item.Title, item.Desc, item.URL, share.SenderName, share.TalkerName} {
+		if strings.Contains(strings.ToLower(s), c.keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finish 汇总目录，条目按最后分享时间降序排列，分享记录按时间升序排列
+func (c *Catalog) Finish() {
+	c.Items = make([]*CatalogItem, 0, len(c.items))
+	for _, item := range c.items {
+		sort.SliceStable(item.Shares, func(i, j int) bool {
+			return item.Shares[i].Time.Before(item.Shares[j].Time)
+		})
+		c.Items = append(c.Items, item)
+	}
+	sort.Slice(c.Items, func(i, j int) bool {
+		if !c.Items[i].LastTime.Equal(c.Items[j].LastTime) {
+			return c.Items[i].LastTime.After(c.Items[j].LastTime)
+		}
+		return c.Items[i].Title < c.Items[j].Title
+	})
+	c.Total = len(c.Items)
+}
+
+// Page 截取分页结果
+func (c *Catalog) Page(limit, offset int) {
+	if offset > 0 {
+		if offset >= len(c.Items) {
+			c.Items = c.Items[:0]
+		} else {
+			c.Items = c.Items[offset:]
+		}
+	}
+	if limit > 0 && limit < len(c.Items) {
+		c.Items = c.Items[:limit]
+	}
+}
+
+// Link 返回条目的访问地址，文件使用 HTTP 服务的 /file 接口
+func (i *CatalogItem) Link(host string) string {
+	if i.Kind == CatalogFile {
+		if host == "" || i.MD5 == "" {
+			return ""
+		}
+		return fmt.Sprintf("http://%s/file/%s", host, i.MD5)
+	}
+	return i.URL
+}
+
+// CSVHeader 目录 CSV 表头
+func (c *Catalog) CSVHeader() []string {
+	return []string{"Kind", "Title", "URL", "MD5", "Count", "FirstTime", "LastTime", "SenderName", "Sender", "TalkerName", "Talker"}
+}
+
+// CSV 目录条目 CSV 数据，发送者和对话方为最近一次分享
+func (i *CatalogItem) CSV(host string) []string {
+	last := i.Shares[len(i.Shares)-1]
+	return []string{
+		i.Kind,
+		i.Title,
+		i.Link(host),
+		i.MD5,
+		fmt.Sprint(i.Count),
+		i.FirstTime.Format("2006-01-02 15:04:05"),
+		i.LastTime.Format("2006-01-02 15:04:05"),
+		last.SenderName,
+		last.Sender,
+		last.TalkerName,
+		last.Talker,
+	}
+}
+
+// Key 返回去重条目的标识：链接为 URL，文件为 MD5，缺少时使用标题
+func (i *CatalogItem) Key() string {
+	key := i.URL
+	if i.Kind == CatalogFile {
+		key = i.MD5
+	}
+	if key == "" {
+		key = i.Title
+	}
+	return i.Kind + ":" + key
+}
+
+// PlainText 以纯文本形式输出目录
+func (c *Catalog) PlainText(host string) string {
+	return JoinTextBlocks(c.TextBlocks(host))
+}
+
+// TextBlocks 按概要和每个条目分段输出目录
+func (c *Catalog) TextBlocks(host string) []TextBlock {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("时间范围: %s ~ %s，共 %d 条\n", c.StartTime.Format("2006-01-02 15:04:05"), c.EndTime.Format("2006-01-02 15:04:05"), c.Total))
+	if c.Truncated {
+		buf.WriteString(fmt.Sprintf("仅扫描了最近活跃的 %d 个会话，请指定对话方查看其他会话\n", c.Talkers))
+	}
+	blocks := []TextBlock{{Key: "header", Text: buf.String()}}
+	for _, item := range c.Items {
+		blocks = append(blocks, TextBlock{Key: "item/" + item.Key(), Text: "\n" + item.PlainText(host)})
+	}
+	return blocks
+}
+
+// PlainText 以纯文本形式输出条目
+func (i *CatalogItem) PlainText(host string) string {
+	buf := strings.Builder{}
+	title := i.Title
+	if title == "" {
+		title = "(无标题)"
+	}
+	buf.WriteString(fmt.Sprintf("[%s] %s\n", i.Kind, title))
+	if link := i.Link(host); link != "" {
+		buf.WriteString(link + "\n")
+	} else if i.MD5 != "" {
+		buf.WriteString("md5: " + i.MD5 + "\n")
+	}
+	if i.Count > 1 {
+		buf.WriteString(fmt.Sprintf("分享 %d 次\n", i.Count))
+	}
+	for _, s := range i.Shares {
+		buf.WriteString(fmt.Sprintf("  %s %s 分享于 %s\n", s.Time.Format("2006-01-02 15:04:05"), nameOf(s.Sender, s.SenderName), nameOf(s.Talker, s.TalkerName)))
+	}
+	return buf.String()
+}
+
+func nameOf(id, name string) string {
+	if name == "" {
+		return id
+	}
+	return fmt.Sprintf("%s(%s)", name, id)
+}
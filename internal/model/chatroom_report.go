@@ -0,0 +1,279 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChatRoomReport 群聊成员活跃度报告
+type ChatRoomReport struct {
+	ChatRoom     string                 `json:"chatRoom"`
+	ChatRoomName string                 `json:"chatRoomName"`
+	StartTime    time.Time              `json:"startTime"`
+	EndTime      time.Time              `json:"endTime"`
+	Total        int                    `json:"total"`
+	MemberCount  int                    `json:"memberCount"`
+	SilentCount  int                    `json:"silentCount"`
+	Members      []*MemberActivity      `json:"members"`
+	Events       []*ChatRoomMemberEvent `json:"events"`
+
+	members   map[string]*MemberActivity
+	userNames map[string][]string
+	mentions  *MentionMatcher
+}
+
+// MemberActivity 群成员活跃度
+type MemberActivity struct {
+	UserName    string     `json:"userName"`
+	DisplayName string     `json:"displayName"`
+	IsMember    bool       `json:"isMember"` // 是否仍在群成员列表中
+	IsSelf      bool       `json:"isSelf"`
+	Count       int        `json:"count"`
+	LastTime    *time.Time `json:"lastTime,omitempty"`
+	Mentioned   int        `json:"mentioned"` // 被 @ 的次数
+	Mentions    int        `json:"mentions"`  // @ 他人的次数
+	JoinTime    *time.Time `json:"joinTime,omitempty"`
+	LeaveTime   *time.Time `json:"leaveTime,omitempty"`
+}
+
+// ChatRoomMemberEvent 群成员变动事件
+type ChatRoomMemberEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	UserName    string    `json:"userName"`
+	DisplayName string    `json:"displayName"`
+}
+
+// NewChatRoomReport 创建群聊报告
+// userNames 为成员 ID 到可用名称（群昵称、备注、昵称等）的映射，第一个名称用于展示，其余名称用于匹配 @ 和成员变动消息
+func NewChatRoomReport(chatRoom *ChatRoom, userNames map[string][]string, startTime, endTime time.Time) *ChatRoomReport {
+	r := &ChatRoomReport{
+		ChatRoom:     chatRoom.Name,
+		ChatRoomName: chatRoom.DisplayName(),
+		StartTime:    startTime,
+		EndTime:      endTime,
+		MemberCount:  len(chatRoom.Users),
+		members:      make(map[string]*MemberActivity),
+		userNames:    userNames,
+		mentions:     NewMentionMatcher(userNames),
+	}
+
+	for _, user := range chatRoom.Users {
+		r.member(user.UserName).IsMember = true
+	}
+	return r
+}
+
+func (r *ChatRoomReport) member(user string) *MemberActivity {
+	m, ok := r.members[user]
+	if !ok {
+		m = &MemberActivity{UserName: user}
+		if names := r.userNames[user]; len(names) > 0 {
+			m.DisplayName = names[0]
+		}
+		r.members[user] = m
+	}
+	return m
+}
+
+// AddMessage 按时间顺序输入群聊消息
+func (r *ChatRoomReport) AddMessage(msg *Message) {
+	if msg.Type == MessageTypeSystem {
+		r.addMemberEvent(msg)
+		return
+	}
+
+	r.Total++
+	m := r.member(msg.Sender)
+	if msg.IsSelf {
+		m.IsSelf = true
+		if m.DisplayName == "" {
+			m.DisplayName = "我"
+		}
+	}
+	if m.DisplayName == "" && msg.SenderName != "" {
+		m.DisplayName = msg.SenderName
+	}
+	m.Count++
+	t := msg.Time
+	m.LastTime = &t
+
+	if msg.Type == MessageTypeText {
+		for _, user := range r.mentions.Match(msg.Content) {
+			if user == msg.Sender {
+				continue
+			}
+			r.member(user).Mentioned++
+			m.Mentions++
+		}
+	}
+}
+
+func (r *ChatRoomReport) addMemberEvent(msg *Message) {
+	event, members := msg.extra.memberEvent, msg.extra.members
+	if event == "" {
+		return
+	}
+
+	for _, member := range members {
+		user := member.Username
+		if user == "" {
+			user = r.mentions.User(member.Nickname)
+		}
+		name := member.Nickname
+		if names := r.userNames[user]; len(names) > 0 {
+			name = names[0]
+		}
+		r.Events = append(r.Events, &ChatRoomMemberEvent{
+			Time:        msg.Time,
+			Event:       event,
+			UserName:    user,
+			DisplayName: name,
+		})
+		if user == "" {
+			continue
+		}
+
+		t := msg.Time
+		m := r.member(user)
+		if m.DisplayName == "" {
+			m.DisplayName = name
+		}
+		switch event {
+		case MemberEventJoin:
+			m.JoinTime = &t
+		case MemberEventLeave:
+			m.LeaveTime = &t
+		}
+	}
+}
+
+// Finish 汇总统计结果，成员按发言数量降序排列
+func (r *ChatRoomReport) Finish() {
+	r.Members = make([]*MemberActivity, 0, len(r.members))
+	r.SilentCount = 0
+	for _, m := range r.members {
+		r.Members = append(r.Members, m)
+		if m.IsMember && m.Count == 0 {
+			r.SilentCount++
+		}
+	}
+	sort.Slice(r.Members, func(i, j int) bool {
+		if r.Members[i].Count != r.Members[j].Count {
+			return r.Members[i].Count > r.Members[j].Count
+		}
+		return r.Members[i].UserName < r.Members[j].UserName
+	})
+}
+
+// CSVHeader 成员活跃度 CSV 表头
+func (r *ChatRoomReport) CSVHeader() []string {
+	return []string{"UserName", "DisplayName", "IsMember", "Count", "LastTime", "Mentioned", "Mentions", "JoinTime", "LeaveTime"}
+}
+
+// CSV 成员活跃度 CSV 数据
+func (m *MemberActivity) CSV() []string {
+	return []string{
+		m.UserName,
+		m.DisplayName,
+		fmt.Sprint(m.IsMember),
+		fmt.Sprint(m.Count),
+		formatTimePtr(m.LastTime),
+		fmt.Sprint(m.Mentioned),
+		fmt.Sprint(m.Mentions),
+		formatTimePtr(m.JoinTime),
+		formatTimePtr(m.LeaveTime),
+	}
+}
+
+// PlainText 以纯文本形式输出报告
+func (r *ChatRoomReport) PlainText() string {
+	return JoinTextBlocks(r.TextBlocks())
+}
+
+// TextBlocks 按概要、各小节标题和每个成员或变动记录分段输出报告
+func (r *ChatRoomReport) TextBlocks() []TextBlock {
+	buf := strings.Builder{}
+	name := r.ChatRoom
+	if r.ChatRoomName != "" {
+		name = fmt.Sprintf("%s(%s)", r.ChatRoomName, r.ChatRoom)
+	}
+	buf.WriteString(fmt.Sprintf("群聊: %s\n", name))
+	buf.WriteString(fmt.Sprintf("时间范围: %s ~ %s\n", r.StartTime.Format("2006-01-02 15:04:05"), r.EndTime.Format("2006-01-02 15:04:05")))
+	buf.WriteString(fmt.Sprintf("消息总数: %d，成员数: %d，未发言成员数: %d\n", r.Total, r.MemberCount, r.SilentCount))
+	blocks := []TextBlock{{Key: "header", Text: buf.String()}}
+
+	blocks = append(blocks, TextBlock{Key: "ranking", Text: "\n## 发言排行\n"})
+	for i, m := range r.Members {
+		if m.Count == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%d. %s: %d 条，最后发言 %s", i+1, m.Name(), m.Count, formatTimePtr(m.LastTime))
+		if m.Mentioned > 0 || m.Mentions > 0 {
+			line += fmt.Sprintf("，被@ %d 次，@他人 %d 次", m.Mentioned, m.Mentions)
+		}
+		if !m.IsMember {
+			line += "（已不在群中）"
+		}
+		blocks = append(blocks, TextBlock{Key: "ranking/" + m.key(), Text: line + "\n"})
+	}
+
+	blocks = append(blocks, TextBlock{Key: "silent", Text: "\n## 未发言成员\n"})
+	for _, m := range r.Members {
+		if m.IsMember && m.Count == 0 {
+			line := m.Name()
+			if m.Mentioned > 0 {
+				line += fmt.Sprintf("，被@ %d 次", m.Mentioned)
+			}
+			blocks = append(blocks, TextBlock{Key: "silent/" + m.key(), Text: line + "\n"})
+		}
+	}
+
+	if len(r.Events) > 0 {
+		blocks = append(blocks, TextBlock{Key: "events", Text: "\n## 成员变动\n"})
+		for _, e := range r.Events {
+			action := "加入"
+			if e.Event == MemberEventLeave {
+				action = "移出"
+			}
+			who := e.DisplayName
+			if e.UserName != "" {
+				who = fmt.Sprintf("%s(%s)", e.DisplayName, e.UserName)
+			}
+			blocks = append(blocks, TextBlock{
+				Key:  fmt.Sprintf("events/%d/%s/%s", e.Time.Unix(), e.Event, who),
+				Text: fmt.Sprintf("%s %s %s\n", e.Time.Format("2006-01-02 15:04:05"), action, who),
+			})
+		}
+	}
+
+	return blocks
+}
+
+// key 返回成员在报告中的唯一标识，没有微信 ID 的成员使用展示名称
+func (m *MemberActivity) key() string {
+	if m.UserName != "" {
+		return m.UserName
+	}
+	return "name:" + m.DisplayName
+}
+
+// Name 返回成员的展示名称
+func (m *MemberActivity) Name() string {
+	switch {
+	case m.UserName == "":
+		return m.DisplayName
+	case m.DisplayName != "":
+		return fmt.Sprintf("%s(%s)", m.DisplayName, m.UserName)
+	}
+	return m.UserName
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
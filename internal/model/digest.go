@@ -0,0 +1,226 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// digestTopSenders 摘要中展示的活跃发送者数量
+	digestTopSenders = 5
+
+	// digestContentLength 摘要中消息内容的最大长度
+	digestContentLength = 100
+)
+
+// Digest 一段时间内的聊天摘要
+type Digest struct {
+	Name      string          `json:"name"`
+	StartTime time.Time       `json:"startTime"`
+	EndTime   time.Time       `json:"endTime"`
+	Total     int             `json:"total"`
+	Talkers   []*TalkerDigest `json:"talkers"`
+}
+
+// TalkerDigest 单个对话的摘要
+type TalkerDigest struct {
+	Talker     string           `json:"talker"`
+	TalkerName string           `json:"talkerName"`
+	IsChatRoom bool             `json:"isChatRoom"`
+	Count      int              `json:"count"`
+	TopSenders []*DigestSender  `json:"topSenders"`
+	Links      []*DigestMessage `json:"links"`
+	Files      []*DigestMessage `json:"files"`
+	Mentions   []*DigestMessage `json:"mentions"`  // @我 或引用我的消息
+	Questions  []*DigestMessage `json:"questions"` // 向我提出且尚未回复的问题
+
+	senders  map[string]*DigestSender
+	self     string
+	mentions *MentionMatcher
+	pending  []*DigestMessage
+}
+
+// DigestSender 发送者消息数量
+type DigestSender struct {
+	Sender     string `json:"sender"`
+	SenderName string `json:"senderName"`
+	Count      int    `json:"count"`
+}
+
+// DigestMessage 摘要中引用的消息
+type DigestMessage struct {
+	Time       time.Time `json:"time"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName"`
+	Content    string    `json:"content"`
+	Title      string    `json:"title,omitempty"`
+	URL        string    `json:"url,omitempty"`
+}
+
+// NewTalkerDigest 创建对话摘要，self 为自己的微信 ID，mentions 用于识别群聊中 @我 的消息
+func NewTalkerDigest(talker, talkerName, self string, mentions *MentionMatcher) *TalkerDigest {
+	return &TalkerDigest{
+		Talker:     talker,
+		TalkerName: talkerName,
+		IsChatRoom: strings.HasSuffix(talker, "@chatroom"),
+		senders:    make(map[string]*DigestSender),
+		self:       self,
+		mentions:   mentions,
+	}
+}
+
+// AddMessage 按时间顺序输入对话中的新消息
+func (d *TalkerDigest) AddMessage(msg *Message) {
+	if msg.Type == MessageTypeSystem {
+		return
+	}
+	d.Count++
+
+	if msg.IsSelf {
+		// 我发言后视为已回复之前的问题
+		d.pending = nil
+		return
+	}
+
+	s, ok := d.senders[msg.Sender]
+	if !ok {
+		s = &DigestSender{Sender: msg.Sender, SenderName: msg.SenderName}
+		d.senders[msg.Sender] = s
+	}
+	s.Count++
+
+	dm := &DigestMessage{
+		Time:       msg.Time,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		Content:    truncateRunes(msg.PlainTextContent(), digestContentLength),
+	}
+
+	switch {
+	case msg.Type == MessageTypeShare && (msg.SubType == MessageSubTypeLink || msg.SubType == MessageSubTypeLink2):
+		dm.Title, _ = msg.Contents["title"].(string)
+		dm.URL, _ = msg.Contents["url"].(string)
+		d.Links = append(d.Links, dm)
+	case msg.Type == MessageTypeShare && msg.SubType == MessageSubTypeFile:
+		dm.Title, _ = msg.Contents["title"].(string)
+		d.Files = append(d.Files, dm)
+	case msg.Type == MessageTypeText, msg.Type == MessageTypeShare && msg.SubType == MessageSubTypeQuote:
+		mentioned := d.IsChatRoom && MentionOf(msg, d.self, d.mentions) != ""
+		if mentioned {
+			d.Mentions = append(d.Mentions, dm)
+		}
+		// 私聊中的问题，或群聊中 @我 或引用我的问题
+		if (!d.IsChatRoom || mentioned) && IsQuestion(msg.Content) {
+			d.pending = append(d.pending, dm)
+		}
+	}
+}
+
+// Finish 汇总活跃发送者和未回复的问题
+func (d *TalkerDigest) Finish() {
+	d.Questions = d.pending
+	d.TopSenders = make([]*DigestSender, 0, len(d.senders))
+	for _, s := range d.senders {
+		d.TopSenders = append(d.TopSenders, s)
+	}
+	sort.Slice(d.TopSenders, func(i, j int) bool {
+		if d.TopSenders[i].Count != d.TopSenders[j].Count {
+			return d.TopSenders[i].Count > d.TopSenders[j].Count
+		}
+		return d.TopSenders[i].Sender < d.TopSenders[j].Sender
+	})
+	if len(d.TopSenders) > digestTopSenders {
+		d.TopSenders = d.TopSenders[:digestTopSenders]
+	}
+}
+
+// IsQuestion 粗略判断文本是否为问句
+func IsQuestion(text string) bool {
+	text = strings.TrimSpace(text)
+	if strings.ContainsAny(text, "?？") {
+		return true
+	}
+	for _, suffix := range []string{"吗", "么", "呢", "吧"} {
+		if strings.HasSuffix(text, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown 以 Markdown 格式输出摘要
+func (d *Digest) Markdown() string {
+	buf := strings.Builder{}
+	title := "聊天摘要"
+	if d.Name != "" {
+		title += " - " + d.Name
+	}
+	buf.WriteString(fmt.Sprintf("# %s\n\n", title))
+	buf.WriteString(fmt.Sprintf("时间范围：%s ~ %s，共 %d 个对话，%d 条新消息\n", d.StartTime.Format("2006-01-02 15:04"), d.EndTime.Format("2006-01-02 15:04"), len(d.Talkers), d.Total))
+
+	for _, t := range d.Talkers {
+		name := t.Talker
+		if t.TalkerName != "" {
+			name = fmt.Sprintf("%s (%s)", t.TalkerName, t.Talker)
+		}
+		buf.WriteString(fmt.Sprintf("\n## %s\n\n", name))
+		buf.WriteString(fmt.Sprintf("- 新消息：%d 条\n", t.Count))
+		if len(t.TopSenders) > 0 {
+			senders := make([]string, 0, len(t.TopSenders))
+			for _, s := range t.TopSenders {
+				senders = append(senders, fmt.Sprintf("%s(%d)", digestSenderName(s.Sender, s.SenderName), s.Count))
+			}
+			buf.WriteString(fmt.Sprintf("- 活跃发送者：%s\n", strings.Join(senders, "、")))
+		}
+
+		writeDigestMessages(&buf, "@我的消息", t.Mentions)
+		writeDigestMessages(&buf, "待回复的问题", t.Questions)
+
+		if len(t.Links) > 0 {
+			buf.WriteString("\n### 分享的链接\n\n")
+			for _, m := range t.Links {
+				title := m.Title
+				if title == "" {
+					title = m.URL
+				}
+				buf.WriteString(fmt.Sprintf("- [%s](%s) — %s\n", title, m.URL, digestSenderName(m.Sender, m.SenderName)))
+			}
+		}
+		if len(t.Files) > 0 {
+			buf.WriteString("\n### 分享的文件\n\n")
+			for _, m := range t.Files {
+				buf.WriteString(fmt.Sprintf("- %s — %s\n", m.Title, digestSenderName(m.Sender, m.SenderName)))
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+func writeDigestMessages(buf *strings.Builder, title string, messages []*DigestMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	buf.WriteString(fmt.Sprintf("\n### %s\n\n", title))
+	for _, m := range messages {
+		buf.WriteString(fmt.Sprintf("- %s %s：%s\n", m.Time.Format("01-02 15:04"), digestSenderName(m.Sender, m.SenderName), m.Content))
+	}
+}
+
+func digestSenderName(sender, senderName string) string {
+	if senderName != "" {
+		return senderName
+	}
+	return sender
+}
+
+func truncateRunes(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
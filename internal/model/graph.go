@@ -0,0 +1,386 @@
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// GraphSelfID 图中代表自己的节点 ID
+	GraphSelfID = "self"
+
+	GraphNodeSelf     = "self"
+	GraphNodeContact  = "contact"
+	GraphNodeChatRoom = "chatroom"
+
+	// GraphEdgeMessage 私聊中发送者到接收者的消息
+	GraphEdgeMessage = "message"
+	// GraphEdgeMember 成员到群聊，权重为成员在群里的发言数量
+	GraphEdgeMember = "member"
+	// GraphEdgeQuote 发送者到被引用消息的发送者
+	GraphEdgeQuote = "quote"
+	// GraphEdgeMention 发送者到被 @ 的成员
+	GraphEdgeMention = "mention"
+)
+
+// GraphFormats 支持的关系图导出格式
+var GraphFormats = []string{"json", "graphml", "gexf"}
+
+// Graph 联系人关系图
+type Graph struct {
+	StartTime time.Time    `json:"startTime"`
+	EndTime   time.Time    `json:"endTime"`
+	Nodes     []*GraphNode `json:"nodes"`
+	Edges     []*GraphEdge `json:"edges"`
+
+	nodes   map[string]*GraphNode
+	edges   map[[3]string]*GraphEdge
+	aliases map[string]string
+}
+
+// GraphNode 关系图节点，Messages 为该节点发送的消息数量
+type GraphNode struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Messages int    `json:"messages"`
+}
+
+// GraphEdge 关系图的有向边
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	Weight int    `json:"weight"`
+}
+
+func NewGraph(startTime, endTime time.Time) *Graph {
+	g := &Graph{
+		StartTime: startTime,
+		EndTime:   endTime,
+		nodes:     make(map[string]*GraphNode),
+		edges:     make(map[[3]string]*GraphEdge),
+		aliases:   make(map[string]string),
+	}
+	g.AddNode(GraphSelfID, "我", GraphNodeSelf)
+	return g
+}
+
+// AddNode 添加节点，节点已存在时补充缺失的名称
+func (g *Graph) AddNode(id, label, _type string) *GraphNode {
+	n, ok := g.nodes[id]
+	if !ok {
+		n = &GraphNode{ID: id, Label: label, Type: _type}
+		g.nodes[id] = n
+	}
+	if n.Label == "" {
+		n.Label = label
+	}
+	return n
+}
+
+// AddEdge 累加边的权重，weight 为 0 时仅建立连接
+func (g *Graph) AddEdge(source, target, _type string, weight int) {
+	key := [3]string{source, target, _type}
+	e, ok := g.edges[key]
+	if !ok {
+		e = &GraphEdge{Source: source, Target: target, Type: _type}
+		g.edges[key] = e
+	}
+	e.Weight += weight
+}
+
+// SetAlias 将节点 from 合并到节点 to，用于合并自己的微信 ID 和 GraphSelfID
+func (g *Graph) SetAlias(from, to string) {
+	if from != "" && from != to {
+		g.aliases[from] = to
+	}
+}
+
+// Finish 合并别名节点，去除自环，并按稳定顺序输出节点和边
+func (g *Graph) Finish() {
+	resolve := func(id string) string {
+		if to, ok := g.aliases[id]; ok {
+			return to
+		}
+		return id
+	}
+
+	nodes := make(map[string]*GraphNode, len(g.nodes))
+	for id, n := range g.nodes {
+		if _, ok := g.aliases[id]; !ok {
+			nodes[id] = n
+		}
+	}
+	for id, n := range g.nodes {
+		to, ok := g.aliases[id]
+		if !ok {
+			continue
+		}
+		if m, ok := nodes[to]; ok {
+			m.Messages += n.Messages
+			continue
+		}
+		n.ID = to
+		nodes[to] = n
+	}
+	for id, n := range nodes {
+		if n.Label == "" {
+			n.Label = id
+		}
+	}
+
+	edges := make(map[[3]string]*GraphEdge, len(g.edges))
+	for _, e := range g.edges {
+		source, target := resolve(e.Source), resolve(e.Target)
+		if source == target {
+			continue
+		}
+		key := [3]string{source, target, e.Type}
+		if m, ok := edges[key]; ok {
+			m.Weight += e.Weight
+			continue
+		}
+		e.Source, e.Target = source, target
+		edges[key] = e
+	}
+	g.nodes, g.edges, g.aliases = nodes, edges, make(map[string]string)
+
+	g.Nodes = make([]*GraphNode, 0, len(nodes))
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+
+	g.Edges = make([]*GraphEdge, 0, len(edges))
+	for _, e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Type < b.Type
+	})
+}
+
+// Export 按指定格式导出关系图，支持 json、graphml、gexf
+func (g *Graph) Export(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	case "graphml":
+		return writeXML(w, g.graphML())
+	case "gexf":
+		return writeXML(w, g.gexf())
+	}
+	return fmt.Errorf("unsupported graph format: %s", format)
+}
+
+// GraphContentType 返回导出格式对应的 Content-Type
+func GraphContentType(format string) string {
+	switch format {
+	case "graphml":
+		return "application/graphml+xml; charset=utf-8"
+	case "gexf":
+		return "application/gexf+xml; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type graphMLDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (g *Graph) graphML() *graphMLDoc {
+	doc := &graphMLDoc{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{ID: "type", For: "node", AttrName: "type", AttrType: "string"},
+			{ID: "messages", For: "node", AttrName: "messages", AttrType: "int"},
+			{ID: "edgeType", For: "edge", AttrName: "type", AttrType: "string"},
+			{ID: "weight", For: "edge", AttrName: "weight", AttrType: "int"},
+		},
+		Graph: graphMLGraph{ID: "chatlog", EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{Key: "label", Value: n.Label},
+				{Key: "type", Value: n.Type},
+				{Key: "messages", Value: strconv.Itoa(n.Messages)},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Data: []graphMLData{
+				{Key: "edgeType", Value: e.Type},
+				{Key: "weight", Value: strconv.Itoa(e.Weight)},
+			},
+		})
+	}
+	return doc
+}
+
+type gexfDoc struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	Creator     string `xml:"creator"`
+	Description string `xml:"description"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Mode            string           `xml:"mode,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Weight    int            `xml:"weight,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func (g *Graph) gexf() *gexfDoc {
+	doc := &gexfDoc{
+		Xmlns:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta: gexfMeta{
+			Creator:     "chatlog",
+			Description: fmt.Sprintf("%s ~ %s", g.StartTime.Format("2006-01-02 15:04:05"), g.EndTime.Format("2006-01-02 15:04:05")),
+		},
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Mode:            "static",
+			Attributes: []gexfAttributes{
+				{Class: "node", Attributes: []gexfAttribute{
+					{ID: "type", Title: "type", Type: "string"},
+					{ID: "messages", Title: "messages", Type: "integer"},
+				}},
+				{Class: "edge", Attributes: []gexfAttribute{
+					{ID: "type", Title: "type", Type: "string"},
+				}},
+			},
+		},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID:    n.ID,
+			Label: n.Label,
+			AttValues: []gexfAttValue{
+				{For: "type", Value: n.Type},
+				{For: "messages", Value: strconv.Itoa(n.Messages)},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: e.Source,
+			Target: e.Target,
+			Weight: e.Weight,
+			AttValues: []gexfAttValue{
+				{For: "type", Value: e.Type},
+			},
+		})
+	}
+	return doc
+}
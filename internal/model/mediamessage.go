@@ -74,7 +74,7 @@ type App struct {
 	PatMsg            *PatMsg     `xml:"patMsg,omitempty"`            // type 62 拍一拍
 	PatInfo           *PatInfo    `xml:"patinfo,omitempty"`           // type 62 拍一拍 v2
 	FinderLive        *FinderLive `xml:"finderLive,omitempty"`        // type 63 视频号直播
-	WCPayInfo         *WCPayInfo  `xml:"wcpayinfo,omitempty"`         // type 2000 微信转账
+	WCPayInfo         *WCPayInfo  `xml:"wcpayinfo,omitempty"`         // type 2000 微信转账 & type 2001 红包
 }
 
 type Emoji struct {
@@ -348,6 +348,9 @@ type WCPayInfo struct {
 	PayMemo           string `xml:"pay_memo"`          // 支付备注
 	ReceiverUsername  string `xml:"receiver_username"` // 接收方用户名
 	PayerUsername     string `xml:"payer_username"`    // 支付方用户名
+	PayMsgID          string `xml:"paymsgid"`          // 红包ID
+	SenderTitle       string `xml:"sendertitle"`       // 红包祝福语
+	SceneText         string `xml:"scenetext"`         // 红包场景，如"微信红包"
 }
 
 // FinderFeed 视频号信息
@@ -588,3 +591,67 @@ func (s *SysMsg) SysMsgTemplateString() string {
 
 	return result
 }
+
+const (
+	// MemberEventJoin 成员加入群聊
+	MemberEventJoin = "join"
+
+	// MemberEventLeave 成员被移出群聊
+	MemberEventLeave = "leave"
+)
+
+// MemberEvent 解析群成员变动，返回事件类型和变动的成员，非成员变动消息返回空字符串
+func (s *SysMsg) MemberEvent() (string, []Member) {
+	if s.SysMsgTemplate == nil {
+		return "", nil
+	}
+
+	template := s.SysMsgTemplate.ContentTemplate.Template
+	var event string
+	var names []string
+	switch {
+	case strings.Contains(template, "移出"):
+		event, names = MemberEventLeave, []string{"kickoutname", "names"}
+	case strings.Contains(template, "加入"):
+		// 邀请者为 username/from，加入者为 names/adder
+		event, names = MemberEventJoin, []string{"names", "adder"}
+	default:
+		return "", nil
+	}
+
+	var members []Member
+	for _, link := range s.SysMsgTemplate.ContentTemplate.LinkList.Links {
+		for _, name := range names {
+			if link.Name == name {
+				members = append(members, link.MemberList.Members...)
+			}
+		}
+	}
+	return event, members
+}
+
+var (
+	plainJoinRegex   = regexp.MustCompile(`邀请"(.+)"加入了群聊`)
+	plainQRJoinRegex = regexp.MustCompile(`^"(.+?)"通过扫描.*加入群聊`)
+	plainLeaveRegex  = regexp.MustCompile(`将"(.+)"移出了群聊`)
+)
+
+// ParsePlainMemberEvent 解析纯文本格式的群成员变动消息，只能获取到成员昵称
+func ParsePlainMemberEvent(content string) (string, []Member) {
+	var event, names string
+	if match := plainJoinRegex.FindStringSubmatch(content); match != nil {
+		event, names = MemberEventJoin, match[1]
+	} else if match := plainQRJoinRegex.FindStringSubmatch(content); match != nil {
+		event, names = MemberEventJoin, match[1]
+	} else if match := plainLeaveRegex.FindStringSubmatch(content); match != nil {
+		event, names = MemberEventLeave, match[1]
+	} else {
+		return "", nil
+	}
+
+	var members []Member
+	for _, name := range strings.Split(names, "、") {
+		members = append(members, Member{Nickname: strings.Trim(name, `"`)})
+	}
+	return event, members
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MentionMatcher 根据群成员名称解析消息中 @ 的成员
+type MentionMatcher struct {
+	index map[string]string
+	names []string
+}
+
+// NewMentionMatcher userNames 为成员 ID 到可用名称（群昵称、备注、昵称等）的映射
+func NewMentionMatcher(userNames map[string][]string) *MentionMatcher {
+	m := &MentionMatcher{
+		index: make(map[string]string),
+	}
+	for user, names := range userNames {
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			if _, ok := m.index[name]; !ok {
+				m.index[name] = user
+				m.names = append(m.names, name)
+			}
+		}
+	}
+	// 优先匹配较长的名称，避免名称互为前缀时匹配错误
+	sort.Slice(m.names, func(i, j int) bool {
+		return len(m.names[i]) > len(m.names[j])
+	})
+	return m
+}
+
+// User 返回名称对应的成员 ID，找不到时返回空字符串
+func (m *MentionMatcher) User(name string) string {
+	return m.index[name]
+}
+
+// Match 返回消息内容中被 @ 的成员 ID，同一成员只返回一次
+func (m *MentionMatcher) Match(content string) []string {
+	var users []string
+	seen := make(map[string]bool)
+	for {
+		i := strings.Index(content, "@")
+		if i < 0 {
+			break
+		}
+		content = content[i+1:]
+		for _, name := range m.names {
+			if strings.HasPrefix(content, name) {
+				user := m.index[name]
+				if !seen[user] {
+					seen[user] = true
+					users = append(users, user)
+				}
+				content = content[len(name):]
+				break
+			}
+		}
+	}
+	return users
+}
+
+const (
+	// MentionTypeAt @ 了指定成员
+	MentionTypeAt = "at"
+	// MentionTypeAll @所有人
+	MentionTypeAll = "all"
+	// MentionTypeQuote 引用了指定成员的消息
+	MentionTypeQuote = "quote"
+)
+
+// mentionAll @所有人 在不同语言客户端中的写法
+var mentionAll = []string{"@所有人", "@all", "@All"}
+
+// isMentionAll 判断消息内容是否 @所有人，英文写法后不能紧跟字母或数字，避免匹配 @allen 等成员名
+func isMentionAll(content string) bool {
+	for _, mark := range mentionAll {
+		for rest := content; ; {
+			i := strings.Index(rest, mark)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(mark):]
+			r, _ := utf8.DecodeRuneInString(rest)
+			if rest == "" || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MentionType 判断消息内容是否 @ 了成员 user，返回 MentionTypeAt、MentionTypeAll 或空字符串
+func (m *MentionMatcher) MentionType(content, user string) string {
+	if m != nil && user != "" {
+		for _, u := range m.Match(content) {
+			if u == user {
+				return MentionTypeAt
+			}
+		}
+	}
+	if isMentionAll(content) {
+		return MentionTypeAll
+	}
+	return ""
+}
+
+// MentionOf 判断群聊消息是否 @ 或引用了成员 user，返回提及类型，未提及时返回空字符串
+func MentionOf(msg *Message, user string, mentions *MentionMatcher) string {
+	if msg.IsSelf {
+		return ""
+	}
+	switch {
+	case msg.Type == MessageTypeText:
+		return mentions.MentionType(msg.Content, user)
+	case msg.Type == MessageTypeShare && msg.SubType == MessageSubTypeQuote:
+		if refer, ok := msg.Contents["refer"].(*Message); ok && user != "" && refer.Sender == user {
+			return MentionTypeQuote
+		}
+		return mentions.MentionType(msg.Content, user)
+	}
+	return ""
+}
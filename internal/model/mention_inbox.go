@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Mention 群聊中 @我、@所有人 或引用我的消息
+type Mention struct {
+	Type       string    `json:"type"`
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName"`
+	Content    string    `json:"content"` // 引用消息包含被引用的内容
+	Unread     bool      `json:"unread"`
+}
+
+// MentionInbox 提及我的消息列表，按时间倒序排列
+type MentionInbox struct {
+	StartTime time.Time  `json:"startTime"`
+	EndTime   time.Time  `json:"endTime"`
+	Total     int        `json:"total"`
+	Unread    int        `json:"unread"`
+	Items     []*Mention `json:"items"`
+}
+
+// NewMention 根据消息创建提及记录
+func NewMention(msg *Message, _type string) *Mention {
+	return &Mention{
+		Type:       _type,
+		Seq:        msg.Seq,
+		Time:       msg.Time,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		Content:    msg.PlainTextContent(),
+	}
+}
+
+// Header 返回提及列表的时间范围和数量
+func (i *MentionInbox) Header() string {
+	return fmt.Sprintf("时间范围: %s ~ %s，共 %d 条，未读 %d 条\n", i.StartTime.Format("2006-01-02 15:04:05"), i.EndTime.Format("2006-01-02 15:04:05"), i.Total, i.Unread)
+}
+
+// PlainText 以纯文本形式输出提及列表
+func (i *MentionInbox) PlainText() string {
+	buf := strings.Builder{}
+	buf.WriteString(i.Header())
+	for _, m := range i.Items {
+		buf.WriteString("\n")
+		buf.WriteString(m.PlainText())
+	}
+	return buf.String()
+}
+
+// PlainText 以纯文本形式输出提及记录
+func (m *Mention) PlainText() string {
+	buf := strings.Builder{}
+	label := "@我"
+	switch m.Type {
+	case MentionTypeAll:
+		label = "@所有人"
+	case MentionTypeQuote:
+		label = "引用我"
+	}
+	if m.Unread {
+		label += "，未读"
+	}
+	buf.WriteString(fmt.Sprintf("[%s] %s %s %s\n", label, nameOf(m.Talker, m.TalkerName), nameOf(m.Sender, m.SenderName), m.Time.Format("2006-01-02 15:04:05")))
+	buf.WriteString(m.Content + "\n")
+	return buf.String()
+}
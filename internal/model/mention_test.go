@@ -0,0 +1,94 @@
+package model
+
+import "testing"
+
+func TestMentionOf(t *testing.T) {
+	mentions := NewMentionMatcher(map[string][]string{
+		"wxid_self":  {"小明", "Ming"},
+		"wxid_allen": {"allen"},
+		"wxid_other": {"小明同学"},
+	})
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want string
+	}{
+		{
+			name: "at self",
+			msg:  &Message{Type: MessageTypeText, Content: "@小明 今晚开会"},
+			want: MentionTypeAt,
+		},
+		{
+			name: "at self by another name",
+			msg:  &Message{Type: MessageTypeText, Content: "@Ming see above"},
+			want: MentionTypeAt,
+		},
+		{
+			// 名称互为前缀时匹配较长的名称
+			name: "at member with longer name",
+			msg:  &Message{Type: MessageTypeText, Content: "@小明同学 你好"},
+			want: "",
+		},
+		{
+			name: "at all in chinese",
+			msg:  &Message{Type: MessageTypeText, Content: "@所有人 通知"},
+			want: MentionTypeAll,
+		},
+		{
+			name: "at all in english",
+			msg:  &Message{Type: MessageTypeText, Content: "@all please check"},
+			want: MentionTypeAll,
+		},
+		{
+			name: "at All at end of message",
+			msg:  &Message{Type: MessageTypeText, Content: "meeting at 3pm @All"},
+			want: MentionTypeAll,
+		},
+		{
+			name: "at member whose name starts with all",
+			msg:  &Message{Type: MessageTypeText, Content: "@allen 好的"},
+			want: "",
+		},
+		{
+			name: "at self takes precedence over at all",
+			msg:  &Message{Type: MessageTypeText, Content: "@所有人 @小明 收到请回复"},
+			want: MentionTypeAt,
+		},
+		{
+			name: "quote self",
+			msg: &Message{Type: MessageTypeShare, SubType: MessageSubTypeQuote, Content: "同意",
+				Contents: map[string]interface{}{"refer": &Message{Sender: "wxid_self"}}},
+			want: MentionTypeQuote,
+		},
+		{
+			name: "quote other with at self",
+			msg: &Message{Type: MessageTypeShare, SubType: MessageSubTypeQuote, Content: "@小明 看这个",
+				Contents: map[string]interface{}{"refer": &Message{Sender: "wxid_other"}}},
+			want: MentionTypeAt,
+		},
+		{
+			name: "sent by self",
+			msg:  &Message{Type: MessageTypeText, Content: "@所有人 通知", IsSelf: true},
+			want: "",
+		},
+		{
+			name: "not text",
+			msg:  &Message{Type: MessageTypeImage, Content: "@小明"},
+			want: "",
+		},
+		{
+			name: "no mention",
+			msg:  &Message{Type: MessageTypeText, Content: "email me at ming@example.com"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MentionOf(tt.msg, "wxid_self", mentions); got != tt.want {
+				t.Errorf("MentionOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -123,6 +123,25 @@ type Message struct {
 	// Debug Info
 	MediaMsg *MediaMsg `json:"mediaMsg,omitempty"` // 原始多媒体消息，XML 格式
 	SysMsg   *SysMsg   `json:"sysMsg,omitempty"`   // 原始系统消息，XML 格式
+
+	extra messageExtra
+}
+
+// messageExtra 解析消息时记录的内部信息，仅供账本、群聊报告等统计使用，不随消息输出
+type messageExtra struct {
+	payInfo     *WCPayInfo // 转账和红包信息
+	payTitle    string     // 红包标题
+	memberEvent string     // 群成员变动类型
+	members     []Member   // 变动的群成员
+}
+
+// setMemberEvent 记录群成员变动信息
+func (m *Message) setMemberEvent(event string, members []Member) {
+	if event == "" {
+		return
+	}
+	m.extra.memberEvent = event
+	m.extra.members = members
 }
 
 func (m *Message) ParseMediaInfo(data string) error {
@@ -140,12 +159,14 @@ func (m *Message) ParseMediaInfo(data string) error {
 		var sysMsg SysMsg
 		if err := xml.Unmarshal([]byte(data), &sysMsg); err != nil {
 			m.Content = data
+			m.setMemberEvent(ParsePlainMemberEvent(data))
 			return nil
 		}
 		if Debug {
 			m.SysMsg = &sysMsg
 		}
 		m.Content = sysMsg.String()
+		m.setMemberEvent(sysMsg.MemberEvent())
 		return nil
 	}
 
@@ -283,6 +304,11 @@ func (m *Message) ParseMediaInfo(data string) error {
 				payMemo = "(" + msg.App.WCPayInfo.PayMemo + ")"
 			}
 			m.Content = fmt.Sprintf("[转账|%s%s]%s", _type, msg.App.WCPayInfo.FeeDesc, payMemo)
+			m.extra.payInfo = msg.App.WCPayInfo
+		case MessageSubTypeRedEnvelope:
+			// 红包，消息中不包含金额
+			m.extra.payTitle = msg.App.Title
+			m.extra.payInfo = msg.App.WCPayInfo
 		}
 	}
 
@@ -0,0 +1,406 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PaymentTransfer    = "transfer"
+	PaymentRedEnvelope = "redEnvelope"
+
+	PaymentStatusPending  = "pending"
+	PaymentStatusReceived = "received"
+	PaymentStatusRefunded = "refunded"
+	// PaymentStatusSent 红包已发出，领取情况不在消息中
+	PaymentStatusSent = "sent"
+
+	// PaymentOut 我付出
+	PaymentOut = "out"
+	// PaymentIn 我收到
+	PaymentIn = "in"
+)
+
+// 金额符号与币种，按符号长度降序匹配
+var paymentCurrencies = []struct {
+	symbol   string
+	currency string
+}{
+	{"HK$", "HKD"},
+	{"US$", "USD"},
+	{"￥", "CNY"},
+	{"¥", "CNY"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+}
+
+// PaymentLedger 转账和红包账本
+type PaymentLedger struct {
+	StartTime time.Time       `json:"startTime"`
+	EndTime   time.Time       `json:"endTime"`
+	Payments  []*Payment      `json:"payments"`
+	Totals    []*PaymentTotal `json:"totals"`
+
+	payments map[string]*Payment
+}
+
+// Payment 一笔交易，转账的发送、收款和退还回执合并为一条记录
+type Payment struct {
+	ID            string     `json:"id"` // 转账ID或红包ID
+	Kind          string     `json:"kind"`
+	Status        string     `json:"status"`
+	Direction     string     `json:"direction"`
+	Talker        string     `json:"talker"`
+	TalkerName    string     `json:"talkerName"`
+	Contact       string     `json:"contact"` // 交易对方，群聊中我发出的红包为群聊本身
+	ContactName   string     `json:"contactName"`
+	Amount        float64    `json:"amount"`
+	AmountUnknown bool       `json:"amountUnknown,omitempty"` // 红包金额不在消息中，Amount 为 0
+	Currency      string     `json:"currency"`                // 金额未知时为空
+	FeeDesc       string     `json:"feeDesc"`
+	Memo          string     `json:"memo"`
+	TransactionID string     `json:"transactionId,omitempty"`
+	Time          time.Time  `json:"time"`
+	ReceiveTime   *time.Time `json:"receiveTime,omitempty"`
+	RefundTime    *time.Time `json:"refundTime,omitempty"`
+	Balance       float64    `json:"balance"` // 与交易对方同币种的累计净收入，待收款和已退还的转账不计入
+
+	cents int64
+}
+
+// PaymentTotal 与单个交易对方按币种的汇总，金额未知的红包单独汇总，Currency 为空
+// Sent、Received 和 Net 不包含待收款和已退还的转账，待收款金额单独统计
+type PaymentTotal struct {
+	Contact         string  `json:"contact"`
+	ContactName     string  `json:"contactName"`
+	Currency        string  `json:"currency"`
+	Count           int     `json:"count"`
+	RedEnvelopes    int     `json:"redEnvelopes"`
+	Sent            float64 `json:"sent"`
+	Received        float64 `json:"received"`
+	Net             float64 `json:"net"`
+	PendingSent     float64 `json:"pendingSent"`
+	PendingReceived float64 `json:"pendingReceived"`
+
+	sent            int64
+	received        int64
+	pendingSent     int64
+	pendingReceived int64
+}
+
+func NewPaymentLedger(startTime, endTime time.Time) *PaymentLedger {
+	return &PaymentLedger{
+		StartTime: startTime,
+		EndTime:   endTime,
+		payments:  make(map[string]*Payment),
+	}
+}
+
+// ParseFeeDesc 解析金额描述，如"￥200.00"，返回以分为单位的金额和币种
+func ParseFeeDesc(desc string) (int64, string, bool) {
+	desc = strings.TrimSpace(desc)
+	currency := ""
+	for _, c := range paymentCurrencies {
+		if strings.HasPrefix(desc, c.symbol) {
+			desc = strings.TrimPrefix(desc, c.symbol)
+			currency = c.currency
+			break
+		}
+	}
+	desc = strings.ReplaceAll(strings.TrimSpace(desc), ",", "")
+	amount, err := strconv.ParseFloat(desc, 64)
+	if err != nil {
+		return 0, currency, false
+	}
+	if currency == "" {
+		currency = "CNY"
+	}
+	return int64(amount*100 + 0.5), currency, true
+}
+
+// AddMessage 按时间顺序输入转账和红包消息
+func (l *PaymentLedger) AddMessage(msg *Message) {
+	if msg.Type != MessageTypeShare {
+		return
+	}
+	info := msg.extra.payInfo
+	if info == nil {
+		return
+	}
+	switch msg.SubType {
+	case MessageSubTypePay:
+		l.addTransfer(msg, info)
+	case MessageSubTypeRedEnvelope:
+		l.addRedEnvelope(msg, info)
+	}
+}
+
+func (l *PaymentLedger) addTransfer(msg *Message, info *WCPayInfo) {
+	id := info.TransferID
+	if id == "" {
+		id = info.TranscationID
+	}
+	if id == "" {
+		id = fmt.Sprintf("%s_%d", msg.Talker, msg.Seq)
+	}
+
+	p, ok := l.payments[id]
+	if !ok {
+		// 发起消息不在时间范围内时，以回执时间作为交易时间
+		p = &Payment{
+			ID:         id,
+			Kind:       PaymentTransfer,
+			Status:     PaymentStatusPending,
+			Talker:     msg.Talker,
+			TalkerName: msg.TalkerName,
+			Time:       msg.Time,
+		}
+		p.Contact, p.ContactName = paymentContact(msg)
+		l.payments[id] = p
+	}
+	if p.FeeDesc == "" {
+		p.FeeDesc = info.FeeDesc
+		p.cents, p.Currency, _ = ParseFeeDesc(info.FeeDesc)
+	}
+	if p.Memo == "" {
+		p.Memo = info.PayMemo
+	}
+	if p.TransactionID == "" {
+		p.TransactionID = info.TranscationID
+	}
+
+	// 发起转账的消息由付款方发送，收款和退还回执由收款方发送
+	t := msg.Time
+	switch info.PaySubType {
+	case 1, 7:
+		p.Time = msg.Time
+		p.Direction = paymentDirection(msg, info, msg.IsSelf)
+	case 3, 5:
+		p.Status = PaymentStatusReceived
+		p.ReceiveTime = &t
+		if p.Direction == "" {
+			p.Direction = paymentDirection(msg, info, !msg.IsSelf)
+		}
+	case 4:
+		p.Status = PaymentStatusRefunded
+		p.RefundTime = &t
+		if p.Direction == "" {
+			p.Direction = paymentDirection(msg, info, !msg.IsSelf)
+		}
+	}
+}
+
+func (l *PaymentLedger) addRedEnvelope(msg *Message, info *WCPayInfo) {
+	id := info.PayMsgID
+	if id == "" {
+		id = fmt.Sprintf("%s_%d", msg.Talker, msg.Seq)
+	}
+	if _, ok := l.payments[id]; ok {
+		return
+	}
+
+	p := &Payment{
+		ID:            id,
+		Kind:          PaymentRedEnvelope,
+		Status:        PaymentStatusSent,
+		Direction:     PaymentIn,
+		Talker:        msg.Talker,
+		TalkerName:    msg.TalkerName,
+		AmountUnknown: true,
+		Memo:          info.SenderTitle,
+		Time:          msg.Time,
+	}
+	if msg.IsSelf {
+		p.Direction = PaymentOut
+	}
+	if p.Memo == "" {
+		p.Memo = msg.extra.payTitle
+	}
+	p.Contact, p.ContactName = paymentContact(msg)
+	l.payments[id] = p
+}
+
+// paymentContact 返回交易对方，私聊为对话方，群聊中为发送者，我在群聊中发出的为群聊本身
+func paymentContact(msg *Message) (string, string) {
+	if msg.IsChatRoom && !msg.IsSelf {
+		return msg.Sender, msg.SenderName
+	}
+	return msg.Talker, msg.TalkerName
+}
+
+// paymentDirection 判断转账方向，优先使用消息中的付款方和收款方，payerIsSelf 为无法判断时的兜底
+func paymentDirection(msg *Message, info *WCPayInfo, payerIsSelf bool) string {
+	contact, _ := paymentContact(msg)
+	switch contact {
+	case info.PayerUsername:
+		return PaymentIn
+	case info.ReceiverUsername:
+		return PaymentOut
+	}
+	if payerIsSelf {
+		return PaymentOut
+	}
+	return PaymentIn
+}
+
+// Finish 按时间排序，按交易对方和币种计算累计金额
+func (l *PaymentLedger) Finish() {
+	l.Payments = make([]*Payment, 0, len(l.payments))
+	for _, p := range l.payments {
+		l.Payments = append(l.Payments, p)
+	}
+	sort.Slice(l.Payments, func(i, j int) bool {
+		if !l.Payments[i].Time.Equal(l.Payments[j].Time) {
+			return l.Payments[i].Time.Before(l.Payments[j].Time)
+		}
+		return l.Payments[i].ID < l.Payments[j].ID
+	})
+
+	totals := make(map[[2]string]*PaymentTotal)
+	l.Totals = make([]*PaymentTotal, 0)
+	for _, p := range l.Payments {
+		p.Amount = centsToAmount(p.cents)
+		if p.Direction == "" {
+			p.Direction = PaymentIn
+		}
+
+		key := [2]string{p.Contact, p.Currency}
+		t, ok := totals[key]
+		if !ok {
+			t = &PaymentTotal{Contact: p.Contact, Currency: p.Currency}
+			totals[key] = t
+			l.Totals = append(l.Totals, t)
+		}
+		if t.ContactName == "" {
+			t.ContactName = p.ContactName
+		}
+		t.Count++
+		if p.Kind == PaymentRedEnvelope {
+			t.RedEnvelopes++
+		}
+		switch {
+		case p.Status == PaymentStatusPending && p.Direction == PaymentOut:
+			t.pendingSent += p.cents
+		case p.Status == PaymentStatusPending:
+			t.pendingReceived += p.cents
+		case p.Status == PaymentStatusRefunded:
+		case p.Direction == PaymentOut:
+			t.sent += p.cents
+		default:
+			t.received += p.cents
+		}
+		p.Balance = centsToAmount(t.received - t.sent)
+	}
+
+	for _, t := range l.Totals {
+		t.Sent = centsToAmount(t.sent)
+		t.Received = centsToAmount(t.received)
+		t.Net = centsToAmount(t.received - t.sent)
+		t.PendingSent = centsToAmount(t.pendingSent)
+		t.PendingReceived = centsToAmount(t.pendingReceived)
+	}
+	sort.SliceStable(l.Totals, func(i, j int) bool {
+		ti, tj := l.Totals[i], l.Totals[j]
+		return ti.sent+ti.received+ti.pendingSent+ti.pendingReceived > tj.sent+tj.received+tj.pendingSent+tj.pendingReceived
+	})
+}
+
+// amountText 返回 CSV 中的金额，金额未知时为空
+func (p *Payment) amountText() string {
+	if p.AmountUnknown {
+		return ""
+	}
+	return strconv.FormatFloat(p.Amount, 'f', 2, 64)
+}
+
+func centsToAmount(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// CSVHeader 账本 CSV 表头
+func (l *PaymentLedger) CSVHeader() []string {
+	return []string{"Time", "Kind", "Status", "Direction", "ContactName", "Contact", "TalkerName", "Talker", "Amount", "Currency", "Memo", "Balance", "ID", "TransactionID", "ReceiveTime", "RefundTime"}
+}
+
+// CSV 交易 CSV 数据
+func (p *Payment) CSV() []string {
+	return []string{
+		p.Time.Format("2006-01-02 15:04:05"),
+		p.Kind,
+		p.Status,
+		p.Direction,
+		p.ContactName,
+		p.Contact,
+		p.TalkerName,
+		p.Talker,
+		p.amountText(),
+		p.Currency,
+		p.Memo,
+		strconv.FormatFloat(p.Balance, 'f', 2, 64),
+		p.ID,
+		p.TransactionID,
+		formatTimePtr(p.ReceiveTime),
+		formatTimePtr(p.RefundTime),
+	}
+}
+
+// PlainText 以纯文本形式输出账本
+func (l *PaymentLedger) PlainText() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("时间范围: %s ~ %s，共 %d 笔\n", l.StartTime.Format("2006-01-02 15:04:05"), l.EndTime.Format("2006-01-02 15:04:05"), len(l.Payments)))
+
+	buf.WriteString("\n## 交易对方汇总\n")
+	for _, t := range l.Totals {
+		if t.Currency == "" {
+			buf.WriteString(fmt.Sprintf("%s: 红包 %d 个（金额未知）\n", nameOf(t.Contact, t.ContactName), t.RedEnvelopes))
+			continue
+		}
+		line := fmt.Sprintf("%s [%s]: %d 笔，付出 %.2f，收到 %.2f，净额 %.2f", nameOf(t.Contact, t.ContactName), t.Currency, t.Count, t.Sent, t.Received, t.Net)
+		if t.pendingSent > 0 || t.pendingReceived > 0 {
+			line += fmt.Sprintf("，待收款 付出 %.2f 收到 %.2f（不计入净额）", t.PendingSent, t.PendingReceived)
+		}
+		buf.WriteString(line + "\n")
+	}
+
+	buf.WriteString("\n## 交易明细\n")
+	for _, p := range l.Payments {
+		action := "收到"
+		if p.Direction == PaymentOut {
+			action = "付出"
+		}
+		kind := "转账"
+		if p.Kind == PaymentRedEnvelope {
+			kind = "红包"
+		}
+		amount := p.FeeDesc
+		if p.AmountUnknown {
+			amount = "金额未知"
+		}
+		line := fmt.Sprintf("%s %s %s %s %s [%s]", p.Time.Format("2006-01-02 15:04:05"), kind, action, nameOf(p.Contact, p.ContactName), amount, paymentStatusName(p.Status))
+		if p.Memo != "" {
+			line += fmt.Sprintf(" (%s)", p.Memo)
+		}
+		if !p.AmountUnknown {
+			line += fmt.Sprintf("，累计净额 %.2f %s", p.Balance, p.Currency)
+		}
+		buf.WriteString(line + "\n")
+	}
+
+	return buf.String()
+}
+
+func paymentStatusName(status string) string {
+	switch status {
+	case PaymentStatusPending:
+		return "待收款"
+	case PaymentStatusReceived:
+		return "已收款"
+	case PaymentStatusRefunded:
+		return "已退还"
+	}
+	return "已发出"
+}
@@ -0,0 +1,418 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats 对话统计信息
+type Stats struct {
+	Talker       string             `json:"talker"`
+	TalkerName   string             `json:"talkerName"`
+	IsChatRoom   bool               `json:"isChatRoom"`
+	StartTime    time.Time          `json:"startTime"`
+	EndTime      time.Time          `json:"endTime"`
+	Total        int                `json:"total"`
+	FirstTime    time.Time          `json:"firstTime"`
+	LastTime     time.Time          `json:"lastTime"`
+	Senders      []*SenderStats     `json:"senders"`
+	Types        []*TypeStats       `json:"types"`
+	Hours        [24]int            `json:"hours"`
+	Days         []*DayStats        `json:"days"`
+	Media        map[string]int     `json:"media"`
+	ResponseTime *ResponseTimeStats `json:"responseTime"`
+
+	// 统计过程中的中间数据
+	senders     map[string]*SenderStats
+	types       map[[2]int64]*TypeStats
+	days        map[string]int
+	lastTime    int64
+	lastIsSelf  bool
+	selfReplies []int64
+	peerReplies []int64
+}
+
+// SenderStats 发送者统计
+type SenderStats struct {
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName"`
+	IsSelf     bool      `json:"isSelf"`
+	Count      int       `json:"count"`
+	FirstTime  time.Time `json:"firstTime"`
+	LastTime   time.Time `json:"lastTime"`
+}
+
+// TypeStats 消息类型统计
+type TypeStats struct {
+	Type    int64  `json:"type"`
+	SubType int64  `json:"subType"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+}
+
+// DayStats 每日消息数量
+type DayStats struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// ResponseTimeStats 回复时间统计
+// Self 为我回复对方的耗时，Others 为对方回复我的耗时
+type ResponseTimeStats struct {
+	Self   *ResponseTimeDist `json:"self"`
+	Others *ResponseTimeDist `json:"others"`
+}
+
+// ResponseTimeDist 回复时间分布，时间单位为秒
+type ResponseTimeDist struct {
+	Count   int                   `json:"count"`
+	Mean    int64                 `json:"mean"`
+	Median  int64                 `json:"median"`
+	P90     int64                 `json:"p90"`
+	Buckets []*ResponseTimeBucket `json:"buckets"`
+}
+
+// ResponseTimeBucket 回复时间区间，Max 为 0 表示无上限
+type ResponseTimeBucket struct {
+	Label string `json:"label"`
+	Max   int64  `json:"max"`
+	Count int    `json:"count"`
+}
+
+var responseTimeBuckets = []ResponseTimeBucket{
+	{Label: "1分钟内", Max: 60},
+	{Label: "5分钟内", Max: 5 * 60},
+	{Label: "30分钟内", Max: 30 * 60},
+	{Label: "1小时内", Max: 60 * 60},
+	{Label: "6小时内", Max: 6 * 60 * 60},
+	{Label: "1天内", Max: 24 * 60 * 60},
+	{Label: "1天以上"},
+}
+
+func NewStats(talker string, startTime, endTime time.Time) *Stats {
+	return &Stats{
+		Talker:     talker,
+		IsChatRoom: strings.HasSuffix(talker, "@chatroom"),
+		StartTime:  startTime,
+		EndTime:    endTime,
+		senders:    make(map[string]*SenderStats),
+		types:      make(map[[2]int64]*TypeStats),
+		days:       make(map[string]int),
+	}
+}
+
+// AddSender 累加发送者的消息数量，first/last 为 Unix 时间戳
+func (s *Stats) AddSender(sender string, isSelf bool, count int, first, last int64) {
+	key := sender
+	if isSelf {
+		// 自己发送的消息在部分版本中没有 sender，统一合并
+		key = ""
+	}
+	ss, ok := s.senders[key]
+	if !ok {
+		ss = &SenderStats{IsSelf: isSelf}
+		s.senders[key] = ss
+	}
+	if ss.Sender == "" {
+		ss.Sender = sender
+	}
+	ss.Count += count
+	if ft := time.Unix(first, 0); ss.FirstTime.IsZero() || ft.Before(ss.FirstTime) {
+		ss.FirstTime = ft
+	}
+	if lt := time.Unix(last, 0); lt.After(ss.LastTime) {
+		ss.LastTime = lt
+	}
+
+	s.Total += count
+	if ft := time.Unix(first, 0); s.FirstTime.IsZero() || ft.Before(s.FirstTime) {
+		s.FirstTime = ft
+	}
+	if lt := time.Unix(last, 0); lt.After(s.LastTime) {
+		s.LastTime = lt
+	}
+}
+
+// AddType 累加消息类型数量
+func (s *Stats) AddType(_type, subType int64, count int) {
+	key := [2]int64{_type, subType}
+	ts, ok := s.types[key]
+	if !ok {
+		ts = &TypeStats{Type: _type, SubType: subType, Name: MessageTypeName(_type, subType)}
+		s.types[key] = ts
+	}
+	ts.Count += count
+}
+
+// AddHour 累加某一小时的消息数量，hour 格式为 "2006-01-02 15"
+func (s *Stats) AddHour(hour string, count int) {
+	t, err := time.ParseInLocation("2006-01-02 15", hour, time.Local)
+	if err != nil {
+		return
+	}
+	s.Hours[t.Hour()] += count
+	s.days[t.Format("2006-01-02")] += count
+}
+
+// AddEvent 按时间顺序输入每条消息的发送时间和发送方，用于统计回复时间
+func (s *Stats) AddEvent(t int64, isSelf bool) {
+	if s.lastTime != 0 && isSelf != s.lastIsSelf && t >= s.lastTime {
+		if isSelf {
+			s.selfReplies = append(s.selfReplies, t-s.lastTime)
+		} else {
+			s.peerReplies = append(s.peerReplies, t-s.lastTime)
+		}
+	}
+	s.lastTime = t
+	s.lastIsSelf = isSelf
+}
+
+// Finish 汇总中间数据，生成最终的统计结果
+func (s *Stats) Finish() {
+	s.Senders = make([]*SenderStats, 0, len(s.senders))
+	for _, ss := range s.senders {
+		s.Senders = append(s.Senders, ss)
+	}
+	sort.Slice(s.Senders, func(i, j int) bool {
+		if s.Senders[i].Count != s.Senders[j].Count {
+			return s.Senders[i].Count > s.Senders[j].Count
+		}
+		return s.Senders[i].Sender < s.Senders[j].Sender
+	})
+
+	s.Media = make(map[string]int)
+	s.Types = make([]*TypeStats, 0, len(s.types))
+	for _, ts := range s.types {
+		s.Types = append(s.Types, ts)
+		if media := mediaTypeOf(ts.Type, ts.SubType); media != "" {
+			s.Media[media] += ts.Count
+		}
+	}
+	sort.Slice(s.Types, func(i, j int) bool {
+		if s.Types[i].Count != s.Types[j].Count {
+			return s.Types[i].Count > s.Types[j].Count
+		}
+		return s.Types[i].Type*10000+s.Types[i].SubType < s.Types[j].Type*10000+s.Types[j].SubType
+	})
+
+	s.Days = make([]*DayStats, 0, len(s.days))
+	for date, count := range s.days {
+		s.Days = append(s.Days, &DayStats{Date: date, Count: count})
+	}
+	sort.Slice(s.Days, func(i, j int) bool {
+		return s.Days[i].Date < s.Days[j].Date
+	})
+
+	s.ResponseTime = &ResponseTimeStats{
+		Self:   newResponseTimeDist(s.selfReplies),
+		Others: newResponseTimeDist(s.peerReplies),
+	}
+}
+
+func newResponseTimeDist(durations []int64) *ResponseTimeDist {
+	d := &ResponseTimeDist{
+		Count:   len(durations),
+		Buckets: make([]*ResponseTimeBucket, 0, len(responseTimeBuckets)),
+	}
+	for _, b := range responseTimeBuckets {
+		d.Buckets = append(d.Buckets, &ResponseTimeBucket{Label: b.Label, Max: b.Max})
+	}
+	if len(durations) == 0 {
+		return d
+	}
+
+	sorted := make([]int64, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum int64
+	for _, v := range sorted {
+		sum += v
+		for _, b := range d.Buckets {
+			if b.Max == 0 || v <= b.Max {
+				b.Count++
+				break
+			}
+		}
+	}
+	d.Mean = sum / int64(len(sorted))
+	d.Median = sorted[len(sorted)/2]
+	d.P90 = sorted[len(sorted)*9/10]
+	return d
+}
+
+// mediaTypeOf 返回媒体消息的类别，非媒体消息返回空字符串
+func mediaTypeOf(_type, subType int64) string {
+	switch _type {
+	case MessageTypeImage:
+		return "image"
+	case MessageTypeVoice:
+		return "voice"
+	case MessageTypeVideo:
+		return "video"
+	case MessageTypeAnimation:
+		return "animation"
+	case MessageTypeShare:
+		if subType == MessageSubTypeFile {
+			return "file"
+		}
+	}
+	return ""
+}
+
+// MessageTypeName 返回消息类型的中文名称
+func MessageTypeName(_type, subType int64) string {
+	switch _type {
+	case MessageTypeText:
+		return "文本"
+	case MessageTypeImage:
+		return "图片"
+	case MessageTypeVoice:
+		return "语音"
+	case MessageTypeCard:
+		return "名片"
+	case MessageTypeVideo:
+		return "视频"
+	case MessageTypeAnimation:
+		return "动画表情"
+	case MessageTypeLocation:
+		return "位置"
+	case MessageTypeVOIP:
+		return "语音通话"
+	case MessageTypeSystem:
+		return "系统消息"
+	case MessageTypeShare:
+		switch subType {
+		case MessageSubTypeText:
+			return "文本分享"
+		case MessageSubTypeLink, MessageSubTypeLink2:
+			return "链接"
+		case MessageSubTypeFile:
+			return "文件"
+		case MessageSubTypeGIF:
+			return "动图"
+		case MessageSubTypeMergeForward:
+			return "合并转发"
+		case MessageSubTypeNote:
+			return "笔记"
+		case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
+			return "小程序"
+		case MessageSubTypeChannel:
+			return "视频号"
+		case MessageSubTypeQuote:
+			return "引用"
+		case MessageSubTypePat:
+			return "拍一拍"
+		case MessageSubTypeChannelLive:
+			return "视频号直播"
+		case MessageSubTypeChatRoomNotice:
+			return "群公告"
+		case MessageSubTypeMusic:
+			return "音乐"
+		case MessageSubTypePay:
+			return "转账"
+		case MessageSubTypeRedEnvelope:
+			return "红包"
+		case MessageSubTypeRedEnvelopeCover:
+			return "红包封面"
+		}
+		return "分享"
+	}
+	return "其他"
+}
+
+// PlainText 以纯文本形式输出统计结果
+func (s *Stats) PlainText() string {
+	return JoinTextBlocks(s.TextBlocks())
+}
+
+// TextBlocks 按概要、各小节标题和每行数据分段输出统计结果
+func (s *Stats) TextBlocks() []TextBlock {
+	buf := strings.Builder{}
+	talker := s.Talker
+	if s.TalkerName != "" {
+		talker = fmt.Sprintf("%s(%s)", s.TalkerName, s.Talker)
+	}
+	buf.WriteString(fmt.Sprintf("对话: %s\n", talker))
+	buf.WriteString(fmt.Sprintf("时间范围: %s ~ %s\n", s.StartTime.Format("2006-01-02 15:04:05"), s.EndTime.Format("2006-01-02 15:04:05")))
+	buf.WriteString(fmt.Sprintf("消息总数: %d\n", s.Total))
+	if s.Total == 0 {
+		return []TextBlock{{Key: "header", Text: buf.String()}}
+	}
+	buf.WriteString(fmt.Sprintf("首条消息: %s\n", s.FirstTime.Format("2006-01-02 15:04:05")))
+	buf.WriteString(fmt.Sprintf("末条消息: %s\n", s.LastTime.Format("2006-01-02 15:04:05")))
+	blocks := []TextBlock{{Key: "header", Text: buf.String()}}
+
+	blocks = append(blocks, TextBlock{Key: "senders", Text: "\n## 发送者\n"})
+	for _, ss := range s.Senders {
+		name := ss.Sender
+		switch {
+		case ss.IsSelf:
+			name = "我"
+		case ss.SenderName != "":
+			name = fmt.Sprintf("%s(%s)", ss.SenderName, ss.Sender)
+		}
+		blocks = append(blocks, TextBlock{
+			Key:  "senders/" + ss.Sender,
+			Text: fmt.Sprintf("%s: %d 条，%s ~ %s\n", name, ss.Count, ss.FirstTime.Format("2006-01-02 15:04"), ss.LastTime.Format("2006-01-02 15:04")),
+		})
+	}
+
+	blocks = append(blocks, TextBlock{Key: "types", Text: "\n## 消息类型\n"})
+	for _, ts := range s.Types {
+		blocks = append(blocks, TextBlock{
+			Key:  fmt.Sprintf("types/%d.%d", ts.Type, ts.SubType),
+			Text: fmt.Sprintf("%s(%d,%d): %d\n", ts.Name, ts.Type, ts.SubType, ts.Count),
+		})
+	}
+
+	if len(s.Media) > 0 {
+		blocks = append(blocks, TextBlock{Key: "media", Text: "\n## 媒体\n"})
+		for _, media := range []string{"image", "video", "voice", "animation", "file"} {
+			if n, ok := s.Media[media]; ok {
+				blocks = append(blocks, TextBlock{Key: "media/" + media, Text: fmt.Sprintf("%s: %d\n", media, n)})
+			}
+		}
+	}
+
+	blocks = append(blocks, TextBlock{Key: "hours", Text: "\n## 每小时\n"})
+	for hour, n := range s.Hours {
+		if n > 0 {
+			blocks = append(blocks, TextBlock{Key: fmt.Sprintf("hours/%02d", hour), Text: fmt.Sprintf("%02d: %d\n", hour, n)})
+		}
+	}
+
+	blocks = append(blocks, TextBlock{Key: "days", Text: "\n## 每日\n"})
+	for _, d := range s.Days {
+		blocks = append(blocks, TextBlock{Key: "days/" + d.Date, Text: fmt.Sprintf("%s: %d\n", d.Date, d.Count)})
+	}
+
+	if s.ResponseTime != nil {
+		blocks = append(blocks, TextBlock{Key: "response", Text: "\n## 回复时间\n"})
+		self, others := strings.Builder{}, strings.Builder{}
+		writeResponseTimeDist(&self, "我回复对方", s.ResponseTime.Self)
+		writeResponseTimeDist(&others, "对方回复我", s.ResponseTime.Others)
+		blocks = append(blocks,
+			TextBlock{Key: "response/self", Text: self.String()},
+			TextBlock{Key: "response/others", Text: others.String()},
+		)
+	}
+
+	return blocks
+}
+
+func writeResponseTimeDist(buf *strings.Builder, label string, d *ResponseTimeDist) {
+	if d == nil || d.Count == 0 {
+		buf.WriteString(fmt.Sprintf("%s: 无\n", label))
+		return
+	}
+	buf.WriteString(fmt.Sprintf("%s: %d 次，平均 %s，中位数 %s，P90 %s\n", label, d.Count,
+		time.Duration(d.Mean)*time.Second, time.Duration(d.Median)*time.Second, time.Duration(d.P90)*time.Second))
+	for _, b := range d.Buckets {
+		if b.Count > 0 {
+			buf.WriteString(fmt.Sprintf("  %s: %d\n", b.Label, b.Count))
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package model
+
+import "strings"
+
+// TextBlock 报告中可以单独分页输出的一段文本
+// Key 在同一报告中唯一，重新生成报告时标识同一段数据，分页时据此定位上次输出到的位置
+type TextBlock struct {
+	Key  string
+	Text string
+}
+
+// JoinTextBlocks 按顺序拼接各段文本
+func JoinTextBlocks(blocks []TextBlock) string {
+	buf := strings.Builder{}
+	for _, b := range blocks {
+		buf.WriteString(b.Text)
+	}
+	return buf.String()
+}
@@ -0,0 +1,249 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/pkg/util/nlp"
+)
+
+const (
+	// topicSegmentGap 消息间隔超过该时长时视为新的一段对话
+	topicSegmentGap = 30 * time.Minute
+	// topicSegmentSize 每段对话的最大消息数量
+	topicSegmentSize = 50
+	// topicSimilarity 对话段归入同一话题的最低相似度
+	topicSimilarity = 0.25
+	// topicMinCount 关键词的最少出现次数
+	topicMinCount = 2
+	// trendingMinCount 热词在当前时间范围内的最少出现次数
+	trendingMinCount = 3
+	// topicKeywords 每个话题的关键词数量
+	topicKeywords = 5
+	// topicSamples 每个话题的示例消息数量
+	topicSamples = 3
+)
+
+// mentionRegexp 匹配消息中的 @某人，群昵称不作为关键词
+var mentionRegexp = regexp.MustCompile(`@[^\s\x{2005}]+`)
+
+// TopicReport 时间范围内各对话的关键词、热词与话题
+type TopicReport struct {
+	StartTime time.Time       `json:"startTime"`
+	EndTime   time.Time       `json:"endTime"`
+	Talkers   []*TalkerTopics `json:"talkers"`
+}
+
+// TalkerTopics 单个对话的关键词、热词与话题
+type TalkerTopics struct {
+	Talker     string     `json:"talker"`
+	TalkerName string     `json:"talkerName"`
+	Messages   int        `json:"messages"` // 参与分析的消息数量
+	Keywords   []nlp.Term `json:"keywords"` // 按 TF-IDF 排序的关键词
+	Trending   []nlp.Term `json:"trending"` // 相比上一个等长时间范围增长最多的词
+	Topics     []*Topic   `json:"topics"`
+}
+
+// Topic 由相似对话段聚合而成的话题
+type Topic struct {
+	Keywords  []string         `json:"keywords"`
+	Segments  int              `json:"segments"`
+	Messages  int              `json:"messages"`
+	StartTime time.Time        `json:"startTime"`
+	EndTime   time.Time        `json:"endTime"`
+	Samples   []*DigestMessage `json:"samples"`
+}
+
+// topicSegment 一段连续的对话
+type topicSegment struct {
+	messages []*Message
+	terms    []string
+}
+
+// NewTalkerTopics 分析对话的关键词、热词与话题
+// baseline 为上一个等长时间范围内的消息，用于计算热词，seg 为 nil 时使用内置词典
+func NewTalkerTopics(talker, talkerName string, messages, baseline []*Message, seg *nlp.Segmenter, limit int) *TalkerTopics {
+	if seg == nil {
+		seg = nlp.Default()
+	}
+	segments := topicSegments(seg, messages)
+
+	corpus := nlp.NewCorpus()
+	t := &TalkerTopics{
+		Talker:     talker,
+		TalkerName: talkerName,
+		Topics:     make([]*Topic, 0),
+	}
+	for _, s := range segments {
+		corpus.Add(s.terms)
+		t.Messages += len(s.messages)
+	}
+
+	base := nlp.NewCorpus()
+	for _, s := range topicSegments(seg, baseline) {
+		base.Add(s.terms)
+	}
+
+	t.Keywords = corpus.Keywords(limit, topicMinCount)
+	t.Trending = corpus.Trending(base, limit, trendingMinCount)
+
+	for _, c := range corpus.Cluster(topicSimilarity, 2, topicKeywords) {
+		topic := &Topic{
+			Keywords: make([]string, 0, len(c.Terms)),
+			Segments: len(c.Docs),
+		}
+		for _, term := range c.Terms {
+			topic.Keywords = append(topic.Keywords, term.Word)
+		}
+		for _, d := range c.Docs {
+			s := segments[d]
+			topic.Messages += len(s.messages)
+			if topic.StartTime.IsZero() || s.messages[0].Time.Before(topic.StartTime) {
+				topic.StartTime = s.messages[0].Time
+			}
+			if last := s.messages[len(s.messages)-1].Time; last.After(topic.EndTime) {
+				topic.EndTime = last
+			}
+		}
+		topic.Samples = topicSampleMessages(seg, segments, c.Docs, topic.Keywords)
+		t.Topics = append(t.Topics, topic)
+		if limit > 0 && len(t.Topics) >= limit {
+			break
+		}
+	}
+
+	return t
+}
+
+// topicSegments 按时间间隔将消息切分为对话段，忽略没有关键词的消息
+func topicSegments(seg *nlp.Segmenter, messages []*Message) []*topicSegment {
+	segments := make([]*topicSegment, 0)
+	var cur *topicSegment
+	var last time.Time
+	for _, msg := range messages {
+		terms := seg.Terms(TopicText(msg))
+		if len(terms) == 0 {
+			continue
+		}
+		if cur == nil || msg.Time.Sub(last) > topicSegmentGap || len(cur.messages) >= topicSegmentSize {
+			cur = &topicSegment{}
+			segments = append(segments, cur)
+		}
+		cur.messages = append(cur.messages, msg)
+		cur.terms = append(cur.terms, terms...)
+		last = msg.Time
+	}
+	return segments
+}
+
+// topicSampleMessages 选取包含话题关键词最多的消息作为示例
+func topicSampleMessages(seg *nlp.Segmenter, segments []*topicSegment, docs []int, keywords []string) []*DigestMessage {
+	type scored struct {
+		msg   *Message
+		score int
+	}
+	keywordSet := make(map[string]bool, len(keywords))
+	for _, k := range keywords {
+		keywordSet[k] = true
+	}
+	best := make([]scored, 0)
+	for _, d := range docs {
+		for _, msg := range segments[d].messages {
+			score := 0
+			for _, term := range seg.Terms(TopicText(msg)) {
+				if keywordSet[term] {
+					score++
+				}
+			}
+			if score > 0 {
+				best = append(best, scored{msg, score})
+			}
+		}
+	}
+	sort.SliceStable(best, func(i, j int) bool {
+		return best[i].score > best[j].score
+	})
+	if len(best) > topicSamples {
+		best = best[:topicSamples]
+	}
+
+	samples := make([]*DigestMessage, 0, len(best))
+	for _, b := range best {
+		samples = append(samples, &DigestMessage{
+			Time:       b.msg.Time,
+			Sender:     b.msg.Sender,
+			SenderName: b.msg.SenderName,
+			Content:    truncateRunes(TopicText(b.msg), digestContentLength),
+		})
+	}
+	return samples
+}
+
+// TopicText 返回用于关键词提取的消息文本
+// 图片、语音等媒体消息没有文本内容，引用消息仅使用回复内容，避免重复统计被引用的消息
+func TopicText(msg *Message) string {
+	var text string
+	switch msg.Type {
+	case MessageTypeText:
+		text = msg.Content
+	case MessageTypeShare:
+		switch msg.SubType {
+		case MessageSubTypeQuote:
+			text = msg.Content
+		case MessageSubTypePat, MessageSubTypeGIF:
+			return ""
+		default:
+			text = msg.PlainTextContent()
+		}
+	default:
+		return ""
+	}
+	return mentionRegexp.ReplaceAllString(text, " ")
+}
+
+// PlainText 以纯文本形式输出话题报告
+func (r *TopicReport) PlainText() string {
+	return JoinTextBlocks(r.TextBlocks())
+}
+
+// TextBlocks 按概要和每个对话分段输出话题报告
+func (r *TopicReport) TextBlocks() []TextBlock {
+	blocks := []TextBlock{{
+		Key:  "header",
+		Text: fmt.Sprintf("时间范围: %s ~ %s，共 %d 个对话\n", r.StartTime.Format("2006-01-02 15:04:05"), r.EndTime.Format("2006-01-02 15:04:05"), len(r.Talkers)),
+	}}
+	for _, t := range r.Talkers {
+		blocks = append(blocks, TextBlock{Key: "talker/" + t.Talker, Text: "\n" + t.PlainText()})
+	}
+	return blocks
+}
+
+// PlainText 以纯文本形式输出单个对话的话题
+func (t *TalkerTopics) PlainText() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("## %s，%d 条消息\n", nameOf(t.Talker, t.TalkerName), t.Messages))
+	if len(t.Keywords) > 0 {
+		buf.WriteString("关键词: " + formatTerms(t.Keywords) + "\n")
+	}
+	if len(t.Trending) > 0 {
+		buf.WriteString("热词: " + formatTerms(t.Trending) + "\n")
+	}
+	for i, topic := range t.Topics {
+		buf.WriteString(fmt.Sprintf("话题 %d: %s（%d 段对话，%d 条消息，%s ~ %s）\n", i+1, strings.Join(topic.Keywords, " / "), topic.Segments, topic.Messages, topic.StartTime.Format("01-02 15:04"), topic.EndTime.Format("01-02 15:04")))
+		for _, m := range topic.Samples {
+			buf.WriteString(fmt.Sprintf("  %s %s：%s\n", m.Time.Format("01-02 15:04"), digestSenderName(m.Sender, m.SenderName), m.Content))
+		}
+	}
+	return buf.String()
+}
+
+func formatTerms(terms []nlp.Term) string {
+	list := make([]string, 0, len(terms))
+	for _, term := range terms {
+		list = append(list, fmt.Sprintf("%s(%d)", term.Word, term.Count))
+	}
+	return strings.Join(list, " ")
+}
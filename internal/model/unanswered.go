@@ -0,0 +1,111 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// UnansweredMessage 私聊中对方发送的消息未回复
+	UnansweredMessage = "message"
+	// UnansweredQuestion 私聊中对方的提问未回复
+	UnansweredQuestion = "question"
+	// UnansweredMention 群聊中 @我 或引用我的消息未回复
+	UnansweredMention = "mention"
+
+	// unansweredMessageLimit 每个对话列出的未回复消息数量
+	unansweredMessageLimit = 5
+)
+
+// Unanswered 尚未回复的对话
+type Unanswered struct {
+	Talker     string           `json:"talker"`
+	TalkerName string           `json:"talkerName"`
+	IsChatRoom bool             `json:"isChatRoom"`
+	Type       string           `json:"type"`
+	Pending    int              `json:"pending"` // 我最后一次发言之后未回复的消息数量
+	Time       time.Time        `json:"time"`    // 最早一条未回复消息的时间
+	Age        int64            `json:"age"`     // 最早一条未回复消息距今的秒数
+	Messages   []*DigestMessage `json:"messages"`
+}
+
+// FindUnanswered 查找对话中我最后一次发言之后尚未回复的消息
+// 私聊中为对方发送的全部消息，群聊中仅为 @我 或引用我的消息，没有未回复消息时返回 nil
+func FindUnanswered(talker, talkerName string, messages []*Message, self string, mentions *MentionMatcher) *Unanswered {
+	isChatRoom := strings.HasSuffix(talker, "@chatroom")
+
+	var pending []*Message
+	question := false
+	for _, msg := range messages {
+		if msg.Type == MessageTypeSystem {
+			continue
+		}
+		if msg.IsSelf {
+			pending, question = nil, false
+			continue
+		}
+		if isChatRoom {
+			// @所有人 通常为通知，不要求回复
+			if _type := MentionOf(msg, self, mentions); _type == "" || _type == MentionTypeAll {
+				continue
+			}
+		} else if msg.Type == MessageTypeText && IsQuestion(msg.Content) {
+			question = true
+		}
+		pending = append(pending, msg)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	u := &Unanswered{
+		Talker:     talker,
+		TalkerName: talkerName,
+		IsChatRoom: isChatRoom,
+		Type:       UnansweredMessage,
+		Pending:    len(pending),
+		Time:       pending[0].Time,
+	}
+	switch {
+	case isChatRoom:
+		u.Type = UnansweredMention
+	case question:
+		u.Type = UnansweredQuestion
+	}
+	for _, msg := range pending[max(0, len(pending)-unansweredMessageLimit):] {
+		u.Messages = append(u.Messages, &DigestMessage{
+			Time:       msg.Time,
+			Sender:     msg.Sender,
+			SenderName: msg.SenderName,
+			Content:    truncateRunes(msg.PlainTextContent(), digestContentLength),
+		})
+	}
+	return u
+}
+
+// UnansweredPlainText 以纯文本形式输出未回复的对话
+func UnansweredPlainText(items []*Unanswered) string {
+	return JoinTextBlocks(UnansweredTextBlocks(items))
+}
+
+// UnansweredTextBlocks 按概要和每个对话分段输出未回复的对话
+func UnansweredTextBlocks(items []*Unanswered) []TextBlock {
+	blocks := []TextBlock{{Key: "header", Text: fmt.Sprintf("共 %d 个对话未回复\n", len(items))}}
+	for _, u := range items {
+		label := "私聊"
+		switch u.Type {
+		case UnansweredQuestion:
+			label = "私聊提问"
+		case UnansweredMention:
+			label = "群聊@我"
+		}
+		buf := strings.Builder{}
+		buf.WriteString(fmt.Sprintf("\n[%s] %s 已等待 %s，未回复 %d 条\n", label, nameOf(u.Talker, u.TalkerName), (time.Duration(u.Age) * time.Second).Round(time.Minute), u.Pending))
+		for _, m := range u.Messages {
+			buf.WriteString(fmt.Sprintf("  %s %s：%s\n", m.Time.Format("2006-01-02 15:04"), digestSenderName(m.Sender, m.SenderName), m.Content))
+		}
+		blocks = append(blocks, TextBlock{Key: "talker/" + u.Talker, Text: buf.String()})
+	}
+	return blocks
+}
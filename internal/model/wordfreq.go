@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/pkg/util/nlp"
+	"github.com/sjzar/chatlog/pkg/util/wordcloud"
+)
+
+// WordFreq 时间范围内消息的词频统计
+type WordFreq struct {
+	StartTime time.Time  `json:"startTime"`
+	EndTime   time.Time  `json:"endTime"`
+	Talker    string     `json:"talker"`
+	Sender    string     `json:"sender"`
+	NGram     int        `json:"ngram"`    // 1 为单词，2 为相邻两个词组成的词组
+	Messages  int        `json:"messages"` // 包含有效词的消息数量
+	Total     int        `json:"total"`    // 词或词组的总数
+	Words     []nlp.Term `json:"words"`    // Score 为出现次数占总数的比例
+}
+
+// NewWordFreq 统计消息中出现次数最多的 limit 个词或词组
+func NewWordFreq(startTime, endTime time.Time, talker, sender string, messages []*Message, seg *nlp.Segmenter, ngram, limit int) *WordFreq {
+	if ngram < 1 {
+		ngram = 1
+	}
+	f := &WordFreq{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Talker:    talker,
+		Sender:    sender,
+		NGram:     ngram,
+		Words:     make([]nlp.Term, 0),
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range messages {
+		grams := seg.NGrams(TopicText(msg), ngram)
+		if len(grams) == 0 {
+			continue
+		}
+		f.Messages++
+		f.Total += len(grams)
+		for _, g := range grams {
+			counts[g]++
+		}
+	}
+
+	for word, count := range counts {
+		f.Words = append(f.Words, nlp.Term{Word: word, Count: count, Score: float64(count) / float64(f.Total)})
+	}
+	sort.Slice(f.Words, func(i, j int) bool {
+		if f.Words[i].Count != f.Words[j].Count {
+			return f.Words[i].Count > f.Words[j].Count
+		}
+		return f.Words[i].Word < f.Words[j].Word
+	})
+	if limit > 0 && len(f.Words) > limit {
+		f.Words = f.Words[:limit]
+	}
+	return f
+}
+
+// SVG 以词云形式输出词频
+func (f *WordFreq) SVG(width, height int) []byte {
+	words := make([]wordcloud.Word, 0, len(f.Words))
+	for _, w := range f.Words {
+		words = append(words, wordcloud.Word{Text: w.Word, Weight: float64(w.Count)})
+	}
+	return wordcloud.SVG(words, width, height)
+}
+
+// CSVHeader 词频 CSV 表头
+func (f *WordFreq) CSVHeader() []string {
+	return []string{"Word", "Count", "Frequency"}
+}
+
+// CSV 词频 CSV 数据
+func (f *WordFreq) CSV() [][]string {
+	rows := make([][]string, 0, len(f.Words))
+	for _, w := range f.Words {
+		rows = append(rows, []string{w.Word, strconv.Itoa(w.Count), strconv.FormatFloat(w.Score, 'f', 6, 64)})
+	}
+	return rows
+}
+
+// PlainText 以纯文本形式输出词频
+func (f *WordFreq) PlainText() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("时间范围: %s ~ %s，%d 条消息，共 %d 个词\n", f.StartTime.Format("2006-01-02 15:04:05"), f.EndTime.Format("2006-01-02 15:04:05"), f.Messages, f.Total))
+	for i, w := range f.Words {
+		buf.WriteString(fmt.Sprintf("%d. %s %d (%.2f%%)\n", i+1, w.Word, w.Count, w.Score*100))
+	}
+	return buf.String()
+}
@@ -0,0 +1,338 @@
+package decrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/pkg/filemonitor"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// Format 数据库格式，由平台和版本确定
+type Format struct {
+	Platform string `json:"platform"`
+	Version  int    `json:"version"`
+}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%s v%d", f.Platform, f.Version)
+}
+
+// Formats 支持的数据库格式，自动检测时按此顺序尝试
+var Formats = []Format{
+	{Platform: "windows", Version: 3},
+	{Platform: "windows", Version: 4},
+	{Platform: "darwin", Version: 3},
+	{Platform: "darwin", Version: 4},
+}
+
+// 数据库文件的验证状态
+const (
+	FileValid   = "valid"
+	FileInvalid = "invalid"
+	FilePlain   = "plain"
+	FileError   = "error"
+)
+
+// 图片密钥的验证状态
+const (
+	ImgKeySkipped  = "skipped"
+	ImgKeyValid    = "valid"
+	ImgKeyInvalid  = "invalid"
+	ImgKeyNoSample = "no sample"
+)
+
+// FileCheck 单个数据库文件的密钥验证结果
+type FileCheck struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+
+	// Formats 密钥验证通过的数据库格式
+	Formats []Format `json:"formats,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// KeyReport 数据密钥与图片密钥的验证结果
+type KeyReport struct {
+	DataDir  string `json:"dataDir"`
+	Platform string `json:"platform"`
+	Version  int    `json:"version"`
+
+	// Detected 平台与版本由数据库第一页检测得到
+	Detected bool `json:"detected"`
+
+	Files   []*FileCheck `json:"files"`
+	Valid   int          `json:"valid"`
+	Invalid int          `json:"invalid"`
+	Plain   int          `json:"plain"`
+	Failed  int          `json:"failed"`
+
+	ImgKey string `json:"imgKey"`
+
+	// Diagnostics 验证失败时的可能原因与处理建议
+	Diagnostics []string `json:"diagnostics"`
+}
+
+// OK 判断数据密钥能否解密全部加密数据库，且图片密钥未验证失败
+func (r *KeyReport) OK() bool {
+	return r.Valid > 0 && r.Invalid == 0 && r.Failed == 0 && r.ImgKey != ImgKeyInvalid
+}
+
+func (r *KeyReport) String() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("data dir: %s\n", r.DataDir))
+	if len(r.Platform) != 0 {
+		source := "specified"
+		if r.Detected {
+			source = "detected"
+		}
+		buf.WriteString(fmt.Sprintf("format: %s v%d (%s)\n", r.Platform, r.Version, source))
+	} else {
+		buf.WriteString("format: unknown\n")
+	}
+	buf.WriteString(fmt.Sprintf("files: %d valid, %d invalid, %d plain, %d error\n", r.Valid, r.Invalid, r.Plain, r.Failed))
+	for _, f := range r.Files {
+		rel, err := filepath.Rel(r.DataDir, f.Path)
+		if err != nil {
+			rel = f.Path
+		}
+		line := fmt.Sprintf("  [%s] %s", f.Status, rel)
+		switch {
+		case f.Error != "":
+			line += ": " + f.Error
+		case f.Status == FileInvalid && len(f.Formats) > 0:
+			formats := make([]string, 0, len(f.Formats))
+			for _, format := range f.Formats {
+				formats = append(formats, format.String())
+			}
+			line += " (matches " + strings.Join(formats, ", ") + ")"
+		}
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString(fmt.Sprintf("image key: %s\n", r.ImgKey))
+	if len(r.Diagnostics) > 0 {
+		buf.WriteString("diagnostics:\n")
+		for _, d := range r.Diagnostics {
+			buf.WriteString(fmt.Sprintf("  - %s\n", d))
+		}
+	}
+	return buf.String()
+}
+
+func (r *KeyReport) addDiagnostic(format string, args ...any) {
+	r.Diagnostics = append(r.Diagnostics, fmt.Sprintf(format, args...))
+}
+
+// CheckKey 使用数据密钥验证数据目录中的全部数据库，并验证图片密钥
+// 平台与版本由 DetectFormat 检测，每个数据库的第一页依次尝试所有格式的 Decryptor，与检测到的格式一致时验证通过
+// platform 和 version 不为空时优先使用，与检测结果不一致时给出提示
+func CheckKey(dataDir string, platform string, version int, hexKey string, imgKey string) (*KeyReport, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, errors.DecodeKeyFailed(err)
+	}
+	if len(key) != common.KeySize {
+		return nil, errors.DecodeKeyFailed(fmt.Errorf("key is %d bytes, expected %d bytes (%d hex characters)", len(key), common.KeySize, common.KeySize*2))
+	}
+
+	dbGroup, err := filemonitor.NewFileGroup("wechat", dataDir, `.*\.db$`, []string{"fts"})
+	if err != nil {
+		return nil, err
+	}
+	dbFiles, err := dbGroup.List()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &KeyReport{
+		DataDir:  dataDir,
+		Platform: platform,
+		Version:  version,
+		Files:    checkDBFiles(dbFiles, key),
+		ImgKey:   ImgKeySkipped,
+	}
+
+	// 使用与启动时相同的检测逻辑推断平台与版本
+	if detected, err := DetectFormat(dataDir, hexKey); err == nil {
+		if detected.Version == 4 && len(platform) != 0 && !detected.Conflicts(platform, version) {
+			// 4.x 数据库格式相同，沿用指定的平台
+			detected.Platform = platform
+		}
+		if len(platform) != 0 && version != 0 && detected.Conflicts(platform, version) {
+			report.addDiagnostic("databases are in %s format, not %s v%d, run with --platform %s --version %d",
+				detected, platform, version, detected.Platform, detected.Version)
+		}
+		if detected.Version == 4 && len(platform) == 0 {
+			report.addDiagnostic("windows v4 and darwin v4 databases share the same format, assumed %s, use --platform if this is wrong", detected.Platform)
+		}
+		report.Platform = detected.Platform
+		report.Version = detected.Version
+		report.Detected = true
+	}
+
+	format := Format{Platform: report.Platform, Version: report.Version}
+	for _, f := range report.Files {
+		switch f.Status {
+		case FilePlain:
+			report.Plain++
+			continue
+		case FileError:
+			report.Failed++
+			continue
+		}
+		f.Status = FileInvalid
+		for _, ff := range f.Formats {
+			if ff == format {
+				f.Status = FileValid
+			}
+		}
+		if f.Status == FileValid {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+	}
+
+	switch {
+	case len(report.Files) == 0:
+		report.addDiagnostic("no database found, make sure --data-dir points to the account directory that contains db_storage, Msg or Message")
+	case report.Plain == len(report.Files):
+		report.addDiagnostic("all databases are already decrypted, no key is needed")
+	case report.Valid == 0 && report.Invalid > 0:
+		report.addDiagnostic("the key does not match any database, it may belong to another account or have changed after WeChat logged in again, run `chatlog key` while WeChat is running to get the current key")
+	case report.Invalid > 0:
+		report.addDiagnostic("%d databases cannot be decrypted with this key, they may be left over from another account or corrupted, run `chatlog decrypt --verify` to check", report.Invalid)
+	}
+	if report.Failed > 0 {
+		report.addDiagnostic("%d databases cannot be read, they may be too small, locked or being written", report.Failed)
+	}
+
+	checkImgKey(report, imgKey)
+
+	return report, nil
+}
+
+// checkDBFiles 并行读取每个数据库的第一页，记录密钥验证通过的格式
+func checkDBFiles(dbFiles []string, key []byte) []*FileCheck {
+	decryptors := make([]Decryptor, len(Formats))
+	for i, format := range Formats {
+		decryptors[i], _ = NewDecryptor(format.Platform, format.Version)
+	}
+
+	files := make([]*FileCheck, len(dbFiles))
+	tasks := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < max(common.Workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				files[i] = checkDBFile(dbFiles[i], key, decryptors)
+			}
+		}()
+	}
+	for i := range dbFiles {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	return files
+}
+
+func checkDBFile(path string, key []byte, decryptors []Decryptor) *FileCheck {
+	f := &FileCheck{Path: path}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		f.Status, f.Error = FileError, err.Error()
+		return f
+	}
+	defer fp.Close()
+
+	minPageSize, maxPageSize := decryptors[0].GetPageSize(), 0
+	for _, d := range decryptors {
+		minPageSize = min(minPageSize, d.GetPageSize())
+		maxPageSize = max(maxPageSize, d.GetPageSize())
+	}
+	page1 := make([]byte, maxPageSize)
+	n, err := io.ReadFull(fp, page1)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		f.Status, f.Error = FileError, err.Error()
+		return f
+	}
+	page1 = page1[:n]
+
+	if bytes.HasPrefix(page1, []byte(common.SQLiteHeader[:len(common.SQLiteHeader)-1])) {
+		f.Status = FilePlain
+		return f
+	}
+
+	for i, d := range decryptors {
+		if len(page1) < d.GetPageSize() {
+			continue
+		}
+		if d.Validate(page1[:d.GetPageSize()], key) {
+			f.Formats = append(f.Formats, Formats[i])
+		}
+	}
+	if len(page1) < minPageSize {
+		f.Status, f.Error = FileError, fmt.Sprintf("file too small (%d bytes)", n)
+		return f
+	}
+	f.Status = FileInvalid
+	return f
+}
+
+// checkImgKey 使用数据目录中的 V4 格式图片验证图片密钥，仅 4.x 版本的图片使用 AES 加密
+func checkImgKey(report *KeyReport, imgKey string) {
+	if report.Version != 4 {
+		if len(imgKey) != 0 {
+			report.addDiagnostic("image key is only used by WeChat 4.x, ignored")
+		}
+		return
+	}
+	if len(imgKey) == 0 {
+		report.addDiagnostic("image key not checked, pass --img-key to validate it")
+		return
+	}
+
+	key, err := hex.DecodeString(imgKey)
+	if err != nil || len(key) < 16 {
+		report.ImgKey = ImgKeyInvalid
+		report.addDiagnostic("image key must be at least 16 bytes in hex (32 hex characters)")
+		return
+	}
+
+	// 与恢复图片密钥时相同，密钥需解密全部样本
+	validators := dat2img.NewImgKeyValidators(report.DataDir, dat2img.ImgKeySamples)
+	if len(validators) == 0 {
+		report.ImgKey = ImgKeyNoSample
+		report.addDiagnostic("no AES encrypted image (.dat) found in data dir, open some images in WeChat and check again")
+		return
+	}
+	passed := 0
+	for _, v := range validators {
+		if v.Validate(key) {
+			passed++
+		}
+	}
+	if passed != len(validators) {
+		report.ImgKey = ImgKeyInvalid
+		report.addDiagnostic("image key decrypts %d of %d sample images, open some images in WeChat and run `chatlog key` again to get the image key", passed, len(validators))
+		return
+	}
+	if len(validators) < dat2img.ImgKeySamples {
+		report.addDiagnostic("only %d sample images found, open more images in WeChat to check the image key more reliably", len(validators))
+	}
+	report.ImgKey = ImgKeyValid
+}
@@ -0,0 +1,246 @@
+package decrypt_test
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// datasets 按平台、版本和随机种子缓存生成的数据目录，CheckKey 不会修改数据库
+var datasets = make(map[string]*fixture.Dataset)
+
+// generate 生成加密的数据目录，相同参数的数据目录在测试间共用
+func generate(t *testing.T, platform string, version int, seed int64) *fixture.Dataset {
+	t.Helper()
+	name := fmt.Sprintf("%s_v%d_%d", platform, version, seed)
+	if ds, ok := datasets[name]; ok {
+		return ds
+	}
+	dir, err := os.MkdirTemp("", "chatlog-check-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := fixture.Generate(filepath.Join(dir, "data"), fixture.Options{
+		Platform:   platform,
+		Version:    version,
+		Seed:       seed,
+		Contacts:   2,
+		Messages:   4,
+		MessageDBs: 1,
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	datasets[name] = ds
+	return ds
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for _, ds := range datasets {
+		os.RemoveAll(filepath.Dir(ds.Dir))
+	}
+	os.Exit(code)
+}
+
+// hasDiagnostic 判断验证结果中是否有包含 substr 的提示
+func hasDiagnostic(report *decrypt.KeyReport, substr string) bool {
+	for _, d := range report.Diagnostics {
+		if strings.Contains(d, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckKey(t *testing.T) {
+	wrongKey := strings.Repeat("ab", 32)
+	tests := []struct {
+		name     string
+		platform string
+		version  int
+
+		// checkPlatform 和 checkVersion 为验证时指定的平台和版本
+		checkPlatform string
+		checkVersion  int
+		wrongKey      bool
+
+		wantOK         bool
+		wantDiagnostic string
+	}{
+		{name: "windows v3", platform: "windows", version: 3, wantOK: true},
+		{name: "windows v4", platform: "windows", version: 4, wantOK: true, wantDiagnostic: "share the same format"},
+		{name: "darwin v3", platform: "darwin", version: 3, wantOK: true},
+		{name: "darwin v4", platform: "darwin", version: 4, checkPlatform: "darwin", checkVersion: 4, wantOK: true},
+		{name: "specified", platform: "windows", version: 3, checkPlatform: "windows", checkVersion: 3, wantOK: true},
+		{name: "wrong key", platform: "windows", version: 4, wrongKey: true, wantDiagnostic: "does not match any database"},
+		{name: "wrong key darwin v3", platform: "darwin", version: 3, wrongKey: true, wantDiagnostic: "does not match any database"},
+		// 指定的平台或版本与数据库不符时使用检测到的格式，并提示正确的参数
+		{name: "wrong platform", platform: "windows", version: 3, checkPlatform: "darwin", checkVersion: 3, wantOK: true, wantDiagnostic: "run with --platform windows --version 3"},
+		{name: "wrong version", platform: "windows", version: 4, checkPlatform: "windows", checkVersion: 3, wantOK: true, wantDiagnostic: "not windows v3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := generate(t, tt.platform, tt.version, 1)
+			key := ds.Key
+			if tt.wrongKey {
+				key = wrongKey
+			}
+
+			report, err := decrypt.CheckKey(ds.Dir, tt.checkPlatform, tt.checkVersion, key, "")
+			if err != nil {
+				t.Fatalf("CheckKey() error = %v", err)
+			}
+			if report.OK() != tt.wantOK {
+				t.Errorf("CheckKey() OK = %v, want %v\n%s", report.OK(), tt.wantOK, report)
+			}
+			if tt.wantDiagnostic != "" && !hasDiagnostic(report, tt.wantDiagnostic) {
+				t.Errorf("CheckKey() diagnostics = %q, want %q", report.Diagnostics, tt.wantDiagnostic)
+			}
+			if len(report.Files) != len(ds.Files) {
+				t.Errorf("CheckKey() checked %d files, want %d", len(report.Files), len(ds.Files))
+			}
+			if tt.wrongKey {
+				if report.Valid != 0 || report.Invalid != len(ds.Files) {
+					t.Errorf("CheckKey() valid = %d, invalid = %d, want 0, %d", report.Valid, report.Invalid, len(ds.Files))
+				}
+				return
+			}
+
+			if report.Valid != len(ds.Files) {
+				t.Errorf("CheckKey() valid = %d, want %d\n%s", report.Valid, len(ds.Files), report)
+			}
+			want := decrypt.Format{Platform: tt.platform, Version: tt.version}
+			if tt.version == 4 && tt.checkPlatform == "" {
+				want.Platform = "windows"
+				if runtime.GOOS == "darwin" {
+					want.Platform = "darwin"
+				}
+			}
+			if got := (decrypt.Format{Platform: report.Platform, Version: report.Version}); !report.Detected || got != want {
+				t.Errorf("CheckKey() format = %s (detected %v), want %s", got, report.Detected, want)
+			}
+		})
+	}
+}
+
+// TestCheckKeyMixed 数据目录中有其他账号遗留的数据库和已解密的数据库
+func TestCheckKeyMixed(t *testing.T) {
+	ds, err := fixture.Generate(filepath.Join(t.TempDir(), "data"), fixture.Options{Platform: "windows", Version: 4, Contacts: 2, Messages: 4})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	other := generate(t, "windows", 4, 2)
+	data, err := os.ReadFile(filepath.Join(other.Dir, other.Files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ds.Dir, "db_storage", "other.db"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ds.Dir, "db_storage", "plain.db"), []byte("SQLite format 3\x00"+strings.Repeat("\x00", 4080)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := decrypt.CheckKey(ds.Dir, "windows", 4, ds.Key, "")
+	if err != nil {
+		t.Fatalf("CheckKey() error = %v", err)
+	}
+	if report.OK() || report.Valid != len(ds.Files) || report.Invalid != 1 || report.Plain != 1 {
+		t.Errorf("CheckKey() = %d valid, %d invalid, %d plain, want %d, 1, 1\n%s", report.Valid, report.Invalid, report.Plain, len(ds.Files), report)
+	}
+	if !hasDiagnostic(report, "1 databases cannot be decrypted") {
+		t.Errorf("CheckKey() diagnostics = %q", report.Diagnostics)
+	}
+}
+
+func TestCheckKeyInvalidKey(t *testing.T) {
+	ds := generate(t, "windows", 4, 1)
+	for _, key := range []string{"zz", ds.Key[:32]} {
+		if _, err := decrypt.CheckKey(ds.Dir, "", 0, key, ""); err == nil {
+			t.Errorf("CheckKey(%q) error = nil, want error", key)
+		}
+	}
+}
+
+// writeImage 写入使用 key 加密第一个 AES 块的 V4 格式图片
+func writeImage(t *testing.T, path string, key []byte) {
+	t.Helper()
+	block := make([]byte, aes.BlockSize)
+	copy(block, dat2img.JPG.Header)
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 15+aes.BlockSize)
+	copy(data, dat2img.V4Format2.Header)
+	c.Encrypt(data[15:], block)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckKeyImgKey(t *testing.T) {
+	imgKey := []byte("0123456789abcdef")
+	otherKey := []byte("fedcba9876543210")
+
+	tests := []struct {
+		name    string
+		version int
+
+		// images 图片使用的密钥，每张图片一个
+		images [][]byte
+		imgKey string
+
+		want           string
+		wantOK         bool
+		wantDiagnostic string
+	}{
+		{name: "skipped", version: 4, images: [][]byte{imgKey}, want: decrypt.ImgKeySkipped, wantOK: true, wantDiagnostic: "pass --img-key"},
+		{name: "valid", version: 4, images: [][]byte{imgKey, imgKey, imgKey}, imgKey: hex.EncodeToString(imgKey), want: decrypt.ImgKeyValid, wantOK: true},
+		{name: "few samples", version: 4, images: [][]byte{imgKey}, imgKey: hex.EncodeToString(imgKey), want: decrypt.ImgKeyValid, wantOK: true, wantDiagnostic: "only 1 sample images"},
+		{name: "wrong key", version: 4, images: [][]byte{imgKey, imgKey}, imgKey: hex.EncodeToString(otherKey), want: decrypt.ImgKeyInvalid, wantDiagnostic: "decrypts 0 of 2"},
+		{name: "partially matching", version: 4, images: [][]byte{imgKey, otherKey, imgKey}, imgKey: hex.EncodeToString(imgKey), want: decrypt.ImgKeyInvalid, wantDiagnostic: "decrypts 2 of 3"},
+		{name: "malformed", version: 4, images: [][]byte{imgKey}, imgKey: "0123", want: decrypt.ImgKeyInvalid, wantDiagnostic: "at least 16 bytes"},
+		{name: "no sample", version: 4, imgKey: hex.EncodeToString(imgKey), want: decrypt.ImgKeyNoSample, wantOK: true, wantDiagnostic: "no AES encrypted image"},
+		{name: "v3", version: 3, images: [][]byte{imgKey}, imgKey: hex.EncodeToString(imgKey), want: decrypt.ImgKeySkipped, wantOK: true, wantDiagnostic: "only used by WeChat 4.x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := generate(t, "windows", tt.version, 1)
+			images := filepath.Join(ds.Dir, "msg", "attach")
+			defer os.RemoveAll(images)
+			for i, key := range tt.images {
+				writeImage(t, filepath.Join(images, "a", "2024-03", "Img", fmt.Sprintf("%d.dat", i)), key)
+			}
+
+			report, err := decrypt.CheckKey(ds.Dir, "windows", tt.version, ds.Key, tt.imgKey)
+			if err != nil {
+				t.Fatalf("CheckKey() error = %v", err)
+			}
+			if report.ImgKey != tt.want {
+				t.Errorf("CheckKey() image key = %s, want %s\n%s", report.ImgKey, tt.want, report)
+			}
+			if report.OK() != tt.wantOK {
+				t.Errorf("CheckKey() OK = %v, want %v\n%s", report.OK(), tt.wantOK, report)
+			}
+			if tt.wantDiagnostic != "" && !hasDiagnostic(report, tt.wantDiagnostic) {
+				t.Errorf("CheckKey() diagnostics = %q, want %q", report.Diagnostics, tt.wantDiagnostic)
+			}
+		})
+	}
+}
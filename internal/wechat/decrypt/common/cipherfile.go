@@ -0,0 +1,137 @@
+package common
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// CipherFile 按页加解密的 SQLCipher 格式数据库文件，实现 pagevfs.File
+// SQLite 通过 pagevfs 读写时只在内存中保留正在访问的页面的明文，磁盘上始终为密文
+type CipherFile struct {
+	c      *Cipher
+	f      *os.File
+	salt   []byte
+	encKey []byte
+	macKey []byte
+	size   int64
+	raw    []byte
+}
+
+// OpenFile 打开使用 passphrase 加密的数据库文件
+// readOnly 为 false 时文件不存在则创建，空文件在第一次写入时使用随机 salt 加密
+// 密码与已有文件不匹配时返回 ErrWorkKeyIncorrect
+func (c *Cipher) OpenFile(path string, passphrase string, readOnly bool) (*CipherFile, error) {
+	return c.openFile(path, passphrase, readOnly, nil)
+}
+
+// openFile 打开数据库文件，salt 不为空时空文件使用 salt 加密
+func (c *Cipher) openFile(path string, passphrase string, readOnly bool, salt []byte) (*CipherFile, error) {
+	flag := os.O_RDONLY
+	if !readOnly {
+		flag = os.O_RDWR | os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.StatFileFailed(path, err)
+	}
+
+	cf := &CipherFile{c: c, f: f, size: info.Size(), raw: make([]byte, c.PageSize)}
+	if cf.size == 0 && !readOnly && salt != nil {
+		cf.salt = salt
+	} else if cf.size == 0 && !readOnly {
+		cf.salt = make([]byte, SaltSize)
+		if _, err := rand.Read(cf.salt); err != nil {
+			f.Close()
+			return nil, errors.DecryptCreateCipherFailed(err)
+		}
+	} else {
+		if _, err := io.ReadFull(f, cf.raw); err != nil {
+			f.Close()
+			return nil, errors.ReadFileFailed(path, err)
+		}
+		if cf.salt, err = c.openPage(cf.raw, passphrase); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	cf.encKey, cf.macKey = c.DeriveKeys([]byte(passphrase), cf.salt)
+	return cf, nil
+}
+
+// Salt 返回文件的 salt
+func (cf *CipherFile) Salt() []byte {
+	return cf.salt
+}
+
+// PageSize 返回页面大小
+func (cf *CipherFile) PageSize() int {
+	return cf.c.PageSize
+}
+
+// ReadPage 读取并解密页面，第一页的 salt 替换为 SQLite 头
+func (cf *CipherFile) ReadPage(pageNum int64, page []byte) error {
+	pageSize := cf.c.PageSize
+	if _, err := cf.f.ReadAt(cf.raw, pageNum*int64(pageSize)); err != nil {
+		return errors.ReadFileFailed(cf.f.Name(), err)
+	}
+	plain, err := DecryptPage(cf.raw, cf.encKey, cf.macKey, pageNum, cf.c.HashFunc, cf.c.HMACSize, cf.c.Reserve, pageSize)
+	if err != nil {
+		return err
+	}
+	if pageNum == 0 {
+		copy(page, SQLiteHeader)
+		copy(page[SaltSize:], plain)
+		return nil
+	}
+	copy(page, plain)
+	return nil
+}
+
+// WritePage 加密并写入页面
+func (cf *CipherFile) WritePage(pageNum int64, page []byte) error {
+	enc, err := cf.c.EncryptPage(page, pageNum, cf.salt, cf.encKey, cf.macKey)
+	if err != nil {
+		return err
+	}
+	offset := pageNum * int64(cf.c.PageSize)
+	if _, err := cf.f.WriteAt(enc, offset); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	cf.size = max(cf.size, offset+int64(len(enc)))
+	return nil
+}
+
+// Size 返回文件大小
+func (cf *CipherFile) Size() (int64, error) {
+	return cf.size, nil
+}
+
+// Truncate 截断文件
+func (cf *CipherFile) Truncate(size int64) error {
+	if err := cf.f.Truncate(size); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	cf.size = size
+	return nil
+}
+
+// Sync 将写入的页面同步到磁盘
+func (cf *CipherFile) Sync() error {
+	if err := cf.f.Sync(); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	return nil
+}
+
+// Close 关闭文件
+func (cf *CipherFile) Close() error {
+	return cf.f.Close()
+}
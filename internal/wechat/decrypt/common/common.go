@@ -25,7 +25,8 @@ const (
 type DBFile struct {
 	Path       string
 	Salt       []byte
-	TotalPages int64
+	Size       int64 // 打开时的文件大小，解密只处理此范围内的页面
+	TotalPages int64 // 页面数量，包含末尾不完整的页面
 	FirstPage  []byte
 }
 
@@ -64,6 +65,7 @@ func OpenDBFile(dbPath string, pageSize int) (*DBFile, error) {
 		Path:       dbPath,
 		Salt:       buffer[:SaltSize],
 		FirstPage:  buffer,
+		Size:       fileSize,
 		TotalPages: totalPages,
 	}, nil
 }
@@ -83,6 +85,11 @@ func ValidateKey(page1 []byte, key []byte, salt []byte, hashFunc func() hash.Has
 
 	_, macKey := deriveKeys(key, salt)
 
+	return ValidateMAC(page1, macKey, hashFunc, hmacSize, reserve, pageSize)
+}
+
+// ValidateMAC 使用已派生的 MAC 密钥验证第一页
+func ValidateMAC(page1 []byte, macKey []byte, hashFunc func() hash.Hash, hmacSize int, reserve int, pageSize int) bool {
 	mac := hmac.New(hashFunc, macKey)
 	dataEnd := pageSize - reserve + IVSize
 	mac.Write(page1[SaltSize:dataEnd])
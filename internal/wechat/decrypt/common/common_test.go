@@ -0,0 +1,173 @@
+package common_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
+)
+
+// testDB 由 fixture 生成的明文数据库及按平台格式加密后的副本
+type testDB struct {
+	platform string
+	version  int
+	key      string
+	salt     []byte
+
+	// plain 明文数据库，path 加密后的数据库
+	plain string
+	path  string
+
+	// last 上次加密时的明文内容和 salt，内容未变的页面沿用上次的密文
+	last     []byte
+	lastSalt []byte
+
+	decryptor decrypt.Decryptor
+}
+
+// newTestDB 生成平台的明文数据库，写入 padRows 行约 1KB 的数据后加密
+func newTestDB(t *testing.T, platform string, version int, padRows int) *testDB {
+	t.Helper()
+	ds, err := fixture.Generate(filepath.Join(t.TempDir(), "plain"), fixture.Options{
+		Platform: platform,
+		Version:  version,
+		Plain:    true,
+
+		// 只需要一个数据库文件，减少生成的数据
+		Contacts:   1,
+		ChatRooms:  -1,
+		Messages:   5,
+		MessageDBs: 1,
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	d, err := decrypt.NewDecryptor(platform, version)
+	if err != nil {
+		t.Fatalf("NewDecryptor() error = %v", err)
+	}
+
+	db := &testDB{
+		platform:  platform,
+		version:   version,
+		key:       ds.Key,
+		salt:      bytes.Repeat([]byte{0x5a}, common.SaltSize),
+		plain:     filepath.Join(ds.Dir, ds.Files[0]),
+		path:      filepath.Join(t.TempDir(), "test.db"),
+		decryptor: d,
+	}
+	db.exec(t, "CREATE TABLE pad (data BLOB)")
+	db.pad(t, padRows)
+	return db
+}
+
+// exec 在明文数据库中执行语句并重新加密
+func (db *testDB) exec(t *testing.T, queries ...string) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", db.plain)
+	if err != nil {
+		t.Fatalf("open %s error = %v", db.plain, err)
+	}
+	defer conn.Close()
+	for _, query := range queries {
+		if _, err := conn.Exec(query); err != nil {
+			t.Fatalf("%s error = %v", query, err)
+		}
+	}
+	db.encrypt(t)
+}
+
+// pad 写入 rows 行随机数据
+func (db *testDB) pad(t *testing.T, rows int) {
+	t.Helper()
+	if rows <= 0 {
+		return
+	}
+	db.exec(t, fmt.Sprintf("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d) INSERT INTO pad SELECT randomblob(1000) FROM n", rows))
+}
+
+// encrypt 按平台格式加密明文数据库
+// 与微信只改写变化的页面相同，内容未变的页面沿用上次的密文，否则随机 IV 会导致所有页面的密文都发生变化
+func (db *testDB) encrypt(t *testing.T) {
+	t.Helper()
+	key, err := hex.DecodeString(db.key)
+	if err != nil {
+		t.Fatalf("decode key error = %v", err)
+	}
+	temp := db.path + ".enc"
+	if err := fixture.EncryptDB(db.plain, temp, db.platform, db.version, key, db.salt); err != nil {
+		t.Fatalf("EncryptDB() error = %v", err)
+	}
+	data := readFile(t, temp)
+	os.Remove(temp)
+	plain := readFile(t, db.plain)
+
+	if bytes.Equal(db.lastSalt, db.salt) {
+		if old, err := os.ReadFile(db.path); err == nil {
+			pageSize := db.decryptor.GetPageSize()
+			for off := 0; off+pageSize <= min(len(plain), len(db.last), len(old)); off += pageSize {
+				if bytes.Equal(plain[off:off+pageSize], db.last[off:off+pageSize]) {
+					copy(data[off:off+pageSize], old[off:off+pageSize])
+				}
+			}
+		}
+	}
+	if err := os.WriteFile(db.path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db.last, db.lastSalt = plain, bytes.Clone(db.salt)
+}
+
+// pages 明文数据库的页数
+func (db *testDB) pages(t *testing.T) int {
+	t.Helper()
+	info, err := os.Stat(db.plain)
+	if err != nil {
+		t.Fatalf("stat %s error = %v", db.plain, err)
+	}
+	return int(info.Size()) / db.decryptor.GetPageSize()
+}
+
+// check 比较解密结果与明文数据库，保留字节中为密文的 IV 和 HMAC，不参与比较
+func (db *testDB) check(t *testing.T, got []byte) {
+	t.Helper()
+	want, err := os.ReadFile(db.plain)
+	if err != nil {
+		t.Fatalf("read %s error = %v", db.plain, err)
+	}
+	pageSize, reserve := db.decryptor.GetPageSize(), db.decryptor.GetReserve()
+	if len(got) != len(want) {
+		t.Fatalf("decrypted size = %d, want %d", len(got), len(want))
+	}
+	for off := 0; off < len(want); off += pageSize {
+		if !bytes.Equal(got[off:off+pageSize-reserve], want[off:off+pageSize-reserve]) {
+			t.Fatalf("page %d differs from plain database", off/pageSize+1)
+		}
+	}
+}
+
+// checkFile 比较解密后的文件与明文数据库
+func (db *testDB) checkFile(t *testing.T, path string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s error = %v", path, err)
+	}
+	db.check(t, got)
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
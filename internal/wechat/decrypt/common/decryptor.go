@@ -0,0 +1,180 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// DeriveKeysFunc 从原始密钥和 salt 派生加密密钥和 MAC 密钥
+type DeriveKeysFunc func(key []byte, salt []byte) ([]byte, []byte)
+
+// BaseDecryptor 各平台解密器共用的解密流程
+// 平台解密器只需提供页面参数和密钥派生方式，解密、增量解密和校验的流程相同
+type BaseDecryptor struct {
+	version    string
+	pageSize   int
+	hmacSize   int
+	reserve    int
+	hashFunc   func() hash.Hash
+	deriveKeys DeriveKeysFunc
+	keys       KeyCache
+	cipher     *Cipher // 工作目录数据库的加密格式，缓存重新加密时派生的密钥
+}
+
+// NewBaseDecryptor 创建解密器，保留字节数为 IV 和 HMAC 长度之和按 AES 块大小对齐
+func NewBaseDecryptor(version string, pageSize int, hmacSize int, hashFunc func() hash.Hash, deriveKeys DeriveKeysFunc) *BaseDecryptor {
+	reserve := IVSize + hmacSize
+	if reserve%AESBlockSize != 0 {
+		reserve = ((reserve / AESBlockSize) + 1) * AESBlockSize
+	}
+
+	return &BaseDecryptor{
+		version:    version,
+		pageSize:   pageSize,
+		hmacSize:   hmacSize,
+		reserve:    reserve,
+		hashFunc:   hashFunc,
+		deriveKeys: deriveKeys,
+		cipher:     NewCipher(),
+	}
+}
+
+// Validate 验证密钥是否有效
+func (d *BaseDecryptor) Validate(page1 []byte, key []byte) bool {
+	if len(page1) < d.pageSize || len(key) != KeySize {
+		return false
+	}
+
+	salt := page1[:SaltSize]
+	return ValidateKey(page1, key, salt, d.hashFunc, d.hmacSize, d.reserve, d.pageSize, d.deriveKeys)
+}
+
+// Decrypt 解密数据库
+func (d *BaseDecryptor) Decrypt(ctx context.Context, dbfile string, hexKey string, output io.Writer) error {
+	dbInfo, decryptPage, err := d.prepare(ctx, dbfile, hexKey, false)
+	if err != nil {
+		return err
+	}
+
+	// 并行解密每一页，按顺序写入
+	return DecryptDBFile(ctx, dbInfo, d.pageSize, output, decryptPage)
+}
+
+// DecryptIncremental 增量解密数据库到 outputPath，仅解密与上次解密相比发生变化的页面
+// outputKey 不为空时解密后的文件按 SQLCipher 4 格式重新加密
+func (d *BaseDecryptor) DecryptIncremental(ctx context.Context, dbfile string, hexKey string, outputPath string, outputKey string) error {
+	dbInfo, decryptPage, err := d.prepare(ctx, dbfile, hexKey, false)
+	if err != nil {
+		return err
+	}
+
+	return DecryptDBFileIncremental(ctx, dbInfo, d.pageSize, d.reserve, outputPath, d.cipher, outputKey, decryptPage)
+}
+
+// Verify 校验每个页面的 HMAC 并解密数据库，校验失败的页面以全零页面代替
+// 第一页损坏时使用第一个通过 HMAC 校验的页面验证密钥，第一页记为损坏页面
+func (d *BaseDecryptor) Verify(ctx context.Context, dbfile string, hexKey string, output io.Writer, outputKey string) (*VerifyReport, error) {
+	dbInfo, decryptPage, err := d.prepare(ctx, dbfile, hexKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return VerifyDBFile(ctx, dbInfo, d.pageSize, d.reserve, output, d.cipher, outputKey, decryptPage)
+}
+
+// prepare 打开数据库文件并验证密钥，返回页面解密函数
+// anyPage 为 true 时，第一页未通过校验则使用后续第一个通过校验的页面验证密钥
+func (d *BaseDecryptor) prepare(ctx context.Context, dbfile string, hexKey string, anyPage bool) (*DBFile, PageDecryptFunc, error) {
+	// 解码密钥
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, nil, errors.DecodeKeyFailed(err)
+	}
+
+	// 打开数据库文件并读取基本信息
+	dbInfo, err := OpenDBFile(dbfile, d.pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 计算密钥，同一解密器对相同密钥和 salt 的派生结果会被缓存
+	if len(key) != KeySize {
+		return nil, nil, errors.ErrDecryptIncorrectKey
+	}
+	encKey, macKey := d.keys.Derive(key, dbInfo.Salt, d.deriveKeys)
+
+	decryptPage := func(pageBuf []byte, pageNum int64) ([]byte, error) {
+		return DecryptPage(pageBuf, encKey, macKey, pageNum, d.hashFunc, d.hmacSize, d.reserve, d.pageSize)
+	}
+
+	// 验证密钥
+	if ValidateMAC(dbInfo.FirstPage, macKey, d.hashFunc, d.hmacSize, d.reserve, d.pageSize) {
+		return dbInfo, decryptPage, nil
+	}
+	if !anyPage {
+		return nil, nil, errors.ErrDecryptIncorrectKey
+	}
+	ok, err := validateAnyPage(ctx, dbInfo, d.pageSize, decryptPage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, errors.ErrDecryptIncorrectKey
+	}
+	return dbInfo, decryptPage, nil
+}
+
+// validateAnyPage 从第二页开始查找通过 HMAC 校验的页面，找到时密钥正确
+// 第一页损坏时 salt 可能仍然完好，密钥错误时需要读取整个文件
+func validateAnyPage(ctx context.Context, dbInfo *DBFile, pageSize int, decryptPage PageDecryptFunc) (bool, error) {
+	f, err := os.Open(dbInfo.Path)
+	if err != nil {
+		return false, errors.OpenFileFailed(dbInfo.Path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(io.NewSectionReader(f, int64(pageSize), dbInfo.Size-int64(pageSize)), BatchPages*pageSize)
+	pageBuf := make([]byte, pageSize)
+	for pageNum := int64(1); ; pageNum++ {
+		if pageNum%BatchPages == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, errors.ErrDecryptOperationCanceled
+			}
+		}
+		if _, err := io.ReadFull(r, pageBuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, errors.ReadFileFailed(dbInfo.Path, err)
+		}
+		if _, err := decryptPage(pageBuf, pageNum); err == nil {
+			return true, nil
+		}
+	}
+}
+
+// GetPageSize 返回页面大小
+func (d *BaseDecryptor) GetPageSize() int {
+	return d.pageSize
+}
+
+// GetReserve 返回保留字节数
+func (d *BaseDecryptor) GetReserve() int {
+	return d.reserve
+}
+
+// GetHMACSize 返回HMAC大小
+func (d *BaseDecryptor) GetHMACSize() int {
+	return d.hmacSize
+}
+
+// GetVersion 返回解密器版本
+func (d *BaseDecryptor) GetVersion() string {
+	return d.version
+}
@@ -0,0 +1,409 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	// JournalSuffix 增量解密写入日志文件的后缀
+	JournalSuffix = ".journal"
+
+	journalMagic = "CLPJ"
+
+	// journalCommit 日志提交记录的页号，其后为数据库的总页数
+	journalCommit = ^uint64(0)
+
+	// maxChangedRatio 变化页面超过该比例时改为并行全量解密
+	maxChangedRatio = 0.5
+)
+
+// DecryptDBFileIncremental 增量解密数据库文件到 outputPath
+// 根据页面指纹清单找出密文发生变化的页面，仅解密这些页面并更新解密后的文件。
+// 变化的页面先写入日志文件并提交，再直接写入 outputPath，同步到磁盘后才删除日志，
+// 中途退出时下次运行会重放已提交的日志，保证解密后的文件不会停留在部分更新的状态。
+// 数据库的 WAL 文件中已提交的页面会一并解密并合并到解密后的文件，尚未 checkpoint 的新消息也能立即查询。
+// 清单不存在、与数据库不匹配或变化的页面过多时，全量解密到临时文件后重命名。
+// outputKey 不为空时，解密后的页面按 outputCipher 的 SQLCipher 4 格式使用 outputKey 重新加密，日志和解密后的文件中均不保留明文，
+// reserve 为数据库每页的保留字节数，页面布局与 SQLCipher 4 不同时改为重建数据库，见 rebuildDBFile。
+func DecryptDBFileIncremental(ctx context.Context, dbInfo *DBFile, pageSize int, reserve int, outputPath string, outputCipher *Cipher, outputKey string, decryptPage PageDecryptFunc) error {
+	journalPath := outputPath + JournalSuffix
+	manifestPath := outputPath + ManifestSuffix
+
+	if outputKey == "" {
+		outputCipher = nil
+	}
+
+	// 重放上次已提交但未完成的写入，重放失败时解密后的文件可能只更新了一部分，改为全量解密
+	if err := replayJournal(journalPath, outputPath, pageSize); err != nil {
+		log.Debug().Err(err).Msgf("replay journal %s failed", journalPath)
+		os.Remove(manifestPath)
+	}
+	os.Remove(journalPath)
+	// 上次全量解密或重建中断时留下的临时文件
+	os.Remove(outputPath + ".tmp")
+
+	wal, err := readWAL(dbInfo.Path+WALSuffix, pageSize)
+	if err != nil {
+		log.Debug().Err(err).Msgf("read wal of %s failed", dbInfo.Path)
+	}
+	if outputCipher != nil && !outputCipher.Compatible(pageSize, reserve) {
+		return rebuildDBFile(ctx, dbInfo, pageSize, outputPath, outputCipher, outputKey, wal, decryptPage)
+	}
+	full := func() error {
+		return decryptFull(ctx, dbInfo, pageSize, outputPath, wal, outputCipher, outputKey, decryptPage)
+	}
+
+	manifest, err := LoadManifest(manifestPath)
+	if err != nil || !manifest.Match(dbInfo, pageSize) {
+		return full()
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Size() != manifest.OutputPages*int64(pageSize) {
+		return full()
+	}
+
+	// 解密后的文件需与本次的加密方式一致，切换加密方式或更换密码时全量解密
+	encryptPage, ok := outputEncryptor(outputPath, pageSize, outputCipher, outputKey)
+	if !ok {
+		return full()
+	}
+
+	start := time.Now()
+	changed, sums, err := writeJournal(ctx, dbInfo, pageSize, journalPath, manifest, wal, encryptPage, decryptPage)
+	if err != nil {
+		os.Remove(journalPath)
+		if err == errTooManyChanges {
+			return full()
+		}
+		return err
+	}
+	if sums == nil {
+		// 没有变化
+		os.Remove(journalPath)
+		return nil
+	}
+
+	if err := replayJournal(journalPath, outputPath, pageSize); err != nil {
+		// 日志已提交，保留日志，下次运行时重新写入
+		return err
+	}
+	manifest.Sums = sums
+	manifest.OutputPages = outputPages(len(sums), wal)
+	manifest.WAL, manifest.WALPages = WALState{}, nil
+	if wal != nil {
+		manifest.WAL, manifest.WALPages = wal.state, wal.overlay(len(sums))
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		return err
+	}
+	os.Remove(journalPath)
+
+	log.Debug().
+		Str("file", dbInfo.Path).
+		Int("changed", changed).
+		Int("pages", len(sums)).
+		Bool("wal", wal != nil).
+		Dur("elapsed", time.Since(start)).
+		Msg("database decrypted incrementally")
+	return nil
+}
+
+// outputPages 解密后文件的页数，WAL 中有已提交的内容时以最后一次提交后的数据库大小为准
+func outputPages(dbPages int, wal *walFile) int64 {
+	if wal != nil {
+		return int64(wal.dbSize)
+	}
+	return int64(dbPages)
+}
+
+var errTooManyChanges = fmt.Errorf("too many changed pages")
+
+// outputEncryptor 检查已有的解密后文件是否与本次的加密方式一致，返回加密页面的函数
+// 不加密时要求文件为明文，加密时要求文件使用相同的格式和密码加密，并沿用文件的 salt
+func outputEncryptor(outputPath string, pageSize int, outputCipher *Cipher, outputKey string) (PageEncryptFunc, bool) {
+	if outputCipher == nil {
+		f, err := os.Open(outputPath)
+		if err != nil {
+			return nil, false
+		}
+		defer f.Close()
+		header := make([]byte, len(SQLiteHeader))
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, false
+		}
+		return nil, string(header) == SQLiteHeader
+	}
+
+	cf, err := outputCipher.OpenFile(outputPath, outputKey, true)
+	if err != nil {
+		return nil, false
+	}
+	cf.Close()
+	return outputCipher.Encryptor(outputKey, cf.Salt()), true
+}
+
+// decryptFull 全量解密到临时文件并合并 WAL 后重命名，并重新生成页面指纹清单
+// outputCipher 不为空时使用新的随机 salt 重新加密
+func decryptFull(ctx context.Context, dbInfo *DBFile, pageSize int, outputPath string, wal *walFile, outputCipher *Cipher, outputKey string, decryptPage PageDecryptFunc) error {
+	var encryptPage PageEncryptFunc
+	if outputCipher != nil {
+		var err error
+		if encryptPage, err = outputCipher.NewEncryptor(outputKey); err != nil {
+			return err
+		}
+	}
+
+	manifestPath := outputPath + ManifestSuffix
+	os.Remove(manifestPath)
+
+	outputTemp := outputPath + ".tmp"
+	outputFile, err := os.Create(outputTemp)
+	if err != nil {
+		return errors.OpenFileFailed(outputTemp, err)
+	}
+	fail := func(err error) error {
+		outputFile.Close()
+		os.Remove(outputTemp)
+		return err
+	}
+
+	manifest := &Manifest{}
+	if err := decryptDBFile(ctx, dbInfo, pageSize, outputFile, decryptOptions{manifest: manifest, encryptPage: encryptPage}, decryptPage); err != nil {
+		return fail(err)
+	}
+	manifest.OutputPages = outputPages(len(manifest.Sums), wal)
+	if wal != nil {
+		err := wal.eachPage(pageSize, decryptPage, func(pageNum int64, page []byte) error {
+			if encryptPage != nil {
+				var err error
+				if page, err = encryptPage(page, pageNum); err != nil {
+					return err
+				}
+			}
+			_, err := outputFile.WriteAt(page, pageNum*int64(pageSize))
+			return err
+		})
+		if err != nil {
+			return fail(err)
+		}
+		if err := outputFile.Truncate(manifest.OutputPages * int64(pageSize)); err != nil {
+			return fail(errors.WriteOutputFailed(err))
+		}
+		manifest.WAL, manifest.WALPages = wal.state, wal.overlay(len(manifest.Sums))
+	}
+
+	if err := outputFile.Close(); err != nil {
+		os.Remove(outputTemp)
+		return errors.WriteOutputFailed(err)
+	}
+	if err := os.Rename(outputTemp, outputPath); err != nil {
+		os.Remove(outputTemp)
+		return errors.WriteOutputFailed(err)
+	}
+	return manifest.Save(manifestPath)
+}
+
+// writeJournal 比较页面指纹，将变化页面和 WAL 中的页面解密后写入日志文件并提交
+// 返回变化的页面数量和当前全部页面的指纹，数据库和 WAL 均没有变化时指纹为 nil，
+// encryptPage 不为空时页面重新加密后写入日志
+func writeJournal(ctx context.Context, dbInfo *DBFile, pageSize int, journalPath string, manifest *Manifest, wal *walFile, encryptPage PageEncryptFunc, decryptPage PageDecryptFunc) (int, []uint64, error) {
+	oldSums := manifest.Sums
+	var walState WALState
+	if wal != nil {
+		walState = wal.state
+	}
+
+	// WAL 变化后，之前被 WAL 覆盖或截断的页面需要从数据库重新解密
+	stale := make(map[int64]bool)
+	if walState != manifest.WAL {
+		for _, p := range manifest.WALPages {
+			stale[int64(p)] = true
+		}
+	}
+
+	dbFile, err := os.Open(dbInfo.Path)
+	if err != nil {
+		return 0, nil, errors.OpenFileFailed(dbInfo.Path, err)
+	}
+	defer dbFile.Close()
+
+	journal, err := os.Create(journalPath)
+	if err != nil {
+		return 0, nil, errors.OpenFileFailed(journalPath, err)
+	}
+	defer journal.Close()
+	w := bufio.NewWriter(journal)
+
+	header := binary.LittleEndian.AppendUint32([]byte(journalMagic), uint32(pageSize))
+	if _, err := w.Write(header); err != nil {
+		return 0, nil, errors.WriteOutputFailed(err)
+	}
+
+	r := bufio.NewReaderSize(dbFile, BatchPages*pageSize)
+	pageBuf := make([]byte, pageSize)
+	// 只处理打开时已存在的完整页面，之后追加的页面留到下次解密
+	fullPages := dbInfo.Size / int64(pageSize)
+	sums := make([]uint64, 0, fullPages)
+	changed := 0
+	for pageNum := int64(0); pageNum < fullPages; pageNum++ {
+		if pageNum%BatchPages == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, nil, errors.ErrDecryptOperationCanceled
+			}
+		}
+		if _, err := io.ReadFull(r, pageBuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// 文件在打开后被截断
+				break
+			}
+			return 0, nil, errors.ReadFileFailed(dbInfo.Path, err)
+		}
+
+		sum := PageSum(pageBuf)
+		sums = append(sums, sum)
+		if pageNum < int64(len(oldSums)) && oldSums[pageNum] == sum && !stale[pageNum] {
+			continue
+		}
+
+		changed++
+		if float64(changed) > float64(dbInfo.TotalPages)*maxChangedRatio {
+			return 0, nil, errTooManyChanges
+		}
+		page, err := decryptBatchPage(pageBuf, pageNum, decryptPage)
+		if err != nil {
+			return 0, nil, err
+		}
+		if encryptPage != nil {
+			if page, err = encryptOutputPage(page, pageNum, encryptPage); err != nil {
+				return 0, nil, err
+			}
+		}
+		w.Write(binary.LittleEndian.AppendUint64(nil, uint64(pageNum)))
+		if pageNum == 0 && encryptPage == nil {
+			// 第一页写入 SQLite 头，替换 salt
+			w.WriteString(SQLiteHeader)
+		}
+		if _, err := w.Write(page); err != nil {
+			return 0, nil, errors.WriteOutputFailed(err)
+		}
+	}
+
+	if changed == 0 && len(sums) == len(oldSums) && walState == manifest.WAL {
+		return 0, nil, nil
+	}
+
+	// WAL 中的页面比数据库中的新，写在后面以覆盖数据库中的页面
+	if wal != nil {
+		err := wal.eachPage(pageSize, decryptPage, func(pageNum int64, page []byte) error {
+			if encryptPage != nil {
+				var err error
+				if page, err = encryptPage(page, pageNum); err != nil {
+					return err
+				}
+			}
+			w.Write(binary.LittleEndian.AppendUint64(nil, uint64(pageNum)))
+			_, err := w.Write(page)
+			return err
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// 提交记录
+	commit := binary.LittleEndian.AppendUint64(nil, journalCommit)
+	commit = binary.LittleEndian.AppendUint64(commit, uint64(outputPages(len(sums), wal)))
+	if _, err := w.Write(commit); err != nil {
+		return 0, nil, errors.WriteOutputFailed(err)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, nil, errors.WriteOutputFailed(err)
+	}
+	if err := journal.Sync(); err != nil {
+		return 0, nil, errors.WriteOutputFailed(err)
+	}
+	return changed, sums, nil
+}
+
+// replayJournal 将已提交的日志中的页面写入解密后的文件并同步到磁盘，日志不存在或未提交时不做任何修改
+// 日志中为完整的页面，重复应用的结果相同，调用方在同步完成后才删除日志
+func replayJournal(journalPath string, outputPath string, pageSize int) error {
+	journal, err := os.Open(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.OpenFileFailed(journalPath, err)
+	}
+	defer journal.Close()
+
+	info, err := journal.Stat()
+	if err != nil {
+		return errors.StatFileFailed(journalPath, err)
+	}
+
+	// 日志结构：头部 | (页号, 页面)... | (提交标记, 总页数)
+	headerSize, entrySize, commitSize := int64(len(journalMagic)+4), int64(8+pageSize), int64(16)
+	if info.Size() < headerSize+commitSize || (info.Size()-headerSize-commitSize)%entrySize != 0 {
+		return nil
+	}
+	buf := make([]byte, max(headerSize, commitSize))
+	if _, err := journal.ReadAt(buf[:headerSize], 0); err != nil {
+		return errors.ReadFileFailed(journalPath, err)
+	}
+	if string(buf[:4]) != journalMagic || int(binary.LittleEndian.Uint32(buf[4:8])) != pageSize {
+		return nil
+	}
+	if _, err := journal.ReadAt(buf[:commitSize], info.Size()-commitSize); err != nil {
+		return errors.ReadFileFailed(journalPath, err)
+	}
+	if binary.LittleEndian.Uint64(buf[:8]) != journalCommit {
+		return nil
+	}
+	totalPages := int64(binary.LittleEndian.Uint64(buf[8:16]))
+
+	// 直接在解密后的文件中写入变化的页面，同步到磁盘前保留日志，中途退出时下次运行重新应用
+	output, err := os.OpenFile(outputPath, os.O_RDWR, 0)
+	if err != nil {
+		return errors.OpenFileFailed(outputPath, err)
+	}
+	defer output.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(journal, headerSize, info.Size()-headerSize-commitSize))
+	entry := make([]byte, entrySize)
+	for {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errors.ReadFileFailed(journalPath, err)
+		}
+		pageNum := int64(binary.LittleEndian.Uint64(entry[:8]))
+		if pageNum >= totalPages {
+			// 被 WAL 截断的页面
+			continue
+		}
+		if _, err := output.WriteAt(entry[8:], pageNum*int64(pageSize)); err != nil {
+			return errors.WriteOutputFailed(err)
+		}
+	}
+	if err := output.Truncate(totalPages * int64(pageSize)); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	if err := output.Sync(); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	if err := output.Close(); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	return nil
+}
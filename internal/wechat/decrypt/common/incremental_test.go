@@ -0,0 +1,169 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// decryptIncremental 增量解密到 output，并检查没有留下日志和临时文件
+func (db *testDB) decryptIncremental(t *testing.T, output string, outputKey string) {
+	t.Helper()
+	d := db.decryptor.(decrypt.IncrementalDecryptor)
+	if err := d.DecryptIncremental(context.Background(), db.path, db.key, output, outputKey); err != nil {
+		t.Fatalf("DecryptIncremental() error = %v", err)
+	}
+	for _, path := range []string{output + common.JournalSuffix, output + ".tmp"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists after DecryptIncremental()", path)
+		}
+	}
+	if _, err := common.LoadManifest(output + common.ManifestSuffix); err != nil {
+		t.Errorf("LoadManifest() error = %v", err)
+	}
+}
+
+// TestDecryptDBFileIncremental 数据库变化后增量解密的结果与明文数据库一致
+func TestDecryptDBFileIncremental(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, db *testDB)
+
+		// corruptManifest 截断上次生成的页面指纹清单
+		corruptManifest bool
+	}{
+		{name: "unchanged", change: func(t *testing.T, db *testDB) {}},
+		{name: "update", change: func(t *testing.T, db *testDB) {
+			db.exec(t, "UPDATE pad SET data = randomblob(1000) WHERE rowid % 50 = 0")
+		}},
+		{name: "grow", change: func(t *testing.T, db *testDB) {
+			db.pad(t, 200)
+		}},
+		{name: "shrink", change: func(t *testing.T, db *testDB) {
+			db.exec(t, "DELETE FROM pad WHERE rowid > 100", "VACUUM")
+		}},
+		// 变化的页面过多时全量解密
+		{name: "rewrite", change: func(t *testing.T, db *testDB) {
+			db.exec(t, "UPDATE pad SET data = randomblob(1000)")
+		}},
+		// 数据库重建后 salt 变化，清单不再匹配
+		{name: "new salt", change: func(t *testing.T, db *testDB) {
+			db.salt = bytes.Repeat([]byte{0xa5}, common.SaltSize)
+			db.encrypt(t)
+		}},
+		// 损坏的清单
+		{name: "corrupt manifest", change: func(t *testing.T, db *testDB) {
+			db.exec(t, "UPDATE pad SET data = randomblob(1000) WHERE rowid = 1")
+		}, corruptManifest: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, "windows", 4, 300)
+			output := db.path + ".out"
+			db.decryptIncremental(t, output, "")
+			db.checkFile(t, output)
+
+			if tt.corruptManifest {
+				manifest := output + common.ManifestSuffix
+				data, err := os.ReadFile(manifest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(manifest, data[:len(data)-3], 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.change(t, db)
+			db.decryptIncremental(t, output, "")
+			db.checkFile(t, output)
+		})
+	}
+}
+
+// writeTestJournal 写入增量解密日志，pages 为解密后的全部页面，commit 为 false 时不写提交记录
+func writeTestJournal(t *testing.T, path string, pageSize int, pages []byte, commit bool) {
+	t.Helper()
+	buf := binary.LittleEndian.AppendUint32([]byte("CLPJ"), uint32(pageSize))
+	for pageNum := 0; pageNum*pageSize < len(pages); pageNum++ {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(pageNum))
+		buf = append(buf, pages[pageNum*pageSize:(pageNum+1)*pageSize]...)
+	}
+	if commit {
+		buf = binary.LittleEndian.AppendUint64(buf, ^uint64(0))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(pages)/pageSize))
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDecryptDBFileIncrementalJournal 上次增量解密在写入日志后中断，下次运行时恢复
+func TestDecryptDBFileIncrementalJournal(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// commit 日志是否已提交
+		commit bool
+
+		// pageSize 日志记录的页面大小，为 0 时与数据库相同
+		pageSize int
+
+		// temp 是否留下了未完成的临时文件
+		temp bool
+
+		// partial 日志中的页面是否已部分写入解密后的文件
+		partial bool
+	}{
+		{name: "committed", commit: true},
+		{name: "committed with temp file", commit: true, temp: true},
+		{name: "committed and partially applied", commit: true, partial: true},
+		{name: "uncommitted", commit: false},
+		{name: "page size mismatch", commit: true, pageSize: 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, "windows", 4, 300)
+			output := db.path + ".out"
+			db.decryptIncremental(t, output, "")
+
+			// 数据库变化后，变化的页面已写入日志，但尚未写入解密后的文件
+			db.exec(t, "UPDATE pad SET data = randomblob(1000) WHERE rowid % 40 = 0")
+			var buf bytes.Buffer
+			if err := db.decryptor.Decrypt(context.Background(), db.path, db.key, &buf); err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			pageSize := db.decryptor.GetPageSize()
+			if tt.pageSize != 0 {
+				pageSize = tt.pageSize
+			}
+			writeTestJournal(t, output+common.JournalSuffix, pageSize, buf.Bytes(), tt.commit)
+			if tt.temp {
+				if err := os.WriteFile(output+".tmp", []byte("partial"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.partial {
+				f, err := os.OpenFile(output, os.O_RDWR, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = f.WriteAt(buf.Bytes()[pageSize:2*pageSize], int64(pageSize))
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db.decryptIncremental(t, output, "")
+			db.checkFile(t, output)
+		})
+	}
+}
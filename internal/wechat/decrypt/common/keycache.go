@@ -0,0 +1,42 @@
+package common
+
+import "sync"
+
+// maxCachedKeys 派生密钥缓存的最大数量，超出后清空重建
+const maxCachedKeys = 256
+
+// KeyCache 按密钥和 salt 缓存派生结果
+// PBKDF2 迭代次数较多，缓存可避免同一文件重复解密或多次验证时重复计算。
+// 缓存属于单个解密器或加密格式实例，不同实例之间不共享派生结果。零值可直接使用。
+type KeyCache struct {
+	keys map[string][2][]byte
+	mu   sync.Mutex
+}
+
+// Derive 返回 key 和 salt 对应的加密密钥和 MAC 密钥，未缓存时调用 deriveKeys 计算
+// c 为 nil 时不缓存
+func (c *KeyCache) Derive(key []byte, salt []byte, deriveKeys DeriveKeysFunc) ([]byte, []byte) {
+	if c == nil {
+		return deriveKeys(key, salt)
+	}
+
+	id := string(key) + "\x00" + string(salt)
+
+	c.mu.Lock()
+	keys, ok := c.keys[id]
+	c.mu.Unlock()
+	if ok {
+		return keys[0], keys[1]
+	}
+
+	encKey, macKey := deriveKeys(key, salt)
+
+	c.mu.Lock()
+	if c.keys == nil || len(c.keys) >= maxCachedKeys {
+		c.keys = make(map[string][2][]byte)
+	}
+	c.keys[id] = [2][]byte{encKey, macKey}
+	c.mu.Unlock()
+
+	return encKey, macKey
+}
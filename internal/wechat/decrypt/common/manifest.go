@@ -0,0 +1,150 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cespare/xxhash"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	// ManifestSuffix 页面指纹清单文件的后缀，与解密后的数据库文件放在一起
+	ManifestSuffix = ".pages"
+
+	manifestMagic   = "CLPM"
+	manifestVersion = 2
+
+	// manifestHeaderSize 清单头部大小：magic、版本、页面大小、salt、解密后页数、WAL 状态、页面数量
+	manifestHeaderSize = 4 + 4 + 4 + SaltSize + 8 + 8 + 4 + 8 + 8
+)
+
+// Manifest 记录加密数据库每个页面密文的指纹，用于增量解密时找出发生变化的页面
+type Manifest struct {
+	PageSize int
+	Salt     []byte
+
+	// OutputPages 解密后文件的页数，合并 WAL 后可能与数据库文件不同
+	OutputPages int64
+
+	// WAL 已合并到解密后文件的 WAL 内容
+	WAL WALState
+
+	Sums []uint64
+
+	// WALPages 解密后文件中内容来自 WAL 或被 WAL 截断的页面，WAL 变化时需从数据库重新解密
+	WALPages []uint32
+}
+
+// PageSum 计算页面密文的指纹
+func PageSum(page []byte) uint64 {
+	return xxhash.Sum64(page)
+}
+
+// Match 判断清单是否与数据库文件对应，数据库重建后 salt 会发生变化
+// 清单中的页面数量超过数据库当前页数时视为不匹配，改为全量解密
+func (m *Manifest) Match(dbInfo *DBFile, pageSize int) bool {
+	return m.PageSize == pageSize && bytes.Equal(m.Salt, dbInfo.Salt) && int64(len(m.Sums)) <= dbInfo.TotalPages
+}
+
+// LoadManifest 读取页面指纹清单
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.StatFileFailed(path, err)
+	}
+	r := bufio.NewReader(f)
+
+	header := make([]byte, manifestHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	if string(header[:4]) != manifestMagic || binary.LittleEndian.Uint32(header[4:8]) != manifestVersion {
+		return nil, errors.ReadFileFailed(path, fmt.Errorf("invalid manifest header"))
+	}
+	m := &Manifest{
+		PageSize: int(binary.LittleEndian.Uint32(header[8:12])),
+		Salt:     bytes.Clone(header[12 : 12+SaltSize]),
+	}
+	rest := header[12+SaltSize:]
+	m.OutputPages = int64(binary.LittleEndian.Uint64(rest[0:8]))
+	copy(m.WAL.Salt[:], rest[8:16])
+	m.WAL.Frames = binary.LittleEndian.Uint32(rest[16:20])
+	copy(m.WAL.Checksum[:], rest[20:28])
+	count := binary.LittleEndian.Uint64(rest[28:36])
+
+	// 页面数量需与文件大小一致，避免损坏的清单导致分配过大的内存
+	if info.Size() < manifestHeaderSize+8 {
+		return nil, errors.ReadFileFailed(path, fmt.Errorf("invalid manifest size"))
+	}
+	remain := uint64(info.Size()-manifestHeaderSize) - 8
+	if count > remain/8 {
+		return nil, errors.ReadFileFailed(path, fmt.Errorf("invalid manifest page count"))
+	}
+	m.Sums = make([]uint64, count)
+	if err := binary.Read(r, binary.LittleEndian, m.Sums); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	var walCount uint64
+	if err := binary.Read(r, binary.LittleEndian, &walCount); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	if walCount > count || walCount*4 != remain-count*8 {
+		return nil, errors.ReadFileFailed(path, fmt.Errorf("invalid manifest wal pages"))
+	}
+	m.WALPages = make([]uint32, walCount)
+	if err := binary.Read(r, binary.LittleEndian, m.WALPages); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	return m, nil
+}
+
+// Save 保存页面指纹清单，先写入临时文件再重命名，避免留下不完整的清单
+func (m *Manifest) Save(path string) error {
+	temp := path + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return errors.OpenFileFailed(temp, err)
+	}
+	w := bufio.NewWriter(f)
+
+	header := make([]byte, 0, manifestHeaderSize)
+	header = append(header, manifestMagic...)
+	header = binary.LittleEndian.AppendUint32(header, manifestVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(m.PageSize))
+	header = append(header, m.Salt...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(m.OutputPages))
+	header = append(header, m.WAL.Salt[:]...)
+	header = binary.LittleEndian.AppendUint32(header, m.WAL.Frames)
+	header = append(header, m.WAL.Checksum[:]...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(m.Sums)))
+	w.Write(header)
+	binary.Write(w, binary.LittleEndian, m.Sums)
+	binary.Write(w, binary.LittleEndian, uint64(len(m.WALPages)))
+	binary.Write(w, binary.LittleEndian, m.WALPages)
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(temp)
+		return errors.WriteOutputFailed(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(temp)
+		return errors.WriteOutputFailed(err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return errors.WriteOutputFailed(err)
+	}
+	return nil
+}
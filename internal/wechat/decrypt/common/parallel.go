@@ -0,0 +1,247 @@
+package common
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// BatchPages 每个解密任务包含的页面数量
+const BatchPages = 256
+
+// Workers 并行解密的协程数量，默认为 CPU 核数
+var Workers = runtime.NumCPU()
+
+// PageDecryptFunc 解密单个页面，pageNum 从 0 开始
+type PageDecryptFunc func(pageBuf []byte, pageNum int64) ([]byte, error)
+
+// pageBatch 一批连续的页面
+type pageBatch struct {
+	index     int64
+	firstPage int64
+	data      []byte
+	output    []byte
+	sums      []uint64
+	corrupt   []int64
+	err       error
+}
+
+// DecryptDBFile 并行解密数据库文件的全部页面，并按页面顺序写入 output
+// 各页面相互独立，由多个协程同时解密，写入顺序与串行解密一致
+func DecryptDBFile(ctx context.Context, dbInfo *DBFile, pageSize int, output io.Writer, decryptPage PageDecryptFunc) error {
+	return DecryptDBFileWithManifest(ctx, dbInfo, pageSize, output, nil, decryptPage)
+}
+
+// DecryptDBFileWithManifest 与 DecryptDBFile 相同，manifest 不为空时同时记录每个页面密文的指纹
+func DecryptDBFileWithManifest(ctx context.Context, dbInfo *DBFile, pageSize int, output io.Writer, manifest *Manifest, decryptPage PageDecryptFunc) error {
+	return decryptDBFile(ctx, dbInfo, pageSize, output, decryptOptions{manifest: manifest}, decryptPage)
+}
+
+// decryptOptions 并行解密的可选参数
+type decryptOptions struct {
+	// manifest 不为空时记录每个页面密文的指纹
+	manifest *Manifest
+
+	// report 不为空时，HMAC 校验失败的页面以全零页面代替并记录到 report 中，不中断解密
+	report *VerifyReport
+
+	// encryptPage 不为空时，解密后的页面重新加密后写入
+	encryptPage PageEncryptFunc
+}
+
+// decryptDBFile 并行解密数据库文件
+func decryptDBFile(ctx context.Context, dbInfo *DBFile, pageSize int, output io.Writer, opts decryptOptions, decryptPage PageDecryptFunc) error {
+	manifest, report := opts.manifest, opts.report
+	start := time.Now()
+	if manifest != nil {
+		manifest.PageSize = pageSize
+		manifest.Salt = dbInfo.Salt
+		manifest.Sums = manifest.Sums[:0]
+	}
+
+	dbFile, err := os.Open(dbInfo.Path)
+	if err != nil {
+		return errors.OpenFileFailed(dbInfo.Path, err)
+	}
+	defer dbFile.Close()
+
+	// 写入 SQLite 头，重新加密时第一页以 salt 开头
+	if opts.encryptPage == nil {
+		if _, err := output.Write([]byte(SQLiteHeader)); err != nil {
+			return errors.WriteOutputFailed(err)
+		}
+	}
+
+	workers := max(Workers, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// tokens 限制未写入的批次数量，避免读取速度快于写入时占用过多内存
+	tokens := make(chan struct{}, workers*2)
+	jobs := make(chan *pageBatch, workers)
+	results := make(chan *pageBatch, workers)
+
+	// 按顺序读取打开文件时的完整页面，解密期间文件增长的部分和末尾不完整的页面不处理
+	fullPages := dbInfo.Size / int64(pageSize)
+	go func() {
+		defer close(jobs)
+		for index := int64(0); index*BatchPages < fullPages; index++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			b := &pageBatch{index: index, firstPage: index * BatchPages}
+			b.data = make([]byte, min(BatchPages, fullPages-b.firstPage)*int64(pageSize))
+			if _, err := io.ReadFull(dbFile, b.data); err != nil {
+				b.err = errors.ReadFileFailed(dbInfo.Path, err)
+			}
+			select {
+			case jobs <- b:
+			case <-ctx.Done():
+				return
+			}
+			if b.err != nil {
+				return
+			}
+		}
+	}()
+
+	// 解密页面
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				if b.err == nil {
+					b.output = make([]byte, 0, len(b.data))
+					for off := 0; off < len(b.data); off += pageSize {
+						if manifest != nil {
+							b.sums = append(b.sums, PageSum(b.data[off:off+pageSize]))
+						}
+						pageNum := b.firstPage + int64(off/pageSize)
+						page, err := decryptBatchPage(b.data[off:off+pageSize], pageNum, decryptPage)
+						if err == errors.ErrDecryptHashVerificationFailed && report != nil {
+							b.corrupt = append(b.corrupt, pageNum)
+							page = corruptPage(pageNum, pageSize)
+						} else if err != nil {
+							b.err = err
+							break
+						}
+						if opts.encryptPage != nil {
+							if page, err = encryptOutputPage(page, pageNum, opts.encryptPage); err != nil {
+								b.err = err
+								break
+							}
+						}
+						b.output = append(b.output, page...)
+					}
+				}
+				select {
+				case results <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// 按批次顺序写入
+	pending := make(map[int64]*pageBatch)
+	next := int64(0)
+	var pages, size int64
+	for {
+		var b *pageBatch
+		var ok bool
+		select {
+		case b, ok = <-results:
+		case <-ctx.Done():
+			return errors.ErrDecryptOperationCanceled
+		}
+		if !ok {
+			break
+		}
+		pending[b.index] = b
+		for b, ok := pending[next]; ok; b, ok = pending[next] {
+			if b.err != nil {
+				return b.err
+			}
+			if _, err := output.Write(b.output); err != nil {
+				return errors.WriteOutputFailed(err)
+			}
+			if manifest != nil {
+				manifest.Sums = append(manifest.Sums, b.sums...)
+			}
+			if report != nil {
+				report.addCorrupt(b.corrupt)
+			}
+			pages += int64(len(b.data) / pageSize)
+			size += int64(len(b.data))
+			delete(pending, next)
+			next++
+			<-tokens
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.ErrDecryptOperationCanceled
+	}
+
+	if report != nil {
+		report.Pages = pages
+		report.TrailingBytes = dbInfo.Size % int64(pageSize)
+		// 末尾不完整的页面同样以全零页面代替，保持解密后的页数与数据库头部记录一致
+		if report.TrailingBytes > 0 {
+			page := corruptPage(pages, pageSize)
+			if opts.encryptPage != nil {
+				if page, err = encryptOutputPage(page, pages, opts.encryptPage); err != nil {
+					return err
+				}
+			}
+			if _, err := output.Write(page); err != nil {
+				return errors.WriteOutputFailed(err)
+			}
+			report.addCorrupt([]int64{pages})
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Debug().
+		Str("file", dbInfo.Path).
+		Int64("pages", pages).
+		Int("workers", workers).
+		Dur("elapsed", elapsed).
+		Float64("mb_per_sec", float64(size)/1024/1024/max(elapsed.Seconds(), 1e-6)).
+		Msg("database decrypted")
+
+	return nil
+}
+
+// decryptBatchPage 解密单个页面，全为零的页面原样写入
+func decryptBatchPage(pageBuf []byte, pageNum int64, decryptPage PageDecryptFunc) ([]byte, error) {
+	for _, b := range pageBuf {
+		if b != 0 {
+			return decryptPage(pageBuf, pageNum)
+		}
+	}
+	return pageBuf, nil
+}
+
+// encryptOutputPage 重新加密解密后的页面，解密后的第一页不包含 SQLite 头，加密前补全
+func encryptOutputPage(page []byte, pageNum int64, encryptPage PageEncryptFunc) ([]byte, error) {
+	if pageNum == 0 {
+		page = append([]byte(SQLiteHeader), page...)
+	}
+	return encryptPage(page, pageNum)
+}
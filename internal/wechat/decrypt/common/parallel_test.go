@@ -0,0 +1,77 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// TestDecryptDBFile 并行解密的结果按页面顺序写入，与明文数据库一致
+func TestDecryptDBFile(t *testing.T) {
+	tests := []struct {
+		platform string
+		version  int
+		padRows  int
+		workers  int
+		trailing int
+
+		// batches 为 true 时数据库页面超过一个批次
+		batches bool
+	}{
+		{platform: "windows", version: 3, workers: 4},
+		{platform: "windows", version: 4, workers: 4},
+		{platform: "darwin", version: 4, workers: 4},
+		// 超过一个批次的页面
+		{platform: "windows", version: 4, padRows: 1500, workers: 1, batches: true},
+		{platform: "windows", version: 4, padRows: 1500, workers: 8, batches: true},
+		{platform: "darwin", version: 3, padRows: 700, workers: 8, batches: true},
+		// 末尾不完整的页面不解密
+		{platform: "windows", version: 4, padRows: 100, workers: 4, trailing: 100},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s_v%d_rows%d_workers%d_trailing%d", tt.platform, tt.version, tt.padRows, tt.workers, tt.trailing)
+		t.Run(name, func(t *testing.T) {
+			workers := common.Workers
+			common.Workers = tt.workers
+			defer func() { common.Workers = workers }()
+
+			db := newTestDB(t, tt.platform, tt.version, tt.padRows)
+			if tt.batches && db.pages(t) <= common.BatchPages {
+				t.Fatalf("database has %d pages, want more than %d", db.pages(t), common.BatchPages)
+			}
+			if tt.trailing > 0 {
+				f, err := os.OpenFile(db.path, os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write(bytes.Repeat([]byte{1}, tt.trailing))
+				f.Close()
+			}
+
+			var buf bytes.Buffer
+			if err := db.decryptor.Decrypt(context.Background(), db.path, db.key, &buf); err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			db.check(t, buf.Bytes())
+		})
+	}
+}
+
+// TestDecryptDBFileIncorrectKey 密钥错误时不输出任何内容
+func TestDecryptDBFileIncorrectKey(t *testing.T) {
+	db := newTestDB(t, "windows", 4, 0)
+	key := bytes.Repeat([]byte("00"), common.KeySize)
+
+	var buf bytes.Buffer
+	if err := db.decryptor.Decrypt(context.Background(), db.path, string(key), &buf); err == nil {
+		t.Fatal("Decrypt() with incorrect key error = nil")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Decrypt() with incorrect key wrote %d bytes", buf.Len())
+	}
+}
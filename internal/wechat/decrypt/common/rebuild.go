@@ -0,0 +1,248 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/pagevfs"
+)
+
+// sqliteFcntlReserveBytes SQLITE_FCNTL_RESERVE_BYTES，设置 VACUUM 后每页的保留字节数
+const sqliteFcntlReserveBytes = 38
+
+// rebuildDBFile 将页面布局与 SQLCipher 4 不同的数据库重建为 SQLCipher 4 格式写入 outputPath
+// 保留字节数不同的页面无法逐页重新加密，通过 pagevfs 让 SQLite 读取解密后的页面，
+// VACUUM INTO 到使用 outputKey 加密的临时文件后重命名，明文只在内存中逐页处理。
+// 根据页面指纹清单判断数据库和 WAL 是否变化，没有变化时不重建。
+func rebuildDBFile(ctx context.Context, dbInfo *DBFile, pageSize int, outputPath string, c *Cipher, outputKey string, wal *walFile, decryptPage PageDecryptFunc) error {
+	manifestPath := outputPath + ManifestSuffix
+	sums, err := pageSums(ctx, dbInfo, pageSize)
+	if err != nil {
+		return err
+	}
+	var walState WALState
+	if wal != nil {
+		walState = wal.state
+	}
+
+	// 已有的输出文件可以使用 outputKey 打开时，重建后沿用它的 salt
+	var salt []byte
+	if cf, err := c.OpenFile(outputPath, outputKey, true); err == nil {
+		salt = cf.Salt()
+		cf.Close()
+	}
+	manifest, err := LoadManifest(manifestPath)
+	if err == nil && salt != nil && manifest.Match(dbInfo, pageSize) && manifest.WAL == walState && slices.Equal(manifest.Sums, sums) {
+		return nil
+	}
+	os.Remove(manifestPath)
+
+	start := time.Now()
+	pages := outputPages(len(sums), wal)
+	outputTemp := outputPath + ".tmp"
+	os.Remove(outputTemp)
+	err = rebuild(ctx, dbInfo.Path, func(readOnly bool) (pagevfs.File, error) {
+		return openPlainView(dbInfo.Path, pageSize, pages, wal, decryptPage)
+	}, outputTemp, c, outputKey, salt)
+	if err != nil {
+		os.Remove(outputTemp)
+		return err
+	}
+	info, err := os.Stat(outputTemp)
+	if err != nil {
+		os.Remove(outputTemp)
+		return errors.StatFileFailed(outputTemp, err)
+	}
+	if err := os.Rename(outputTemp, outputPath); err != nil {
+		os.Remove(outputTemp)
+		return errors.WriteOutputFailed(err)
+	}
+
+	manifest = &Manifest{
+		PageSize:    pageSize,
+		Salt:        dbInfo.Salt,
+		OutputPages: info.Size() / int64(c.PageSize),
+		WAL:         walState,
+		Sums:        sums,
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		return err
+	}
+
+	log.Debug().
+		Str("file", dbInfo.Path).
+		Int64("pages", pages).
+		Dur("elapsed", time.Since(start)).
+		Msg("database rebuilt in sqlcipher format")
+	return nil
+}
+
+// rebuild 使用 SQLite 读取 open 打开的数据库，VACUUM INTO 到使用 key 和 salt 加密的 output，salt 为空时随机生成
+// 新数据库的页面大小和每页保留字节数与 c 一致，临时数据保存在内存中，不会写出明文临时文件
+func rebuild(ctx context.Context, path string, open pagevfs.OpenFunc, output string, c *Cipher, key string, salt []byte) error {
+	srcID, err := pagevfs.Register(open)
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	defer pagevfs.Unregister(srcID)
+	outputID, err := pagevfs.Register(func(readOnly bool) (pagevfs.File, error) {
+		return c.openFile(output, key, readOnly, salt)
+	})
+	if err != nil {
+		return errors.DBConnectFailed(output, err)
+	}
+	defer pagevfs.Unregister(outputID)
+
+	db, err := sql.Open("sqlite3", pagevfs.URI(path, srcID)+"&mode=ro&immutable=1")
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	defer conn.Close()
+
+	// PRAGMA page_size 会重置保留字节数，需在设置保留字节数之前执行
+	queries := []string{
+		"PRAGMA temp_store = MEMORY",
+		fmt.Sprintf("PRAGMA page_size = %d", c.PageSize),
+	}
+	for _, query := range queries {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return errors.QueryFailed(query, err)
+		}
+	}
+	err = conn.Raw(func(dc any) error {
+		return dc.(*sqlite3.SQLiteConn).SetFileControlInt("main", sqliteFcntlReserveBytes, c.Reserve)
+	})
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM INTO ?", pagevfs.URI(output, outputID)); err != nil {
+		return errors.QueryFailed("VACUUM INTO", err)
+	}
+	return nil
+}
+
+// pageSums 计算打开时数据库文件中每个完整页面密文的指纹
+func pageSums(ctx context.Context, dbInfo *DBFile, pageSize int) ([]uint64, error) {
+	f, err := os.Open(dbInfo.Path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(dbInfo.Path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, BatchPages*pageSize)
+	pageBuf := make([]byte, pageSize)
+	fullPages := dbInfo.Size / int64(pageSize)
+	sums := make([]uint64, 0, fullPages)
+	for pageNum := int64(0); pageNum < fullPages; pageNum++ {
+		if pageNum%BatchPages == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, errors.ErrDecryptOperationCanceled
+			}
+		}
+		if _, err := io.ReadFull(r, pageBuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, errors.ReadFileFailed(dbInfo.Path, err)
+		}
+		sums = append(sums, PageSum(pageBuf))
+	}
+	return sums, nil
+}
+
+// plainView 以解密后的页面呈现微信数据库，WAL 中已提交的页面覆盖数据库中的页面，实现只读的 pagevfs.File
+type plainView struct {
+	db          *os.File
+	wal         *os.File
+	walPages    map[uint32]int64
+	pageSize    int
+	pages       int64
+	raw         []byte
+	decryptPage PageDecryptFunc
+}
+
+func openPlainView(path string, pageSize int, pages int64, wal *walFile, decryptPage PageDecryptFunc) (*plainView, error) {
+	db, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	v := &plainView{
+		db:          db,
+		pageSize:    pageSize,
+		pages:       pages,
+		raw:         make([]byte, pageSize),
+		decryptPage: decryptPage,
+	}
+	if wal != nil {
+		if v.wal, err = os.Open(wal.path); err != nil {
+			db.Close()
+			return nil, errors.OpenFileFailed(wal.path, err)
+		}
+		v.walPages = wal.pages
+	}
+	return v, nil
+}
+
+func (v *plainView) PageSize() int {
+	return v.pageSize
+}
+
+// ReadPage 读取并解密页面，页面在 WAL 中有已提交的版本时读取 WAL
+func (v *plainView) ReadPage(pageNum int64, page []byte) error {
+	f, offset := v.db, pageNum*int64(v.pageSize)
+	if off, ok := v.walPages[uint32(pageNum+1)]; ok {
+		f, offset = v.wal, off
+	}
+	if _, err := f.ReadAt(v.raw, offset); err != nil {
+		return errors.ReadFileFailed(f.Name(), err)
+	}
+	plain, err := decryptBatchPage(v.raw, pageNum, v.decryptPage)
+	if err != nil {
+		return err
+	}
+	if pageNum == 0 {
+		copy(page, SQLiteHeader)
+		copy(page[SaltSize:], plain)
+		return nil
+	}
+	copy(page, plain)
+	return nil
+}
+
+func (v *plainView) WritePage(pageNum int64, page []byte) error {
+	return errors.WriteOutputFailed(os.ErrPermission)
+}
+
+func (v *plainView) Size() (int64, error) {
+	return v.pages * int64(v.pageSize), nil
+}
+
+func (v *plainView) Truncate(size int64) error {
+	return errors.WriteOutputFailed(os.ErrPermission)
+}
+
+func (v *plainView) Sync() error {
+	return nil
+}
+
+func (v *plainView) Close() error {
+	if v.wal != nil {
+		v.wal.Close()
+	}
+	return v.db.Close()
+}
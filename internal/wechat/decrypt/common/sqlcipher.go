@@ -0,0 +1,132 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	// cipherPageSize SQLCipher 4 默认的页面大小
+	cipherPageSize = 4096
+
+	// cipherReserve SQLCipher 4 每页的保留字节数：IV 和 HMAC-SHA512
+	cipherReserve = IVSize + sha512.Size
+
+	// cipherIterCount SQLCipher 4 默认的 PBKDF2 迭代次数
+	cipherIterCount = 256000
+)
+
+// Cipher SQLCipher 兼容的页面加密格式，用于将解密后的数据库重新加密后保存到工作目录
+// 页面结构与微信数据库相同：第一页以 salt 开头，每页末尾依次为 IV 和 HMAC
+type Cipher struct {
+	Name      string
+	PageSize  int
+	Reserve   int
+	IterCount int
+	HMACSize  int
+	HashFunc  func() hash.Hash
+
+	// Keys 派生密钥缓存，为 nil 时不缓存
+	Keys *KeyCache
+}
+
+// NewCipher 返回 SQLCipher 4 默认格式：页面大小 4096，每页保留 80 字节，PBKDF2-HMAC-SHA512 迭代 256000 次
+// 工作目录中的数据库均使用此格式，sqlcipher 只需 PRAGMA key 即可打开
+func NewCipher() *Cipher {
+	return &Cipher{
+		Name:      "sqlcipher4",
+		PageSize:  cipherPageSize,
+		Reserve:   cipherReserve,
+		IterCount: cipherIterCount,
+		HMACSize:  sha512.Size,
+		HashFunc:  sha512.New,
+		Keys:      &KeyCache{},
+	}
+}
+
+// Compatible 判断页面大小和保留字节数为 pageSize、reserve 的数据库能否逐页重新加密
+// 微信 4.x 数据库的页面结构与 SQLCipher 4 相同，3.x 数据库每页只保留 48 字节，需要重建数据库
+func (c *Cipher) Compatible(pageSize, reserve int) bool {
+	return pageSize == c.PageSize && reserve == c.Reserve
+}
+
+// DeriveKeys 使用 PBKDF2 从密码派生加密密钥和 MAC 密钥，与 SQLCipher 的 PRAGMA key 相同
+func (c *Cipher) DeriveKeys(passphrase []byte, salt []byte) ([]byte, []byte) {
+	return c.Keys.Derive(passphrase, salt, func(key, salt []byte) ([]byte, []byte) {
+		encKey := pbkdf2.Key(key, salt, c.IterCount, KeySize, c.HashFunc)
+		macKey := pbkdf2.Key(encKey, XorBytes(salt, 0x3a), 2, KeySize, c.HashFunc)
+		return encKey, macKey
+	})
+}
+
+// PageEncryptFunc 重新加密解密后的页面，pageNum 从 0 开始，第一页包含 SQLite 头
+type PageEncryptFunc func(page []byte, pageNum int64) ([]byte, error)
+
+// Encryptor 返回使用 passphrase 和 salt 加密页面的函数
+func (c *Cipher) Encryptor(passphrase string, salt []byte) PageEncryptFunc {
+	encKey, macKey := c.DeriveKeys([]byte(passphrase), salt)
+	return func(page []byte, pageNum int64) ([]byte, error) {
+		return c.EncryptPage(page, pageNum, salt, encKey, macKey)
+	}
+}
+
+// NewEncryptor 生成随机 salt，返回使用 passphrase 加密页面的函数
+func (c *Cipher) NewEncryptor(passphrase string) (PageEncryptFunc, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	return c.Encryptor(passphrase, salt), nil
+}
+
+// EncryptPage 加密单个页面，是 DecryptPage 的逆过程
+func (c *Cipher) EncryptPage(page []byte, pageNum int64, salt, encKey, macKey []byte) ([]byte, error) {
+	offset := 0
+	out := make([]byte, c.PageSize)
+	if pageNum == 0 {
+		// 第一页的 SQLite 头替换为 salt
+		offset = SaltSize
+		copy(out, salt)
+	}
+
+	dataEnd := c.PageSize - c.Reserve
+	iv := out[dataEnd : dataEnd+IVSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[offset:dataEnd], page[offset:dataEnd])
+
+	mac := hmac.New(c.HashFunc, macKey)
+	mac.Write(out[offset : dataEnd+IVSize])
+	mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(pageNum+1)))
+	copy(out[dataEnd+IVSize:], mac.Sum(nil))
+	return out, nil
+}
+
+// openPage 使用第一页验证密码，返回文件的 salt
+func (c *Cipher) openPage(page1 []byte, passphrase string) ([]byte, error) {
+	if bytes.HasPrefix(page1, []byte(SQLiteHeader)) {
+		return nil, errors.ErrWorkKeyIncorrect
+	}
+
+	salt := bytes.Clone(page1[:SaltSize])
+	_, macKey := c.DeriveKeys([]byte(passphrase), salt)
+	if !ValidateMAC(page1, macKey, c.HashFunc, c.HMACSize, c.Reserve, c.PageSize) {
+		return nil, errors.ErrWorkKeyIncorrect
+	}
+	return salt, nil
+}
@@ -0,0 +1,186 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/pkg/pagevfs"
+)
+
+const testWorkKey = "test-work-key"
+
+// checkCipherFile 使用 outputKey 打开重新加密后的文件，与明文数据库比较
+// 页面布局与 SQLCipher 4 相同的数据库逐页比较，重建的数据库比较各表的内容
+func (db *testDB) checkCipherFile(t *testing.T, path string, outputKey string) {
+	t.Helper()
+	data := readFile(t, path)
+	if bytes.HasPrefix(data, []byte(common.SQLiteHeader)) {
+		t.Fatalf("%s is not encrypted", path)
+	}
+	c := common.NewCipher()
+	if c.Compatible(db.decryptor.GetPageSize(), db.decryptor.GetReserve()) {
+		cf, err := c.OpenFile(path, outputKey, true)
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		defer cf.Close()
+		plain := make([]byte, len(data))
+		for off := 0; off < len(data); off += c.PageSize {
+			if err := cf.ReadPage(int64(off/c.PageSize), plain[off:off+c.PageSize]); err != nil {
+				t.Fatalf("ReadPage() error = %v", err)
+			}
+		}
+		db.check(t, plain)
+		return
+	}
+
+	id, err := pagevfs.Register(func(readOnly bool) (pagevfs.File, error) {
+		return c.OpenFile(path, outputKey, readOnly)
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	defer pagevfs.Unregister(id)
+	got := dumpTables(t, pagevfs.URI(path, id)+"&mode=ro&immutable=1")
+	want := dumpTables(t, "file:"+db.plain+"?mode=ro")
+	if !slices.Equal(got, want) {
+		t.Fatalf("tables differ from plain database: got %d rows, want %d rows", len(got), len(want))
+	}
+}
+
+// dumpTables 按表名和 rowid 顺序返回数据库中所有表的内容
+func dumpTables(t *testing.T, dsn string) []string {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var tables []string
+	rows, err := conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	var result []string
+	for _, table := range tables {
+		rows, err := conn.Query(fmt.Sprintf("SELECT quote(rowid), * FROM %q ORDER BY rowid", table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, _ := rows.Columns()
+		for rows.Next() {
+			values := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, fmt.Sprintf("%s %v", table, values))
+		}
+		rows.Close()
+	}
+	return result
+}
+
+// TestCipherRoundTrip 解密后按 SQLCipher 4 格式重新加密的文件可以使用工作目录密码还原
+func TestCipherRoundTrip(t *testing.T) {
+	tests := []struct {
+		platform string
+		version  int
+	}{
+		{platform: "windows", version: 3},
+		{platform: "windows", version: 4},
+		{platform: "darwin", version: 3},
+		{platform: "darwin", version: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s_v%d", tt.platform, tt.version), func(t *testing.T) {
+			db := newTestDB(t, tt.platform, tt.version, 200)
+			output := db.path + ".out"
+
+			// 全量解密后重新加密
+			db.decryptIncremental(t, output, testWorkKey)
+			db.checkCipherFile(t, output, testWorkKey)
+			salt := readFile(t, output)[:common.SaltSize]
+
+			// 增量更新沿用已有文件的 salt
+			db.exec(t, "UPDATE pad SET data = randomblob(1000) WHERE rowid % 20 = 0")
+			db.pad(t, 20)
+			db.decryptIncremental(t, output, testWorkKey)
+			db.checkCipherFile(t, output, testWorkKey)
+			if !bytes.Equal(readFile(t, output)[:common.SaltSize], salt) {
+				t.Error("incremental update changed the salt of the encrypted output")
+			}
+
+			if _, err := common.NewCipher().OpenFile(output, "wrong-key", true); err != errors.ErrWorkKeyIncorrect {
+				t.Errorf("OpenFile() with wrong key error = %v, want %v", err, errors.ErrWorkKeyIncorrect)
+			}
+
+			// 校验时逐页重新加密，需要重建的 3.x 数据库返回错误
+			verifyOutput := db.path + ".verify"
+			f, err := os.Create(verifyOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.decryptor.(decrypt.VerifyDecryptor).Verify(context.Background(), db.path, db.key, f, testWorkKey)
+			f.Close()
+			if tt.version == 3 {
+				if err == nil {
+					t.Error("Verify() with work key on 3.x database succeeded, want layout error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			db.checkCipherFile(t, verifyOutput, testWorkKey)
+		})
+	}
+}
+
+// TestCipherSwitchKey 切换工作目录密码或改为明文保存时重新生成解密后的文件
+func TestCipherSwitchKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, next string
+	}{
+		{name: "plain to encrypted", first: "", next: testWorkKey},
+		{name: "encrypted to plain", first: testWorkKey, next: ""},
+		{name: "change key", first: testWorkKey, next: "another-work-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, "windows", 4, 100)
+			output := db.path + ".out"
+			db.decryptIncremental(t, output, tt.first)
+			db.decryptIncremental(t, output, tt.next)
+			if tt.next == "" {
+				db.checkFile(t, output)
+				return
+			}
+			db.checkCipherFile(t, output, tt.next)
+		})
+	}
+}
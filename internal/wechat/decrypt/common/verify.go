@@ -0,0 +1,129 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// PageRange 连续的页面范围，页号从 1 开始，与 SQLite 的页号一致
+type PageRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+func (r PageRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// VerifyReport 数据库文件的完整性校验结果
+type VerifyReport struct {
+	Path   string `json:"path"`
+	Output string `json:"output"`
+
+	// Pages 数据库文件的完整页面数量
+	Pages int64 `json:"pages"`
+
+	// TrailingBytes 末尾不完整页面的字节数，不为零时文件可能被截断，该页面记为损坏页面
+	TrailingBytes int64 `json:"trailingBytes"`
+
+	// Corrupt HMAC 校验失败的页面，解密后的文件中以全零页面代替
+	Corrupt      []PageRange `json:"corrupt"`
+	CorruptPages int64       `json:"corruptPages"`
+
+	// IntegrityCheck 解密后的文件执行 PRAGMA integrity_check 的结果
+	IntegrityCheck []string `json:"integrityCheck"`
+
+	// Error 无法解密时的错误信息
+	Error string `json:"error,omitempty"`
+}
+
+// OK 判断数据库文件是否完整
+func (r *VerifyReport) OK() bool {
+	return r.Error == "" && r.CorruptPages == 0 && r.TrailingBytes == 0 &&
+		len(r.IntegrityCheck) == 1 && r.IntegrityCheck[0] == "ok"
+}
+
+// addCorrupt 按页面顺序记录 HMAC 校验失败的页面，pageNum 从 0 开始
+func (r *VerifyReport) addCorrupt(pageNums []int64) {
+	for _, pageNum := range pageNums {
+		pgno := pageNum + 1
+		r.CorruptPages++
+		if n := len(r.Corrupt); n > 0 && r.Corrupt[n-1].End+1 == pgno {
+			r.Corrupt[n-1].End = pgno
+			continue
+		}
+		r.Corrupt = append(r.Corrupt, PageRange{Start: pgno, End: pgno})
+	}
+}
+
+func (r *VerifyReport) String() string {
+	buf := strings.Builder{}
+	status := "ok"
+	if !r.OK() {
+		status = "corrupt"
+	}
+	buf.WriteString(fmt.Sprintf("%s: %s\n", r.Path, status))
+	if r.Error != "" {
+		buf.WriteString(fmt.Sprintf("  error: %s\n", r.Error))
+		return buf.String()
+	}
+	buf.WriteString(fmt.Sprintf("  pages: %d, corrupt pages: %d\n", r.Pages, r.CorruptPages))
+	if len(r.Corrupt) > 0 {
+		ranges := make([]string, 0, len(r.Corrupt))
+		for _, c := range r.Corrupt {
+			ranges = append(ranges, c.String())
+		}
+		buf.WriteString(fmt.Sprintf("  corrupt ranges: %s\n", strings.Join(ranges, ", ")))
+	}
+	if r.TrailingBytes > 0 {
+		buf.WriteString(fmt.Sprintf("  truncated: last page has only %d bytes\n", r.TrailingBytes))
+	}
+	if len(r.IntegrityCheck) > 0 {
+		buf.WriteString("  integrity check:\n")
+		for _, line := range r.IntegrityCheck {
+			for _, l := range strings.Split(line, "\n") {
+				buf.WriteString(fmt.Sprintf("    %s\n", l))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// VerifyDBFile 校验并解密数据库文件的全部页面
+// 与 DecryptDBFile 不同，HMAC 校验失败的页面不会中断解密，而是写入全零页面并记录到校验结果中，
+// 用于从同步不完整或被截断的数据库文件中尽可能恢复数据
+// outputKey 不为空时解密后的页面按 outputCipher 的 SQLCipher 4 格式重新加密后写入，
+// 页面大小和保留字节数与 SQLCipher 4 不同的数据库需要重建，无法逐页写入，返回错误
+func VerifyDBFile(ctx context.Context, dbInfo *DBFile, pageSize int, reserve int, output io.Writer, outputCipher *Cipher, outputKey string, decryptPage PageDecryptFunc) (*VerifyReport, error) {
+	var encryptPage PageEncryptFunc
+	if outputKey != "" {
+		if !outputCipher.Compatible(pageSize, reserve) {
+			return nil, errors.SQLCipherLayoutUnsupported(pageSize, reserve)
+		}
+		var err error
+		if encryptPage, err = outputCipher.NewEncryptor(outputKey); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &VerifyReport{Path: dbInfo.Path, Corrupt: make([]PageRange, 0)}
+	if err := decryptDBFile(ctx, dbInfo, pageSize, output, decryptOptions{report: report, encryptPage: encryptPage}, decryptPage); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// corruptPage 损坏页面的占位内容，第一页的 SQLite 头已单独写入
+func corruptPage(pageNum int64, pageSize int) []byte {
+	if pageNum == 0 {
+		return make([]byte, pageSize-SaltSize)
+	}
+	return make([]byte, pageSize)
+}
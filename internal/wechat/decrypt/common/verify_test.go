@@ -0,0 +1,101 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// TestVerifyDBFile 校验失败的页面以全零页面代替并记录到校验结果中，其他页面正常解密
+func TestVerifyDBFile(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// corrupt 损坏的页面，页号从 0 开始；truncate 从文件末尾截掉的字节数
+		corrupt  []int
+		truncate int
+		wrongKey bool
+
+		// trailing 为 true 时末尾不完整的页面同样记为损坏页面
+		want     []common.PageRange
+		trailing bool
+		wantErr  bool
+	}{
+		{name: "intact", want: []common.PageRange{}},
+		{name: "single page", corrupt: []int{5}, want: []common.PageRange{{Start: 6, End: 6}}},
+		{name: "ranges", corrupt: []int{5, 6, 7, 20}, want: []common.PageRange{{Start: 6, End: 8}, {Start: 21, End: 21}}},
+		{name: "truncated", corrupt: []int{3}, truncate: 3000, want: []common.PageRange{{Start: 4, End: 4}}, trailing: true},
+		// 第一页损坏时使用其他页面验证密钥
+		{name: "first page", corrupt: []int{0, 1}, want: []common.PageRange{{Start: 1, End: 2}}},
+		{name: "first page with wrong key", corrupt: []int{0}, wrongKey: true, wantErr: true},
+		{name: "wrong key", wrongKey: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, "windows", 4, 100)
+			pageSize := db.decryptor.GetPageSize()
+			want := tt.want
+			if last := int64(db.pages(t)); tt.trailing {
+				want = append(want, common.PageRange{Start: last, End: last})
+			}
+
+			data := readFile(t, db.path)
+			for _, pageNum := range tt.corrupt {
+				data[pageNum*pageSize+100] ^= 0xff
+			}
+			data = data[:len(data)-tt.truncate]
+			if err := os.WriteFile(db.path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			key := db.key
+			if tt.wrongKey {
+				key = strings.Repeat("00", common.KeySize)
+			}
+			var buf bytes.Buffer
+			report, err := db.decryptor.(decrypt.VerifyDecryptor).Verify(context.Background(), db.path, key, &buf, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Verify() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if !reflect.DeepEqual(report.Corrupt, want) {
+				t.Errorf("Verify() corrupt = %v, want %v", report.Corrupt, want)
+			}
+			if (report.TrailingBytes != 0) != tt.trailing {
+				t.Errorf("Verify() trailing bytes = %d, want trailing %v", report.TrailingBytes, tt.trailing)
+			}
+
+			// 损坏的页面为全零，其余页面与明文数据库一致
+			got := buf.Bytes()
+			plain := readFile(t, db.plain)
+			zero := make([]byte, pageSize)
+			for _, r := range want {
+				for pgno := r.Start; pgno <= r.End; pgno++ {
+					off := int(pgno-1) * pageSize
+					// 第一页保留 SQLite 头
+					start := off
+					if pgno == 1 {
+						start += common.SaltSize
+					}
+					if !bytes.Equal(got[start:off+pageSize], zero[start-off:]) {
+						t.Errorf("corrupt page %d is not zeroed", pgno)
+					}
+					copy(got[off:off+pageSize], plain[off:off+pageSize])
+				}
+			}
+			db.check(t, got)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	// WALSuffix WAL 文件的后缀
+	WALSuffix = "-wal"
+
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+)
+
+// WALState 标识 WAL 文件中已提交的内容，用于判断 WAL 是否发生变化
+type WALState struct {
+	Salt     [8]byte
+	Frames   uint32
+	Checksum [8]byte
+}
+
+// walFile 加密数据库 WAL 文件中已提交的有效帧
+// WAL 文件头和帧头为明文，帧中的页面与主数据库使用相同的方式加密
+type walFile struct {
+	path  string
+	state WALState
+
+	// dbSize 最后一次提交后数据库的总页数
+	dbSize uint32
+
+	// pages 每个页面（页号从 1 开始）最新一帧的页面数据在文件中的偏移
+	pages map[uint32]int64
+}
+
+// readWAL 读取 WAL 文件，校验帧的 salt 和校验和，仅保留最后一次提交及之前的帧
+// 文件不存在、为空或与数据库页面大小不一致时返回 nil
+func readWAL(path string, pageSize int) (*walFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, nil
+	}
+	magic := binary.BigEndian.Uint32(header[0:4])
+	if magic != walMagicLE && magic != walMagicBE {
+		return nil, nil
+	}
+	if int(binary.BigEndian.Uint32(header[8:12])) != pageSize {
+		return nil, nil
+	}
+	bigEndian := magic == walMagicBE
+	s1, s2 := walChecksum(bigEndian, header[:24], 0, 0)
+	if s1 != binary.BigEndian.Uint32(header[24:28]) || s2 != binary.BigEndian.Uint32(header[28:32]) {
+		return nil, nil
+	}
+
+	wal := &walFile{
+		path:  path,
+		pages: make(map[uint32]int64),
+	}
+	copy(wal.state.Salt[:], header[16:24])
+
+	// 未提交的帧，遇到提交帧时合并
+	pending := make(map[uint32]int64)
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for offset := int64(walHeaderSize); ; offset += int64(len(frame)) {
+		if _, err := f.ReadAt(frame, offset); err != nil {
+			break
+		}
+		// 帧的 salt 与文件头不同时为上一轮写入的旧数据
+		if string(frame[8:16]) != string(header[16:24]) {
+			break
+		}
+		s1, s2 = walChecksum(bigEndian, frame[:8], s1, s2)
+		s1, s2 = walChecksum(bigEndian, frame[walFrameHeaderSize:], s1, s2)
+		if s1 != binary.BigEndian.Uint32(frame[16:20]) || s2 != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+
+		pgno := binary.BigEndian.Uint32(frame[0:4])
+		pending[pgno] = offset + walFrameHeaderSize
+		if dbSize := binary.BigEndian.Uint32(frame[4:8]); dbSize != 0 {
+			for p, o := range pending {
+				wal.pages[p] = o
+			}
+			clear(pending)
+			wal.dbSize = dbSize
+			wal.state.Frames = uint32((offset-walHeaderSize)/int64(len(frame))) + 1
+			copy(wal.state.Checksum[:], frame[16:24])
+		}
+	}
+
+	if wal.state.Frames == 0 {
+		return nil, nil
+	}
+	return wal, nil
+}
+
+// walChecksum 计算 WAL 校验和，data 的长度为 8 的倍数
+func walChecksum(bigEndian bool, data []byte, s1, s2 uint32) (uint32, uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(data); i += 8 {
+		s1 += order.Uint32(data[i:]) + s2
+		s2 += order.Uint32(data[i+4:]) + s1
+	}
+	return s1, s2
+}
+
+// overlay 返回数据库前 dbPages 页中被 WAL 覆盖或截断的页面
+func (w *walFile) overlay(dbPages int) []uint32 {
+	pages := make([]uint32, 0, len(w.pages))
+	for pgno := range w.pages {
+		if pgno <= w.dbSize && int(pgno) <= dbPages {
+			pages = append(pages, pgno-1)
+		}
+	}
+	for p := w.dbSize; int(p) < dbPages; p++ {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	return pages
+}
+
+// eachPage 按页号顺序解密 WAL 中每个页面的最新版本
+// 页号从 0 开始，第一页包含 SQLite 头，与解密后的数据库文件中的页面一致
+func (w *walFile) eachPage(pageSize int, decryptPage PageDecryptFunc, fn func(pageNum int64, page []byte) error) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return errors.OpenFileFailed(w.path, err)
+	}
+	defer f.Close()
+
+	pgnos := make([]uint32, 0, len(w.pages))
+	for pgno := range w.pages {
+		// 超出数据库大小的页面已被截断
+		if pgno <= w.dbSize {
+			pgnos = append(pgnos, pgno)
+		}
+	}
+	sort.Slice(pgnos, func(i, j int) bool { return pgnos[i] < pgnos[j] })
+
+	pageBuf := make([]byte, pageSize)
+	for _, pgno := range pgnos {
+		if _, err := f.ReadAt(pageBuf, w.pages[pgno]); err != nil {
+			return errors.ReadFileFailed(w.path, err)
+		}
+		pageNum := int64(pgno) - 1
+		page, err := decryptBatchPage(pageBuf, pageNum, decryptPage)
+		if err != nil {
+			return err
+		}
+		if pageNum == 0 {
+			page = append([]byte(SQLiteHeader), page...)
+		}
+		if err := fn(pageNum, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package common_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// walTestChecksum 计算小端序 WAL 的校验和
+func walTestChecksum(data []byte, s1, s2 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s1 += binary.LittleEndian.Uint32(data[i:]) + s2
+		s2 += binary.LittleEndian.Uint32(data[i+4:]) + s1
+	}
+	return s1, s2
+}
+
+// walFrame WAL 中的一帧，dbSize 不为零时为提交帧
+type walFrame struct {
+	pgno   uint32
+	dbSize uint32
+	page   []byte
+}
+
+// buildWAL 生成 WAL 文件内容，frameSalt 为 nil 时帧的 salt 与文件头相同
+func buildWAL(pageSize int, frames []walFrame, frameSalt []byte) []byte {
+	salt := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if frameSalt == nil {
+		frameSalt = salt
+	}
+	buf := binary.BigEndian.AppendUint32(nil, 0x377f0682)
+	buf = binary.BigEndian.AppendUint32(buf, 3007000)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(pageSize))
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = append(buf, salt...)
+	s1, s2 := walTestChecksum(buf, 0, 0)
+	buf = binary.BigEndian.AppendUint32(buf, s1)
+	buf = binary.BigEndian.AppendUint32(buf, s2)
+
+	for _, f := range frames {
+		header := binary.BigEndian.AppendUint32(nil, f.pgno)
+		header = binary.BigEndian.AppendUint32(header, f.dbSize)
+		s1, s2 = walTestChecksum(header, s1, s2)
+		s1, s2 = walTestChecksum(f.page, s1, s2)
+		header = append(header, frameSalt...)
+		header = binary.BigEndian.AppendUint32(header, s1)
+		header = binary.BigEndian.AppendUint32(header, s2)
+		buf = append(append(buf, header...), f.page...)
+	}
+	return buf
+}
+
+// walTest 数据库文件中为修改前的内容，修改后的页面写在 WAL 中
+type walTest struct {
+	// before 和 after 分别对应修改前后的明文数据库
+	before, after *testDB
+
+	// frames 修改后内容不同或新增的页面，最后一帧为提交帧
+	frames []walFrame
+}
+
+func newWALTest(t *testing.T) *walTest {
+	t.Helper()
+	db := newTestDB(t, "windows", 4, 300)
+	pageSize, reserve := db.decryptor.GetPageSize(), db.decryptor.GetReserve()
+
+	before := *db
+	before.plain = filepath.Join(t.TempDir(), "before.db")
+	plainBefore := readFile(t, db.plain)
+	encBefore := readFile(t, db.path)
+	if err := os.WriteFile(before.plain, plainBefore, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db.exec(t, "UPDATE pad SET data = randomblob(1000) WHERE rowid % 30 = 0")
+	db.pad(t, 40)
+	plainAfter := readFile(t, db.plain)
+	encAfter := readFile(t, db.path)
+	if err := os.WriteFile(db.path, encBefore, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db.last = plainBefore
+
+	w := &walTest{before: &before, after: db}
+	for off := 0; off < len(plainAfter); off += pageSize {
+		end := off + pageSize - reserve
+		if off < len(plainBefore) && bytes.Equal(plainAfter[off:end], plainBefore[off:end]) {
+			continue
+		}
+		w.frames = append(w.frames, walFrame{pgno: uint32(off/pageSize) + 1, page: encAfter[off : off+pageSize]})
+	}
+	w.frames[len(w.frames)-1].dbSize = uint32(len(plainAfter) / pageSize)
+	return w
+}
+
+// TestDecryptDBFileIncrementalWAL WAL 中已提交的有效帧合并到解密后的文件，校验失败或未提交的帧被忽略
+func TestDecryptDBFileIncrementalWAL(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// modify 修改 WAL 的帧，salt 为帧使用的 salt，corrupt 修改生成的 WAL 文件
+		modify  func(frames []walFrame) []walFrame
+		salt    []byte
+		corrupt func(wal []byte)
+
+		// merged 为 true 时期望 WAL 的内容已合并
+		merged bool
+	}{
+		{name: "committed", merged: true},
+		{name: "uncommitted", modify: func(frames []walFrame) []walFrame {
+			frames[len(frames)-1].dbSize = 0
+			return frames
+		}},
+		// 提交帧的校验和不匹配，整个事务无效
+		{name: "bad checksum", corrupt: func(wal []byte) {
+			wal[len(wal)-100] ^= 0xff
+		}},
+		{name: "stale salt", salt: []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+		// 提交之后未提交的帧被忽略
+		{name: "trailing uncommitted frames", modify: func(frames []walFrame) []walFrame {
+			extra := frames[0]
+			extra.page = bytes.Repeat([]byte{0xee}, len(extra.page))
+			return append(frames, extra)
+		}, merged: true},
+	}
+
+	for _, tt := range tests {
+		for _, incremental := range []bool{false, true} {
+			name := tt.name + "/full"
+			if incremental {
+				name = tt.name + "/incremental"
+			}
+			t.Run(name, func(t *testing.T) {
+				w := newWALTest(t)
+				output := w.before.path + ".out"
+				if incremental {
+					// 先生成页面指纹清单，WAL 出现后增量合并
+					w.before.decryptIncremental(t, output, "")
+				}
+
+				frames := w.frames
+				if tt.modify != nil {
+					frames = tt.modify(frames)
+				}
+				wal := buildWAL(w.before.decryptor.GetPageSize(), frames, tt.salt)
+				if tt.corrupt != nil {
+					tt.corrupt(wal)
+				}
+				if err := os.WriteFile(w.before.path+common.WALSuffix, wal, 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				w.before.decryptIncremental(t, output, "")
+				if tt.merged {
+					w.after.checkFile(t, output)
+				} else {
+					w.before.checkFile(t, output)
+				}
+			})
+		}
+	}
+}
+
+// TestDecryptDBFileIncrementalWALOverlay WAL 变化后，之前来自 WAL 的页面从数据库重新解密
+func TestDecryptDBFileIncrementalWALOverlay(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// checkpoint 为 true 时 WAL 的内容已写回数据库
+		checkpoint bool
+	}{
+		{name: "wal removed", checkpoint: false},
+		{name: "checkpointed", checkpoint: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWALTest(t)
+			output := w.before.path + ".out"
+			walPath := w.before.path + common.WALSuffix
+			if err := os.WriteFile(walPath, buildWAL(w.before.decryptor.GetPageSize(), w.frames, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			w.before.decryptIncremental(t, output, "")
+			w.after.checkFile(t, output)
+
+			if err := os.Remove(walPath); err != nil {
+				t.Fatal(err)
+			}
+			want := w.before
+			if tt.checkpoint {
+				w.after.encrypt(t)
+				want = w.after
+			}
+			want.decryptIncremental(t, output, "")
+			want.checkFile(t, output)
+		})
+	}
+}
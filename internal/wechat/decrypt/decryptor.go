@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt/darwin"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt/windows"
 )
@@ -30,6 +31,20 @@ type Decryptor interface {
 	GetVersion() string
 }
 
+// IncrementalDecryptor 支持增量解密的解密器
+type IncrementalDecryptor interface {
+	// DecryptIncremental 增量解密数据库到 outputPath，仅解密与上次解密相比发生变化的页面
+	// outputKey 不为空时解密后的文件按 SQLCipher 格式使用 outputKey 重新加密
+	DecryptIncremental(ctx context.Context, dbfile string, key string, outputPath string, outputKey string) error
+}
+
+// VerifyDecryptor 支持完整性校验的解密器
+type VerifyDecryptor interface {
+	// Verify 校验每个页面的 HMAC 并解密数据库，校验失败的页面以全零页面代替，不中断解密
+	// outputKey 不为空时解密后的文件按 SQLCipher 格式使用 outputKey 重新加密
+	Verify(ctx context.Context, dbfile string, key string, output io.Writer, outputKey string) (*common.VerifyReport, error)
+}
+
 // NewDecryptor 创建一个新的解密器
 func NewDecryptor(platform string, version int) (Decryptor, error) {
 	// 根据平台返回对应的实现
@@ -0,0 +1,193 @@
+package decrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// layout 数据目录布局，匹配任一样例数据库即认为目录属于对应的格式
+type layout struct {
+	patterns []string
+	formats  []Format
+}
+
+// maxSamples 目录布局未知时最多检查的数据库数量
+const maxSamples = 8
+
+var layouts = []layout{
+	{
+		patterns: []string{"db_storage/message/message_*.db", "db_storage/*/*.db"},
+		formats:  []Format{{Platform: "windows", Version: 4}, {Platform: "darwin", Version: 4}},
+	},
+	{
+		patterns: []string{"Msg/Multi/MSG*.db", "Msg/*.db"},
+		formats:  []Format{{Platform: "windows", Version: 3}},
+	},
+	{
+		patterns: []string{"Message/msg_*.db"},
+		formats:  []Format{{Platform: "darwin", Version: 3}},
+	},
+}
+
+// DetectFormat 根据数据目录的文件布局与数据库第一页推断平台和版本
+// 先根据目录布局筛选可能的格式，再检查样例数据库的第一页：
+// 已解密的数据库读取文件头中的页面大小与保留字节数，加密的数据库根据文件大小排除页面大小不符的格式，
+// hexKey 不为空时使用各格式的 Decryptor 验证第一页
+// Windows 与 macOS 的 4.x 数据库格式相同，无法区分时优先选择当前系统
+func DetectFormat(dir string, hexKey string) (Format, error) {
+	candidates, samples := detectLayout(dir)
+	if len(samples) == 0 {
+		return Format{}, errors.FormatUndetected(dir)
+	}
+
+	var key []byte
+	if len(hexKey) != 0 {
+		if k, err := hex.DecodeString(hexKey); err == nil && len(k) == common.KeySize {
+			key = k
+		}
+	}
+
+	for _, sample := range samples {
+		if decided(candidates) {
+			break
+		}
+		if formats := detectPage(sample, candidates, key); len(formats) > 0 {
+			candidates = formats
+		}
+	}
+
+	if !decided(candidates) {
+		return Format{}, errors.FormatUndetected(dir)
+	}
+
+	// 优先选择当前系统的格式
+	format := candidates[0]
+	for _, f := range candidates {
+		if f.Platform == runtime.GOOS {
+			format = f
+		}
+	}
+	log.Debug().Msgf("detected %s from %s", format, dir)
+	return format, nil
+}
+
+// Conflicts 判断格式与指定的平台和版本是否冲突，未指定的平台或版本不参与比较
+// Windows 与 macOS 的 4.x 数据库格式相同，平台不同不视为冲突
+func (f Format) Conflicts(platform string, version int) bool {
+	if version != 0 && version != f.Version {
+		return true
+	}
+	return len(platform) != 0 && platform != f.Platform && f.Version != 4
+}
+
+// detectLayout 根据目录布局返回可能的格式与样例数据库，目录布局未知时返回全部格式与目录中的数据库
+func detectLayout(dir string) ([]Format, []string) {
+	for _, l := range layouts {
+		var samples []string
+		for _, pattern := range l.patterns {
+			matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+			sort.Strings(matches)
+			for _, m := range matches {
+				if !slices.Contains(samples, m) {
+					samples = append(samples, m)
+				}
+			}
+		}
+		if len(samples) > 0 {
+			return slices.Clone(l.formats), samples
+		}
+	}
+
+	var samples []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".db" {
+			samples = append(samples, path)
+			if len(samples) >= maxSamples {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return slices.Clone(Formats), samples
+}
+
+// detectPage 检查数据库第一页，返回候选格式中与之相符的格式，无法读取时返回空
+func detectPage(path string, candidates []Format, key []byte) []Format {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return nil
+	}
+
+	page1 := make([]byte, 4096)
+	n, err := io.ReadFull(fp, page1)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil
+	}
+	page1 = page1[:n]
+
+	var formats []Format
+	for _, f := range candidates {
+		d, err := NewDecryptor(f.Platform, f.Version)
+		if err != nil {
+			continue
+		}
+		pageSize := d.GetPageSize()
+
+		if bytes.HasPrefix(page1, []byte(common.SQLiteHeader[:len(common.SQLiteHeader)-1])) {
+			// 已解密的数据库保留了原有的页面大小与保留字节数
+			if len(page1) < 21 {
+				return nil
+			}
+			size := int(binary.BigEndian.Uint16(page1[16:18]))
+			if size == 1 {
+				size = 65536
+			}
+			if size == pageSize && int(page1[20]) == d.GetReserve() {
+				formats = append(formats, f)
+			}
+			continue
+		}
+
+		if len(page1) < pageSize || info.Size()%int64(pageSize) != 0 {
+			continue
+		}
+		if key != nil && !d.Validate(page1[:pageSize], key) {
+			continue
+		}
+		formats = append(formats, f)
+	}
+	return formats
+}
+
+// decided 判断候选格式是否已经确定，Windows 与 macOS 的 4.x 数据库格式相同，视为同一种格式
+func decided(formats []Format) bool {
+	if len(formats) == 1 {
+		return true
+	}
+	for _, f := range formats {
+		if f.Version != 4 {
+			return false
+		}
+	}
+	return len(formats) > 0
+}
@@ -0,0 +1,135 @@
+package decrypt_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
+)
+
+// TestDetectFormat 使用各平台和版本生成的数据目录推断格式
+func TestDetectFormat(t *testing.T) {
+	formats := []decrypt.Format{
+		{Platform: "windows", Version: 3},
+		{Platform: "windows", Version: 4},
+		{Platform: "darwin", Version: 3},
+		{Platform: "darwin", Version: 4},
+	}
+	tests := []struct {
+		name string
+
+		// flat 为 true 时将一个数据库移到空目录中，目录布局未知，只能根据第一页推断
+		flat    bool
+		plain   bool
+		withKey bool
+		badKey  bool
+		wantErr bool
+	}{
+		{name: "layout"},
+		{name: "layout with key", withKey: true},
+		{name: "plain", plain: true},
+		{name: "unknown layout with key", flat: true, withKey: true},
+		{name: "unknown layout plain", flat: true, plain: true},
+		{name: "unknown layout with wrong key", flat: true, badKey: true, wantErr: true},
+	}
+
+	for _, f := range formats {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s_v%d/%s", f.Platform, f.Version, tt.name), func(t *testing.T) {
+				dir := filepath.Join(t.TempDir(), "data")
+				ds, err := fixture.Generate(dir, fixture.Options{
+					Platform: f.Platform,
+					Version:  f.Version,
+					Contacts: 2,
+					Messages: 4,
+					Plain:    tt.plain,
+				})
+				if err != nil {
+					t.Fatalf("Generate() error = %v", err)
+				}
+
+				if tt.flat {
+					dir = t.TempDir()
+					if err := os.Rename(filepath.Join(ds.Dir, messageDB(t, ds.Files)), filepath.Join(dir, "a.db")); err != nil {
+						t.Fatal(err)
+					}
+				}
+				key := ""
+				switch {
+				case tt.withKey:
+					key = ds.Key
+				case tt.badKey:
+					key = strings.Repeat("ab", 32)
+				}
+
+				got, err := decrypt.DetectFormat(dir, key)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("DetectFormat() = %s, want error", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("DetectFormat() error = %v", err)
+				}
+				want := f
+				// Windows 与 macOS 的 4.x 数据库格式相同，优先选择当前系统
+				if f.Version == 4 {
+					want.Platform = "windows"
+					if runtime.GOOS == "darwin" {
+						want.Platform = "darwin"
+					}
+				}
+				if got != want {
+					t.Errorf("DetectFormat() = %s, want %s", got, want)
+				}
+				if got.Conflicts(f.Platform, f.Version) {
+					t.Errorf("%s conflicts with %s", got, f)
+				}
+			})
+		}
+	}
+}
+
+func TestDetectFormatEmpty(t *testing.T) {
+	if got, err := decrypt.DetectFormat(t.TempDir(), ""); err == nil {
+		t.Errorf("DetectFormat() = %s, want error", got)
+	}
+}
+
+func TestFormatConflicts(t *testing.T) {
+	tests := []struct {
+		format   decrypt.Format
+		platform string
+		version  int
+		want     bool
+	}{
+		{format: decrypt.Format{Platform: "windows", Version: 3}},
+		{format: decrypt.Format{Platform: "windows", Version: 3}, platform: "windows", version: 3},
+		{format: decrypt.Format{Platform: "windows", Version: 3}, platform: "darwin", want: true},
+		{format: decrypt.Format{Platform: "windows", Version: 3}, version: 4, want: true},
+		{format: decrypt.Format{Platform: "windows", Version: 4}, platform: "darwin", version: 4},
+		{format: decrypt.Format{Platform: "darwin", Version: 3}, platform: "darwin", version: 4, want: true},
+	}
+	for _, tt := range tests {
+		if got := tt.format.Conflicts(tt.platform, tt.version); got != tt.want {
+			t.Errorf("%s.Conflicts(%q, %d) = %v, want %v", tt.format, tt.platform, tt.version, got, tt.want)
+		}
+	}
+}
+
+// messageDB 返回生成的消息数据库的相对路径
+func messageDB(t *testing.T, files []string) string {
+	for _, file := range files {
+		if name := strings.ToLower(filepath.Base(file)); strings.HasPrefix(name, "msg") || strings.HasPrefix(name, "message_") {
+			return file
+		}
+	}
+	t.Fatalf("no message database in %v", files)
+	return ""
+}
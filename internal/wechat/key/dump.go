@@ -0,0 +1,182 @@
+package key
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+)
+
+const (
+	// DumpChunkSize 每次从内存转储文件中读取的大小
+	DumpChunkSize = 64 * 1024 * 1024 // 64MB
+
+	// DumpOverlapBytes 相邻分块的重叠大小，需大于密钥特征与密钥之间的最大偏移
+	DumpOverlapBytes = 4096
+)
+
+// ImgKeySearcher 支持在内存中搜索图片密钥的提取器
+type ImgKeySearcher interface {
+	SearchImgKey(ctx context.Context, memory []byte) (string, bool)
+}
+
+// DumpFormat 确定内存转储文件对应的平台和版本
+// 未指定版本时根据转储文件名识别，仍无法确定时与平台一起根据数据目录推断；
+// Windows 与 macOS 的 4.x 数据库格式相同，无法推断平台，需要指定
+func DumpFormat(path string, dataDir string, platform string, version int) (decrypt.Format, error) {
+	if version == 0 {
+		version = dumpFileVersion(path)
+	}
+	if len(platform) != 0 && version != 0 {
+		return decrypt.Format{Platform: platform, Version: version}, nil
+	}
+
+	detected, err := decrypt.DetectFormat(dataDir, "")
+	if err != nil {
+		if len(platform) == 0 {
+			return decrypt.Format{}, errors.DumpPlatformUndetected(path, dataDir)
+		}
+		return decrypt.Format{}, err
+	}
+	if detected.Conflicts(platform, version) {
+		return decrypt.Format{}, errors.FormatConflict(detected.String(), dataDir, platform, version)
+	}
+	if version == 0 {
+		version = detected.Version
+	}
+	if len(platform) == 0 {
+		if version == 4 {
+			return decrypt.Format{}, errors.DumpPlatformUndetected(path, dataDir)
+		}
+		platform = detected.Platform
+	}
+	return decrypt.Format{Platform: platform, Version: version}, nil
+}
+
+// dumpFileVersion 从转储文件名 wechat_<version>_<pid>_<session>.bin 中识别微信主版本号
+func dumpFileVersion(path string) int {
+	parts := strings.Split(filepath.Base(path), "_")
+	if len(parts) < 2 || parts[0] != "wechat" {
+		return 0
+	}
+	major, _, _ := strings.Cut(parts[1], ".")
+	version, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// SearchDumpFile 在内存转储文件中搜索密钥
+// 支持 dumpmemory 生成的 .bin 文件，以及包含 .bin 文件的 .zip 压缩包
+func SearchDumpFile(ctx context.Context, e Extractor, path string) (string, string, error) {
+	r, err := openDumpFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+	return SearchDump(ctx, e, r)
+}
+
+// openDumpFile 打开内存转储文件，.zip 压缩包返回其中第一个 .bin 文件
+func openDumpFile(path string) (io.ReadCloser, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, errors.OpenFileFailed(path, err)
+		}
+		for _, f := range zr.File {
+			if !strings.EqualFold(filepath.Ext(f.Name), ".bin") {
+				continue
+			}
+			log.Debug().Msgf("search key in %s of %s", f.Name, path)
+			r, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, errors.OpenFileFailed(f.Name, err)
+			}
+			return &zipFileReader{ReadCloser: r, zr: zr}, nil
+		}
+		zr.Close()
+		return nil, errors.ErrMemoryDumpNotFound
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	return f, nil
+}
+
+// zipFileReader 关闭时同时关闭压缩包
+type zipFileReader struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (r *zipFileReader) Close() error {
+	r.ReadCloser.Close()
+	return r.zr.Close()
+}
+
+// SearchDump 分块读取内存转储并搜索密钥，不会将整个转储读入内存
+// 找到数据密钥和图片密钥（提取器支持时）后提前返回
+func SearchDump(ctx context.Context, e Extractor, r io.Reader) (string, string, error) {
+	imgSearcher, searchImg := e.(ImgKeySearcher)
+
+	var dataKey, imgKey string
+	buf := make([]byte, DumpOverlapBytes+DumpChunkSize)
+	kept := 0
+	offset := int64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", "", err
+		}
+
+		n, err := io.ReadFull(r, buf[kept:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", "", errors.ReadFileFailed("memory dump", err)
+		}
+		eof := err != nil
+		if n == 0 {
+			break
+		}
+		chunk := buf[:kept+n]
+
+		log.Debug().Int64("offset", offset).Int("size", len(chunk)).Msg("search key in memory dump chunk")
+		if dataKey == "" {
+			if key, ok := e.SearchKey(ctx, chunk); ok {
+				dataKey = key
+			}
+		}
+		if searchImg && imgKey == "" {
+			if key, ok := imgSearcher.SearchImgKey(ctx, chunk); ok {
+				imgKey = key
+			}
+		}
+		if dataKey != "" && (imgKey != "" || !searchImg) {
+			break
+		}
+		if eof {
+			break
+		}
+
+		// 保留分块末尾的数据，以免遗漏跨越分块边界的密钥
+		kept = min(DumpOverlapBytes, len(chunk))
+		copy(buf, chunk[len(chunk)-kept:])
+		offset += int64(n)
+	}
+
+	if dataKey == "" && imgKey == "" {
+		return "", "", errors.ErrNoValidKey
+	}
+	return dataKey, imgKey, nil
+}
@@ -0,0 +1,218 @@
+package key_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/key"
+	"github.com/sjzar/chatlog/internal/wechat/key/darwin"
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
+)
+
+// newDump 生成随机内容的内存转储，keyAt >= 0 时在 patternAt 写入密钥特征，在 keyAt 写入密钥
+func newDump(size int, patternAt int, keyAt int, dataKey []byte) []byte {
+	memory := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(memory)
+	if keyAt >= 0 {
+		copy(memory[patternAt:], darwin.V4KeyPatterns[0].Pattern)
+		copy(memory[keyAt:], dataKey)
+	}
+	return memory
+}
+
+// newExtractor 创建使用 ds 数据目录验证密钥的 macOS 4.x 提取器
+func newExtractor(t *testing.T, ds *fixture.Dataset) key.Extractor {
+	t.Helper()
+	e, err := key.NewExtractor("darwin", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := decrypt.NewValidator("darwin", 4, ds.Dir)
+	if err != nil {
+		t.Fatalf("NewValidator() error = %v", err)
+	}
+	e.SetValidate(validator)
+	return e
+}
+
+func generateDataset(t *testing.T) (*fixture.Dataset, []byte) {
+	t.Helper()
+	ds, err := fixture.Generate(filepath.Join(t.TempDir(), "data"), fixture.Options{Platform: "darwin", Version: 4, Contacts: 2, Messages: 4, MessageDBs: 1})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	dataKey, err := hex.DecodeString(ds.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds, dataKey
+}
+
+func TestSearchDump(t *testing.T) {
+	ds, dataKey := generateDataset(t)
+
+	// 第一个分块包含重叠区域的大小，之后的分块与上一分块末尾重叠 DumpOverlapBytes
+	const b1 = key.DumpChunkSize + key.DumpOverlapBytes
+	const b2 = b1 + key.DumpChunkSize
+	tests := []struct {
+		name      string
+		size      int
+		patternAt int
+		keyAt     int
+		wantErr   bool
+	}{
+		{name: "single chunk", size: 1 << 20, patternAt: 1000, keyAt: 1016},
+		// 第一个分块末尾只有特征的前 4 个字节
+		{name: "pattern across chunks", size: b1 + 1<<20, patternAt: b1 - 4, keyAt: b1 + 12},
+		{name: "key across chunks", size: b1 + 1<<20, patternAt: b1 - 20, keyAt: b1 - 4},
+		// 特征在第二个分块，密钥在第一个分块末尾，只能从重叠区域中读取
+		{name: "key in previous chunk", size: b1 + 1<<20, patternAt: b1 + 10, keyAt: b1 - 70},
+		{name: "last chunk", size: b2 + 100, patternAt: b2 + 50, keyAt: b2 - 30},
+		{name: "no key", size: b1 + 1<<20, keyAt: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := newDump(tt.size, tt.patternAt, tt.keyAt, dataKey)
+			got, _, err := key.SearchDump(context.Background(), newExtractor(t, ds), bytes.NewReader(memory))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SearchDump() = %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SearchDump() error = %v", err)
+			}
+			if got != ds.Key {
+				t.Errorf("SearchDump() = %s, want %s", got, ds.Key)
+			}
+		})
+	}
+}
+
+// TestSearchDumpFile 从 .bin 文件和 dumpmemory 生成的 .zip 压缩包中搜索跨越分块边界的密钥
+func TestSearchDumpFile(t *testing.T) {
+	ds, dataKey := generateDataset(t)
+	boundary := key.DumpChunkSize + key.DumpOverlapBytes
+	memory := newDump(boundary+1<<20, boundary-4, boundary+12, dataKey)
+	dir := t.TempDir()
+
+	bin := filepath.Join(dir, "wechat_4.0.3.22_1234_20250101120000.bin")
+	if err := os.WriteFile(bin, memory, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 压缩包中的 .bin 文件之外还有复制的 session.db
+	zipPath := filepath.Join(dir, "wechat_4.0.3.22_1234_20250101120000.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "wechat_4.0.3.22_1234_session.db", data: []byte("SQLite format 3\x00")},
+		{name: filepath.Base(bin), data: memory},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{bin, zipPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			got, _, err := key.SearchDumpFile(context.Background(), newExtractor(t, ds), path)
+			if err != nil {
+				t.Fatalf("SearchDumpFile() error = %v", err)
+			}
+			if got != ds.Key {
+				t.Errorf("SearchDumpFile() = %s, want %s", got, ds.Key)
+			}
+		})
+	}
+
+	t.Run("zip without dump", func(t *testing.T) {
+		path := filepath.Join(dir, "empty.zip")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zip.NewWriter(f).Close()
+		f.Close()
+		if _, _, err := key.SearchDumpFile(context.Background(), newExtractor(t, ds), path); err == nil {
+			t.Error("SearchDumpFile() error = nil, want error")
+		}
+	})
+}
+
+func TestDumpFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		dbFile   string // 数据目录中的数据库，空表示数据目录为空
+		dump     string
+		platform string
+		version  int
+		want     decrypt.Format
+		wantErr  bool
+	}{
+		{name: "darwin v3 from data dir", dbFile: "Message/msg_0.db", dump: "dump.bin", want: decrypt.Format{Platform: "darwin", Version: 3}},
+		{name: "windows v3 from data dir", dbFile: "Msg/Multi/MSG0.db", dump: "wechat_3.9.12.51_1234_20250101120000.bin", want: decrypt.Format{Platform: "windows", Version: 3}},
+		{name: "v4 requires platform", dbFile: "db_storage/message/message_0.db", dump: "wechat_4.0.3.22_1234_20250101120000.zip", wantErr: true},
+		{name: "v4 with platform", dbFile: "db_storage/message/message_0.db", dump: "dump.bin", platform: "darwin", want: decrypt.Format{Platform: "darwin", Version: 4}},
+		{name: "version from file name", dump: "wechat_4.0.3.22_1234_20250101120000.zip", platform: "darwin", want: decrypt.Format{Platform: "darwin", Version: 4}},
+		{name: "specified", dump: "dump.bin", platform: "windows", version: 3, want: decrypt.Format{Platform: "windows", Version: 3}},
+		{name: "undetected platform", dump: "wechat_3.9.12.51_1234_20250101120000.bin", wantErr: true},
+		{name: "undetected version", dump: "dump.bin", platform: "darwin", wantErr: true},
+		{name: "platform conflict", dbFile: "Message/msg_0.db", dump: "dump.bin", platform: "windows", wantErr: true},
+		{name: "version conflict", dbFile: "Message/msg_0.db", dump: "wechat_4.0.3.22_1234_20250101120000.bin", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.dbFile != "" {
+				path := filepath.Join(dir, filepath.FromSlash(tt.dbFile))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := key.DumpFormat(tt.dump, dir, tt.platform, tt.version)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DumpFormat() = %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DumpFormat() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DumpFormat() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
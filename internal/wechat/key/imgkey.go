@@ -0,0 +1,248 @@
+package key
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+const (
+	// ImgKeySize 图片密钥的长度，AES-128
+	ImgKeySize = 16
+
+	// ImgKeySamples 验证候选图片密钥使用的样本数量，候选密钥需解密全部样本
+	ImgKeySamples = dat2img.ImgKeySamples
+
+	// imgKeyChunkSize 穷举内存转储时每个任务的大小，为 ImgKeySize 的整数倍以保持对齐
+	imgKeyChunkSize = 4 * 1024 * 1024
+
+	// imgKeyOverlap 相邻分块的重叠大小，需大于字符串形式密钥的最大长度
+	imgKeyOverlap = 64
+
+	// imgKeyMinDistinct 候选密钥至少包含的不同字节数，跳过零值、指针等明显不是密钥的数据
+	imgKeyMinDistinct = 8
+
+	// imgKeyListBatch 候选密钥列表每个任务的大小
+	imgKeyListBatch = 64 * 1024
+)
+
+// ImgKeyWorkers 并行验证候选图片密钥的协程数量，默认为 CPU 核数
+var ImgKeyWorkers = runtime.NumCPU()
+
+// NewImgKeyValidators 从 dat 文件目录中收集验证图片密钥的样本
+func NewImgKeyValidators(datDir string) ([]*dat2img.AesKeyValidator, error) {
+	validators := dat2img.NewImgKeyValidators(datDir, ImgKeySamples)
+	if len(validators) == 0 {
+		return nil, errors.ErrImgKeySampleNotFound
+	}
+	if len(validators) < ImgKeySamples {
+		log.Warn().Msgf("only %d image samples found in %s, the recovered key may be a false positive", len(validators), datDir)
+	}
+	return validators, nil
+}
+
+// RecoverImgKeyFromDump 穷举内存转储文件中的候选图片密钥
+// 候选密钥包括按 16 字节对齐的原始数据，以及长度为 16 或 32 的字母数字字符串
+func RecoverImgKeyFromDump(ctx context.Context, path string, validators []*dat2img.AesKeyValidator) (string, error) {
+	r, err := openDumpFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return recoverImgKey(ctx, validators, func(ctx context.Context, tasks chan<- []byte) error {
+		var tail []byte
+		for {
+			// 每个分块从对齐的位置开始，包含上一分块末尾的数据，以免遗漏跨越分块边界的字符串
+			buf := make([]byte, len(tail)+imgKeyChunkSize)
+			copy(buf, tail)
+			n, err := io.ReadFull(r, buf[len(tail):])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return errors.ReadFileFailed("memory dump", err)
+			}
+			if n == 0 {
+				return nil
+			}
+			chunk := buf[:len(tail)+n]
+			select {
+			case tasks <- chunk:
+			case <-ctx.Done():
+				return nil
+			}
+			if err != nil {
+				return nil
+			}
+			tail = chunk[len(chunk)-imgKeyOverlap:]
+		}
+	}, scanDumpChunk)
+}
+
+// RecoverImgKeyFromList 验证候选密钥列表文件中的图片密钥
+// 每行一个候选密钥，十六进制字符串按解码后的字节使用，其他长度不小于 16 的字符串按原始字节使用，# 开头的行为注释
+func RecoverImgKeyFromList(ctx context.Context, path string, validators []*dat2img.AesKeyValidator) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.ReadFileFailed(path, err)
+	}
+
+	return recoverImgKey(ctx, validators, func(ctx context.Context, tasks chan<- []byte) error {
+		for len(data) > 0 {
+			// 按行边界切分任务
+			end := min(imgKeyListBatch, len(data))
+			if i := bytes.IndexByte(data[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(data)
+			}
+			select {
+			case tasks <- data[:end]:
+			case <-ctx.Done():
+				return nil
+			}
+			data = data[end:]
+		}
+		return nil
+	}, scanKeyList)
+}
+
+// scanFunc 遍历任务中的候选密钥，try 返回 true 时表示已找到密钥，应立即返回
+type scanFunc func(data []byte, try func(key []byte) bool) bool
+
+// recoverImgKey 由 produce 生成任务，ImgKeyWorkers 个协程并行执行 scan，找到密钥后取消其余任务
+func recoverImgKey(ctx context.Context, validators []*dat2img.AesKeyValidator, produce func(ctx context.Context, tasks chan<- []byte) error, scan scanFunc) (string, error) {
+	if len(validators) == 0 {
+		return "", errors.ErrImgKeySampleNotFound
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var found atomic.Bool
+	var once sync.Once
+	var imgKey string
+	try := func(key []byte) bool {
+		if found.Load() {
+			return true
+		}
+		for _, v := range validators {
+			if !v.Validate(key) {
+				return false
+			}
+		}
+		once.Do(func() {
+			imgKey = hex.EncodeToString(key[:ImgKeySize])
+			found.Store(true)
+			cancel()
+		})
+		return true
+	}
+
+	tasks := make(chan []byte)
+	wg := sync.WaitGroup{}
+	for i := 0; i < max(ImgKeyWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range tasks {
+				if !found.Load() {
+					scan(data, try)
+				}
+			}
+		}()
+	}
+
+	err := produce(ctx, tasks)
+	close(tasks)
+	wg.Wait()
+
+	if found.Load() {
+		return imgKey, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.ErrNoValidKey
+}
+
+// scanDumpChunk 遍历内存分块中的候选图片密钥
+func scanDumpChunk(data []byte, try func(key []byte) bool) bool {
+	// 原始字节形式的密钥，按 16 字节对齐
+	for i := 0; i+ImgKeySize <= len(data); i += ImgKeySize {
+		if distinctBytes(data[i:i+ImgKeySize]) >= imgKeyMinDistinct && try(data[i:i+ImgKeySize]) {
+			return true
+		}
+	}
+
+	// 字符串形式的密钥，32 位十六进制字符串同时尝试解码后的字节
+	for i := 0; i < len(data); {
+		if !isKeyChar(data[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(data) && isKeyChar(data[j]) {
+			j++
+		}
+		if n := j - i; n == ImgKeySize || n == ImgKeySize*2 {
+			if try(data[i : i+ImgKeySize]) {
+				return true
+			}
+			if n == ImgKeySize*2 {
+				if key, err := hex.DecodeString(string(data[i:j])); err == nil && try(key) {
+					return true
+				}
+			}
+		}
+		i = j
+	}
+	return false
+}
+
+// scanKeyList 遍历候选密钥列表中的密钥
+func scanKeyList(data []byte, try func(key []byte) bool) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if key, err := hex.DecodeString(string(line)); err == nil && len(key) >= ImgKeySize {
+			if try(key) {
+				return true
+			}
+			continue
+		}
+		if len(line) >= ImgKeySize && try(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func distinctBytes(b []byte) int {
+	var seen [4]uint64
+	n := 0
+	for _, c := range b {
+		if seen[c>>6]&(1<<(c&63)) == 0 {
+			seen[c>>6] |= 1 << (c & 63)
+			n++
+		}
+	}
+	return n
+}
+
+func isKeyChar(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,166 @@
+package key
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// newImgKeyValidators 写入使用 imgKey 加密的样本图片，返回验证器
+// 各样本的内容不同，与真实的缩略图一样，候选密钥需分别解密每个样本
+func newImgKeyValidators(t *testing.T, imgKey []byte) []*dat2img.AesKeyValidator {
+	t.Helper()
+	dir := t.TempDir()
+	c, err := aes.NewCipher(imgKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(int64(imgKey[0])))
+	for i := 0; i < ImgKeySamples; i++ {
+		block := make([]byte, aes.BlockSize)
+		r.Read(block)
+		copy(block, dat2img.JPG.Header)
+		data := make([]byte, 15+aes.BlockSize)
+		copy(data, dat2img.V4Format2.Header)
+		c.Encrypt(data[15:], block)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d_t.dat", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	validators, err := NewImgKeyValidators(dir)
+	if err != nil {
+		t.Fatalf("NewImgKeyValidators() error = %v", err)
+	}
+	return validators
+}
+
+// withWorkers 使用 n 个协程执行 f
+func withWorkers(n int, f func()) {
+	workers := ImgKeyWorkers
+	defer func() { ImgKeyWorkers = workers }()
+	ImgKeyWorkers = n
+	f()
+}
+
+func TestRecoverImgKeyFromDump(t *testing.T) {
+	rawKey := []byte{0x8f, 0x01, 0xe2, 0x37, 0x5c, 0x90, 0xaa, 0x13, 0x6b, 0xfe, 0x02, 0x44, 0xd9, 0x7e, 0x21, 0xb8}
+	strKey := []byte("Kq7Zp2Xw9Lm4Vt8R")
+
+	tests := []struct {
+		name   string
+		imgKey []byte
+		// planted 写入 offset 处的数据，前后以 0 字节分隔
+		planted []byte
+		offset  int
+		wantErr bool
+	}{
+		{name: "raw key", imgKey: rawKey, planted: rawKey, offset: 1 << 20},
+		{name: "raw key in second chunk", imgKey: rawKey, planted: rawKey, offset: imgKeyChunkSize + 48},
+		{name: "string key", imgKey: strKey, planted: strKey, offset: 1<<20 + 7},
+		{name: "hex string key", imgKey: rawKey, planted: []byte(hex.EncodeToString(rawKey)), offset: 1<<20 + 3},
+		// 字符串跨越分块边界，只能从下一分块开头的重叠区域中读取
+		{name: "string across chunks", imgKey: strKey, planted: strKey, offset: imgKeyChunkSize - 5},
+		{name: "hex string across chunks", imgKey: rawKey, planted: []byte(hex.EncodeToString(rawKey)), offset: imgKeyChunkSize - 20},
+		// 原始字节形式的密钥只按 16 字节对齐查找
+		{name: "unaligned raw key", imgKey: rawKey, planted: rawKey, offset: 1<<20 + 5, wantErr: true},
+		{name: "no key", imgKey: rawKey, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		memory := make([]byte, 2*imgKeyChunkSize+1000)
+		rand.New(rand.NewSource(1)).Read(memory)
+		if tt.planted != nil {
+			memory[tt.offset-1] = 0
+			copy(memory[tt.offset:], tt.planted)
+			memory[tt.offset+len(tt.planted)] = 0
+		}
+		path := filepath.Join(t.TempDir(), "dump.bin")
+		if err := os.WriteFile(path, memory, 0644); err != nil {
+			t.Fatal(err)
+		}
+		validators := newImgKeyValidators(t, tt.imgKey)
+
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/%d workers", tt.name, workers), func(t *testing.T) {
+				withWorkers(workers, func() {
+					got, err := RecoverImgKeyFromDump(context.Background(), path, validators)
+					if tt.wantErr {
+						if err == nil {
+							t.Fatalf("RecoverImgKeyFromDump() = %s, want error", got)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("RecoverImgKeyFromDump() error = %v", err)
+					}
+					if want := hex.EncodeToString(tt.imgKey); got != want {
+						t.Errorf("RecoverImgKeyFromDump() = %s, want %s", got, want)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestRecoverImgKeyFromList(t *testing.T) {
+	rawKey := []byte{0x8f, 0x01, 0xe2, 0x37, 0x5c, 0x90, 0xaa, 0x13, 0x6b, 0xfe, 0x02, 0x44, 0xd9, 0x7e, 0x21, 0xb8}
+	strKey := []byte("Kq7Zp2Xw9Lm4Vt8R")
+
+	// 候选密钥超过一个任务的大小，正确的密钥在最后一个任务中
+	r := rand.New(rand.NewSource(1))
+	var wrong []string
+	for i := 0; i < 3*imgKeyListBatch/33; i++ {
+		b := make([]byte, ImgKeySize)
+		r.Read(b)
+		wrong = append(wrong, hex.EncodeToString(b))
+	}
+	head := "# candidate keys\n\nshort\n" + strings.Join(wrong, "\n") + "\n"
+
+	tests := []struct {
+		name    string
+		imgKey  []byte
+		list    string
+		wantErr bool
+	}{
+		{name: "hex key", imgKey: rawKey, list: head + hex.EncodeToString(rawKey) + "\n"},
+		{name: "string key", imgKey: strKey, list: head + "  " + string(strKey) + "\r\n"},
+		{name: "commented key", imgKey: rawKey, list: head + "# " + hex.EncodeToString(rawKey), wantErr: true},
+		{name: "no key", imgKey: rawKey, list: head, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "keys.txt")
+		if err := os.WriteFile(path, []byte(tt.list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		validators := newImgKeyValidators(t, tt.imgKey)
+
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/%d workers", tt.name, workers), func(t *testing.T) {
+				withWorkers(workers, func() {
+					got, err := RecoverImgKeyFromList(context.Background(), path, validators)
+					if tt.wantErr {
+						if err == nil {
+							t.Fatalf("RecoverImgKeyFromList() = %s, want error", got)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("RecoverImgKeyFromList() error = %v", err)
+					}
+					if want := hex.EncodeToString(tt.imgKey); got != want {
+						t.Errorf("RecoverImgKeyFromList() = %s, want %s", got, want)
+					}
+				})
+			})
+		}
+	}
+}
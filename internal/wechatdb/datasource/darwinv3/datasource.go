@@ -64,10 +64,10 @@ type DataSource struct {
 	user2DisplayName map[string]string
 }
 
-func New(path string) (*DataSource, error) {
+func New(path string, key string) (*DataSource, error) {
 	ds := &DataSource{
 		path:             path,
-		dbm:              dbm.NewDBManager(path),
+		dbm:              dbm.NewDBManager(path, key),
 		talkerDBMap:      make(map[string]string),
 		user2DisplayName: make(map[string]string),
 	}
@@ -0,0 +1,140 @@
+package darwinv3
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetStats 统计对话在时间范围内的消息，尽量使用 SQL 聚合完成
+func (ds *DataSource) GetStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Stats, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	stats := model.NewStats(talker, startTime, endTime)
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	talkerMd5 := hex.EncodeToString(_talkerMd5Bytes[:])
+	dbPath, ok := ds.talkerDBMap[talkerMd5]
+	if !ok {
+		stats.Finish()
+		return stats, nil
+	}
+
+	db, err := ds.dbm.OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tableName := fmt.Sprintf("Chat_%s", talkerMd5)
+	args := []interface{}{startTime.Unix(), endTime.Unix()}
+
+	// 群聊消息内容以 "发送者:\n" 开头，在 SQL 中截取发送者
+	senderExpr := "''"
+	if stats.IsChatRoom {
+		senderExpr = `CASE WHEN instr(IFNULL(msgContent, ''), ':' || char(10)) > 0
+			THEN substr(msgContent, 1, instr(msgContent, ':' || char(10)) - 1) ELSE '' END`
+	}
+
+	query := fmt.Sprintf(`
+		SELECT mesDes = 0 AS isSelf, %s AS sender, COUNT(*), MIN(msgCreateTime), MAX(msgCreateTime)
+		FROM %s
+		WHERE msgCreateTime >= ? AND msgCreateTime <= ?
+		GROUP BY isSelf, sender
+	`, senderExpr, tableName)
+	err = queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var isSelf bool
+		var sender string
+		var count int
+		var first, last int64
+		if err := rows.Scan(&isSelf, &sender, &count, &first, &last); err != nil {
+			return err
+		}
+		if !stats.IsChatRoom && !isSelf {
+			sender = talker
+		}
+		stats.AddSender(sender, isSelf, count, first, last)
+		return nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			stats.Finish()
+			return stats, nil
+		}
+		return nil, err
+	}
+
+	// darwinv3 没有子类型字段
+	query = fmt.Sprintf(`SELECT messageType, COUNT(*) FROM %s WHERE msgCreateTime >= ? AND msgCreateTime <= ? GROUP BY messageType`, tableName)
+	err = queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var _type int64
+		var count int
+		if err := rows.Scan(&_type, &count); err != nil {
+			return err
+		}
+		stats.AddType(_type, 0, count)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`
+		SELECT strftime('%%Y-%%m-%%d %%H', msgCreateTime, 'unixepoch', 'localtime') AS hour, COUNT(*)
+		FROM %s
+		WHERE msgCreateTime >= ? AND msgCreateTime <= ?
+		GROUP BY hour
+	`, tableName)
+	err = queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var hour string
+		var count int
+		if err := rows.Scan(&hour, &count); err != nil {
+			return err
+		}
+		stats.AddHour(hour, count)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 回复时间需要按时间顺序遍历
+	query = fmt.Sprintf(`SELECT msgCreateTime, mesDes = 0 FROM %s WHERE msgCreateTime >= ? AND msgCreateTime <= ? ORDER BY msgCreateTime ASC`, tableName)
+	err = queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var createTime int64
+		var isSelf bool
+		if err := rows.Scan(&createTime, &isSelf); err != nil {
+			return err
+		}
+		stats.AddEvent(createTime, isSelf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Finish()
+	return stats, nil
+}
+
+func queryRows(ctx context.Context, db *sql.DB, query string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return errors.ScanRowFailed(err)
+		}
+	}
+	return rows.Err()
+}
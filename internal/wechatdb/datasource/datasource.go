@@ -18,6 +18,9 @@ type DataSource interface {
 	// 消息
 	GetMessages(ctx context.Context, startTime, endTime time.Time, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error)
 
+	// 消息统计
+	GetStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Stats, error)
+
 	// 联系人
 	GetContacts(ctx context.Context, key string, limit, offset int) ([]*model.Contact, error)
 
@@ -36,16 +39,17 @@ type DataSource interface {
 	Close() error
 }
 
-func New(path string, platform string, version int) (DataSource, error) {
+// New 创建数据源，key 不为空时工作目录中的数据库为使用 key 加密的 SQLCipher 格式
+func New(path string, platform string, version int, key string) (DataSource, error) {
 	switch {
 	case platform == "windows" && version == 3:
-		return windowsv3.New(path)
+		return windowsv3.New(path, key)
 	case platform == "windows" && version == 4:
-		return v4.New(path)
+		return v4.New(path, key)
 	case platform == "darwin" && version == 3:
-		return darwinv3.New(path)
+		return darwinv3.New(path, key)
 	case platform == "darwin" && version == 4:
-		return v4.New(path)
+		return v4.New(path, key)
 	default:
 		return nil, errors.PlatformUnsupported(platform, version)
 	}
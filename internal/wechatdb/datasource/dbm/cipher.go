@@ -0,0 +1,67 @@
+package dbm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+	"github.com/sjzar/chatlog/pkg/pagevfs"
+)
+
+// OpenCipherDB 打开使用 key 加密的 SQLCipher 4 格式数据库
+// SQLite 通过 pagevfs 按页读取并解密，内存中只保留缓存的页面，磁盘上不会产生明文文件
+func OpenCipherDB(path string, key string) (*sql.DB, error) {
+	return openCipherDB(common.NewCipher(), path, key)
+}
+
+// openCipherDB 使用 c 打开加密数据库，同一个 c 对相同密码和 salt 的密钥派生结果会被缓存
+func openCipherDB(c *common.Cipher, path string, key string) (*sql.DB, error) {
+	// 先验证密码，密码不匹配时返回 ErrWorkKeyIncorrect
+	cf, err := c.OpenFile(path, key, true)
+	if err != nil {
+		return nil, err
+	}
+	cf.Close()
+
+	id, err := pagevfs.Register(func(readOnly bool) (pagevfs.File, error) {
+		return c.OpenFile(path, key, true)
+	})
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	// 工作目录中的数据库只由解密流程更新，更新后 Callback 会关闭连接重新打开
+	db := sql.OpenDB(&cipherConnector{
+		id:     id,
+		dsn:    pagevfs.URI(path, id) + "&mode=ro&immutable=1",
+		driver: &sqlite3.SQLiteDriver{},
+	})
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	return db, nil
+}
+
+// cipherConnector 创建通过 pagevfs 读取加密数据库的连接，关闭时注销 pagevfs 中的文件
+type cipherConnector struct {
+	id     string
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *cipherConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *cipherConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *cipherConnector) Close() error {
+	pagevfs.Unregister(c.id)
+	return nil
+}
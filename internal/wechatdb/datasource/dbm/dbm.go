@@ -12,12 +12,15 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
 	"github.com/sjzar/chatlog/pkg/filecopy"
 	"github.com/sjzar/chatlog/pkg/filemonitor"
 )
 
 type DBManager struct {
 	path    string
+	key     string
+	cipher  *common.Cipher
 	id      string
 	fm      *filemonitor.FileMonitor
 	fgs     map[string]*filemonitor.FileGroup
@@ -26,9 +29,12 @@ type DBManager struct {
 	mutex   sync.RWMutex
 }
 
-func NewDBManager(path string) *DBManager {
+// NewDBManager 创建数据库管理器，key 不为空时数据库文件为使用 key 加密的 SQLCipher 格式
+func NewDBManager(path string, key string) *DBManager {
 	return &DBManager{
 		path:    path,
+		key:     key,
+		cipher:  common.NewCipher(),
 		id:      filepath.Base(path),
 		fm:      filemonitor.NewFileMonitor(),
 		fgs:     make(map[string]*filemonitor.FileGroup),
@@ -119,6 +125,17 @@ func (d *DBManager) OpenDB(path string) (*sql.DB, error) {
 		return db, nil
 	}
 	var err error
+	if d.key != "" {
+		db, err = openCipherDB(d.cipher, path, d.key)
+		if err != nil {
+			log.Err(err).Msgf("连接数据库 %s 失败", path)
+			return nil, err
+		}
+		d.mutex.Lock()
+		d.dbs[path] = db
+		d.mutex.Unlock()
+		return db, nil
+	}
 	tempPath := path
 	if runtime.GOOS == "windows" {
 		tempPath, err = filecopy.GetTempCopy(d.id, path)
@@ -139,7 +156,8 @@ func (d *DBManager) OpenDB(path string) (*sql.DB, error) {
 }
 
 func (d *DBManager) Callback(event fsnotify.Event) error {
-	if !event.Op.Has(fsnotify.Create) {
+	// 增量解密会原地更新数据库文件，同样需要重新打开连接
+	if !event.Op.Has(fsnotify.Create) && !event.Op.Has(fsnotify.Write) {
 		return nil
 	}
 
@@ -1,13 +1,17 @@
 package dbm
 
 import (
-	"fmt"
 	"testing"
-	"time"
+
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
 )
 
 func TestXxx(t *testing.T) {
-	path := "/Users/sarv/Documents/chatlog/bigjun_9e7a"
+	path := t.TempDir()
+	ds, err := fixture.Generate(path, fixture.Options{Platform: "windows", Version: 4, Plain: true})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
 
 	g := &Group{
 		Name:      "session",
@@ -15,28 +19,31 @@ func TestXxx(t *testing.T) {
 		BlackList: []string{},
 	}
 
-	d := NewDBManager(path)
+	d := NewDBManager(path, "")
 	d.AddGroup(g)
-	d.Start()
-
-	i := 0
-	for {
-		db, err := d.GetDB("session")
-		if err != nil {
-			fmt.Println(err)
-			break
-		}
-
-		var username string
-		row := db.QueryRow(`SELECT username FROM SessionTable LIMIT 1`)
-		if err := row.Scan(&username); err != nil {
-			fmt.Printf("Error scanning row: %v\n", err)
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		fmt.Printf("%d: Username: %s\n", i, username)
-		i++
-		time.Sleep(1000 * time.Millisecond)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
 	}
+	defer d.Close()
 
+	db, err := d.GetDB("session")
+	if err != nil {
+		t.Fatalf("GetDB() error = %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM SessionTable`).Scan(&count); err != nil {
+		t.Fatalf("query SessionTable error = %v", err)
+	}
+	if want := len(ds.Talkers()); count != want {
+		t.Errorf("SessionTable has %d rows, want %d", count, want)
+	}
+
+	var username string
+	if err := db.QueryRow(`SELECT username FROM SessionTable ORDER BY sort_timestamp DESC LIMIT 1`).Scan(&username); err != nil {
+		t.Fatalf("query SessionTable error = %v", err)
+	}
+	if want := ds.Messages[len(ds.Messages)-1].Talker; username != want {
+		t.Errorf("latest session = %s, want %s", username, want)
+	}
 }
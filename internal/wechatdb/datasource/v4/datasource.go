@@ -72,11 +72,11 @@ type DataSource struct {
 	messageInfos []MessageDBInfo
 }
 
-func New(path string) (*DataSource, error) {
+func New(path string, key string) (*DataSource, error) {
 
 	ds := &DataSource{
 		path:         path,
-		dbm:          dbm.NewDBManager(path),
+		dbm:          dbm.NewDBManager(path, key),
 		messageInfos: make([]MessageDBInfo, 0),
 	}
 
@@ -0,0 +1,161 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetStats 统计对话在时间范围内的消息，尽量使用 SQL 聚合完成
+func (ds *DataSource) GetStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Stats, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+
+	stats := model.NewStats(talker, startTime, endTime)
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+
+		var exists bool
+		err = db.QueryRowContext(ctx,
+			"SELECT 1 FROM sqlite_master WHERE type='table' AND name=?",
+			tableName).Scan(&exists)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, errors.QueryFailed("", err)
+		}
+
+		if err := statsByDB(ctx, db, stats, tableName, startTime, endTime); err != nil {
+			return nil, err
+		}
+	}
+
+	stats.Finish()
+	return stats, nil
+}
+
+func statsByDB(ctx context.Context, db *sql.DB, stats *model.Stats, tableName string, startTime, endTime time.Time) error {
+	// 与 MessageV4.Wrap 保持一致的自己发送判断
+	selfExpr := "m.status = 2"
+	selfArgs := []interface{}{}
+	if !stats.IsChatRoom {
+		selfExpr = "(m.status = 2 OR IFNULL(n.user_name, '') != ?)"
+		selfArgs = append(selfArgs, stats.Talker)
+	}
+	timeArgs := []interface{}{startTime.Unix(), endTime.Unix()}
+	args := append(append([]interface{}{}, selfArgs...), timeArgs...)
+
+	query := fmt.Sprintf(`
+		SELECT %s AS is_self, IFNULL(n.user_name, '') AS sender, COUNT(*), MIN(m.create_time), MAX(m.create_time)
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.create_time >= ? AND m.create_time <= ?
+		GROUP BY is_self, sender
+	`, selfExpr, tableName)
+	err := queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var isSelf bool
+		var sender string
+		var count int
+		var first, last int64
+		if err := rows.Scan(&isSelf, &sender, &count, &first, &last); err != nil {
+			return err
+		}
+		stats.AddSender(sender, isSelf, count, first, last)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// local_type 高 32 位为子类型，低 32 位为类型
+	query = fmt.Sprintf(`SELECT local_type, COUNT(*) FROM %s WHERE create_time >= ? AND create_time <= ? GROUP BY local_type`, tableName)
+	err = queryRows(ctx, db, query, timeArgs, func(rows *sql.Rows) error {
+		var localType int64
+		var count int
+		if err := rows.Scan(&localType, &count); err != nil {
+			return err
+		}
+		stats.AddType(localType&0xFFFFFFFF, localType>>32, count)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`
+		SELECT strftime('%%Y-%%m-%%d %%H', create_time, 'unixepoch', 'localtime') AS hour, COUNT(*)
+		FROM %s
+		WHERE create_time >= ? AND create_time <= ?
+		GROUP BY hour
+	`, tableName)
+	err = queryRows(ctx, db, query, timeArgs, func(rows *sql.Rows) error {
+		var hour string
+		var count int
+		if err := rows.Scan(&hour, &count); err != nil {
+			return err
+		}
+		stats.AddHour(hour, count)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 回复时间需要按顺序遍历
+	query = fmt.Sprintf(`
+		SELECT m.create_time, %s
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.create_time >= ? AND m.create_time <= ?
+		ORDER BY m.sort_seq ASC
+	`, selfExpr, tableName)
+	return queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var createTime int64
+		var isSelf bool
+		if err := rows.Scan(&createTime, &isSelf); err != nil {
+			return err
+		}
+		stats.AddEvent(createTime, isSelf)
+		return nil
+	})
+}
+
+func queryRows(ctx context.Context, db *sql.DB, query string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return errors.ScanRowFailed(err)
+		}
+	}
+	return rows.Err()
+}
@@ -79,10 +79,10 @@ type DataSource struct {
 }
 
 // New 创建一个新的 WindowsV3DataSource
-func New(path string) (*DataSource, error) {
+func New(path string, key string) (*DataSource, error) {
 	ds := &DataSource{
 		path:         path,
-		dbm:          dbm.NewDBManager(path),
+		dbm:          dbm.NewDBManager(path, key),
 		messageInfos: make([]MessageDBInfo, 0),
 	}
 
@@ -0,0 +1,147 @@
+package windowsv3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetStats 统计对话在时间范围内的消息，尽量使用 SQL 聚合完成
+func (ds *DataSource) GetStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Stats, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	stats := model.NewStats(talker, startTime, endTime)
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+
+		where := "Sequence >= ? AND Sequence <= ? AND StrTalker = ?"
+		args := []interface{}{startTime.Unix() * 1000, endTime.Unix() * 1000, talker}
+		if talkerID, ok := dbInfo.TalkerMap[talker]; ok {
+			where = "Sequence >= ? AND Sequence <= ? AND TalkerId = ?"
+			args[2] = talkerID
+		}
+
+		if err := ds.statsByDB(ctx, db, stats, where, args); err != nil {
+			if strings.Contains(err.Error(), "no such table") {
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	stats.Finish()
+	return stats, nil
+}
+
+func (ds *DataSource) statsByDB(ctx context.Context, db *sql.DB, stats *model.Stats, where string, args []interface{}) error {
+	// 私聊的发送者由 IsSender 决定，直接聚合
+	if !stats.IsChatRoom {
+		query := fmt.Sprintf(`SELECT IsSender, COUNT(*), MIN(CreateTime), MAX(CreateTime) FROM MSG WHERE %s GROUP BY IsSender`, where)
+		err := queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+			var isSender, count int
+			var first, last int64
+			if err := rows.Scan(&isSender, &count, &first, &last); err != nil {
+				return err
+			}
+			sender := stats.Talker
+			if isSender == 1 {
+				sender = ""
+			}
+			stats.AddSender(sender, isSender == 1, count, first, last)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT Type, SubType, COUNT(*) FROM MSG WHERE %s GROUP BY Type, SubType`, where)
+	err := queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var _type, subType int64
+		var count int
+		if err := rows.Scan(&_type, &subType, &count); err != nil {
+			return err
+		}
+		stats.AddType(_type, subType, count)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`SELECT strftime('%%Y-%%m-%%d %%H', CreateTime, 'unixepoch', 'localtime') AS hour, COUNT(*) FROM MSG WHERE %s GROUP BY hour`, where)
+	err = queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var hour string
+		var count int
+		if err := rows.Scan(&hour, &count); err != nil {
+			return err
+		}
+		stats.AddHour(hour, count)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 回复时间需要按顺序遍历，群聊发送者保存在 BytesExtra 中，无法在 SQL 中解析
+	columns := "CreateTime, IsSender, NULL"
+	if stats.IsChatRoom {
+		columns = "CreateTime, IsSender, BytesExtra"
+	}
+	query = fmt.Sprintf(`SELECT %s FROM MSG WHERE %s ORDER BY Sequence ASC`, columns, where)
+	return queryRows(ctx, db, query, args, func(rows *sql.Rows) error {
+		var createTime int64
+		var isSender int
+		var bytesExtra []byte
+		if err := rows.Scan(&createTime, &isSender, &bytesExtra); err != nil {
+			return err
+		}
+		stats.AddEvent(createTime, isSender == 1)
+		if stats.IsChatRoom {
+			sender := ""
+			if isSender != 1 && len(bytesExtra) != 0 {
+				if extra := model.ParseBytesExtra(bytesExtra); extra != nil {
+					sender = extra[1]
+				}
+			}
+			stats.AddSender(sender, isSender == 1, 1, createTime, createTime)
+		}
+		return nil
+	})
+}
+
+func queryRows(ctx context.Context, db *sql.DB, query string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return errors.ScanRowFailed(err)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,207 @@
+package fixture
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// macOS 3.x 数据库表结构
+const (
+	darwinV3ChatSchema = `CREATE TABLE %s(
+		mesLocalID INTEGER PRIMARY KEY AUTOINCREMENT,
+		mesSvrID INTEGER,
+		msgCreateTime INTEGER,
+		msgContent TEXT,
+		msgStatus INTEGER,
+		msgImgStatus INTEGER,
+		messageType INTEGER,
+		mesDes INTEGER,
+		msgSource TEXT,
+		IntRes1 INTEGER,
+		IntRes2 INTEGER,
+		StrRes1 TEXT,
+		StrRes2 TEXT,
+		msgVoiceText TEXT,
+		msgSeq INTEGER,
+		CompressContent BLOB,
+		ConBlob BLOB
+	)`
+	// darwinV3ContactColumns WCContact 与 GroupContact 的列相同
+	darwinV3ContactColumns = `(
+		m_nsUsrName TEXT PRIMARY KEY ASC,
+		m_uiConType INTEGER,
+		nickname TEXT,
+		m_nsFullPY TEXT,
+		m_nsShortPY TEXT,
+		m_nsRemark TEXT,
+		m_nsRemarkPYFull TEXT,
+		m_nsRemarkPYShort TEXT,
+		m_uiCertificationFlag INTEGER,
+		m_uiSex INTEGER,
+		m_uiType INTEGER,
+		m_nsImgStatus TEXT,
+		m_uiImgKey INTEGER,
+		m_nsHeadImgUrl TEXT,
+		m_nsHeadHDImgUrl TEXT,
+		m_nsHeadHDMd5 TEXT,
+		m_nsChatRoomMemList TEXT,
+		m_nsChatRoomAdminList TEXT,
+		m_uiChatRoomStatus INTEGER,
+		m_nsChatRoomDesc TEXT,
+		m_nsDraft TEXT,
+		m_nsBrandIconUrl TEXT,
+		m_nsGoogleContactName TEXT,
+		m_nsAliasName TEXT,
+		m_nsEncodeUserName TEXT,
+		m_uiChatRoomVersion INTEGER,
+		m_uiChatRoomMaxCount INTEGER,
+		m_uiChatRoomType INTEGER,
+		m_patSuffix TEXT,
+		richChatRoomDesc TEXT,
+		_packed_WCContactData BLOB,
+		openIMInfo BLOB
+	)`
+	darwinV3WCContactSchema    = `CREATE TABLE WCContact` + darwinV3ContactColumns
+	darwinV3GroupContactSchema = `CREATE TABLE GroupContact` + darwinV3ContactColumns
+	darwinV3GroupMemberSchema  = `CREATE TABLE GroupMember(m_nsUsrName TEXT PRIMARY KEY, nickname TEXT, m_nsRemark TEXT)`
+	darwinV3SessionSchema      = `CREATE TABLE SessionAbstract(
+		m_nsUserName TEXT PRIMARY KEY,
+		m_uUnReadCount INTEGER,
+		m_bShowUnReadAsRedDot INTEGER,
+		m_bMarkUnread INTEGER,
+		m_uLastTime INTEGER,
+		strRes1 TEXT,
+		strRes2 TEXT,
+		strRes3 TEXT,
+		intRes1 INTEGER,
+		intRes2 INTEGER,
+		intRes3 INTEGER,
+		_packed_MMSessionInfo BLOB
+	)`
+	darwinV3MediaRecordSchema = `CREATE TABLE HlinkMediaRecord(
+		mediaMd5 TEXT,
+		mediaSize INTEGER,
+		inodeNumber INTEGER,
+		modifyTime INTEGER ,
+		CONSTRAINT _Md5_Size UNIQUE (mediaMd5,mediaSize)
+	)`
+	darwinV3MediaDetailSchema = `CREATE TABLE HlinkMediaDetail(
+		localId INTEGER PRIMARY KEY AUTOINCREMENT,
+		inodeNumber INTEGER,
+		relativePath TEXT,
+		fileName TEXT
+	)`
+)
+
+// writeDarwinV3 生成 macOS 3.x 数据库，每个会话的消息保存在同一个数据库中
+func writeDarwinV3(w *writer) error {
+	ds := w.ds
+
+	// 会话按顺序分配到各个消息数据库
+	talkers := ds.Talkers()
+	for i := 0; i < min(w.opts.MessageDBs, len(talkers)); i++ {
+		var schema []string
+		var messages []*Message
+		for j := i; j < len(talkers); j += w.opts.MessageDBs {
+			schema = append(schema, fmt.Sprintf(darwinV3ChatSchema, "Chat_"+talkerMd5(talkers[j])))
+			messages = append(messages, ds.MessagesOf(talkers[j])...)
+		}
+		file := filepath.Join("Message", fmt.Sprintf("msg_%d.db", i))
+		if err := w.createDB(file, schema, func(tx *sql.Tx) error {
+			for _, m := range messages {
+				content := m.Content
+				mesDes := 1
+				if m.IsSelf {
+					mesDes = 0
+				} else if m.IsChatRoom() {
+					content = m.Sender + ":\n" + content
+				}
+				query := fmt.Sprintf(`INSERT INTO Chat_%s (mesSvrID, msgCreateTime, msgContent, msgStatus, messageType, mesDes, msgSeq) VALUES (?, ?, ?, ?, ?, ?, ?)`, talkerMd5(m.Talker))
+				if err := exec(tx, query, m.ServerID, m.Time.Unix(), content, 2, m.Type, mesDes, m.Seq); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := w.createDB(filepath.Join("Contact", "wccontact_new2.db"), []string{darwinV3WCContactSchema}, func(tx *sql.Tx) error {
+		for _, c := range append([]*Contact{ds.Self}, ds.Contacts...) {
+			if err := exec(tx, `INSERT INTO WCContact (m_nsUsrName, m_uiConType, nickname, m_nsRemark, m_uiSex, m_uiType, m_nsAliasName) VALUES (?, 1, ?, ?, 0, 3, ?)`,
+				c.UserName, c.NickName, c.Remark, c.Alias); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := w.createDB(filepath.Join("Group", "group_new.db"), []string{darwinV3GroupContactSchema, darwinV3GroupMemberSchema}, func(tx *sql.Tx) error {
+		members := make(map[string]bool)
+		for _, room := range ds.ChatRooms {
+			if err := exec(tx, `INSERT INTO GroupContact (m_nsUsrName, m_uiConType, nickname, m_nsChatRoomMemList, m_nsChatRoomAdminList) VALUES (?, 2, ?, ?, ?)`,
+				room.UserName, room.NickName, strings.Join(room.Members, ";"), room.Owner); err != nil {
+				return err
+			}
+			for _, member := range room.Members {
+				if members[member] {
+					continue
+				}
+				members[member] = true
+				if err := exec(tx, `INSERT INTO GroupMember (m_nsUsrName, nickname) VALUES (?, ?)`, member, ds.NickName(member)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := w.createDB(filepath.Join("Session", "session_new.db"), []string{darwinV3SessionSchema}, func(tx *sql.Tx) error {
+		for _, talker := range talkers {
+			m := ds.LastMessage(talker)
+			if m == nil {
+				continue
+			}
+			if err := exec(tx, `INSERT INTO SessionAbstract (m_nsUserName, m_uUnReadCount, m_uLastTime) VALUES (?, 0, ?)`, talker, m.Time.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return w.createDB(filepath.Join("MessageTemp", "hldata.db"), []string{darwinV3MediaRecordSchema, darwinV3MediaDetailSchema}, func(tx *sql.Tx) error {
+		for i, media := range ds.Media {
+			if media.Type == "voice" {
+				continue
+			}
+			inode := int64(1000000 + i)
+			if err := exec(tx, `INSERT INTO HlinkMediaRecord (mediaMd5, mediaSize, inodeNumber, modifyTime) VALUES (?, ?, ?, ?)`,
+				media.Md5, media.Size, inode, media.Time.Unix()); err != nil {
+				return err
+			}
+			if err := exec(tx, `INSERT INTO HlinkMediaDetail (inodeNumber, relativePath, fileName) VALUES (?, ?, ?)`,
+				inode, darwinV3MediaDir(media), media.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// darwinV3MediaDir 媒体文件在 MessageTemp 目录中的相对路径
+func darwinV3MediaDir(media *Media) string {
+	dir := "Image"
+	if media.Type == "file" {
+		dir = "File"
+	}
+	return filepath.Join(talkerMd5(media.Talker), dir)
+}
@@ -0,0 +1,275 @@
+package fixture
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+var (
+	surnames   = []string{"张", "王", "李", "赵", "陈", "刘", "杨", "黄", "周", "吴", "徐", "孙"}
+	givenNames = []string{"伟", "芳", "娜", "敏", "静", "磊", "洋", "勇", "艳", "杰", "涛", "明", "超", "秀英", "建华", "晓东"}
+	remarks    = []string{"同事", "大学同学", "房东", "健身教练", "表哥", "邻居"}
+	roomNames  = []string{"周末羽毛球群", "项目讨论组", "相亲相爱一家人", "大学同学群", "读书会", "小区业主群"}
+
+	phrases = []string{
+		"早上好", "今天开会吗？", "收到", "好的，我看一下", "晚上一起吃饭吧", "文档已经发到群里了",
+		"哈哈哈哈", "明天几点出发？", "辛苦了", "这个方案我觉得可以", "周末有空吗", "稍等，我在路上",
+		"[强]", "链接发你了", "刚看到消息", "下午三点老地方见", "谢谢！", "这周五之前能完成吗",
+		"我晚点回复你", "已经处理好了", "图片有点模糊，能再发一次吗", "好久不见，最近怎么样",
+	}
+	links = []struct{ title, desc, url string }{
+		{"Go 1.24 发布说明", "Go 1.24 的语言、工具链和标准库变化", "https://go.dev/doc/go1.24"},
+		{"SQLite 预写式日志", "WAL 模式的工作原理与检查点", "https://www.sqlite.org/wal.html"},
+		{"周末徒步路线推荐", "适合新手的五条城市近郊路线", "https://example.com/hiking/weekend"},
+		{"如何写好技术文档", "从读者出发组织内容", "https://example.com/blog/writing-docs"},
+	}
+	fileNames = []string{"会议纪要.docx", "季度报告.pdf", "预算表.xlsx", "设计稿.zip", "合同扫描件.pdf"}
+)
+
+// 消息种类的权重：文本、图片、语音、链接、文件
+var kindWeights = []int{60, 12, 8, 12, 8}
+
+// newDataset 根据随机种子生成联系人、群聊和消息
+func newDataset(opts Options) (*Dataset, error) {
+	r := rand.New(rand.NewSource(opts.Seed))
+	ds := &Dataset{
+		Platform: opts.Platform,
+		Version:  opts.Version,
+		Key:      opts.Key,
+	}
+	if ds.Key == "" {
+		key := make([]byte, 32)
+		r.Read(key)
+		ds.Key = hex.EncodeToString(key)
+	} else if b, err := hex.DecodeString(ds.Key); err != nil || len(b) != 32 {
+		return nil, errors.ErrKeyLengthMust32
+	}
+
+	ds.Self = &Contact{UserName: userName(r), NickName: personName(r)}
+	for i := 0; i < opts.Contacts; i++ {
+		c := &Contact{UserName: userName(r), NickName: personName(r)}
+		if r.Intn(3) == 0 {
+			c.Remark = remarks[r.Intn(len(remarks))] + c.NickName
+		}
+		if r.Intn(4) == 0 {
+			c.Alias = fmt.Sprintf("alias_%s", randString(r, 6))
+		}
+		ds.Contacts = append(ds.Contacts, c)
+	}
+
+	for i := 0; i < opts.ChatRooms; i++ {
+		room := &ChatRoom{
+			UserName:     fmt.Sprintf("%d@chatroom", 10000000000+r.Int63n(90000000000)),
+			NickName:     roomNames[i%len(roomNames)],
+			Members:      []string{ds.Self.UserName},
+			DisplayNames: make(map[string]string),
+		}
+		for _, j := range r.Perm(len(ds.Contacts))[:min(len(ds.Contacts), 3+r.Intn(4))] {
+			room.Members = append(room.Members, ds.Contacts[j].UserName)
+		}
+		room.Owner = room.Members[r.Intn(len(room.Members))]
+		for _, member := range room.Members {
+			if r.Intn(2) == 0 {
+				room.DisplayNames[member] = personName(r)
+			}
+		}
+		ds.ChatRooms = append(ds.ChatRooms, room)
+	}
+
+	for i, talker := range ds.Talkers() {
+		var members []string
+		if strings.HasSuffix(talker, "@chatroom") {
+			members = ds.ChatRooms[i-len(ds.Contacts)].Members
+		}
+		t := opts.Start.Add(time.Duration(i) * time.Minute)
+		for j := 0; j < opts.Messages; j++ {
+			t = t.Add(time.Duration(1+r.Intn(720)) * time.Minute)
+			m := newMessage(r, ds, talker, members, t)
+			ds.Messages = append(ds.Messages, m)
+			if m.Media != nil {
+				ds.Media = append(ds.Media, m.Media)
+			}
+		}
+	}
+	sort.SliceStable(ds.Messages, func(i, j int) bool {
+		return ds.Messages[i].Time.Before(ds.Messages[j].Time)
+	})
+	return ds, nil
+}
+
+// newMessage 生成一条消息，members 为空时为私聊
+func newMessage(r *rand.Rand, ds *Dataset, talker string, members []string, t time.Time) *Message {
+	m := &Message{
+		Talker:   talker,
+		Time:     t,
+		Seq:      t.Unix() * 1000,
+		ServerID: r.Int63(),
+		Type:     model.MessageTypeText,
+	}
+	if len(members) == 0 {
+		m.IsSelf = r.Intn(2) == 0
+		m.Sender = talker
+	} else {
+		m.Sender = members[r.Intn(len(members))]
+		m.IsSelf = m.Sender == ds.Self.UserName
+	}
+	if m.IsSelf {
+		m.Sender = ds.Self.UserName
+	}
+
+	switch pick(r, kindWeights) {
+	case 0:
+		m.Content = phrases[r.Intn(len(phrases))]
+	case 1:
+		m.Type = model.MessageTypeImage
+		m.Media = newMedia(r, "image", "", talker, t)
+		m.Media.Name = m.Media.Md5 + ".dat"
+		m.Content = fmt.Sprintf(`<?xml version="1.0"?>
+<msg>
+	<img aeskey="%s" encryver="1" md5="%s" length="%d" hdlength="%d" cdnthumbwidth="120" cdnthumbheight="90" />
+</msg>`, randMd5(r), m.Media.Md5, m.Media.Size, m.Media.Size*2)
+	case 2:
+		m.Type = model.MessageTypeVoice
+		m.Media = newMedia(r, "voice", "", talker, t)
+		m.Media.Name = fmt.Sprint(m.ServerID)
+		m.Media.Data = append([]byte("\x02#!SILK_V3"), randBytes(r, 64+r.Intn(256))...)
+		m.Media.Size = int64(len(m.Media.Data))
+		m.Content = fmt.Sprintf(`<msg><voicemsg endflag="1" cancelflag="0" forwardflag="0" voiceformat="4" voicelength="%d" length="%d" /></msg>`,
+			1000+r.Intn(30000), m.Media.Size)
+	case 3:
+		link := links[r.Intn(len(links))]
+		m.Type, m.SubType = model.MessageTypeShare, model.MessageSubTypeLink2
+		m.Content = fmt.Sprintf(`<?xml version="1.0"?>
+<msg>
+	<appmsg appid="" sdkver="0">
+		<title>%s</title>
+		<des>%s</des>
+		<type>5</type>
+		<url>%s</url>
+	</appmsg>
+	<fromusername>%s</fromusername>
+</msg>`, link.title, link.desc, link.url, m.Sender)
+	case 4:
+		m.Type, m.SubType = model.MessageTypeShare, model.MessageSubTypeFile
+		m.Media = newMedia(r, "file", fileNames[r.Intn(len(fileNames))], talker, t)
+		m.Content = fmt.Sprintf(`<?xml version="1.0"?>
+<msg>
+	<appmsg appid="" sdkver="0">
+		<title>%s</title>
+		<type>6</type>
+		<appattach>
+			<totallen>%d</totallen>
+			<fileext>%s</fileext>
+		</appattach>
+		<md5>%s</md5>
+	</appmsg>
+	<fromusername>%s</fromusername>
+</msg>`, m.Media.Name, m.Media.Size, strings.TrimPrefix(filepath.Ext(m.Media.Name), "."), m.Media.Md5, m.Sender)
+	}
+	return m
+}
+
+func newMedia(r *rand.Rand, _type, name, talker string, t time.Time) *Media {
+	return &Media{
+		Type:   _type,
+		Md5:    randMd5(r),
+		Name:   name,
+		Size:   int64(1024 + r.Intn(4<<20)),
+		Talker: talker,
+		Time:   t,
+	}
+}
+
+// pick 按权重随机选择
+func pick(r *rand.Rand, weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	n := r.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+func userName(r *rand.Rand) string {
+	return "wxid_" + randString(r, 14)
+}
+
+func personName(r *rand.Rand) string {
+	return surnames[r.Intn(len(surnames))] + givenNames[r.Intn(len(givenNames))]
+}
+
+func randString(r *rand.Rand, n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+func randBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+func randMd5(r *rand.Rand) string {
+	sum := md5.Sum(randBytes(r, 16))
+	return hex.EncodeToString(sum[:])
+}
+
+// Summary 会话列表中显示的消息摘要
+func (m *Message) Summary() string {
+	switch {
+	case m.Type == model.MessageTypeImage:
+		return "[图片]"
+	case m.Type == model.MessageTypeVoice:
+		return "[语音]"
+	case m.Type == model.MessageTypeShare && m.SubType == model.MessageSubTypeFile:
+		return "[文件]" + m.Media.Name
+	case m.Type == model.MessageTypeShare:
+		return "[链接]"
+	default:
+		return m.Content
+	}
+}
+
+// NickName 返回联系人的昵称
+func (d *Dataset) NickName(userName string) string {
+	if d.Self.UserName == userName {
+		return d.Self.NickName
+	}
+	for _, c := range d.Contacts {
+		if c.UserName == userName {
+			return c.NickName
+		}
+	}
+	return ""
+}
+
+// splitByTime 按时间顺序将消息平均分配到 n 个数据库
+func splitByTime(messages []*Message, n int) [][]*Message {
+	parts := make([][]*Message, 0, n)
+	for i := 0; i < n; i++ {
+		part := messages[i*len(messages)/n : (i+1)*len(messages)/n]
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
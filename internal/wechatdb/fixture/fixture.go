@@ -0,0 +1,241 @@
+// Package fixture 生成用于测试的微信数据库
+//
+// 按各平台的表结构生成联系人、群聊、会话、消息和媒体索引，内容由随机种子决定，
+// 再使用各平台的页面格式加密，不依赖真实的微信数据即可在 Linux 上测试解密、数据源和查询的完整流程
+package fixture
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// Options 生成测试数据库的参数
+type Options struct {
+	// Platform 和 Version 决定表结构、文件布局和加密格式
+	Platform string
+	Version  int
+
+	// Seed 随机种子，相同的参数生成相同的数据
+	Seed int64
+
+	// Key 数据密钥，十六进制编码的 32 字节，为空时由 Seed 生成
+	Key string
+
+	// Contacts 好友数量，ChatRooms 群聊数量，ChatRooms 为负数时不生成群聊
+	Contacts  int
+	ChatRooms int
+
+	// Messages 每个会话的消息数量
+	Messages int
+
+	// MessageDBs 消息数据库数量，消息按时间或会话分布到各个数据库中
+	MessageDBs int
+
+	// Start 第一条消息的时间
+	Start time.Time
+
+	// Plain 为 true 时不加密，生成与解密后工作目录相同的明文数据库
+	Plain bool
+}
+
+// 默认参数
+const (
+	DefaultSeed       = 1
+	DefaultContacts   = 8
+	DefaultChatRooms  = 2
+	DefaultMessages   = 40
+	DefaultMessageDBs = 2
+)
+
+// DefaultStart 默认的第一条消息时间
+var DefaultStart = time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+
+func (o *Options) fill() {
+	if o.Seed == 0 {
+		o.Seed = DefaultSeed
+	}
+	if o.Contacts <= 0 {
+		o.Contacts = DefaultContacts
+	}
+	if o.ChatRooms < 0 {
+		o.ChatRooms = 0
+	} else if o.ChatRooms == 0 {
+		o.ChatRooms = DefaultChatRooms
+	}
+	if o.Messages <= 0 {
+		o.Messages = DefaultMessages
+	}
+	if o.MessageDBs <= 0 {
+		o.MessageDBs = DefaultMessageDBs
+	}
+	if o.Start.IsZero() {
+		o.Start = DefaultStart
+	}
+}
+
+// Contact 联系人
+type Contact struct {
+	UserName string
+	Alias    string
+	Remark   string
+	NickName string
+}
+
+// ChatRoom 群聊，Members 包含自己
+type ChatRoom struct {
+	UserName     string
+	NickName     string
+	Owner        string
+	Members      []string
+	DisplayNames map[string]string
+}
+
+// Message 消息，Content 为文本消息的内容或其他消息的 XML
+type Message struct {
+	Talker   string
+	Sender   string
+	IsSelf   bool
+	Time     time.Time
+	Seq      int64
+	ServerID int64
+	Type     int64
+	SubType  int64
+	Content  string
+	Media    *Media
+}
+
+// IsChatRoom 是否为群聊消息
+func (m *Message) IsChatRoom() bool {
+	return strings.HasSuffix(m.Talker, "@chatroom")
+}
+
+// Media 消息引用的图片、文件或语音
+type Media struct {
+	Type   string
+	Md5    string
+	Name   string
+	Size   int64
+	Talker string
+	Time   time.Time
+
+	// Data 语音数据
+	Data []byte
+}
+
+// Month 媒体文件所在的月份目录
+func (m *Media) Month() string {
+	return m.Time.Format("2006-01")
+}
+
+// Dataset 生成的测试数据，用于与查询结果比对
+type Dataset struct {
+	Platform string
+	Version  int
+	Key      string
+
+	// Dir 数据目录，Files 为其中数据库文件的相对路径
+	Dir   string
+	Files []string
+
+	Self      *Contact
+	Contacts  []*Contact
+	ChatRooms []*ChatRoom
+
+	// Messages 全部消息，按时间排序
+	Messages []*Message
+	Media    []*Media
+}
+
+// Generate 在 dir 中生成指定平台的数据库文件，除 Plain 外均按平台格式加密
+func Generate(dir string, opts Options) (*Dataset, error) {
+	opts.fill()
+	write, ok := layouts[layoutName(opts.Platform, opts.Version)]
+	if !ok {
+		return nil, errors.PlatformUnsupported(opts.Platform, opts.Version)
+	}
+
+	ds, err := newDataset(opts)
+	if err != nil {
+		return nil, err
+	}
+	ds.Dir = dir
+
+	plainDir := dir
+	if !opts.Plain {
+		if plainDir, err = os.MkdirTemp("", "chatlog-fixture-"); err != nil {
+			return nil, errors.OpenFileFailed(plainDir, err)
+		}
+		defer os.RemoveAll(plainDir)
+	}
+
+	w := &writer{ds: ds, dir: plainDir, opts: opts}
+	if err := write(w); err != nil {
+		return nil, err
+	}
+	sort.Strings(w.files)
+	ds.Files = w.files
+
+	if opts.Plain {
+		return ds, nil
+	}
+
+	key, err := hex.DecodeString(ds.Key)
+	if err != nil {
+		return nil, errors.DecodeKeyFailed(err)
+	}
+	for _, file := range ds.Files {
+		output := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return nil, errors.OpenFileFailed(output, err)
+		}
+		if err := EncryptDB(filepath.Join(plainDir, file), output, opts.Platform, opts.Version, key, w.salt(file)); err != nil {
+			return nil, err
+		}
+	}
+	return ds, nil
+}
+
+// Talkers 返回全部会话，好友在前，群聊在后
+func (d *Dataset) Talkers() []string {
+	talkers := make([]string, 0, len(d.Contacts)+len(d.ChatRooms))
+	for _, c := range d.Contacts {
+		talkers = append(talkers, c.UserName)
+	}
+	for _, c := range d.ChatRooms {
+		talkers = append(talkers, c.UserName)
+	}
+	return talkers
+}
+
+// MessagesOf 返回会话的全部消息，按时间排序
+func (d *Dataset) MessagesOf(talker string) []*Message {
+	var messages []*Message
+	for _, m := range d.Messages {
+		if m.Talker == talker {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// LastMessage 返回会话的最后一条消息
+func (d *Dataset) LastMessage(talker string) *Message {
+	messages := d.MessagesOf(talker)
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages[len(messages)-1]
+}
+
+// talkerMd5 会话的 md5，用于 v4 和 macOS 3.x 的消息表名及媒体目录
+func talkerMd5(talker string) string {
+	sum := md5.Sum([]byte(talker))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,206 @@
+package fixture_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/chatlog/wechat"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/internal/wechatdb/fixture"
+)
+
+// config 实现 wechat.Config
+type config struct {
+	key, dataDir, workDir, platform, workKey string
+	version                                  int
+}
+
+func (c *config) GetDataKey() string  { return c.key }
+func (c *config) GetDataDir() string  { return c.dataDir }
+func (c *config) GetWorkDir() string  { return c.workDir }
+func (c *config) GetPlatform() string { return c.platform }
+func (c *config) GetVersion() int     { return c.version }
+func (c *config) GetWorkKey() string  { return c.workKey }
+
+// TestPipeline 生成加密数据库，解密到工作目录后通过 wechatdb 查询，与生成的数据比对
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		platform string
+		version  int
+		workKey  string
+	}{
+		{platform: "windows", version: 3},
+		{platform: "windows", version: 4},
+		{platform: "darwin", version: 3},
+		{platform: "darwin", version: 4},
+		{platform: "windows", version: 3, workKey: "fixture-work-key"},
+		{platform: "windows", version: 4, workKey: "fixture-work-key"},
+		{platform: "darwin", version: 3, workKey: "fixture-work-key"},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s_v%d", tt.platform, tt.version)
+		if tt.workKey != "" {
+			name += "_work_key"
+		}
+		t.Run(name, func(t *testing.T) {
+			ds, err := fixture.Generate(filepath.Join(t.TempDir(), "data"), fixture.Options{
+				Platform: tt.platform,
+				Version:  tt.version,
+				Seed:     42,
+			})
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			conf := &config{
+				key:      ds.Key,
+				dataDir:  ds.Dir,
+				workDir:  filepath.Join(t.TempDir(), "work"),
+				platform: tt.platform,
+				version:  tt.version,
+				workKey:  tt.workKey,
+			}
+			for _, file := range ds.Files {
+				if err := wechat.NewService(conf).DecryptDBFile(filepath.Join(ds.Dir, file)); err != nil {
+					t.Fatalf("DecryptDBFile(%s) error = %v", file, err)
+				}
+			}
+
+			db, err := wechatdb.New(conf.workDir, tt.platform, tt.version, tt.workKey)
+			if err != nil {
+				t.Fatalf("wechatdb.New() error = %v", err)
+			}
+			defer db.Close()
+
+			checkContacts(t, db, ds)
+			checkChatRooms(t, db, ds)
+			checkSessions(t, db, ds)
+			checkMessages(t, db, ds)
+			checkMedia(t, db, ds)
+		})
+	}
+}
+
+func checkContacts(t *testing.T, db *wechatdb.DB, ds *fixture.Dataset) {
+	for _, c := range ds.Contacts {
+		contact, err := db.GetContact(c.UserName)
+		if err != nil {
+			t.Errorf("GetContact(%s) error = %v", c.UserName, err)
+			continue
+		}
+		if contact.NickName != c.NickName || contact.Remark != c.Remark || contact.Alias != c.Alias {
+			t.Errorf("GetContact(%s) = %+v, want %+v", c.UserName, contact, c)
+		}
+	}
+}
+
+func checkChatRooms(t *testing.T, db *wechatdb.DB, ds *fixture.Dataset) {
+	for _, room := range ds.ChatRooms {
+		chatRoom, err := db.GetChatRoom(room.UserName)
+		if err != nil {
+			t.Errorf("GetChatRoom(%s) error = %v", room.UserName, err)
+			continue
+		}
+		var members []string
+		for _, user := range chatRoom.Users {
+			members = append(members, user.UserName)
+		}
+		want := append([]string(nil), room.Members...)
+		sort.Strings(members)
+		sort.Strings(want)
+		if fmt.Sprint(members) != fmt.Sprint(want) {
+			t.Errorf("GetChatRoom(%s) members = %v, want %v", room.UserName, members, want)
+		}
+	}
+}
+
+func checkSessions(t *testing.T, db *wechatdb.DB, ds *fixture.Dataset) {
+	resp, err := db.GetSessions("", 0, 0)
+	if err != nil {
+		t.Fatalf("GetSessions() error = %v", err)
+	}
+	sessions := make(map[string]*model.Session)
+	for _, s := range resp.Items {
+		sessions[s.UserName] = s
+	}
+	for _, talker := range ds.Talkers() {
+		s, ok := sessions[talker]
+		if !ok {
+			t.Errorf("GetSessions() missing %s", talker)
+			continue
+		}
+		if last := ds.LastMessage(talker); !s.NTime.Equal(last.Time) {
+			t.Errorf("session %s time = %v, want %v", talker, s.NTime, last.Time)
+		}
+	}
+}
+
+func checkMessages(t *testing.T, db *wechatdb.DB, ds *fixture.Dataset) {
+	start := ds.Messages[0].Time.Add(-time.Minute)
+	end := ds.Messages[len(ds.Messages)-1].Time.Add(time.Minute)
+	for _, talker := range ds.Talkers() {
+		want := ds.MessagesOf(talker)
+		got, err := db.GetMessages(start, end, talker, "", "", 0, 0)
+		if err != nil {
+			t.Errorf("GetMessages(%s) error = %v", talker, err)
+			continue
+		}
+		if len(got) != len(want) {
+			t.Errorf("GetMessages(%s) returned %d messages, want %d", talker, len(got), len(want))
+			continue
+		}
+		for i, m := range got {
+			w := want[i]
+			if !m.Time.Equal(w.Time) || m.Type != w.Type || m.IsSelf != w.IsSelf {
+				t.Errorf("message %s[%d] = {%v %d self=%v}, want {%v %d self=%v}", talker, i, m.Time, m.Type, m.IsSelf, w.Time, w.Type, w.IsSelf)
+				continue
+			}
+			if !w.IsSelf && m.Sender != w.Sender {
+				t.Errorf("message %s[%d] sender = %s, want %s", talker, i, m.Sender, w.Sender)
+			}
+			switch {
+			case w.Type == model.MessageTypeText && m.Content != w.Content:
+				t.Errorf("message %s[%d] content = %q, want %q", talker, i, m.Content, w.Content)
+			case w.Type == model.MessageTypeImage && m.Contents["md5"] != w.Media.Md5:
+				t.Errorf("message %s[%d] image md5 = %v, want %s", talker, i, m.Contents["md5"], w.Media.Md5)
+			case w.Type == model.MessageTypeShare && m.SubType != w.SubType:
+				t.Errorf("message %s[%d] sub type = %d, want %d", talker, i, m.SubType, w.SubType)
+			}
+		}
+	}
+}
+
+func checkMedia(t *testing.T, db *wechatdb.DB, ds *fixture.Dataset) {
+	for _, media := range ds.Media {
+		if media.Type == "voice" {
+			// macOS 3.x 不支持读取语音
+			if ds.Platform == "darwin" && ds.Version == 3 {
+				continue
+			}
+			got, err := db.GetMedia("voice", media.Name)
+			if err != nil {
+				t.Errorf("GetMedia(voice, %s) error = %v", media.Name, err)
+				continue
+			}
+			if !bytes.Equal(got.Data, media.Data) {
+				t.Errorf("GetMedia(voice, %s) data mismatch", media.Name)
+			}
+			continue
+		}
+
+		got, err := db.GetMedia(media.Type, media.Md5)
+		if err != nil {
+			t.Errorf("GetMedia(%s, %s) error = %v", media.Type, media.Md5, err)
+			continue
+		}
+		if filepath.Base(got.Path) != media.Name {
+			t.Errorf("GetMedia(%s, %s) path = %s, want file %s", media.Type, media.Md5, got.Path, media.Name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package fixture
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// layouts 各平台生成明文数据库的方法
+var layouts = map[string]func(w *writer) error{
+	"windows_v3": writeWindowsV3,
+	"windows_v4": writeV4,
+	"darwin_v3":  writeDarwinV3,
+	"darwin_v4":  writeV4,
+}
+
+func layoutName(platform string, version int) string {
+	return fmt.Sprintf("%s_v%d", platform, version)
+}
+
+// writer 在明文目录中创建数据库
+type writer struct {
+	ds    *Dataset
+	dir   string
+	opts  Options
+	files []string
+}
+
+// createDB 创建数据库 file 并在一个事务中写入数据
+// 页面大小和每页保留字节数与平台加密格式一致，加密时保留字节用于保存 IV 和 HMAC
+func (w *writer) createDB(file string, schema []string, fill func(tx *sql.Tx) error) error {
+	d, err := decrypt.NewDecryptor(w.opts.Platform, w.opts.Version)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.OpenFileFailed(path, err)
+	}
+	if err := createDB(path, d.GetPageSize(), d.GetReserve(), schema, fill); err != nil {
+		return err
+	}
+	w.files = append(w.files, file)
+	return nil
+}
+
+// salt 数据库的 salt，由随机种子和文件路径决定
+func (w *writer) salt(file string) []byte {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d/%s", w.opts.Seed, filepath.ToSlash(file))))
+	return sum[:common.SaltSize]
+}
+
+// createDB 创建指定页面大小和保留字节数的数据库
+func createDB(path string, pageSize, reserve int, schema []string, fill func(tx *sql.Tx) error) error {
+	os.Remove(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	defer conn.Close()
+
+	// 保留字节数需在写入第一个页面前设置
+	if err := conn.Raw(func(driverConn any) error {
+		return driverConn.(*sqlite3.SQLiteConn).SetFileControlInt("main", sqlite3.SQLITE_FCNTL_RESERVE_BYTES, reserve)
+	}); err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	pragmas := []string{
+		fmt.Sprintf("PRAGMA page_size = %d", pageSize),
+		"PRAGMA journal_mode = DELETE",
+	}
+	for _, query := range append(pragmas, schema...) {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return errors.QueryFailed(query, err)
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.DBConnectFailed(path, err)
+	}
+	if err := fill(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.QueryFailed("COMMIT", err)
+	}
+	return nil
+}
+
+// EncryptDB 按平台的页面格式加密明文数据库 src，写入 dst，第一页以 salt 开头
+// src 的页面大小和保留字节数需与平台一致，可由 Generate 的 Plain 模式生成
+func EncryptDB(src, dst string, platform string, version int, key []byte, salt []byte) error {
+	d, err := decrypt.NewDecryptor(platform, version)
+	if err != nil {
+		return err
+	}
+	pageSize, reserve := d.GetPageSize(), d.GetReserve()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return errors.ReadFileFailed(src, err)
+	}
+	if len(data) < pageSize || len(data)%pageSize != 0 {
+		return errors.ReadFileFailed(src, fmt.Errorf("invalid database size %d", len(data)))
+	}
+	// 数据库头部第 20 字节为每页保留字节数
+	if int(data[20]) != reserve {
+		return errors.SQLCipherLayoutUnsupported(pageSize, int(data[20]))
+	}
+
+	encryptPage, err := pageEncryptor(platform, version, pageSize, reserve, key, salt)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return errors.OpenFileFailed(dst, err)
+	}
+	defer f.Close()
+	for pageNum := int64(0); int(pageNum)*pageSize < len(data); pageNum++ {
+		page, err := encryptPage(data[int(pageNum)*pageSize:int(pageNum+1)*pageSize], pageNum)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(page); err != nil {
+			return errors.WriteOutputFailed(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return errors.WriteOutputFailed(err)
+	}
+	return nil
+}
+
+// pageEncryptor 返回平台的页面加密函数
+// Windows 3.x 和 4.x 的页面格式分别与 SQLCipher 3、4 相同，macOS 3.x 直接使用数据密钥作为加密密钥
+func pageEncryptor(platform string, version int, pageSize, reserve int, key []byte, salt []byte) (common.PageEncryptFunc, error) {
+	if platform == "darwin" && version == 3 {
+		c := &common.Cipher{Name: "darwinv3", PageSize: pageSize, Reserve: reserve, HMACSize: sha1.Size, HashFunc: sha1.New}
+		macKey := pbkdf2.Key(key, common.XorBytes(salt, 0x3a), 2, common.KeySize, sha1.New)
+		return func(page []byte, pageNum int64) ([]byte, error) {
+			return c.EncryptPage(page, pageNum, salt, key, macKey)
+		}, nil
+	}
+
+	c := common.NewCipher()
+	if version == 3 {
+		c = &common.Cipher{Name: "windowsv3", PageSize: pageSize, Reserve: reserve, IterCount: 64000, HMACSize: sha1.Size, HashFunc: sha1.New, Keys: &common.KeyCache{}}
+	}
+	return c.Encryptor(string(key), salt), nil
+}
+
+// exec 执行写入语句
+func exec(tx *sql.Tx, query string, args ...any) error {
+	if _, err := tx.Exec(query, args...); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// nameIDs 按首次出现的顺序为名称分配从 1 开始的编号，对应 Name2Id 等表的 rowid
+type nameIDs struct {
+	ids   map[string]int64
+	names []string
+}
+
+func newNameIDs() *nameIDs {
+	return &nameIDs{ids: make(map[string]int64)}
+}
+
+func (n *nameIDs) id(name string) int64 {
+	if id, ok := n.ids[name]; ok {
+		return id
+	}
+	n.names = append(n.names, name)
+	n.ids[name] = int64(len(n.names))
+	return n.ids[name]
+}
+
+// insert 按编号写入全部名称，query 的参数依次为编号和名称
+func (n *nameIDs) insert(tx *sql.Tx, query string) error {
+	for i, name := range n.names {
+		if err := exec(tx, query, i+1, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package fixture
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/model/wxproto"
+	"github.com/sjzar/chatlog/pkg/util/zstd"
+)
+
+// v4 数据库表结构，Windows 与 macOS 相同
+const (
+	v4TimestampSchema = `CREATE TABLE Timestamp(timestamp INTEGER)`
+	v4Name2IdSchema   = `CREATE TABLE Name2Id(user_name TEXT PRIMARY KEY, is_session INTEGER)`
+	v4MsgSchema       = `CREATE TABLE %s(
+		local_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id INTEGER,
+		local_type INTEGER,
+		sort_seq INTEGER,
+		real_sender_id INTEGER,
+		create_time INTEGER,
+		status INTEGER,
+		upload_status INTEGER,
+		download_status INTEGER,
+		server_seq INTEGER,
+		origin_source INTEGER,
+		source TEXT,
+		message_content TEXT,
+		compress_content TEXT,
+		packed_info_data BLOB,
+		WCDB_CT_message_content INTEGER DEFAULT NULL,
+		WCDB_CT_source INTEGER DEFAULT NULL
+	)`
+	v4ContactSchema = `CREATE TABLE contact(
+		id INTEGER PRIMARY KEY,
+		username TEXT,
+		local_type INTEGER,
+		alias TEXT,
+		encrypt_username TEXT,
+		flag INTEGER,
+		delete_flag INTEGER,
+		verify_flag INTEGER,
+		remark TEXT,
+		remark_quan_pin TEXT,
+		remark_pin_yin_initial TEXT,
+		nick_name TEXT,
+		pin_yin_initial TEXT,
+		quan_pin TEXT,
+		big_head_url TEXT,
+		small_head_url TEXT,
+		head_img_md5 TEXT,
+		chat_room_notify INTEGER,
+		is_in_chat_room INTEGER,
+		description TEXT,
+		extra_buffer BLOB,
+		chat_room_type INTEGER
+	)`
+	v4ChatRoomSchema = `CREATE TABLE chat_room(id INTEGER PRIMARY KEY, username TEXT, owner TEXT, ext_buffer BLOB)`
+	v4SessionSchema  = `CREATE TABLE SessionTable(
+		username TEXT PRIMARY KEY,
+		type INTEGER,
+		unread_count INTEGER,
+		unread_first_msg_srv_id INTEGER,
+		is_hidden INTEGER,
+		summary TEXT,
+		draft TEXT,
+		status INTEGER,
+		last_timestamp INTEGER,
+		sort_timestamp INTEGER,
+		last_clear_unread_timestamp INTEGER,
+		last_msg_locald_id INTEGER,
+		last_msg_type INTEGER,
+		last_msg_sub_type INTEGER,
+		last_msg_sender TEXT,
+		last_sender_display_name TEXT,
+		last_msg_ext_type INTEGER
+	)`
+	v4Dir2IdSchema   = `CREATE TABLE dir2id(username TEXT PRIMARY KEY)`
+	v4HardlinkSchema = `CREATE TABLE %s_hardlink_info_v3(
+		md5_hash INTEGER,
+		md5 TEXT,
+		type INTEGER,
+		file_name TEXT,
+		file_size INTEGER,
+		modify_time INTEGER,
+		dir1 INTEGER,
+		dir2 INTEGER,
+		extra_buffer BLOB
+	)`
+	v4VoiceSchema = `CREATE TABLE VoiceInfo(
+		chat_name_id INTEGER,
+		create_time INTEGER,
+		local_id INTEGER,
+		svr_id INTEGER,
+		voice_data BLOB,
+		data_index TEXT DEFAULT '0',
+		PRIMARY KEY(chat_name_id, create_time, local_id)
+	)`
+)
+
+// v4 消息状态
+const (
+	v4StatusSent     = 2
+	v4StatusReceived = 4
+)
+
+// writeV4 生成 v4 数据库，文件布局与 db_storage 目录相同
+func writeV4(w *writer) error {
+	ds := w.ds
+
+	for i, messages := range splitByTime(ds.Messages, w.opts.MessageDBs) {
+		start := messages[0].Time
+		if i == 0 {
+			start = w.opts.Start
+		}
+		file := filepath.Join("db_storage", "message", fmt.Sprintf("message_%d.db", i))
+		if err := w.writeV4Messages(file, start.Unix(), messages); err != nil {
+			return err
+		}
+	}
+
+	var voices []*Message
+	for _, m := range ds.Messages {
+		if m.Type == model.MessageTypeVoice {
+			voices = append(voices, m)
+		}
+	}
+	if err := w.createDB(filepath.Join("db_storage", "message", "media_0.db"), []string{v4Name2IdSchema, v4VoiceSchema}, func(tx *sql.Tx) error {
+		ids := newNameIDs()
+		for i, m := range voices {
+			if err := exec(tx, `INSERT INTO VoiceInfo (chat_name_id, create_time, local_id, svr_id, voice_data) VALUES (?, ?, ?, ?, ?)`,
+				ids.id(m.Talker), m.Time.Unix(), i+1, m.ServerID, m.Media.Data); err != nil {
+				return err
+			}
+		}
+		return ids.insert(tx, `INSERT INTO Name2Id (rowid, user_name, is_session) VALUES (?, ?, 1)`)
+	}); err != nil {
+		return err
+	}
+
+	if err := w.createDB(filepath.Join("db_storage", "contact", "contact.db"), []string{v4ContactSchema, v4ChatRoomSchema}, func(tx *sql.Tx) error {
+		query := `INSERT INTO contact (username, local_type, alias, remark, nick_name, flag, delete_flag, verify_flag) VALUES (?, ?, ?, ?, ?, 3, 0, 0)`
+		for _, c := range append([]*Contact{ds.Self}, ds.Contacts...) {
+			if err := exec(tx, query, c.UserName, 1, c.Alias, c.Remark, c.NickName); err != nil {
+				return err
+			}
+		}
+		for _, room := range ds.ChatRooms {
+			if err := exec(tx, query, room.UserName, 2, "", "", room.NickName); err != nil {
+				return err
+			}
+			extBuffer, err := roomData(room)
+			if err != nil {
+				return err
+			}
+			if err := exec(tx, `INSERT INTO chat_room (username, owner, ext_buffer) VALUES (?, ?, ?)`, room.UserName, room.Owner, extBuffer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := w.createDB(filepath.Join("db_storage", "session", "session.db"), []string{v4SessionSchema}, func(tx *sql.Tx) error {
+		for _, talker := range ds.Talkers() {
+			m := ds.LastMessage(talker)
+			if m == nil {
+				continue
+			}
+			if err := exec(tx, `INSERT INTO SessionTable (username, type, unread_count, summary, last_timestamp, sort_timestamp, last_msg_type, last_msg_sub_type, last_msg_sender, last_sender_display_name)
+				VALUES (?, 0, 0, ?, ?, ?, ?, ?, ?, ?)`,
+				talker, m.Summary(), m.Time.Unix(), m.Seq, m.Type, m.SubType, m.Sender, ds.NickName(m.Sender)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	schema := []string{v4Dir2IdSchema}
+	for _, _type := range []string{"image", "video", "file"} {
+		schema = append(schema, fmt.Sprintf(v4HardlinkSchema, _type))
+	}
+	return w.createDB(filepath.Join("db_storage", "hardlink", "hardlink.db"), schema, func(tx *sql.Tx) error {
+		dirs := newNameIDs()
+		for _, media := range ds.Media {
+			var dir1, dir2 int64
+			switch media.Type {
+			case "image":
+				dir1, dir2 = dirs.id(talkerMd5(media.Talker)), dirs.id(media.Month())
+			case "file":
+				dir1 = dirs.id(media.Month())
+			default:
+				continue
+			}
+			query := fmt.Sprintf(`INSERT INTO %s_hardlink_info_v3 (md5, type, file_name, file_size, modify_time, dir1, dir2) VALUES (?, ?, ?, ?, ?, ?, ?)`, media.Type)
+			if err := exec(tx, query, media.Md5, 1, media.Name, media.Size, media.Time.Unix(), dir1, dir2); err != nil {
+				return err
+			}
+		}
+		return dirs.insert(tx, `INSERT INTO dir2id (rowid, username) VALUES (?, ?)`)
+	})
+}
+
+// writeV4Messages 生成一个消息数据库，每个会话一张 Msg_md5(talker) 表
+func (w *writer) writeV4Messages(file string, start int64, messages []*Message) error {
+	tables := make(map[string]bool)
+	schema := []string{v4TimestampSchema, v4Name2IdSchema}
+	for _, m := range messages {
+		table := "Msg_" + talkerMd5(m.Talker)
+		if !tables[table] {
+			tables[table] = true
+			schema = append(schema, fmt.Sprintf(v4MsgSchema, table))
+		}
+	}
+
+	return w.createDB(file, schema, func(tx *sql.Tx) error {
+		if err := exec(tx, `INSERT INTO Timestamp (timestamp) VALUES (?)`, start); err != nil {
+			return err
+		}
+
+		// Name2Id 记录发送人，消息通过 rowid 关联
+		ids := newNameIDs()
+		ids.id(w.ds.Self.UserName)
+		for _, m := range messages {
+			ids.id(m.Talker)
+			status := v4StatusReceived
+			if m.IsSelf {
+				status = v4StatusSent
+			}
+
+			content := m.Content
+			if m.IsChatRoom() && !m.IsSelf {
+				content = m.Sender + ":\n" + content
+			}
+
+			var messageContent any = content
+			ct := 0
+			if m.Type != model.MessageTypeText {
+				// 非文本消息的 XML 使用 zstd 压缩保存
+				messageContent = zstd.Compress([]byte(content))
+				ct = 4
+			}
+
+			packedInfo, err := v4PackedInfo(m)
+			if err != nil {
+				return err
+			}
+
+			// local_type 高 32 位为子类型
+			query := fmt.Sprintf(`INSERT INTO Msg_%s (server_id, local_type, sort_seq, real_sender_id, create_time, status, message_content, packed_info_data, WCDB_CT_message_content)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, talkerMd5(m.Talker))
+			if err := exec(tx, query, m.ServerID, m.SubType<<32|m.Type, m.Seq, ids.id(m.Sender), m.Time.Unix(), status, messageContent, packedInfo, ct); err != nil {
+				return err
+			}
+		}
+		return ids.insert(tx, `INSERT INTO Name2Id (rowid, user_name, is_session) VALUES (?, ?, 1)`)
+	})
+}
+
+// v4PackedInfo 生成 packed_info_data，图片消息记录图片的 md5
+func v4PackedInfo(m *Message) ([]byte, error) {
+	info := &wxproto.PackedInfo{Type: 106, Version: 14}
+	if m.Type == model.MessageTypeImage {
+		info.Image = &wxproto.ImageHash{Md5: m.Media.Md5}
+	}
+	b, err := proto.Marshal(info)
+	if err != nil {
+		return nil, errors.QueryFailed("packed_info_data", err)
+	}
+	return b, nil
+}
+
+// roomData 生成群成员列表的 protobuf，v3 的 RoomData 与 v4 的 ext_buffer 格式相同
+func roomData(room *ChatRoom) ([]byte, error) {
+	data := &wxproto.RoomData{}
+	for _, member := range room.Members {
+		user := &wxproto.RoomDataUser{UserName: member}
+		if name, ok := room.DisplayNames[member]; ok {
+			user.DisplayName = proto.String(name)
+		}
+		data.Users = append(data.Users, user)
+	}
+	b, err := proto.Marshal(data)
+	if err != nil {
+		return nil, errors.QueryFailed("room data", err)
+	}
+	return b, nil
+}
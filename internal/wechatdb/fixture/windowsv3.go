@@ -0,0 +1,315 @@
+package fixture
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/model/wxproto"
+	"github.com/sjzar/chatlog/pkg/util/lz4"
+)
+
+// Windows 3.x 数据库表结构
+const (
+	windowsV3DBInfoSchema  = `CREATE TABLE DBInfo(tableIndex INTEGER PRIMARY KEY, tableVersion INTEGER, tableDesc TEXT)`
+	windowsV3Name2IDSchema = `CREATE TABLE Name2ID(UsrName TEXT PRIMARY KEY)`
+	windowsV3MsgSchema     = `CREATE TABLE MSG(
+		localId INTEGER PRIMARY KEY AUTOINCREMENT,
+		TalkerId INT DEFAULT 0,
+		MsgSvrID INT,
+		Type INT,
+		SubType INT,
+		IsSender INT,
+		CreateTime INT,
+		Sequence INT DEFAULT 0,
+		StatusEx INT DEFAULT 0,
+		FlagEx INT,
+		Status INT,
+		MsgServerSeq INT,
+		MsgSequence INT,
+		StrTalker TEXT,
+		StrContent TEXT,
+		DisplayContent TEXT,
+		Reserved0 INT DEFAULT 0,
+		Reserved1 INT DEFAULT 0,
+		Reserved2 INT DEFAULT 0,
+		Reserved3 INT DEFAULT 0,
+		Reserved4 TEXT,
+		Reserved5 TEXT,
+		Reserved6 TEXT,
+		CompressContent BLOB,
+		BytesExtra BLOB,
+		BytesTrans BLOB
+	)`
+	windowsV3ContactSchema = `CREATE TABLE Contact(
+		UserName TEXT PRIMARY KEY,
+		Alias TEXT,
+		EncryptUserName TEXT,
+		DelFlag INTEGER DEFAULT 0,
+		Type INTEGER DEFAULT 0,
+		VerifyFlag INTEGER DEFAULT 0,
+		Reserved1 INTEGER DEFAULT 0,
+		Reserved2 INTEGER DEFAULT 0,
+		Reserved3 TEXT,
+		Reserved4 TEXT,
+		Remark TEXT,
+		NickName TEXT,
+		LabelIDList TEXT,
+		DomainList TEXT,
+		ChatRoomType int,
+		PYInitial TEXT,
+		QuanPin TEXT,
+		RemarkPYInitial TEXT,
+		RemarkQuanPin TEXT,
+		BigHeadImgUrl TEXT,
+		SmallHeadImgUrl TEXT,
+		HeadImgMd5 TEXT,
+		ChatRoomNotify INTEGER DEFAULT 0,
+		Reserved5 INTEGER DEFAULT 0,
+		Reserved6 TEXT,
+		Reserved7 TEXT,
+		ExtraBuf BLOB,
+		Reserved8 INTEGER DEFAULT 0,
+		Reserved9 INTEGER DEFAULT 0,
+		Reserved10 TEXT,
+		Reserved11 TEXT
+	)`
+	windowsV3ChatRoomSchema = `CREATE TABLE ChatRoom(
+		ChatRoomName TEXT PRIMARY KEY,
+		UserNameList TEXT,
+		DisplayNameList TEXT,
+		ChatRoomFlag int Default 0,
+		Owner INTEGER DEFAULT 0,
+		IsShowName INTEGER DEFAULT 0,
+		SelfDisplayName TEXT,
+		Reserved1 INTEGER DEFAULT 0,
+		Reserved2 TEXT,
+		Reserved3 INTEGER DEFAULT 0,
+		Reserved4 TEXT,
+		Reserved5 INTEGER DEFAULT 0,
+		Reserved6 TEXT,
+		RoomData BLOB,
+		Reserved7 INTEGER DEFAULT 0,
+		Reserved8 TEXT
+	)`
+	windowsV3SessionSchema = `CREATE TABLE Session(
+		strUsrName TEXT  PRIMARY KEY,
+		nOrder INT DEFAULT 0,
+		nUnReadCount INTEGER DEFAULT 0,
+		parentRef TEXT,
+		Reserved0 INTEGER DEFAULT 0,
+		Reserved1 TEXT,
+		strNickName TEXT,
+		nStatus INTEGER,
+		nIsSend INTEGER,
+		strContent TEXT,
+		nMsgType	INTEGER,
+		nMsgLocalID INTEGER,
+		nMsgStatus INTEGER,
+		nTime INTEGER,
+		editContent TEXT,
+		othersAtMe INT,
+		Reserved2 INTEGER DEFAULT 0,
+		Reserved3 TEXT,
+		Reserved4 INTEGER DEFAULT 0,
+		Reserved5 TEXT,
+		bytesXml BLOB
+	)`
+	windowsV3HardLinkAttributeSchema = `CREATE TABLE HardLink%sAttribute(
+		Md5Hash INTEGER PRIMARY KEY,
+		MD5 BLOB NOT NULL,
+		Type INTEGER,
+		FileName TEXT,
+		FileSize INTEGER,
+		DirID1 INTEGER,
+		DirID2 INTEGER,
+		ModifyTime INTEGER,
+		Reserved0 INTEGER DEFAULT 0,
+		Reserved1 TEXT
+	)`
+	windowsV3HardLinkIDSchema = `CREATE TABLE HardLink%sID(DirId INTEGER PRIMARY KEY, Dir TEXT)`
+	windowsV3MediaSchema      = `CREATE TABLE Media(Key TEXT, Reserved0 INT, Buf BLOB, Reserved1 INT, Reserved2 TEXT)`
+)
+
+// BytesExtra 中的字段类型
+const (
+	bytesExtraSender = 1
+	bytesExtraThumb  = 3
+	bytesExtraImage  = 4
+)
+
+// writeWindowsV3 生成 Windows 3.x 数据库，文件布局与 Msg 目录相同
+func writeWindowsV3(w *writer) error {
+	ds := w.ds
+
+	for i, messages := range splitByTime(ds.Messages, w.opts.MessageDBs) {
+		start := messages[0].Time
+		if i == 0 {
+			start = w.opts.Start
+		}
+		file := filepath.Join("Msg", "Multi", fmt.Sprintf("MSG%d.db", i))
+		if err := w.writeWindowsV3Messages(file, start.UnixMilli(), messages); err != nil {
+			return err
+		}
+	}
+
+	if err := w.createDB(filepath.Join("Msg", "Multi", "MediaMSG0.db"), []string{windowsV3MediaSchema}, func(tx *sql.Tx) error {
+		for _, m := range ds.Messages {
+			if m.Type != model.MessageTypeVoice {
+				continue
+			}
+			if err := exec(tx, `INSERT INTO Media (Key, Reserved0, Buf) VALUES (?, ?, ?)`, fmt.Sprint(m.ServerID), m.ServerID, m.Media.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := w.createDB(filepath.Join("Msg", "MicroMsg.db"), []string{windowsV3ContactSchema, windowsV3ChatRoomSchema, windowsV3SessionSchema}, func(tx *sql.Tx) error {
+		query := `INSERT INTO Contact (UserName, Alias, Remark, NickName, Type, Reserved1) VALUES (?, ?, ?, ?, 3, 1)`
+		for _, c := range append([]*Contact{ds.Self}, ds.Contacts...) {
+			if err := exec(tx, query, c.UserName, c.Alias, c.Remark, c.NickName); err != nil {
+				return err
+			}
+		}
+		for _, room := range ds.ChatRooms {
+			if err := exec(tx, query, room.UserName, "", "", room.NickName); err != nil {
+				return err
+			}
+			data, err := roomData(room)
+			if err != nil {
+				return err
+			}
+			displayNames := make([]string, len(room.Members))
+			for i, member := range room.Members {
+				displayNames[i] = room.DisplayNames[member]
+			}
+			if err := exec(tx, `INSERT INTO ChatRoom (ChatRoomName, UserNameList, DisplayNameList, Reserved2, RoomData) VALUES (?, ?, ?, ?, ?)`,
+				room.UserName, strings.Join(room.Members, "^G"), strings.Join(displayNames, "^G"), room.Owner, data); err != nil {
+				return err
+			}
+		}
+		for _, talker := range ds.Talkers() {
+			m := ds.LastMessage(talker)
+			if m == nil {
+				continue
+			}
+			if err := exec(tx, `INSERT INTO Session (strUsrName, nOrder, strNickName, nIsSend, strContent, nMsgType, nTime) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				talker, m.Seq, ds.NickName(m.Sender), m.IsSelf, m.Summary(), m.Type, m.Time.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, _type := range []string{"Image", "Video", "File"} {
+		file := filepath.Join("Msg", "HardLink"+_type+".db")
+		schema := []string{fmt.Sprintf(windowsV3HardLinkAttributeSchema, _type), fmt.Sprintf(windowsV3HardLinkIDSchema, _type)}
+		if err := w.createDB(file, schema, func(tx *sql.Tx) error {
+			dirs := newNameIDs()
+			for _, media := range ds.Media {
+				if media.Type != strings.ToLower(_type) {
+					continue
+				}
+				dir1, dir2 := dirs.id(talkerMd5(media.Talker)), dirs.id(media.Month())
+				if media.Type == "file" {
+					dir1 = dir2
+				}
+				md5, err := hex.DecodeString(media.Md5)
+				if err != nil {
+					return errors.DecodeKeyFailed(err)
+				}
+				query := fmt.Sprintf(`INSERT INTO HardLink%sAttribute (Md5Hash, MD5, Type, FileName, FileSize, DirID1, DirID2, ModifyTime) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, _type)
+				if err := exec(tx, query, int64(binary.LittleEndian.Uint64(md5)), md5, 1, media.Name, media.Size, dir1, dir2, media.Time.Unix()); err != nil {
+					return err
+				}
+			}
+			return dirs.insert(tx, fmt.Sprintf(`INSERT INTO HardLink%sID (DirId, Dir) VALUES (?, ?)`, _type))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWindowsV3Messages 生成一个消息数据库，startMs 为毫秒时间戳
+func (w *writer) writeWindowsV3Messages(file string, startMs int64, messages []*Message) error {
+	schema := []string{windowsV3DBInfoSchema, windowsV3Name2IDSchema, windowsV3MsgSchema}
+	return w.createDB(file, schema, func(tx *sql.Tx) error {
+		if err := exec(tx, `INSERT INTO DBInfo (tableIndex, tableVersion, tableDesc) VALUES (?, ?, ?)`, 0, startMs, "Start Time"); err != nil {
+			return err
+		}
+
+		// 数据源按 Name2ID 的读取顺序为会话编号，读取时使用主键索引，因此按用户名排序写入
+		var talkers []string
+		seen := make(map[string]bool)
+		for _, m := range messages {
+			if !seen[m.Talker] {
+				seen[m.Talker] = true
+				talkers = append(talkers, m.Talker)
+			}
+		}
+		sort.Strings(talkers)
+		ids := newNameIDs()
+		for _, talker := range talkers {
+			ids.id(talker)
+		}
+		if err := ids.insert(tx, `INSERT INTO Name2ID (rowid, UsrName) VALUES (?, ?)`); err != nil {
+			return err
+		}
+
+		for _, m := range messages {
+			content, compressContent := m.Content, []byte(nil)
+			if m.Type == model.MessageTypeShare {
+				// 分享消息的 XML 使用 lz4 压缩保存在 CompressContent 中
+				b, err := lz4.Compress([]byte(m.Content))
+				if err != nil {
+					return errors.QueryFailed("CompressContent", err)
+				}
+				content, compressContent = "", b
+			}
+			bytesExtra, err := w.windowsV3BytesExtra(m)
+			if err != nil {
+				return err
+			}
+			if err := exec(tx, `INSERT INTO MSG (TalkerId, MsgSvrID, Type, SubType, IsSender, CreateTime, Sequence, StrTalker, StrContent, CompressContent, BytesExtra)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				ids.id(m.Talker), m.ServerID, m.Type, m.SubType, m.IsSelf, m.Time.Unix(), m.Seq, m.Talker, content, compressContent, bytesExtra); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// windowsV3BytesExtra 生成 BytesExtra，记录群聊发送人和图片路径
+func (w *writer) windowsV3BytesExtra(m *Message) ([]byte, error) {
+	extra := &wxproto.BytesExtra{Header: &wxproto.BytesExtraHeader{Field1: 1, Field2: 1}}
+	if m.IsChatRoom() && !m.IsSelf {
+		extra.Items = append(extra.Items, &wxproto.BytesExtraItem{Type: bytesExtraSender, Value: m.Sender})
+	}
+	if m.Type == model.MessageTypeImage {
+		dir := strings.Join([]string{w.ds.Self.UserName, "FileStorage", "MsgAttach", talkerMd5(m.Talker)}, `\`)
+		extra.Items = append(extra.Items,
+			&wxproto.BytesExtraItem{Type: bytesExtraThumb, Value: strings.Join([]string{dir, "Thumb", m.Media.Month(), m.Media.Md5 + "_t.dat"}, `\`)},
+			&wxproto.BytesExtraItem{Type: bytesExtraImage, Value: strings.Join([]string{dir, "Image", m.Media.Month(), m.Media.Name}, `\`)},
+		)
+	}
+	b, err := proto.Marshal(extra)
+	if err != nil {
+		return nil, errors.QueryFailed("BytesExtra", err)
+	}
+	return b, nil
+}
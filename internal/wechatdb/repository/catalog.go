@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetCatalog 汇总时间范围内分享的链接、文件、小程序和视频号
+// 目录不建立索引，每次请求扫描各会话的消息生成
+// talker 为空时使用时间范围内最近有消息的会话，最多 MaxScanTalkers 个，多个对话方以英文逗号分隔
+func (r *Repository) GetCatalog(ctx context.Context, startTime, endTime time.Time, talker, kind, keyword string, limit, offset int) (*model.Catalog, error) {
+	talkers, truncated, err := r.scanTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := model.NewCatalog(startTime, endTime, kind, keyword)
+	catalog.Talkers = len(talkers)
+	catalog.Truncated = truncated
+	for _, talker := range talkers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		for _, msg := range messages {
+			if msg.TalkerName == "" {
+				msg.TalkerName = r.displayName(msg.Talker)
+			}
+			catalog.AddMessage(msg)
+		}
+	}
+	catalog.Finish()
+	catalog.Page(limit, offset)
+
+	return catalog, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetChatRoomReport 根据群成员列表和群聊消息生成成员活跃度报告
+func (r *Repository) GetChatRoomReport(ctx context.Context, startTime, endTime time.Time, key string) (*model.ChatRoomReport, error) {
+	chatRoom, err := r.GetChatRoom(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := r.GetMessages(ctx, startTime, endTime, chatRoom.Name, "", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := model.NewChatRoomReport(chatRoom, r.chatRoomUserNames(chatRoom), startTime, endTime)
+	for _, msg := range messages {
+		report.AddMessage(msg)
+	}
+	report.Finish()
+
+	return report, nil
+}
+
+// chatRoomUserNames 获取群成员的可用名称，群昵称优先，联系人备注和昵称用于匹配 @ 和成员变动消息
+func (r *Repository) chatRoomUserNames(chatRoom *model.ChatRoom) map[string][]string {
+	userNames := make(map[string][]string, len(chatRoom.Users))
+	for _, user := range chatRoom.Users {
+		var names []string
+		if user.DisplayName != "" {
+			names = append(names, user.DisplayName)
+		}
+		if contact := r.getFullContact(user.UserName); contact != nil {
+			if contact.Remark != "" {
+				names = append(names, contact.Remark)
+			}
+			if contact.NickName != "" {
+				names = append(names, contact.NickName)
+			}
+		}
+		userNames[user.UserName] = names
+	}
+	return userNames
+}
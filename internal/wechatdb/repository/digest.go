@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetDigest 汇总时间范围内各对话的新消息
+// talker 为空时使用时间范围内有消息的全部会话，多个对话方以英文逗号分隔
+// self 为自己的微信 ID，为空时根据自己发送的消息识别
+func (r *Repository) GetDigest(ctx context.Context, startTime, endTime time.Time, talker string, self string) (*model.Digest, error) {
+	talkers, err := r.activeTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &model.Digest{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Talkers:   make([]*model.TalkerDigest, 0),
+	}
+	for _, talker := range talkers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		if self == "" {
+			self = detectSelf(messages)
+		}
+
+		var mentions *model.MentionMatcher
+		if strings.HasSuffix(talker, "@chatroom") {
+			mentions = r.mentionMatcher(talker, self)
+		}
+		td := model.NewTalkerDigest(talker, r.displayName(talker), self, mentions)
+		for _, msg := range messages {
+			td.AddMessage(msg)
+		}
+		td.Finish()
+		if td.Count == 0 {
+			continue
+		}
+		digest.Total += td.Count
+		digest.Talkers = append(digest.Talkers, td)
+	}
+
+	return digest, nil
+}
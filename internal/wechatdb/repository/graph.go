@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// GetGraph 根据会话、群成员、引用和 @ 关系生成联系人关系图
+// talker 为空时使用时间范围内有消息的全部会话，多个对话方以英文逗号分隔
+func (r *Repository) GetGraph(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Graph, error) {
+	talkers, _, err := r.scanTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	g := model.NewGraph(startTime, endTime)
+	for _, talker := range talkers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		if strings.HasSuffix(talker, "@chatroom") {
+			r.addChatRoomToGraph(g, talker, messages)
+		} else if len(messages) > 0 {
+			r.addContactToGraph(g, talker, messages)
+		}
+	}
+	g.Finish()
+
+	return g, nil
+}
+
+// MaxScanTalkers talker 为空时逐个会话扫描消息的会话数量上限
+const MaxScanTalkers = 200
+
+// activeTalkers 解析对话方列表，talker 为空时返回 startTime 之后有消息的全部会话，按最近消息时间倒序排列
+func (r *Repository) activeTalkers(ctx context.Context, startTime time.Time, talker string) ([]string, error) {
+	if talker != "" {
+		talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+		return util.Str2List(talker, ","), nil
+	}
+
+	sessions, err := r.GetSessions(ctx, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].NTime.After(sessions[j].NTime)
+	})
+	var talkers []string
+	for _, session := range sessions {
+		if session.NTime.Before(startTime) || strings.HasPrefix(session.UserName, "@") {
+			continue
+		}
+		talkers = append(talkers, session.UserName)
+	}
+	return talkers, nil
+}
+
+// scanTalkers 与 activeTalkers 相同，talker 为空时按最近消息时间最多返回 MaxScanTalkers 个会话
+// 关系图、目录、提及和未回复等接口需要逐个会话读取消息，truncated 表示有会话未被扫描
+func (r *Repository) scanTalkers(ctx context.Context, startTime time.Time, talker string) ([]string, bool, error) {
+	talkers, err := r.activeTalkers(ctx, startTime, talker)
+	if err != nil || talker != "" || len(talkers) <= MaxScanTalkers {
+		return talkers, false, err
+	}
+	log.Warn().Msgf("%d talkers are active since %s, only the latest %d are scanned, specify talker to narrow down", len(talkers), startTime.Format(time.DateTime), MaxScanTalkers)
+	return talkers[:MaxScanTalkers], true, nil
+}
+
+func (r *Repository) addContactToGraph(g *model.Graph, talker string, messages []*model.Message) {
+	g.AddNode(talker, r.displayName(talker), model.GraphNodeContact)
+	for _, msg := range messages {
+		if msg.Type == model.MessageTypeSystem {
+			continue
+		}
+		sender, target := talker, model.GraphSelfID
+		if msg.IsSelf {
+			sender, target = model.GraphSelfID, talker
+			g.SetAlias(msg.Sender, model.GraphSelfID)
+		}
+		g.AddNode(sender, "", "").Messages++
+		g.AddEdge(sender, target, model.GraphEdgeMessage, 1)
+		r.addQuoteToGraph(g, sender, msg)
+	}
+}
+
+func (r *Repository) addChatRoomToGraph(g *model.Graph, talker string, messages []*model.Message) {
+	chatRoom := r.chatRoomCache[talker]
+	label := ""
+	var mentions *model.MentionMatcher
+	if chatRoom != nil {
+		label = chatRoom.DisplayName()
+		userNames := r.chatRoomUserNames(chatRoom)
+		mentions = model.NewMentionMatcher(userNames)
+		for _, user := range chatRoom.Users {
+			g.AddNode(user.UserName, r.displayName(user.UserName), model.GraphNodeContact)
+			g.AddEdge(user.UserName, talker, model.GraphEdgeMember, 0)
+		}
+	} else if len(messages) == 0 {
+		return
+	}
+	g.AddNode(talker, label, model.GraphNodeChatRoom)
+
+	for _, msg := range messages {
+		if msg.Type == model.MessageTypeSystem {
+			continue
+		}
+		sender := msg.Sender
+		if msg.IsSelf {
+			g.SetAlias(msg.Sender, model.GraphSelfID)
+			sender = model.GraphSelfID
+		}
+		if sender == "" {
+			continue
+		}
+		g.AddNode(sender, r.displayName(sender), model.GraphNodeContact).Messages++
+		g.AddEdge(sender, talker, model.GraphEdgeMember, 1)
+		r.addQuoteToGraph(g, sender, msg)
+
+		if mentions != nil && msg.Type == model.MessageTypeText {
+			for _, user := range mentions.Match(msg.Content) {
+				g.AddEdge(sender, user, model.GraphEdgeMention, 1)
+			}
+		}
+	}
+}
+
+func (r *Repository) addQuoteToGraph(g *model.Graph, sender string, msg *model.Message) {
+	if msg.Type != model.MessageTypeShare || msg.SubType != model.MessageSubTypeQuote {
+		return
+	}
+	refer, ok := msg.Contents["refer"].(*model.Message)
+	if !ok || refer.Sender == "" {
+		return
+	}
+	g.AddNode(refer.Sender, r.displayName(refer.Sender), model.GraphNodeContact)
+	g.AddEdge(sender, refer.Sender, model.GraphEdgeQuote, 1)
+}
+
+// displayName 获取联系人或群聊的显示名称
+func (r *Repository) displayName(userName string) string {
+	if chatRoom, ok := r.chatRoomCache[userName]; ok {
+		return chatRoom.DisplayName()
+	}
+	if contact := r.getFullContact(userName); contact != nil {
+		return contact.DisplayName()
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetMentions 获取时间范围内群聊中 @我、@所有人 和引用我的消息，按时间倒序排列
+// talker 为空时使用时间范围内有消息的全部群聊，多个群聊以英文逗号分隔
+// self 为自己的微信 ID，为空时根据自己发送的消息识别
+func (r *Repository) GetMentions(ctx context.Context, startTime, endTime time.Time, talker string, self string) ([]*model.Mention, error) {
+	talkers, _, err := r.scanTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先读取全部群聊消息，以便在识别出自己的微信 ID 后再匹配 @
+	talkerMessages := make(map[string][]*model.Message)
+	for _, talker := range talkers {
+		if !strings.HasSuffix(talker, "@chatroom") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		talkerMessages[talker] = messages
+		if self == "" {
+			self = detectSelf(messages)
+		}
+	}
+
+	mentions := make([]*model.Mention, 0)
+	for talker, messages := range talkerMessages {
+		matcher := r.mentionMatcher(talker, self)
+		for _, msg := range messages {
+			_type := model.MentionOf(msg, self, matcher)
+			if _type == "" {
+				continue
+			}
+			if msg.TalkerName == "" {
+				msg.TalkerName = r.displayName(msg.Talker)
+			}
+			mentions = append(mentions, model.NewMention(msg, _type))
+		}
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		if !mentions[i].Time.Equal(mentions[j].Time) {
+			return mentions[i].Time.After(mentions[j].Time)
+		}
+		return mentions[i].Seq > mentions[j].Seq
+	})
+
+	return mentions, nil
+}
+
+// detectSelf 根据自己发送的消息识别自己的微信 ID
+func detectSelf(messages []*model.Message) string {
+	for _, msg := range messages {
+		if msg.IsSelf && msg.Sender != "" {
+			return msg.Sender
+		}
+	}
+	return ""
+}
+
+// mentionMatcher 创建群聊的 @ 匹配器，自己的名称包括群昵称和联系人昵称
+func (r *Repository) mentionMatcher(talker, self string) *model.MentionMatcher {
+	userNames := make(map[string][]string)
+	if chatRoom, ok := r.chatRoomCache[talker]; ok {
+		userNames = r.chatRoomUserNames(chatRoom)
+		if displayName := chatRoom.User2DisplayName[self]; displayName != "" && len(userNames[self]) == 0 {
+			userNames[self] = []string{displayName}
+		}
+	}
+	if self != "" {
+		if contact := r.getFullContact(self); contact != nil && contact.NickName != "" {
+			userNames[self] = append(userNames[self], contact.NickName)
+		}
+	}
+	return model.NewMentionMatcher(userNames)
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetPayments 汇总时间范围内的转账和红包
+// talker 为空时使用时间范围内有消息的全部会话，多个对话方以英文逗号分隔
+func (r *Repository) GetPayments(ctx context.Context, startTime, endTime time.Time, talker string) (*model.PaymentLedger, error) {
+	talkers, err := r.activeTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := model.NewPaymentLedger(startTime, endTime)
+	for _, talker := range talkers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		for _, msg := range messages {
+			if msg.TalkerName == "" {
+				msg.TalkerName = r.displayName(msg.Talker)
+			}
+			ledger.AddMessage(msg)
+		}
+	}
+	ledger.Finish()
+
+	return ledger, nil
+}
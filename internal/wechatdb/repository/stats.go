@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetStats 获取对话的消息统计，并补充对话和发送者的显示名称
+func (r *Repository) GetStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.Stats, error) {
+
+	talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	stats, err := r.ds.GetStats(ctx, startTime, endTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatRoom *model.ChatRoom
+	if stats.IsChatRoom {
+		chatRoom = r.chatRoomCache[stats.Talker]
+		if chatRoom != nil {
+			stats.TalkerName = chatRoom.DisplayName()
+		}
+	} else if contact := r.getFullContact(stats.Talker); contact != nil {
+		stats.TalkerName = contact.DisplayName()
+	}
+
+	for _, s := range stats.Senders {
+		if s.IsSelf {
+			continue
+		}
+		if chatRoom != nil {
+			if displayName, ok := chatRoom.User2DisplayName[s.Sender]; ok {
+				s.SenderName = displayName
+				continue
+			}
+		}
+		if contact := r.getFullContact(s.Sender); contact != nil {
+			s.SenderName = contact.DisplayName()
+		}
+	}
+
+	return stats, nil
+}
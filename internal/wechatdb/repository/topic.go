@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/nlp"
+)
+
+// topicMinMessages 未指定对话方时，参与话题分析的对话的最少消息数量
+const topicMinMessages = 20
+
+// GetTopics 提取时间范围内各对话的关键词、热词与话题
+// 热词与上一个等长时间范围内的消息对比得出
+// talker 为空时使用时间范围内有消息的全部会话，并忽略消息过少的对话，多个对话方以英文逗号分隔
+// limit 为每个对话返回的关键词、热词与话题数量，seg 为分词器
+func (r *Repository) GetTopics(ctx context.Context, startTime, endTime time.Time, talker string, limit int, seg *nlp.Segmenter) (*model.TopicReport, error) {
+	talkers, err := r.activeTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &model.TopicReport{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Talkers:   make([]*model.TalkerTopics, 0),
+	}
+	baseStart := startTime.Add(-endTime.Sub(startTime))
+	for _, t := range talkers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := r.GetMessages(ctx, startTime, endTime, t, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", t)
+			continue
+		}
+		if len(messages) == 0 || (talker == "" && len(messages) < topicMinMessages) {
+			continue
+		}
+
+		baseline, err := r.GetMessages(ctx, baseStart, startTime.Add(-time.Second), t, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get baseline messages of %s failed", t)
+		}
+
+		tt := model.NewTalkerTopics(t, r.displayName(t), messages, baseline, seg, limit)
+		if tt.Messages == 0 {
+			continue
+		}
+		report.Talkers = append(report.Talkers, tt)
+	}
+	sort.SliceStable(report.Talkers, func(i, j int) bool {
+		return report.Talkers[i].Messages > report.Talkers[j].Messages
+	})
+
+	return report, nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// unansweredSkipTalkers 不需要回复的系统账号
+var unansweredSkipTalkers = map[string]bool{
+	"filehelper":         true,
+	"weixin":             true,
+	"newsapp":            true,
+	"fmessage":           true,
+	"floatbottle":        true,
+	"medianote":          true,
+	"notifymessage":      true,
+	"qqmail":             true,
+	"qmessage":           true,
+	"tmessage":           true,
+	"brandsessionholder": true,
+}
+
+// GetUnanswered 查找时间范围内尚未回复的私聊消息，以及群聊中 @我 或引用我的消息
+// 仅返回最早一条未回复消息已超过 window 的对话，按等待时间降序排列
+// talker 为空时使用时间范围内有消息的全部会话，多个对话方以英文逗号分隔
+// self 为自己的微信 ID，为空时根据自己发送的消息识别
+func (r *Repository) GetUnanswered(ctx context.Context, startTime, endTime time.Time, talker string, self string, window time.Duration) ([]*model.Unanswered, error) {
+	talkers, _, err := r.scanTalkers(ctx, startTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	talkerMessages := make(map[string][]*model.Message)
+	for _, talker := range talkers {
+		if unansweredSkipTalkers[talker] || strings.HasPrefix(talker, "gh_") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		messages, err := r.GetMessages(ctx, startTime, endTime, talker, "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages of %s failed", talker)
+			continue
+		}
+		talkerMessages[talker] = messages
+		if self == "" {
+			self = detectSelf(messages)
+		}
+	}
+
+	now := time.Now()
+	items := make([]*model.Unanswered, 0)
+	for talker, messages := range talkerMessages {
+		var mentions *model.MentionMatcher
+		if strings.HasSuffix(talker, "@chatroom") {
+			mentions = r.mentionMatcher(talker, self)
+		}
+		u := model.FindUnanswered(talker, r.displayName(talker), messages, self, mentions)
+		if u == nil || now.Sub(u.Time) < window {
+			continue
+		}
+		u.Age = int64(now.Sub(u.Time).Seconds())
+		items = append(items, u)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Age != items[j].Age {
+			return items[i].Age > items[j].Age
+		}
+		return items[i].Talker < items[j].Talker
+	})
+
+	return items, nil
+}
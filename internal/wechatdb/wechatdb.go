@@ -10,20 +10,23 @@ import (
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/internal/wechatdb/datasource"
 	"github.com/sjzar/chatlog/internal/wechatdb/repository"
+	"github.com/sjzar/chatlog/pkg/util/nlp"
 )
 
 type DB struct {
 	path     string
+	key      string
 	platform string
 	version  int
 	ds       datasource.DataSource
 	repo     *repository.Repository
 }
 
-func New(path string, platform string, version int) (*DB, error) {
+func New(path string, platform string, version int, key string) (*DB, error) {
 
 	w := &DB{
 		path:     path,
+		key:      key,
 		platform: platform,
 		version:  version,
 	}
@@ -45,7 +48,7 @@ func (w *DB) Close() error {
 
 func (w *DB) Initialize() error {
 	var err error
-	w.ds, err = datasource.New(w.path, w.platform, w.version)
+	w.ds, err = datasource.New(w.path, w.platform, w.version, w.key)
 	if err != nil {
 		return err
 	}
@@ -70,6 +73,51 @@ func (w *DB) GetMessages(start, end time.Time, talker string, sender string, key
 	return messages, nil
 }
 
+func (w *DB) GetStats(start, end time.Time, talker string) (*model.Stats, error) {
+	ctx := context.Background()
+	return w.repo.GetStats(ctx, start, end, talker)
+}
+
+func (w *DB) GetChatRoomReport(start, end time.Time, key string) (*model.ChatRoomReport, error) {
+	ctx := context.Background()
+	return w.repo.GetChatRoomReport(ctx, start, end, key)
+}
+
+func (w *DB) GetGraph(start, end time.Time, talker string) (*model.Graph, error) {
+	ctx := context.Background()
+	return w.repo.GetGraph(ctx, start, end, talker)
+}
+
+func (w *DB) GetDigest(start, end time.Time, talker string, self string) (*model.Digest, error) {
+	ctx := context.Background()
+	return w.repo.GetDigest(ctx, start, end, talker, self)
+}
+
+func (w *DB) GetCatalog(start, end time.Time, talker, kind, keyword string, limit, offset int) (*model.Catalog, error) {
+	ctx := context.Background()
+	return w.repo.GetCatalog(ctx, start, end, talker, kind, keyword, limit, offset)
+}
+
+func (w *DB) GetPayments(start, end time.Time, talker string) (*model.PaymentLedger, error) {
+	ctx := context.Background()
+	return w.repo.GetPayments(ctx, start, end, talker)
+}
+
+func (w *DB) GetMentions(start, end time.Time, talker string, self string) ([]*model.Mention, error) {
+	ctx := context.Background()
+	return w.repo.GetMentions(ctx, start, end, talker, self)
+}
+
+func (w *DB) GetUnanswered(start, end time.Time, talker string, self string, window time.Duration) ([]*model.Unanswered, error) {
+	ctx := context.Background()
+	return w.repo.GetUnanswered(ctx, start, end, talker, self, window)
+}
+
+func (w *DB) GetTopics(start, end time.Time, talker string, limit int, seg *nlp.Segmenter) (*model.TopicReport, error) {
+	ctx := context.Background()
+	return w.repo.GetTopics(ctx, start, end, talker, limit, seg)
+}
+
 type GetContactsResp struct {
 	Items []*model.Contact `json:"items"`
 }
@@ -87,6 +135,10 @@ func (w *DB) GetContacts(key string, limit, offset int) (*GetContactsResp, error
 	}, nil
 }
 
+func (w *DB) GetContact(key string) (*model.Contact, error) {
+	return w.repo.GetContact(context.Background(), key)
+}
+
 type GetChatRoomsResp struct {
 	Items []*model.ChatRoom `json:"items"`
 }
@@ -104,6 +156,10 @@ func (w *DB) GetChatRooms(key string, limit, offset int) (*GetChatRoomsResp, err
 	}, nil
 }
 
+func (w *DB) GetChatRoom(key string) (*model.ChatRoom, error) {
+	return w.repo.GetChatRoom(context.Background(), key)
+}
+
 type GetSessionsResp struct {
 	Items []*model.Session `json:"items"`
 }
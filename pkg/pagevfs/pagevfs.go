@@ -0,0 +1,287 @@
+// Package pagevfs registers a SQLite VFS that reads and writes database files page by page
+// through Go code, so that databases stored in a custom page format (for example encrypted pages)
+// can be queried and written by SQLite without a plaintext copy on disk or in memory.
+//
+// A database is opened through the VFS by registering an OpenFunc and passing the returned ID
+// in the database URI:
+//
+//	id := pagevfs.Register(open)
+//	defer pagevfs.Unregister(id)
+//	db, err := sql.Open("sqlite3", pagevfs.URI(path, id)+"&mode=ro")
+//
+// Only the main database file is handled by the OpenFunc, journals and temporary files
+// are opened by the default VFS.
+package pagevfs
+
+/*
+#include "pagevfs.h"
+*/
+import "C"
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	// sqlite3 library
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Name is the name of the VFS.
+const Name = C.PAGEVFS_NAME
+
+// File is a database file accessed page by page.
+// Page numbers start at 0, page 0 starts with the SQLite header.
+type File interface {
+	// PageSize returns the page size of the database.
+	PageSize() int
+
+	// ReadPage reads page pageNum into page, len(page) is the page size.
+	ReadPage(pageNum int64, page []byte) error
+
+	// WritePage writes page pageNum.
+	WritePage(pageNum int64, page []byte) error
+
+	// Size returns the size of the database in bytes.
+	Size() (int64, error)
+
+	// Truncate truncates the database to size bytes.
+	Truncate(size int64) error
+
+	// Sync commits the written pages to stable storage.
+	Sync() error
+
+	// Close closes the file.
+	Close() error
+}
+
+// OpenFunc opens a registered database, files opened for writing are created when missing.
+// Each database connection opens its own File.
+type OpenFunc func(readOnly bool) (File, error)
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+
+	mu         sync.Mutex
+	nextID     int64
+	nextHandle int64
+	opens      = make(map[string]OpenFunc)
+	handles    = make(map[int64]*handle)
+)
+
+// register registers the VFS with SQLite once.
+func register() error {
+	registerOnce.Do(func() {
+		if rc := C.pagevfsRegister(); rc != C.SQLITE_OK {
+			registerErr = errorCode(rc)
+		}
+	})
+	return registerErr
+}
+
+// Register registers open and returns the ID to pass in the database URI.
+func Register(open OpenFunc) (string, error) {
+	if err := register(); err != nil {
+		return "", err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	nextID++
+	id := strconv.FormatInt(nextID, 10)
+	opens[id] = open
+	return id, nil
+}
+
+// Unregister removes a registered OpenFunc, files already opened are not affected.
+func Unregister(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(opens, id)
+}
+
+// URI returns the URI opening path with the OpenFunc registered as id.
+// More parameters can be appended with "&".
+func URI(path string, id string) string {
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String() + "?vfs=" + Name + "&" + C.PAGEVFS_PARAM + "=" + id
+}
+
+// handle is an open registered file.
+type handle struct {
+	f    File
+	page []byte
+}
+
+func getHandle(h C.longlong) *handle {
+	mu.Lock()
+	defer mu.Unlock()
+	return handles[int64(h)]
+}
+
+//export pagevfsOpen
+func pagevfsOpen(id *C.char, flags C.int, out *C.longlong) C.int {
+	mu.Lock()
+	open, ok := opens[C.GoString(id)]
+	mu.Unlock()
+	if !ok {
+		return C.SQLITE_CANTOPEN
+	}
+
+	f, err := open(flags&C.SQLITE_OPEN_READONLY != 0)
+	if err != nil {
+		return C.SQLITE_CANTOPEN
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	nextHandle++
+	handles[nextHandle] = &handle{f: f, page: make([]byte, f.PageSize())}
+	*out = C.longlong(nextHandle)
+	return C.SQLITE_OK
+}
+
+//export pagevfsClose
+func pagevfsClose(h C.longlong) C.int {
+	mu.Lock()
+	hd := handles[int64(h)]
+	delete(handles, int64(h))
+	mu.Unlock()
+	if hd == nil {
+		return C.SQLITE_OK
+	}
+	if err := hd.f.Close(); err != nil {
+		return C.SQLITE_IOERR_CLOSE
+	}
+	return C.SQLITE_OK
+}
+
+//export pagevfsRead
+func pagevfsRead(h C.longlong, buf unsafe.Pointer, amt C.int, off C.longlong) C.int {
+	hd := getHandle(h)
+	if hd == nil {
+		return C.SQLITE_IOERR_READ
+	}
+	p := unsafe.Slice((*byte)(buf), int(amt))
+	n, err := hd.readAt(p, int64(off))
+	if err != nil {
+		return C.SQLITE_IOERR_READ
+	}
+	if n < len(p) {
+		clear(p[n:])
+		return C.SQLITE_IOERR_SHORT_READ
+	}
+	return C.SQLITE_OK
+}
+
+//export pagevfsWrite
+func pagevfsWrite(h C.longlong, buf unsafe.Pointer, amt C.int, off C.longlong) C.int {
+	hd := getHandle(h)
+	if hd == nil {
+		return C.SQLITE_IOERR_WRITE
+	}
+	if err := hd.writeAt(unsafe.Slice((*byte)(buf), int(amt)), int64(off)); err != nil {
+		return C.SQLITE_IOERR_WRITE
+	}
+	return C.SQLITE_OK
+}
+
+//export pagevfsTruncate
+func pagevfsTruncate(h C.longlong, size C.longlong) C.int {
+	hd := getHandle(h)
+	if hd == nil || hd.f.Truncate(int64(size)) != nil {
+		return C.SQLITE_IOERR_TRUNCATE
+	}
+	return C.SQLITE_OK
+}
+
+//export pagevfsSync
+func pagevfsSync(h C.longlong) C.int {
+	hd := getHandle(h)
+	if hd == nil || hd.f.Sync() != nil {
+		return C.SQLITE_IOERR_FSYNC
+	}
+	return C.SQLITE_OK
+}
+
+//export pagevfsFileSize
+func pagevfsFileSize(h C.longlong, out *C.longlong) C.int {
+	hd := getHandle(h)
+	if hd == nil {
+		return C.SQLITE_IOERR_FSTAT
+	}
+	size, err := hd.f.Size()
+	if err != nil {
+		return C.SQLITE_IOERR_FSTAT
+	}
+	*out = C.longlong(size)
+	return C.SQLITE_OK
+}
+
+// readAt reads the pages covering p, returns the number of bytes read before the end of the file.
+func (hd *handle) readAt(p []byte, off int64) (int, error) {
+	size, err := hd.f.Size()
+	if err != nil {
+		return 0, err
+	}
+	pageSize := int64(len(hd.page))
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		pageNum := pos / pageSize
+		if pageNum*pageSize >= size {
+			break
+		}
+		if err := hd.f.ReadPage(pageNum, hd.page); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], hd.page[pos-pageNum*pageSize:])
+	}
+	return n, nil
+}
+
+// writeAt writes the pages covering p, partially written pages are read and merged first.
+func (hd *handle) writeAt(p []byte, off int64) error {
+	size, err := hd.f.Size()
+	if err != nil {
+		return err
+	}
+	pageSize := int64(len(hd.page))
+	for n := 0; n < len(p); {
+		pos := off + int64(n)
+		pageNum := pos / pageSize
+		start := pos - pageNum*pageSize
+		if start == 0 && int64(len(p)-n) >= pageSize {
+			if err := hd.f.WritePage(pageNum, p[n:n+int(pageSize)]); err != nil {
+				return err
+			}
+			n += int(pageSize)
+			continue
+		}
+
+		if pageNum*pageSize < size {
+			if err := hd.f.ReadPage(pageNum, hd.page); err != nil {
+				return err
+			}
+		} else {
+			clear(hd.page)
+		}
+		n += copy(hd.page[start:], p[n:])
+		if err := hd.f.WritePage(pageNum, hd.page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Error is a SQLite result code returned by the VFS.
+type Error int
+
+func (e Error) Error() string {
+	return "pagevfs: sqlite error " + strconv.Itoa(int(e))
+}
+
+func errorCode(rc C.int) error {
+	return Error(rc)
+}
@@ -0,0 +1,12 @@
+#ifndef PAGEVFS_H
+#define PAGEVFS_H
+
+#include "sqlite3vfs.h"
+
+// PAGEVFS_NAME is the name of the VFS, PAGEVFS_PARAM the URI parameter holding the file ID.
+#define PAGEVFS_NAME  "pagevfs"
+#define PAGEVFS_PARAM "pagevfs"
+
+int pagevfsRegister(void);
+
+#endif
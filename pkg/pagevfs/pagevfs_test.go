@@ -0,0 +1,173 @@
+package pagevfs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// xorFile stores every byte XORed with 0x5a, so the file never contains plaintext
+type xorFile struct {
+	f        *os.File
+	pageSize int
+}
+
+func openXorFile(path string, pageSize int) OpenFunc {
+	return func(readOnly bool) (File, error) {
+		flag := os.O_RDWR | os.O_CREATE
+		if readOnly {
+			flag = os.O_RDONLY
+		}
+		f, err := os.OpenFile(path, flag, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &xorFile{f: f, pageSize: pageSize}, nil
+	}
+}
+
+func xor(p []byte) []byte {
+	out := make([]byte, len(p))
+	for i, b := range p {
+		out[i] = b ^ 0x5a
+	}
+	return out
+}
+
+func (x *xorFile) PageSize() int { return x.pageSize }
+
+func (x *xorFile) ReadPage(pageNum int64, page []byte) error {
+	if _, err := x.f.ReadAt(page, pageNum*int64(x.pageSize)); err != nil {
+		return err
+	}
+	copy(page, xor(page))
+	return nil
+}
+
+func (x *xorFile) WritePage(pageNum int64, page []byte) error {
+	_, err := x.f.WriteAt(xor(page), pageNum*int64(x.pageSize))
+	return err
+}
+
+func (x *xorFile) Size() (int64, error) {
+	info, err := x.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (x *xorFile) Truncate(size int64) error { return x.f.Truncate(size) }
+func (x *xorFile) Sync() error               { return x.f.Sync() }
+func (x *xorFile) Close() error              { return x.f.Close() }
+
+func registerXor(t *testing.T, path string) string {
+	t.Helper()
+	id, err := Register(openXorFile(path, 4096))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	t.Cleanup(func() { Unregister(id) })
+	return id
+}
+
+func queryRows(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT v FROM t ORDER BY rowid")
+	if err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestVFS(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	id := registerXor(t, path)
+
+	db, err := sql.Open("sqlite3", URI(path, id)+"&_journal_mode=OFF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []string{
+		"CREATE TABLE t (v TEXT)",
+		"INSERT INTO t VALUES ('plaintext marker 1'), ('plaintext marker 2')",
+		"INSERT INTO t SELECT 'plaintext marker ' || (rowid + 2) FROM t",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s error = %v", q, err)
+		}
+	}
+	db.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("plaintext marker")) || bytes.Contains(data, []byte("SQLite format 3")) {
+		t.Fatalf("database file contains plaintext")
+	}
+
+	// VACUUM INTO 另一个注册的文件，同时修改每页的保留字节数
+	db, err = sql.Open("sqlite3", URI(path, id)+"&mode=ro&immutable=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := []string{"plaintext marker 1", "plaintext marker 2", "plaintext marker 3", "plaintext marker 4"}
+	if got := queryRows(t, db); !slices.Equal(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+
+	output := filepath.Join(dir, "output.db")
+	outputID := registerXor(t, output)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Raw(func(c any) error {
+		return c.(*sqlite3.SQLiteConn).SetFileControlInt("main", 38, 80) // SQLITE_FCNTL_RESERVE_BYTES
+	})
+	if err != nil {
+		t.Fatalf("set reserve bytes error = %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "VACUUM INTO ?", URI(output, outputID)); err != nil {
+		t.Fatalf("VACUUM INTO error = %v", err)
+	}
+	conn.Close()
+
+	data, err = os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("plaintext marker")) {
+		t.Fatalf("output file contains plaintext")
+	}
+	if header := xor(data[:100]); header[20] != 80 {
+		t.Fatalf("output reserve = %d, want 80", header[20])
+	}
+	out, err := sql.Open("sqlite3", URI(output, outputID)+"&mode=ro&immutable=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if got := queryRows(t, out); !slices.Equal(got, want) {
+		t.Fatalf("output rows = %v, want %v", got, want)
+	}
+}
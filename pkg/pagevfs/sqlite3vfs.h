@@ -0,0 +1,78 @@
+// Declarations from sqlite3.h needed to register a VFS.
+// The sqlite3 library itself is linked in by github.com/mattn/go-sqlite3.
+
+#ifndef PAGEVFS_SQLITE3VFS_H
+#define PAGEVFS_SQLITE3VFS_H
+
+typedef long long int sqlite3_int64;
+
+#define SQLITE_OK                  0
+#define SQLITE_IOERR              10
+#define SQLITE_NOTFOUND           12
+#define SQLITE_CANTOPEN           14
+#define SQLITE_IOERR_READ         (SQLITE_IOERR | (1 << 8))
+#define SQLITE_IOERR_SHORT_READ   (SQLITE_IOERR | (2 << 8))
+#define SQLITE_IOERR_WRITE        (SQLITE_IOERR | (3 << 8))
+#define SQLITE_IOERR_FSYNC        (SQLITE_IOERR | (4 << 8))
+#define SQLITE_IOERR_TRUNCATE     (SQLITE_IOERR | (6 << 8))
+#define SQLITE_IOERR_FSTAT        (SQLITE_IOERR | (7 << 8))
+#define SQLITE_IOERR_CLOSE        (SQLITE_IOERR | (16 << 8))
+
+#define SQLITE_OPEN_READONLY      0x00000001
+#define SQLITE_OPEN_MAIN_DB       0x00000100
+
+typedef struct sqlite3_file sqlite3_file;
+typedef struct sqlite3_io_methods sqlite3_io_methods;
+typedef struct sqlite3_vfs sqlite3_vfs;
+typedef void (*sqlite3_syscall_ptr)(void);
+
+struct sqlite3_file {
+  const struct sqlite3_io_methods *pMethods;
+};
+
+struct sqlite3_io_methods {
+  int iVersion;
+  int (*xClose)(sqlite3_file*);
+  int (*xRead)(sqlite3_file*, void*, int iAmt, sqlite3_int64 iOfst);
+  int (*xWrite)(sqlite3_file*, const void*, int iAmt, sqlite3_int64 iOfst);
+  int (*xTruncate)(sqlite3_file*, sqlite3_int64 size);
+  int (*xSync)(sqlite3_file*, int flags);
+  int (*xFileSize)(sqlite3_file*, sqlite3_int64 *pSize);
+  int (*xLock)(sqlite3_file*, int);
+  int (*xUnlock)(sqlite3_file*, int);
+  int (*xCheckReservedLock)(sqlite3_file*, int *pResOut);
+  int (*xFileControl)(sqlite3_file*, int op, void *pArg);
+  int (*xSectorSize)(sqlite3_file*);
+  int (*xDeviceCharacteristics)(sqlite3_file*);
+};
+
+struct sqlite3_vfs {
+  int iVersion;
+  int szOsFile;
+  int mxPathname;
+  sqlite3_vfs *pNext;
+  const char *zName;
+  void *pAppData;
+  int (*xOpen)(sqlite3_vfs*, const char *zName, sqlite3_file*, int flags, int *pOutFlags);
+  int (*xDelete)(sqlite3_vfs*, const char *zName, int syncDir);
+  int (*xAccess)(sqlite3_vfs*, const char *zName, int flags, int *pResOut);
+  int (*xFullPathname)(sqlite3_vfs*, const char *zName, int nOut, char *zOut);
+  void *(*xDlOpen)(sqlite3_vfs*, const char *zFilename);
+  void (*xDlError)(sqlite3_vfs*, int nByte, char *zErrMsg);
+  void (*(*xDlSym)(sqlite3_vfs*, void*, const char *zSymbol))(void);
+  void (*xDlClose)(sqlite3_vfs*, void*);
+  int (*xRandomness)(sqlite3_vfs*, int nByte, char *zOut);
+  int (*xSleep)(sqlite3_vfs*, int microseconds);
+  int (*xCurrentTime)(sqlite3_vfs*, double*);
+  int (*xGetLastError)(sqlite3_vfs*, int, char *);
+  int (*xCurrentTimeInt64)(sqlite3_vfs*, sqlite3_int64*);
+  int (*xSetSystemCall)(sqlite3_vfs*, const char *zName, sqlite3_syscall_ptr);
+  sqlite3_syscall_ptr (*xGetSystemCall)(sqlite3_vfs*, const char *zName);
+  const char *(*xNextSystemCall)(sqlite3_vfs*, const char *zName);
+};
+
+extern sqlite3_vfs *sqlite3_vfs_find(const char *zVfsName);
+extern int sqlite3_vfs_register(sqlite3_vfs*, int makeDflt);
+extern const char *sqlite3_uri_parameter(const char *zFilename, const char *zParam);
+
+#endif
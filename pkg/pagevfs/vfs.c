@@ -0,0 +1,114 @@
+#include "pagevfs.h"
+#include "_cgo_export.h"
+
+// pageFile is the sqlite3_file of a registered database, the pages are handled in Go.
+// Other files share the same allocation and are opened by the default VFS.
+typedef struct pageFile {
+  sqlite3_file base;
+  sqlite3_int64 handle;
+} pageFile;
+
+static sqlite3_vfs pageVfs;
+static sqlite3_vfs *defaultVfs;
+
+static int pageClose(sqlite3_file *f) {
+  return pagevfsClose(((pageFile*)f)->handle);
+}
+
+static int pageRead(sqlite3_file *f, void *buf, int amt, sqlite3_int64 off) {
+  return pagevfsRead(((pageFile*)f)->handle, buf, amt, off);
+}
+
+static int pageWrite(sqlite3_file *f, const void *buf, int amt, sqlite3_int64 off) {
+  return pagevfsWrite(((pageFile*)f)->handle, (void*)buf, amt, off);
+}
+
+static int pageTruncate(sqlite3_file *f, sqlite3_int64 size) {
+  return pagevfsTruncate(((pageFile*)f)->handle, size);
+}
+
+static int pageSync(sqlite3_file *f, int flags) {
+  return pagevfsSync(((pageFile*)f)->handle);
+}
+
+static int pageFileSize(sqlite3_file *f, sqlite3_int64 *size) {
+  return pagevfsFileSize(((pageFile*)f)->handle, size);
+}
+
+// Registered databases are opened by a single process, locking is left to the caller.
+static int pageLock(sqlite3_file *f, int lock) {
+  return SQLITE_OK;
+}
+
+static int pageCheckReservedLock(sqlite3_file *f, int *out) {
+  *out = 0;
+  return SQLITE_OK;
+}
+
+static int pageFileControl(sqlite3_file *f, int op, void *arg) {
+  return SQLITE_NOTFOUND;
+}
+
+static int pageSectorSize(sqlite3_file *f) {
+  return 4096;
+}
+
+static int pageDeviceCharacteristics(sqlite3_file *f) {
+  return 0;
+}
+
+static const sqlite3_io_methods pageIoMethods = {
+  1,
+  pageClose,
+  pageRead,
+  pageWrite,
+  pageTruncate,
+  pageSync,
+  pageFileSize,
+  pageLock,
+  pageLock,
+  pageCheckReservedLock,
+  pageFileControl,
+  pageSectorSize,
+  pageDeviceCharacteristics,
+};
+
+static int pageOpen(sqlite3_vfs *vfs, const char *name, sqlite3_file *f, int flags, int *outFlags) {
+  const char *id = 0;
+  if (name && (flags & SQLITE_OPEN_MAIN_DB)) {
+    id = sqlite3_uri_parameter(name, PAGEVFS_PARAM);
+  }
+  if (!id) {
+    return defaultVfs->xOpen(defaultVfs, name, f, flags, outFlags);
+  }
+
+  pageFile *p = (pageFile*)f;
+  p->base.pMethods = 0;
+  int rc = pagevfsOpen((char*)id, flags, &p->handle);
+  if (rc != SQLITE_OK) {
+    return rc;
+  }
+  p->base.pMethods = &pageIoMethods;
+  if (outFlags) {
+    *outFlags = flags;
+  }
+  return SQLITE_OK;
+}
+
+int pagevfsRegister(void) {
+  if (sqlite3_vfs_find(PAGEVFS_NAME)) {
+    return SQLITE_OK;
+  }
+  defaultVfs = sqlite3_vfs_find(0);
+  if (!defaultVfs) {
+    return SQLITE_CANTOPEN;
+  }
+  pageVfs = *defaultVfs;
+  pageVfs.pNext = 0;
+  pageVfs.zName = PAGEVFS_NAME;
+  if (pageVfs.szOsFile < (int)sizeof(pageFile)) {
+    pageVfs.szOsFile = sizeof(pageFile);
+  }
+  pageVfs.xOpen = pageOpen;
+  return sqlite3_vfs_register(&pageVfs, 0);
+}
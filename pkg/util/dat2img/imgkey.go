@@ -3,6 +3,7 @@ package dat2img
 import (
 	"bytes"
 	"crypto/aes"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -68,5 +69,54 @@ func (v *AesKeyValidator) Validate(key []byte) bool {
 	decrypted := make([]byte, len(v.EncryptedData))
 	cipher.Decrypt(decrypted, v.EncryptedData)
 
-	return bytes.HasPrefix(decrypted, JPG.Header) || bytes.HasPrefix(decrypted, WXGF.Header)
+	// Accept every image type Dat2ImageV4 recognizes, samples are not always JPG
+	for _, format := range Formats {
+		if bytes.HasPrefix(decrypted, format.Header) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImgKeySamples is the number of samples used to validate an image key.
+const ImgKeySamples = 3
+
+// NewImgKeyValidators collects up to n AES encrypted samples from V4Format2 *.dat files
+// (including *_t.dat thumbnails) and returns one validator per sample.
+// A key is only trusted when it passes every validator, which keeps false positives
+// low when checking a large number of candidates.
+func NewImgKeyValidators(path string, n int) []*AesKeyValidator {
+	var validators []*AesKeyValidator
+
+	filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".dat") {
+			return nil
+		}
+
+		// Only the header and the first block are needed
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil
+		}
+		data := make([]byte, 15+aes.BlockSize)
+		_, err = io.ReadFull(f, data)
+		f.Close()
+		if err != nil || !bytes.Equal(data[:4], V4Format2.Header) {
+			return nil
+		}
+
+		validators = append(validators, &AesKeyValidator{
+			Path:          filePath,
+			EncryptedData: data[15 : 15+aes.BlockSize],
+		})
+		if len(validators) >= n {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return validators
 }
@@ -0,0 +1,47 @@
+package dat2img
+
+import (
+	"crypto/aes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeV4Dat writes a V4Format2 dat file whose first AES block starts with header
+func writeV4Dat(t *testing.T, path string, key []byte, header []byte) {
+	t.Helper()
+	block := make([]byte, aes.BlockSize)
+	copy(block, header)
+	cipher, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 15+aes.BlockSize)
+	copy(data, V4Format2.Header)
+	cipher.Encrypt(data[15:], block)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImgKeyValidatorsMixedFormats(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	dir := t.TempDir()
+	formats := []Format{JPG, PNG, GIF, BMP, WXGF}
+	for _, f := range formats {
+		writeV4Dat(t, filepath.Join(dir, f.Ext+".dat"), key, f.Header)
+	}
+
+	validators := NewImgKeyValidators(dir, len(formats))
+	if len(validators) != len(formats) {
+		t.Fatalf("NewImgKeyValidators() = %d validators, want %d", len(validators), len(formats))
+	}
+	for _, v := range validators {
+		if !v.Validate(key) {
+			t.Errorf("Validate(key) = false for %s", filepath.Base(v.Path))
+		}
+		if v.Validate([]byte("fedcba9876543210")) {
+			t.Errorf("Validate(wrong key) = true for %s", filepath.Base(v.Path))
+		}
+	}
+}
@@ -14,3 +14,15 @@ func Decompress(src []byte) ([]byte, error) {
 	}
 	return out[:n], nil
 }
+
+// Compress 压缩为 lz4 块，是 Decompress 的逆过程
+func Compress(src []byte) ([]byte, error) {
+	out := make([]byte, lz4.CompressBlockBound(len(src)))
+
+	var c lz4.Compressor
+	n, err := c.CompressBlock(src, out)
+	if err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
@@ -0,0 +1,214 @@
+package nlp
+
+import (
+	"math"
+	"sort"
+)
+
+// Term is a keyword with its occurrence count and score
+type Term struct {
+	Word  string  `json:"word"`
+	Count int     `json:"count"`
+	Score float64 `json:"score"`
+}
+
+// Cluster is a group of similar documents
+type Cluster struct {
+	Docs  []int  `json:"docs"`
+	Terms []Term `json:"terms"`
+}
+
+// Corpus collects documents for TF-IDF scoring and clustering
+type Corpus struct {
+	docs  []map[string]int
+	df    map[string]int
+	tf    map[string]int
+	total int
+}
+
+func NewCorpus() *Corpus {
+	return &Corpus{
+		df: make(map[string]int),
+		tf: make(map[string]int),
+	}
+}
+
+// Add adds a document and returns its index
+func (c *Corpus) Add(terms []string) int {
+	doc := make(map[string]int, len(terms))
+	for _, t := range terms {
+		doc[t]++
+		c.tf[t]++
+	}
+	for t := range doc {
+		c.df[t]++
+	}
+	c.total += len(terms)
+	c.docs = append(c.docs, doc)
+	return len(c.docs) - 1
+}
+
+// Len returns the number of documents
+func (c *Corpus) Len() int {
+	return len(c.docs)
+}
+
+// Count returns the number of occurrences of word in the corpus
+func (c *Corpus) Count(word string) int {
+	return c.tf[word]
+}
+
+// IDF returns the smoothed inverse document frequency of word
+func (c *Corpus) IDF(word string) float64 {
+	return math.Log(float64(len(c.docs)+1)/float64(c.df[word]+1)) + 1
+}
+
+// Keywords returns the top n terms ranked by TF-IDF, terms occurring less
+// than minCount times are ignored
+func (c *Corpus) Keywords(n, minCount int) []Term {
+	terms := make([]Term, 0)
+	for word, count := range c.tf {
+		if count < minCount {
+			continue
+		}
+		terms = append(terms, Term{Word: word, Count: count, Score: float64(count) * c.IDF(word)})
+	}
+	return topTerms(terms, n)
+}
+
+// Trending returns the top n terms whose frequency grew the most compared
+// with the base corpus, terms occurring less than minCount times are ignored
+func (c *Corpus) Trending(base *Corpus, n, minCount int) []Term {
+	terms := make([]Term, 0)
+	if c.total == 0 {
+		return terms
+	}
+	baseTotal := 1
+	if base != nil && base.total > 0 {
+		baseTotal = base.total
+	}
+	for word, count := range c.tf {
+		if count < minCount {
+			continue
+		}
+		baseCount := 0
+		if base != nil {
+			baseCount = base.tf[word]
+		}
+		// relative frequency growth, smoothed so that new terms do not dominate,
+		// weighted by the log count so that frequent terms rank higher
+		rate := float64(count) / float64(c.total)
+		baseRate := (float64(baseCount) + 1) / float64(baseTotal+1)
+		growth := rate / baseRate
+		if growth <= 1 {
+			continue
+		}
+		terms = append(terms, Term{Word: word, Count: count, Score: math.Log(growth) * math.Log1p(float64(count))})
+	}
+	return topTerms(terms, n)
+}
+
+// Cluster groups documents by single pass clustering on TF-IDF vectors.
+// A document joins the most similar cluster if the cosine similarity with
+// its centroid reaches threshold, otherwise it starts a new cluster.
+// Clusters with less than minSize documents are dropped, the rest are
+// returned by size with up to n top terms each.
+func (c *Corpus) Cluster(threshold float64, minSize, n int) []Cluster {
+	type cluster struct {
+		docs     []int
+		centroid map[string]float64
+	}
+	var clusters []*cluster
+	for i, doc := range c.docs {
+		vec := c.vector(doc)
+		if len(vec) == 0 {
+			continue
+		}
+		var best *cluster
+		bestSim := threshold
+		for _, cl := range clusters {
+			if sim := cosine(vec, cl.centroid); sim >= bestSim {
+				best, bestSim = cl, sim
+			}
+		}
+		if best == nil {
+			best = &cluster{centroid: make(map[string]float64)}
+			clusters = append(clusters, best)
+		}
+		best.docs = append(best.docs, i)
+		for t, w := range vec {
+			best.centroid[t] += w
+		}
+	}
+
+	ret := make([]Cluster, 0)
+	for _, cl := range clusters {
+		if len(cl.docs) < minSize {
+			continue
+		}
+		terms := make([]Term, 0, len(cl.centroid))
+		for t, w := range cl.centroid {
+			count := 0
+			for _, d := range cl.docs {
+				count += c.docs[d][t]
+			}
+			terms = append(terms, Term{Word: t, Count: count, Score: w})
+		}
+		ret = append(ret, Cluster{Docs: cl.docs, Terms: topTerms(terms, n)})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return len(ret[i].Docs) > len(ret[j].Docs)
+	})
+	return ret
+}
+
+// vector returns the normalized TF-IDF vector of a document
+func (c *Corpus) vector(doc map[string]int) map[string]float64 {
+	vec := make(map[string]float64, len(doc))
+	norm := 0.0
+	for t, count := range doc {
+		w := float64(count) * c.IDF(t)
+		vec[t] = w
+		norm += w * w
+	}
+	norm = math.Sqrt(norm)
+	for t := range vec {
+		vec[t] /= norm
+	}
+	return vec
+}
+
+func cosine(a, b map[string]float64) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	dot, normB := 0.0, 0.0
+	for t, w := range a {
+		dot += w * b[t]
+	}
+	for _, w := range b {
+		normB += w * w
+	}
+	if normB == 0 {
+		return 0
+	}
+	// a may be either the document (unit length) or a centroid
+	normA := 0.0
+	for _, w := range a {
+		normA += w * w
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func topTerms(terms []Term, n int) []Term {
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Score != terms[j].Score {
+			return terms[i].Score > terms[j].Score
+		}
+		return terms[i].Word < terms[j].Word
+	})
+	if n > 0 && len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms
+}
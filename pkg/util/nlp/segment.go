@@ -0,0 +1,336 @@
+// Package nlp provides offline keyword extraction for chat messages:
+// dictionary based Chinese word segmentation, TF-IDF scoring and
+// simple document clustering. Everything runs locally.
+package nlp
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed dict.txt
+var dictData string
+
+//go:embed stopwords.txt
+var stopwordsData string
+
+const (
+	// maxWordLen is the maximum Chinese word length in runes
+	maxWordLen = 8
+
+	// maxLatinLen is the maximum latin word length kept as a keyword
+	maxLatinLen = 20
+
+	// DefaultFreq is the frequency of dictionary words listed without one
+	DefaultFreq = 1000
+)
+
+// urlRegexp matches URLs and markdown links which carry no topic information
+var urlRegexp = regexp.MustCompile(`https?://\S+|www\.\S+`)
+
+// Segmenter splits text into words using a word frequency dictionary
+type Segmenter struct {
+	// freq maps words to their frequencies, prefixes of words which are
+	// not words themselves are stored with frequency 0
+	freq      map[string]int
+	total     int
+	stopwords map[string]bool
+}
+
+var (
+	defaultSegmenter *Segmenter
+	defaultOnce      sync.Once
+)
+
+// Default returns a Segmenter using the bundled dictionary and stop words
+func Default() *Segmenter {
+	defaultOnce.Do(func() {
+		defaultSegmenter = NewSegmenter(ParseDict(dictData), ParseWordList(stopwordsData))
+	})
+	return defaultSegmenter
+}
+
+// NewSegmenter creates a Segmenter from a word frequency dictionary and a
+// stop word list. Stop words missing from the dictionary are added with
+// DefaultFreq so that they are split correctly.
+func NewSegmenter(dict map[string]int, stopwords []string) *Segmenter {
+	s := &Segmenter{
+		freq:      make(map[string]int, len(dict)*2),
+		stopwords: make(map[string]bool, len(stopwords)),
+	}
+	s.addWords(dict)
+	s.addStopwords(stopwords)
+	return s
+}
+
+// ParseDict parses a dictionary in the jieba dict.txt format: one word per
+// line, optionally followed by its frequency and part of speech. Words
+// without a valid frequency use DefaultFreq, lines starting with # are
+// comments.
+func ParseDict(data string) map[string]int {
+	dict := make(map[string]int)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		freq := DefaultFreq
+		if len(fields) > 1 {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				freq = n
+			}
+		}
+		dict[fields[0]] = freq
+	}
+	return dict
+}
+
+// ParseWordList parses a whitespace separated word list, lines starting
+// with # are comments
+func ParseWordList(data string) []string {
+	var words []string
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	return words
+}
+
+// WithDict returns a copy of the Segmenter with additional dictionary words,
+// the frequencies in dict replace those of existing words
+func (s *Segmenter) WithDict(dict map[string]int) *Segmenter {
+	ret := s.clone(len(dict) * 2)
+	ret.addWords(dict)
+	return ret
+}
+
+// WithStopwords returns a copy of the Segmenter with additional stop words
+func (s *Segmenter) WithStopwords(stopwords []string) *Segmenter {
+	ret := s.clone(len(stopwords))
+	ret.addStopwords(stopwords)
+	return ret
+}
+
+func (s *Segmenter) clone(extra int) *Segmenter {
+	ret := &Segmenter{
+		freq:      make(map[string]int, len(s.freq)+extra),
+		total:     s.total,
+		stopwords: make(map[string]bool, len(s.stopwords)),
+	}
+	for w, f := range s.freq {
+		ret.freq[w] = f
+	}
+	for w := range s.stopwords {
+		ret.stopwords[w] = true
+	}
+	return ret
+}
+
+// addWords adds words with their frequencies and records their prefixes
+func (s *Segmenter) addWords(dict map[string]int) {
+	for w, f := range dict {
+		w = strings.ToLower(w)
+		s.total += f - s.freq[w]
+		s.freq[w] = f
+		for i := range w {
+			if i > 0 {
+				if _, ok := s.freq[w[:i]]; !ok {
+					s.freq[w[:i]] = 0
+				}
+			}
+		}
+	}
+}
+
+func (s *Segmenter) addStopwords(stopwords []string) {
+	dict := make(map[string]int)
+	for _, w := range stopwords {
+		w = strings.ToLower(w)
+		s.stopwords[w] = true
+		if s.freq[w] == 0 {
+			dict[w] = DefaultFreq
+		}
+	}
+	s.addWords(dict)
+}
+
+// IsStopword reports whether the word is a stop word
+func (s *Segmenter) IsStopword(word string) bool {
+	return s.stopwords[strings.ToLower(word)]
+}
+
+// Cut splits text into words. Chinese runs are segmented with the dictionary
+// along the most probable route, latin letters and digits are kept as whole
+// words in lower case, and punctuation and spaces are dropped.
+func (s *Segmenter) Cut(text string) []string {
+	text = urlRegexp.ReplaceAllString(text, " ")
+
+	var words []string
+	var run []rune
+	var latin []rune
+	flushRun := func() {
+		if len(run) > 0 {
+			words = append(words, s.cutHan(run)...)
+			run = run[:0]
+		}
+	}
+	flushLatin := func() {
+		if len(latin) > 0 {
+			words = append(words, strings.ToLower(string(latin)))
+			latin = latin[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushLatin()
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushRun()
+			latin = append(latin, r)
+		default:
+			flushRun()
+			flushLatin()
+		}
+	}
+	flushRun()
+	flushLatin()
+	return words
+}
+
+// cutHan segments a run of Chinese characters in the same way as jieba
+// without HMM: among the dictionary words starting at each position, it
+// takes the route which maximizes the product of word probabilities
+// freq/total, where characters outside the dictionary count as frequency 1.
+// Consecutive characters outside the dictionary are joined as one word.
+func (s *Segmenter) cutHan(run []rune) []string {
+	n := len(run)
+	logTotal := math.Log(float64(max(s.total, 1)))
+	// route[i] is the maximum log probability to segment run[i:], next[i] the end of the word at i
+	route := make([]float64, n+1)
+	next := make([]int, n+1)
+	for i := n - 1; i >= 0; i-- {
+		route[i] = math.Inf(-1)
+		for j := i + 1; j <= n && j-i <= maxWordLen; j++ {
+			freq, ok := s.freq[string(run[i:j])]
+			if !ok && j-i > 1 {
+				break
+			}
+			if freq == 0 && j-i > 1 {
+				continue
+			}
+			if p := math.Log(float64(max(freq, 1))) - logTotal + route[j]; p > route[i] {
+				route[i] = p
+				next[i] = j
+			}
+		}
+	}
+
+	var words []string
+	var unknown []rune
+	flush := func() {
+		if len(unknown) > 0 {
+			words = append(words, string(unknown))
+			unknown = unknown[:0]
+		}
+	}
+	for i := 0; i < n; i = next[i] {
+		word := string(run[i:next[i]])
+		if next[i]-i == 1 && s.freq[word] == 0 {
+			unknown = append(unknown, run[i])
+			continue
+		}
+		flush()
+		words = append(words, word)
+	}
+	flush()
+	return words
+}
+
+// Terms returns the words of text which are suitable as keywords: stop words,
+// single characters and pure numbers are removed.
+func (s *Segmenter) Terms(text string) []string {
+	words := s.Cut(text)
+	terms := words[:0]
+	for _, w := range words {
+		if s.isTerm(w) {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// NGrams returns the n-grams of consecutive keywords in text. Words are
+// only combined within a clause, and an n-gram is dropped if any of its
+// words is not a keyword. n <= 1 is the same as Terms.
+func (s *Segmenter) NGrams(text string, n int) []string {
+	if n <= 1 {
+		return s.Terms(text)
+	}
+	var grams []string
+	clauses := strings.FieldsFunc(urlRegexp.ReplaceAllString(text, "\n"), func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r) || r == '\n'
+	})
+	for _, clause := range clauses {
+		words := s.Cut(clause)
+		for i := 0; i+n <= len(words); i++ {
+			gram := words[i : i+n]
+			ok := true
+			for _, w := range gram {
+				if !s.isTerm(w) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				grams = append(grams, joinWords(gram))
+			}
+		}
+	}
+	return grams
+}
+
+// isTerm reports whether the word is suitable as a keyword
+func (s *Segmenter) isTerm(w string) bool {
+	n := utf8.RuneCountInString(w)
+	if n < 2 || s.stopwords[w] || isNumber(w) {
+		return false
+	}
+	// overly long unknown runs are usually sentences rather than words,
+	// and long latin words are usually hashes or identifiers
+	return n <= maxLatinLen && (n <= maxWordLen || !isHan(w))
+}
+
+// joinWords joins Chinese words directly and latin words with spaces
+func joinWords(words []string) string {
+	buf := strings.Builder{}
+	for i, w := range words {
+		if i > 0 && !isHan(w) && !isHan(words[i-1]) {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(w)
+	}
+	return buf.String()
+}
+
+func isHan(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.Is(unicode.Han, r)
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package nlp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDict(t *testing.T) {
+	dict := ParseDict(strings.Join([]string{
+		"# comment",
+		"AT&T 3 nz",
+		"一一列举 34 i",
+		"大模型",
+		"一致 abc",
+		"",
+		"  上线  12  ",
+	}, "\n"))
+	want := map[string]int{
+		"AT&T": 3,
+		"一一列举": 34,
+		"大模型":  DefaultFreq,
+		"一致":   DefaultFreq,
+		"上线":   12,
+	}
+	if !reflect.DeepEqual(dict, want) {
+		t.Errorf("ParseDict() = %v, want %v", dict, want)
+	}
+}
+
+func TestCut(t *testing.T) {
+	// a classic ambiguous sentence, the route depends on word frequencies rather than the number of words
+	freqDict := map[string]int{
+		"结婚": 3000, "的": 300000, "和": 200000, "和尚": 800, "尚未": 2000, "未": 10000,
+		"研究": 5000, "研究生": 1000, "生命": 4000, "命": 2000, "起源": 900, "生": 8000,
+	}
+
+	tests := []struct {
+		name string
+		seg  *Segmenter
+		text string
+		want []string
+	}{
+		{
+			name: "bundled dictionary",
+			seg:  Default(),
+			text: "今天下午开会讨论项目进度",
+			want: []string{"今天", "下午", "开会", "讨论", "项目", "进度"},
+		},
+		{
+			name: "latin and digits",
+			seg:  Default(),
+			text: "明天上线 V2.0 版本，详见 https://example.com/a?b=1 OK",
+			want: []string{"明天", "上线", "v2", "0", "版本", "详见", "ok"},
+		},
+		{
+			name: "unknown characters are joined",
+			seg:  NewSegmenter(map[string]int{"开会": 100}, nil),
+			text: "张三丰开会",
+			want: []string{"张三丰", "开会"},
+		},
+		{
+			name: "frequency resolves ambiguity",
+			seg:  NewSegmenter(freqDict, nil),
+			text: "结婚的和尚未结婚的",
+			want: []string{"结婚", "的", "和", "尚未", "结婚", "的"},
+		},
+		{
+			name: "longer word is not always preferred",
+			seg:  NewSegmenter(freqDict, nil),
+			text: "研究生命起源",
+			want: []string{"研究", "生命", "起源"},
+		},
+		{
+			name: "user dictionary overrides frequency",
+			seg:  NewSegmenter(freqDict, nil).WithDict(map[string]int{"和尚": 100000}),
+			text: "结婚的和尚未结婚的",
+			want: []string{"结婚", "的", "和尚", "未", "结婚", "的"},
+		},
+		{
+			name: "stop words are words",
+			seg:  NewSegmenter(nil, []string{"我们"}),
+			text: "我们",
+			want: []string{"我们"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.seg.Cut(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cut(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	seg := Default().WithStopwords([]string{"项目"})
+	got := seg.Terms("我们今天讨论一下项目进度和上线计划 123")
+	want := []string{"讨论", "进度", "上线", "计划"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms() = %v, want %v", got, want)
+	}
+}
+
+func TestNGrams(t *testing.T) {
+	got := Default().NGrams("需求评审，上线计划。openai api 文档", 2)
+	want := []string{"需求评审", "上线计划", "openai api", "api文档"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NGrams() = %v, want %v", got, want)
+	}
+}
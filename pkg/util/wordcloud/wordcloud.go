@@ -0,0 +1,130 @@
+// Package wordcloud renders word clouds as SVG without external dependencies.
+package wordcloud
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"unicode"
+)
+
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 600
+
+	// MaxSize is the maximum width and height of the canvas
+	MaxSize = 4096
+
+	minFontSize = 12
+	maxFontSize = 72
+)
+
+// palette is the list of colors used for words, chosen by rank
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf", "#bcbd22", "#7f7f7f"}
+
+// Word is a word with its weight, e.g. its occurrence count
+type Word struct {
+	Text   string
+	Weight float64
+}
+
+// box is the placed bounding box of a word
+type box struct {
+	x, y, w, h float64
+}
+
+func (b box) overlaps(o box) bool {
+	return b.x < o.x+o.w && o.x < b.x+b.w && b.y < o.y+o.h && o.y < b.y+b.h
+}
+
+// SVG renders words as an SVG word cloud. Words should be sorted by weight in
+// descending order, the heaviest words are placed near the center and words
+// which do not fit into the canvas are skipped. Non-positive sizes fall back to
+// the defaults and sizes above MaxSize are clamped.
+func SVG(words []Word, width, height int) []byte {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	if height <= 0 {
+		height = DefaultHeight
+	}
+	width, height = min(width, MaxSize), min(height, MaxSize)
+
+	minWeight, maxWeight := math.Inf(1), math.Inf(-1)
+	for _, w := range words {
+		minWeight = math.Min(minWeight, w.Weight)
+		maxWeight = math.Max(maxWeight, w.Weight)
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+
+	cx, cy := float64(width)/2, float64(height)/2
+	placed := make([]box, 0, len(words))
+	for i, w := range words {
+		size := fontSize(w.Weight, minWeight, maxWeight)
+		bw, bh := textWidth(w.Text, size), size
+		b, ok := place(placed, bw, bh, cx, cy, float64(width), float64(height))
+		if !ok {
+			continue
+		}
+		placed = append(placed, b)
+		// text is anchored at the middle of its baseline
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="%.1f" fill="%s" text-anchor="middle" font-family="sans-serif">%s</text>`+"\n",
+			b.x+b.w/2, b.y+b.h*0.85, size, palette[i%len(palette)], html.EscapeString(w.Text))
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// fontSize scales the weight linearly between minFontSize and maxFontSize
+func fontSize(weight, minWeight, maxWeight float64) float64 {
+	if maxWeight <= minWeight {
+		return (minFontSize + maxFontSize) / 2
+	}
+	return minFontSize + (weight-minWeight)/(maxWeight-minWeight)*(maxFontSize-minFontSize)
+}
+
+// textWidth estimates the rendered width of text, wide characters such as
+// Chinese take a full em and others about half of it
+func textWidth(text string, size float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hangul, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) {
+			width += size
+		} else {
+			width += size * 0.6
+		}
+	}
+	return width
+}
+
+// place finds a free position along an Archimedean spiral from the center
+func place(placed []box, w, h, cx, cy, width, height float64) (box, bool) {
+	maxRadius := math.Hypot(width, height) / 2
+	for t := 0.0; ; t += 0.1 {
+		r := 2 * t
+		if r > maxRadius {
+			return box{}, false
+		}
+		// stretch horizontally to fill a landscape canvas
+		x := cx + r*math.Cos(t)*width/height - w/2
+		y := cy + r*math.Sin(t) - h/2
+		b := box{x: x, y: y, w: w, h: h}
+		if b.x < 0 || b.y < 0 || b.x+b.w > width || b.y+b.h > height {
+			continue
+		}
+		free := true
+		for _, p := range placed {
+			if b.overlaps(p) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return b, true
+		}
+	}
+}
@@ -6,6 +6,13 @@ import (
 
 var decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
 
+var encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+
 func Decompress(src []byte) ([]byte, error) {
 	return decoder.DecodeAll(src, nil)
 }
+
+// Compress compresses src into a single zstd frame, the inverse of Decompress
+func Compress(src []byte) []byte {
+	return encoder.EncodeAll(src, nil)
+}